@@ -0,0 +1,427 @@
+package main
+
+import (
+	"cmp"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/units"
+)
+
+// subcommands query a running daemon through its HTTP API
+var subcommands = map[string]func(args []string) error{
+	"top":    runTop,
+	"client": runClient,
+	"reset":  runReset,
+	"pause":  runPause,
+	"resume": runResume,
+	"export": runExport,
+	"import": runImport,
+}
+
+// runSubcommand runs the subcommand named by args[0], if there is one
+func runSubcommand(args []string) (ran bool, code int) {
+	if len(args) == 0 {
+		return false, 0
+	}
+	run, ok := subcommands[args[0]]
+	if !ok {
+		return false, 0
+	}
+	if err := run(args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "catchmole:", err)
+		return true, 1
+	}
+	return true, 0
+}
+
+// apiClient talks to the daemon, addressed and authenticated from its config file
+type apiClient struct {
+	base     string
+	user     string
+	password string
+	token    string
+	http     *http.Client
+}
+
+// clientFlags registers the flags shared by all subcommands
+func clientFlags(fs *flag.FlagSet) func() (*apiClient, error) {
+	configFile := fs.String("c", "config.toml", "Path to the daemon's configuration file")
+	addr := fs.String("addr", "", "Daemon address, e.g. http://192.168.1.1:8080 (default from config)")
+	token := fs.String("token", "", "API token (default auth_token from config)")
+	insecure := fs.Bool("insecure", false, "Skip TLS certificate verification (always skipped for loopback addresses)")
+
+	return func() (*apiClient, error) {
+		// Only warnings about the config file are interesting here
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+		config, err := loadConfig(cliFlags{configFile: *configFile})
+		if err != nil {
+			return nil, err
+		}
+		byteUnits, _ := units.Parse(config.ByteUnits)
+		units.Set(byteUnits)
+
+		transport := &http.Transport{TLSClientConfig: &tls.Config{}}
+		c := &apiClient{
+			base:     *addr,
+			user:     config.AuthUser,
+			password: config.AuthPassword,
+			token:    cmp.Or(*token, config.AuthToken),
+			http:     &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		}
+		if c.base == "" {
+			scheme, listen := "http", config.Listen
+			if path, ok := strings.CutPrefix(listen, unixPrefix); ok {
+				transport.DialContext = unixDialer(path)
+				c.base = "http://catchmole" // Host is ignored by the dialer
+				return c, nil
+			}
+			if listen == "" {
+				scheme, listen = "https", config.ListenTLS
+			}
+			host, port, err := net.SplitHostPort(listen)
+			if err != nil {
+				return nil, fmt.Errorf("invalid listen address %q: %w", listen, err)
+			}
+			if host == "" || host == "0.0.0.0" || host == "::" {
+				host = "127.0.0.1"
+			}
+			c.base = scheme + "://" + net.JoinHostPort(host, port)
+		} else if !strings.Contains(c.base, "://") {
+			c.base = "http://" + c.base
+		}
+		// The daemon's certificate is usually self-signed, only trusted
+		// blindly on this host or when asked to
+		transport.TLSClientConfig.InsecureSkipVerify = *insecure || isLoopback(c.base)
+		return c, nil
+	}
+}
+
+// isLoopback reports whether a daemon URL points at this host
+func isLoopback(base string) bool {
+	u, err := url.Parse(base)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (c *apiClient) do(method, path string, query url.Values, out any) error {
+	return c.send(method, path, query, nil, out)
+}
+
+// send is do with a JSON request body
+func (c *apiClient) send(method, path string, query url.Values, body io.Reader, out any) error {
+	u := strings.TrimRight(c.base, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// runTop prints the clients with the most traffic, optionally refreshing
+func runTop(args []string) error {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	connect := clientFlags(fs)
+	n := fs.Int("n", 15, "Number of clients to show, 0 for all")
+	by := fs.String("by", "speed", "Sort by speed, download, upload or total")
+	all := fs.Bool("all", false, "Include offline clients")
+	watch := fs.Duration("watch", 0, "Refresh every interval, e.g. 2s")
+	fs.Parse(args)
+
+	var key func(c model.ClientStats) uint64
+	switch *by {
+	case "speed":
+		key = func(c model.ClientStats) uint64 { return c.DownloadSpeed + c.UploadSpeed }
+	case "download":
+		key = func(c model.ClientStats) uint64 { return c.TotalDownload }
+	case "upload":
+		key = func(c model.ClientStats) uint64 { return c.TotalUpload }
+	case "total":
+		key = func(c model.ClientStats) uint64 { return c.TotalDownload + c.TotalUpload }
+	default:
+		return fmt.Errorf("invalid sort key %q (want speed, download, upload or total)", *by)
+	}
+
+	api, err := connect()
+	if err != nil {
+		return err
+	}
+
+	for {
+		var stats struct {
+			Global  model.GlobalStats   `json:"global"`
+			Clients []model.ClientStats `json:"clients"`
+		}
+		if err := api.do(http.MethodGet, "/api/stats", nil, &stats); err != nil {
+			return err
+		}
+
+		clients := stats.Clients
+		if !*all {
+			clients = slices.DeleteFunc(clients, func(c model.ClientStats) bool { return !c.Online })
+		}
+		slices.SortStableFunc(clients, func(a, b model.ClientStats) int { return cmp.Compare(key(b), key(a)) })
+		if *n > 0 && len(clients) > *n {
+			clients = clients[:*n]
+		}
+
+		if *watch > 0 {
+			fmt.Print("\033[H\033[2J") // Clear screen
+		}
+		g := stats.Global
+		fmt.Printf("Internet  ↓ %s/s  ↑ %s/s  total ↓ %s  ↑ %s  connections %d\n\n",
+			units.Bytes(g.DownloadSpeed), units.Bytes(g.UploadSpeed),
+			units.Bytes(g.TotalDownload), units.Bytes(g.TotalUpload), g.ActiveConnections)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tMAC\tDOWN/s\tUP/s\tCONNS\tTOTAL DOWN\tTOTAL UP")
+		for _, c := range clients {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", clientName(c), c.MAC,
+				units.Bytes(c.DownloadSpeed), units.Bytes(c.UploadSpeed), c.ActiveConnections,
+				units.Bytes(c.TotalDownload), units.Bytes(c.TotalUpload))
+		}
+		w.Flush()
+
+		if *watch <= 0 {
+			return nil
+		}
+		time.Sleep(*watch)
+	}
+}
+
+// runClient prints one client and its busiest remotes
+func runClient(args []string) error {
+	fs := flag.NewFlagSet("client", flag.ExitOnError)
+	connect := clientFlags(fs)
+	n := fs.Int("n", 20, "Number of remotes to show, 0 for all")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: catchmole client [flags] <mac>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	api, err := connect()
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Client   *model.ClientStats `json:"client"`
+		Flows    []model.FlowDetail `json:"flows"`
+		LocalIPs []string           `json:"local_ips"`
+	}
+	if err := api.do(http.MethodGet, "/api/client", url.Values{"mac": {fs.Arg(0)}}, &resp); err != nil {
+		return err
+	}
+	c := resp.Client
+	if c == nil {
+		return fmt.Errorf("unknown client %s", fs.Arg(0))
+	}
+
+	status := "offline"
+	if c.Online {
+		status = "online"
+	}
+	fmt.Printf("%s (%s) %s, last active %s\n", clientName(*c), c.MAC, status, c.LastActive.Local().Format(time.DateTime))
+	if len(resp.LocalIPs) > 0 {
+		fmt.Printf("IPs       %s\n", strings.Join(resp.LocalIPs, ", "))
+	}
+	fmt.Printf("Speed     ↓ %s/s  ↑ %s/s  connections %d\n", units.Bytes(c.DownloadSpeed), units.Bytes(c.UploadSpeed), c.ActiveConnections)
+	fmt.Printf("Total     ↓ %s  ↑ %s\n", units.Bytes(c.TotalDownload), units.Bytes(c.TotalUpload))
+	fmt.Printf("IPv4      ↓ %s  ↑ %s\n", units.Bytes(c.TotalDownload4), units.Bytes(c.TotalUpload4))
+	fmt.Printf("IPv6      ↓ %s  ↑ %s\n", units.Bytes(c.TotalDownload6), units.Bytes(c.TotalUpload6))
+	fmt.Printf("Session   ↓ %s  ↑ %s\n\n", units.Bytes(c.SessionDownload), units.Bytes(c.SessionUpload))
+
+	flows := resp.Flows
+	slices.SortStableFunc(flows, func(a, b model.FlowDetail) int {
+		return cmp.Or(
+			cmp.Compare(b.DownloadSpeed+b.UploadSpeed, a.DownloadSpeed+a.UploadSpeed),
+			cmp.Compare(b.TotalDownload+b.TotalUpload, a.TotalDownload+a.TotalUpload),
+		)
+	})
+	if *n > 0 && len(flows) > *n {
+		flows = flows[:*n]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROTO\tREMOTE\tPORT\tDOWN/s\tUP/s\tCONNS\tTOTAL DOWN\tTOTAL UP")
+	for _, f := range flows {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%d\t%s\t%s\n", f.Protocol, cmp.Or(f.RemoteHostname, f.RemoteIP), f.RemotePort,
+			units.Bytes(f.DownloadSpeed), units.Bytes(f.UploadSpeed), f.ActiveConnections,
+			units.Bytes(f.TotalDownload), units.Bytes(f.TotalUpload))
+	}
+	return w.Flush()
+}
+
+// runReset clears all statistics, or those of one client
+func runReset(args []string) error {
+	fs := flag.NewFlagSet("reset", flag.ExitOnError)
+	connect := clientFlags(fs)
+	mac := fs.String("mac", "", "Reset only this client")
+	session := fs.Bool("session", false, "Reset only the client's session counters (needs -mac)")
+	flush := fs.Bool("flush", false, "Also flush the kernel conntrack table (not with -mac)")
+	fs.Parse(args)
+	if *session && *mac == "" {
+		return fmt.Errorf("-session needs -mac")
+	}
+	if *flush && *mac != "" {
+		return fmt.Errorf("-flush resets all clients, it can't be used with -mac")
+	}
+
+	api, err := connect()
+	if err != nil {
+		return err
+	}
+	switch {
+	case *session:
+		err = api.do(http.MethodPost, "/api/client/reset_session", url.Values{"mac": {*mac}}, nil)
+	case *mac != "":
+		err = api.do(http.MethodPost, "/api/client/reset", url.Values{"mac": {*mac}}, nil)
+	case *flush:
+		err = api.do(http.MethodPost, "/api/reset", url.Values{"flush": {"true"}}, nil)
+	default:
+		err = api.do(http.MethodPost, "/api/reset", nil, nil)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("OK")
+	return nil
+}
+
+// runPause stops accounting traffic until resumed
+func runPause(args []string) error {
+	return runMonitorControl("pause", args)
+}
+
+// runResume accounts traffic again after a pause
+func runResume(args []string) error {
+	return runMonitorControl("resume", args)
+}
+
+func runMonitorControl(action string, args []string) error {
+	fs := flag.NewFlagSet(action, flag.ExitOnError)
+	connect := clientFlags(fs)
+	fs.Parse(args)
+
+	api, err := connect()
+	if err != nil {
+		return err
+	}
+	var state model.MonitorState
+	if err := api.do(http.MethodPost, "/api/monitor/"+action, nil, &state); err != nil {
+		return err
+	}
+	if state.Paused {
+		fmt.Printf("Paused since %s\n", state.Since.Format(time.DateTime))
+	} else {
+		fmt.Println("Monitoring")
+	}
+	return nil
+}
+
+// runExport saves a backup of all statistics to a file (or stdout)
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	connect := clientFlags(fs)
+	out := fs.String("o", "", "Write the backup to this file instead of stdout")
+	fs.Parse(args)
+
+	api, err := connect()
+	if err != nil {
+		return err
+	}
+	api.http.Timeout = time.Minute // Large installations
+	var backup json.RawMessage
+	if err := api.do(http.MethodGet, "/api/export", nil, &backup); err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err := fmt.Println(string(backup))
+		return err
+	}
+	return os.WriteFile(*out, backup, 0o600)
+}
+
+// runImport restores a backup written by export into the running daemon
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	connect := clientFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: catchmole import [flags] <backup.json>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	api, err := connect()
+	if err != nil {
+		return err
+	}
+	api.http.Timeout = time.Minute
+	if err := api.send(http.MethodPost, "/api/import", nil, f, nil); err != nil {
+		return err
+	}
+	fmt.Println("OK")
+	return nil
+}
+
+func clientName(c model.ClientStats) string {
+	return cmp.Or(c.Name, c.Hostname, "-")
+}
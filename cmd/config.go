@@ -0,0 +1,736 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/alert"
+	grpcapi "github.com/kisy/catchmole/pkg/api/grpc"
+	"github.com/kisy/catchmole/pkg/federation"
+	"github.com/kisy/catchmole/pkg/logging"
+	"github.com/kisy/catchmole/pkg/monitor"
+	"github.com/kisy/catchmole/pkg/monitor/scanner"
+	"github.com/kisy/catchmole/pkg/report"
+	"github.com/kisy/catchmole/pkg/shaping"
+	"github.com/kisy/catchmole/pkg/stats"
+	"github.com/kisy/catchmole/pkg/storage"
+	"github.com/kisy/catchmole/pkg/telemetry"
+	"github.com/kisy/catchmole/pkg/units"
+	"github.com/kisy/catchmole/web"
+)
+
+type Config struct {
+	Listen          string                    `toml:"listen"`     // Address, or unix:/path for a Unix domain socket
+	ListenTLS       string                    `toml:"listen_tls"` // HTTPS listener (optional)
+	CertFile        string                    `toml:"cert_file"`  // Empty: self-signed, generated next to the config file
+	KeyFile         string                    `toml:"key_file"`
+	GRPCListen      string                    `toml:"grpc_listen"`    // gRPC API listener (optional)
+	MetricsListen   string                    `toml:"metrics_listen"` // Serve /metrics here instead of on listen/listen_tls
+	SocketMode      string                    `toml:"socket_mode"`    // Octal permissions of unix: sockets, default 0660
+	GRPCTLS         bool                      `toml:"grpc_tls"`       // Serve gRPC with cert_file/key_file
+	Interface       string                    `toml:"interface"`
+	NetNS           string                    `toml:"netns"` // Network namespace to monitor, e.g. the host's from a container
+	IgnoreLAN       bool                      `toml:"ignore_lan"`
+	RouterTraffic   bool                      `toml:"router_traffic"` // Account the router's own traffic as client "router"
+	NAT64Prefixes   []string                  `toml:"nat64_prefixes"` // Traffic to these /96 prefixes counts as IPv4, default 64:ff9b::/96
+	RefreshInterval int                       `toml:"interval"`
+	FlowTTL         int                       `toml:"flow_ttl"`
+	TCPTTL          int                       `toml:"tcp_ttl"` // Per-protocol flow TTLs, 0 uses flow_ttl
+	UDPTTL          int                       `toml:"udp_ttl"`
+	ICMPTTL         int                       `toml:"icmp_ttl"`
+	ClientRetention int                       `toml:"client_retention"` // Days before offline clients are evicted, 0 keeps them
+	IdleGap         int                       `toml:"idle_gap"`         // Minutes without traffic that end a presence session
+	SessionReset    string                    `toml:"session_reset"`    // Roll session totals over, e.g. "daily 04:00", "weekly mon 04:00", "monthly 1 04:00"
+	FlowArchive     int                       `toml:"flow_archive"`     // Finished flows kept for /api/flows/recent
+	WatchdogTimeout int                       `toml:"watchdog_timeout"`
+	Source          string                    `toml:"source"`           // auto, conntrack, packet or ebpf
+	CaptureSample   int                       `toml:"capture_sample"`   // Packet source: count 1 in N packets
+	MonitorMode     string                    `toml:"monitor_mode"`     // Conntrack: hybrid, poll or events
+	ConntrackMark   string                    `toml:"conntrack_mark"`   // Conntrack: track only flows with this mark[/mask]
+	OffloadFallback string                    `toml:"offload_fallback"` // Flowtables without counters: warn, counter or capture
+	Devices         map[string]storage.Device `toml:"devices"`          // Name, or a table with name, tags, notes and plan
+	DevicesFile     string                    `toml:"devices_file"`     // Devices set via the API
+	Aliases         map[string][]string       `toml:"aliases"`          // Primary MAC -> further MACs of the same device
+	AliasesFile     string                    `toml:"aliases_file"`     // Aliases set via the API
+	SettingsFile    string                    `toml:"settings_file"`    // Settings changed via /api/settings
+	IpTools         map[string]string         `toml:"ip_tools"`
+	PortGroups      map[string]string         `toml:"port_groups"`
+	MarkClasses     map[string]string         `toml:"mark_classes"` // Conntrack mark (optionally /mask) -> class
+	Zones           map[string]string         `toml:"zones"`        // Conntrack zone -> label, e.g. the uplink
+	IgnoreZones     []int                     `toml:"ignore_zones"`
+	LinkCapacity    string                    `toml:"link_capacity"` // WAN "down/up" in Mbps, e.g. "500/50"
+	ZoneCapacity    map[string]string         `toml:"zone_capacity"` // Conntrack zone -> "down/up" in Mbps
+	DHCPLeases      []string                  `toml:"dhcp_leases"`
+	Ubus            bool                      `toml:"ubus"`       // OpenWrt: hostnames and wireless stations from ubus
+	Hostapd         string                    `toml:"hostapd"`    // hostapd control socket directory for Wi-Fi associations, "" disables
+	WireGuard       bool                      `toml:"wireguard"`  // Account WireGuard peers (wg show) as clients
+	OpenVPN         []string                  `toml:"openvpn"`    // Account OpenVPN clients from status files (status-version 2 or 3)
+	NameProbe       bool                      `toml:"name_probe"` // Ask unnamed clients for their name via mDNS, NetBIOS and LLMNR
+	Groups          map[string][]string       `toml:"groups"`
+	IgnoreSubnets   []string                  `toml:"ignore_subnets"`
+	IgnorePorts     []string                  `toml:"ignore_ports"`
+	IgnoreMACs      []string                  `toml:"ignore_macs"`
+	PrivateMACs     []string                  `toml:"private_macs"` // Counted in aggregate only, flows never recorded or exposed
+	Tags            map[string][]string       `toml:"tags"`         // Tag -> CIDRs or host patterns
+	ExcludeTags     []string                  `toml:"exclude_tags"` // Tags kept out of usage totals
+	Timezone        string                    `toml:"timezone"`     // IANA name for calendar boundaries, default local
+	UsageDays       int                       `toml:"usage_days"`   // Days of daily usage rollups to keep
+	ByteUnits       string                    `toml:"byte_units"`   // iec (KiB, powers of 1024, default) or si (kB, powers of 1000)
+	DNSSniff        bool                      `toml:"dns_sniff"`
+	DNSPTR          bool                      `toml:"dns_ptr"`
+	SNISniff        bool                      `toml:"sni_sniff"` // Label flows with TLS/QUIC server names
+	RTTSniff        bool                      `toml:"rtt_sniff"` // Estimate latency from TCP handshakes
+	GeoIPCountryDB  string                    `toml:"geoip_country_db"`
+	GeoIPASNDB      string                    `toml:"geoip_asn_db"`
+
+	// Countries LAN devices should not talk to, reported in /api/countries
+	BlockedCountries []string `toml:"blocked_countries"`
+
+	// Per-schedule overrides of timezone, e.g. an ISP billing day in another zone
+	SessionResetTimezone string `toml:"session_reset_timezone"`
+	UsageTimezone        string `toml:"usage_timezone"` // Daily/weekly/monthly usage rollups and daily_bytes alerts
+
+	// SQLite persistence (disabled if path is empty)
+	StoragePath      string `toml:"storage_path"`
+	StorageInterval  int    `toml:"storage_interval"`
+	StorageRetention int    `toml:"storage_retention"`
+
+	// JSON state file persistence (disabled if path is empty)
+	StateFile     string `toml:"state_file"`
+	StateInterval int    `toml:"state_interval"`
+
+	// Write-ahead log of traffic deltas, replayed on startup (disabled if dir is empty)
+	WALDir         string `toml:"wal_dir"`
+	WALInterval    int    `toml:"wal_interval"`     // Seconds
+	WALSegmentSize int    `toml:"wal_segment_size"` // MB before rotation and compaction
+
+	// Speed and connection count smoothing, 0 uses the defaults
+	EMAAlpha        float64 `toml:"ema_alpha"`
+	SpeedMinElapsed int     `toml:"speed_min_elapsed"` // Milliseconds
+	SpeedWindow     int     `toml:"speed_window"`      // Seconds, 0 reports per-interval speeds
+
+	// Largest byte delta accepted from one event, larger ones are dropped (default 1GB)
+	MaxDelta uint64 `toml:"max_delta"`
+
+	// Table caps, the least recently seen entries are evicted beyond them
+	MaxFlows   int `toml:"max_flows"`   // Default 100000
+	MaxClients int `toml:"max_clients"` // Default 4096
+
+	// Experimental /api/v2/ with a versioned schema
+	APIv2 bool `toml:"api_v2"`
+
+	// Elephant flow thresholds
+	ElephantBytes   uint64 `toml:"elephant_bytes"`
+	ElephantRate    uint64 `toml:"elephant_rate"`
+	ElephantSustain int    `toml:"elephant_sustain"`
+
+	// Upload anomaly detection, 0 uses the defaults
+	AnomalyThreshold float64 `toml:"anomaly_threshold"`  // Standard deviations above the client's baseline
+	AnomalyMinUpload uint64  `toml:"anomaly_min_upload"` // Bytes per minute
+
+	// Port scan and host sweep detection, 0 uses the defaults
+	FanoutThreshold int `toml:"fanout_threshold"` // Distinct remote IP and port pairs
+	FanoutWindow    int `toml:"fanout_window"`    // Seconds
+
+	// Expected bandwidth of devices, see the plan of [devices]
+	Plans       map[string]string `toml:"plans"`        // Plan -> "down/up" in Mbps, 0 = unlimited
+	PlanSustain int               `toml:"plan_sustain"` // Seconds above the plan that count as a violation
+
+	// NetFlow v9 / IPFIX export (disabled if collector is empty)
+	NetFlowCollector string `toml:"netflow_collector"`
+	NetFlowVersion   int    `toml:"netflow_version"`
+	NetFlowInterval  int    `toml:"netflow_interval"` // Active timeout in seconds
+
+	// Prometheus remote-write push (disabled if url is empty)
+	RemoteWriteURL      string `toml:"remote_write_url"`
+	RemoteWriteInterval int    `toml:"remote_write_interval"`
+	RemoteWriteUser     string `toml:"remote_write_user"`
+	RemoteWritePassword string `toml:"remote_write_password"`
+	RemoteWriteToken    string `toml:"remote_write_token"`
+
+	// Alert rules and notification channels
+	Alert alert.Config `toml:"alert"`
+
+	// Scheduled usage reports by email or webhook
+	Report report.Config `toml:"report"`
+
+	// nftables rate limits per client
+	Shaping shaping.Config `toml:"shaping"`
+
+	// Active ARP/NDP discovery of quiet devices
+	Scan scanner.Config `toml:"scan"`
+
+	// Merged view of remote catchmole instances, e.g. AP routers
+	Federation federation.Config `toml:"federation"`
+
+	// Log level, format and optional file output
+	Log logging.Config `toml:"log"`
+
+	// OTLP export of catchmole's own metrics and traces (disabled if endpoint is empty)
+	Otel telemetry.Config `toml:"otel"`
+
+	// Web authentication (disabled if empty)
+	AuthUser     string `toml:"auth_user"`
+	AuthPassword string `toml:"auth_password"`
+	AuthToken    string `toml:"auth_token"`
+
+	// Mutating API requests are also appended here as JSON lines (optional)
+	AuditFile string `toml:"audit_file"`
+
+	// Per client IP request rate on /api/stats and /api/client (disabled if 0)
+	APIRateLimit float64 `toml:"api_rate_limit"`
+	APIBurst     int     `toml:"api_burst"`
+
+	selfSigned bool        // No cert_file/key_file configured, generate a certificate
+	socketMode os.FileMode // Parsed socket_mode
+}
+
+// cliFlags holds command line overrides, re-applied on every (re)load
+type cliFlags struct {
+	configFile string
+	listenAddr string
+	enableLAN  bool
+	interval   int
+	ifaceName  string
+	flowTTL    int
+	netns      string
+	mode       string
+	paused     bool // Startup only, not part of the config
+}
+
+func loadConfig(f cliFlags) (*Config, error) {
+	config := &Config{}
+	config.IgnoreLAN = true // Default to true (ignore LAN traffic)
+	config.NameProbe = true
+
+	if _, err := os.Stat(f.configFile); err == nil {
+		if _, err := toml.DecodeFile(f.configFile, config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+		slog.Info("Loaded config", "path", f.configFile)
+	} else if os.IsNotExist(err) && f.configFile != "config.toml" {
+		// Only error if user explicitly provided a config file that doesn't exist
+		return nil, fmt.Errorf("config file not found: %s", f.configFile)
+	}
+
+	// Flag overrides config
+	if f.listenAddr != "" {
+		config.Listen = f.listenAddr
+	}
+	if f.interval > 0 {
+		config.RefreshInterval = f.interval
+	}
+	if f.flowTTL > 0 {
+		config.FlowTTL = f.flowTTL
+	}
+	if f.ifaceName != "" {
+		config.Interface = f.ifaceName
+	}
+	if f.netns != "" {
+		config.NetNS = f.netns
+	}
+	if f.mode != "" {
+		config.MonitorMode = f.mode
+	}
+
+	// Default interval
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = 1
+	}
+	// Default TTL
+	if config.FlowTTL <= 0 {
+		config.FlowTTL = 60
+	}
+	// Default watchdog timeout
+	if config.WatchdogTimeout <= 0 {
+		config.WatchdogTimeout = 30
+	}
+	// Default traffic source
+	if config.Source == "" {
+		config.Source = "auto"
+	}
+	switch config.Source {
+	case "auto", "conntrack", "packet", "ebpf":
+	default:
+		return nil, fmt.Errorf("invalid source %q (want auto, conntrack, packet or ebpf)", config.Source)
+	}
+	if config.CaptureSample <= 0 {
+		config.CaptureSample = 1
+	}
+	if config.MonitorMode == "" {
+		config.MonitorMode = monitor.ModeHybrid
+	}
+	switch config.MonitorMode {
+	case monitor.ModeHybrid, monitor.ModePoll, monitor.ModeEvents:
+	default:
+		return nil, fmt.Errorf("invalid monitor_mode %q (want hybrid, poll or events)", config.MonitorMode)
+	}
+	if config.ConntrackMark != "" {
+		if _, _, err := monitor.ParseMark(config.ConntrackMark); err != nil {
+			return nil, fmt.Errorf("invalid conntrack_mark: %w", err)
+		}
+	}
+	if config.OffloadFallback == "" {
+		config.OffloadFallback = monitor.OffloadWarn
+	}
+	switch config.OffloadFallback {
+	case monitor.OffloadWarn, monitor.OffloadCounter, monitor.OffloadCapture:
+	default:
+		return nil, fmt.Errorf("invalid offload_fallback %q (want warn, counter or capture)", config.OffloadFallback)
+	}
+	// Default storage settings
+	if config.StorageInterval <= 0 {
+		config.StorageInterval = 60
+	}
+	if config.StorageRetention <= 0 {
+		config.StorageRetention = 30
+	}
+	if config.NetFlowVersion <= 0 {
+		config.NetFlowVersion = 9
+	}
+	if config.NetFlowInterval <= 0 {
+		config.NetFlowInterval = 30
+	}
+	if config.RemoteWriteInterval <= 0 {
+		config.RemoteWriteInterval = 30
+	}
+	if config.UsageDays <= 0 {
+		config.UsageDays = 90
+	}
+	for key, name := range map[string]string{
+		"timezone":               config.Timezone,
+		"session_reset_timezone": config.SessionResetTimezone,
+		"usage_timezone":         config.UsageTimezone,
+	} {
+		if _, err := time.LoadLocation(name); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", key, name, err)
+		}
+	}
+	if config.FanoutThreshold > stats.MaxFanoutTargets {
+		return nil, fmt.Errorf("invalid fanout_threshold %d (want at most %d)", config.FanoutThreshold, stats.MaxFanoutTargets)
+	}
+	if err := config.Federation.Validate(); err != nil {
+		return nil, err
+	}
+	if (config.AuthUser == "") != (config.AuthPassword == "") {
+		return nil, fmt.Errorf("auth_user and auth_password must be set together")
+	}
+	if _, err := stats.ParseSessionSchedule(config.SessionReset); err != nil {
+		return nil, err
+	}
+	if _, err := units.Parse(config.ByteUnits); err != nil {
+		return nil, err
+	}
+	if config.StateInterval <= 0 {
+		config.StateInterval = 60
+	}
+	if config.WALInterval <= 0 {
+		config.WALInterval = 10
+	}
+	if config.WALSegmentSize <= 0 {
+		config.WALSegmentSize = 4
+	}
+	// Default elephant thresholds
+	if config.ElephantBytes == 0 {
+		config.ElephantBytes = 100 * 1024 * 1024 // 100MB
+	}
+	if config.ElephantRate == 0 {
+		config.ElephantRate = 1024 * 1024 // 1MB/s
+	}
+	if config.ElephantSustain <= 0 {
+		config.ElephantSustain = 10
+	}
+
+	// Runtime device names live next to the config file by default
+	if config.DevicesFile == "" {
+		config.DevicesFile = filepath.Join(filepath.Dir(f.configFile), "devices.json")
+	}
+	if config.AliasesFile == "" {
+		config.AliasesFile = filepath.Join(filepath.Dir(f.configFile), "aliases.json")
+	}
+	if config.SettingsFile == "" {
+		config.SettingsFile = filepath.Join(filepath.Dir(f.configFile), "settings.json")
+	}
+
+	// Self-signed HTTPS certificate next to the config file by default
+	if config.CertFile == "" && config.KeyFile == "" {
+		dir := filepath.Dir(f.configFile)
+		config.CertFile = filepath.Join(dir, "catchmole.crt")
+		config.KeyFile = filepath.Join(dir, "catchmole.key")
+		config.selfSigned = true
+	}
+
+	// HTTPS only if just listen_tls is set
+	if config.Listen == "" && config.ListenTLS == "" {
+		config.Listen = ":8080" // Default
+	}
+	for _, addr := range []string{config.Listen, config.MetricsListen} {
+		if addr == unixPrefix {
+			return nil, fmt.Errorf("invalid listen address %q: missing socket path", addr)
+		}
+	}
+	config.socketMode = defaultSocketMode
+	if config.SocketMode != "" {
+		mode, err := strconv.ParseUint(config.SocketMode, 8, 32)
+		if err != nil || mode > 0o777 {
+			return nil, fmt.Errorf("invalid socket_mode %q (want octal permissions, e.g. 0660)", config.SocketMode)
+		}
+		config.socketMode = os.FileMode(mode)
+	}
+	if f.enableLAN {
+		config.IgnoreLAN = false
+	}
+
+	return config, nil
+}
+
+// location loads a per-schedule timezone override, empty falls back to
+// timezone and then to the system timezone. Names are validated by loadConfig.
+func (c *Config) location(name string) *time.Location {
+	if name == "" {
+		name = c.Timezone
+	}
+	if name == "" {
+		return time.Local // LoadLocation("") is UTC
+	}
+	loc, _ := time.LoadLocation(name)
+	return loc
+}
+
+// settings returns the config values of the settings adjustable via /api/settings
+func (c *Config) settings() model.Settings {
+	return model.Settings{
+		FlowTTL:       c.FlowTTL,
+		Interval:      c.RefreshInterval,
+		MonitorLAN:    !c.IgnoreLAN,
+		Interface:     c.Interface,
+		IgnoreSubnets: c.IgnoreSubnets,
+		IgnorePorts:   c.IgnorePorts,
+		IgnoreMACs:    c.IgnoreMACs,
+	}
+}
+
+// applyRuntime overrides the config with settings changed via /api/settings
+func (c *Config) applyRuntime(r storage.RuntimeSettings) {
+	s := c.settings()
+	r.Apply(&s)
+	c.FlowTTL, c.RefreshInterval, c.IgnoreLAN, c.Interface = s.FlowTTL, s.Interval, !s.MonitorLAN, s.Interface
+	c.IgnoreSubnets, c.IgnorePorts, c.IgnoreMACs = s.IgnoreSubnets, s.IgnorePorts, s.IgnoreMACs
+}
+
+// applyReload pushes settings that can change at runtime into the running components.
+// Accumulated statistics are kept; other settings require a restart.
+func applyReload(old, cur *Config, agg *stats.Aggregator, mon monitor.TrafficSource, srv *web.Server, gsrv *grpcapi.Server, alerts *alert.Engine, devices *storage.DeviceStore, aliases *storage.AliasStore, shaper *shaping.Controller, scan *scanner.Scanner, reporter *report.Reporter, fed *federation.Poller) {
+	if !maps.EqualFunc(old.Devices, cur.Devices, storage.Device.Equal) {
+		devices.SetConfigDevices(cur.Devices)
+		agg.SetDeviceNames(devices.Names())
+		agg.SetDeviceTags(devices.Tags())
+		agg.SetDevicePlans(devices.Plans())
+		slog.Info("Reload: devices updated", "entries", len(cur.Devices))
+	}
+
+	aliasesChanged := !maps.EqualFunc(old.Aliases, cur.Aliases, slices.Equal)
+	if aliasesChanged {
+		aliases.SetConfigAliases(cur.Aliases)
+		if err := agg.SetAliases(aliases.Aliases()); err != nil {
+			slog.Error("Reload: invalid aliases, keeping previous", "err", err)
+			aliases.SetConfigAliases(old.Aliases)
+			cur.Aliases = old.Aliases
+		} else {
+			slog.Info("Reload: aliases updated", "entries", len(cur.Aliases))
+		}
+	}
+
+	// Private MACs are resolved to their primary
+	if aliasesChanged || !slices.Equal(old.PrivateMACs, cur.PrivateMACs) {
+		agg.SetPrivateMACs(cur.PrivateMACs)
+		slog.Info("Reload: private clients updated", "macs", len(cur.PrivateMACs))
+	}
+
+	if old.Log.Level != cur.Log.Level {
+		if err := logging.SetLevel(cur.Log.Level); err != nil {
+			slog.Error("Reload: invalid log level, keeping previous", "err", err)
+		} else {
+			slog.Info("Reload: log level updated", "level", cur.Log.Level)
+		}
+	}
+
+	if old.ClientRetention != cur.ClientRetention {
+		agg.SetClientRetention(time.Duration(cur.ClientRetention) * 24 * time.Hour)
+		slog.Info("Reload: client retention updated", "days", cur.ClientRetention)
+	}
+
+	if old.FlowArchive != cur.FlowArchive {
+		agg.SetArchiveSize(cur.FlowArchive)
+		slog.Info("Reload: flow_archive updated", "flows", cur.FlowArchive)
+	}
+
+	if old.ByteUnits != cur.ByteUnits {
+		u, _ := units.Parse(cur.ByteUnits) // Validated by loadConfig
+		units.Set(u)
+		slog.Info("Reload: byte units updated", "units", u)
+	}
+
+	if old.SessionReset != cur.SessionReset || old.SessionResetTimezone != cur.SessionResetTimezone {
+		sched, _ := stats.ParseSessionSchedule(cur.SessionReset) // Validated by loadConfig
+		agg.SetSessionReset(sched, cur.location(cur.SessionResetTimezone))
+		slog.Info("Reload: session reset schedule updated", "schedule", cur.SessionReset, "timezone", cur.location(cur.SessionResetTimezone).String())
+	}
+
+	if old.IdleGap != cur.IdleGap {
+		agg.SetIdleGap(time.Duration(cur.IdleGap) * time.Minute)
+		slog.Info("Reload: idle gap updated", "minutes", cur.IdleGap)
+	}
+
+	if old.RouterTraffic != cur.RouterTraffic {
+		agg.SetRouterTraffic(cur.RouterTraffic)
+		slog.Info("Reload: router traffic accounting updated", "enabled", cur.RouterTraffic)
+	}
+
+	if old.AuthUser != cur.AuthUser || old.AuthPassword != cur.AuthPassword || old.AuthToken != cur.AuthToken {
+		srv.SetAuth(cur.AuthUser, cur.AuthPassword, cur.AuthToken)
+		if gsrv != nil {
+			gsrv.SetAuth(cur.AuthUser, cur.AuthPassword, cur.AuthToken)
+		}
+		slog.Info("Reload: web authentication updated")
+	}
+
+	if old.AuditFile != cur.AuditFile {
+		if err := srv.SetAuditFile(cur.AuditFile); err != nil {
+			slog.Error("Reload: failed to open audit file", "path", cur.AuditFile, "err", err)
+		} else {
+			slog.Info("Reload: audit file updated", "path", cur.AuditFile)
+		}
+	}
+
+	if old.APIRateLimit != cur.APIRateLimit || old.APIBurst != cur.APIBurst {
+		srv.SetRateLimit(cur.APIRateLimit, cur.APIBurst)
+		slog.Info("Reload: API rate limit updated", "rps", cur.APIRateLimit, "burst", cur.APIBurst)
+	}
+
+	if !slices.Equal(old.IgnoreSubnets, cur.IgnoreSubnets) || !slices.Equal(old.IgnorePorts, cur.IgnorePorts) ||
+		!slices.Equal(old.IgnoreMACs, cur.IgnoreMACs) {
+		if err := agg.SetIgnoreRules(cur.IgnoreSubnets, cur.IgnorePorts, cur.IgnoreMACs); err != nil {
+			slog.Error("Reload: invalid ignore lists, keeping previous", "err", err)
+		} else {
+			slog.Info("Reload: ignore lists updated")
+		}
+	}
+
+	if !maps.EqualFunc(old.Tags, cur.Tags, slices.Equal) || !slices.Equal(old.ExcludeTags, cur.ExcludeTags) {
+		if err := agg.SetTags(cur.Tags, cur.ExcludeTags); err != nil {
+			slog.Error("Reload: invalid tags, keeping previous", "err", err)
+		} else {
+			slog.Info("Reload: traffic tags updated")
+		}
+	}
+
+	if !slices.Equal(old.NAT64Prefixes, cur.NAT64Prefixes) {
+		if err := agg.SetNAT64Prefixes(cur.NAT64Prefixes); err != nil {
+			slog.Error("Reload: invalid nat64_prefixes, keeping previous", "err", err)
+		} else {
+			slog.Info("Reload: NAT64 prefixes updated")
+		}
+	}
+
+	if !maps.EqualFunc(old.Groups, cur.Groups, slices.Equal) {
+		agg.SetGroups(cur.Groups)
+		slog.Info("Reload: client groups updated", "groups", len(cur.Groups))
+	}
+
+	if old.IgnoreLAN != cur.IgnoreLAN {
+		agg.SetIgnoreLAN(cur.IgnoreLAN)
+		slog.Info("Reload: ignore_lan updated", "value", cur.IgnoreLAN)
+	}
+
+	if old.FlowTTL != cur.FlowTTL {
+		agg.SetFlowTTL(time.Duration(cur.FlowTTL) * time.Second)
+		slog.Info("Reload: flow_ttl updated", "seconds", cur.FlowTTL)
+	}
+
+	if old.TCPTTL != cur.TCPTTL || old.UDPTTL != cur.UDPTTL || old.ICMPTTL != cur.ICMPTTL {
+		agg.SetProtoTTLs(time.Duration(cur.TCPTTL)*time.Second, time.Duration(cur.UDPTTL)*time.Second, time.Duration(cur.ICMPTTL)*time.Second)
+		slog.Info("Reload: protocol flow TTLs updated", "tcp", cur.TCPTTL, "udp", cur.UDPTTL, "icmp", cur.ICMPTTL)
+	}
+
+	if old.RefreshInterval != cur.RefreshInterval {
+		d := time.Duration(cur.RefreshInterval) * time.Second
+		agg.SetInterval(d)
+		if err := mon.SetPollInterval(d); err != nil {
+			slog.Error("Reload: failed to restart monitor with new interval", "err", err)
+		}
+		slog.Info("Reload: interval updated", "seconds", cur.RefreshInterval)
+	}
+
+	if !maps.Equal(old.PortGroups, cur.PortGroups) {
+		if err := agg.SetPortGroups(cur.PortGroups); err != nil {
+			slog.Error("Reload: invalid port_groups, keeping previous", "err", err)
+			cur.PortGroups = old.PortGroups
+		} else {
+			slog.Info("Reload: port_groups updated")
+		}
+	}
+
+	if !maps.Equal(old.MarkClasses, cur.MarkClasses) {
+		if err := agg.SetMarkClasses(cur.MarkClasses); err != nil {
+			slog.Error("Reload: invalid mark_classes, keeping previous", "err", err)
+			cur.MarkClasses = old.MarkClasses
+		} else {
+			slog.Info("Reload: mark_classes updated")
+		}
+	}
+
+	if !slices.Equal(old.BlockedCountries, cur.BlockedCountries) {
+		agg.SetBlockedCountries(cur.BlockedCountries)
+		slog.Info("Reload: blocked_countries updated", "countries", cur.BlockedCountries)
+	}
+
+	if !maps.Equal(old.Zones, cur.Zones) || !slices.Equal(old.IgnoreZones, cur.IgnoreZones) {
+		if err := agg.SetZones(cur.Zones, cur.IgnoreZones); err != nil {
+			slog.Error("Reload: invalid zones, keeping previous", "err", err)
+			cur.Zones, cur.IgnoreZones = old.Zones, old.IgnoreZones
+		} else {
+			slog.Info("Reload: zones updated")
+		}
+	}
+
+	if old.LinkCapacity != cur.LinkCapacity || !maps.Equal(old.ZoneCapacity, cur.ZoneCapacity) {
+		if err := agg.SetLinkCapacity(cur.LinkCapacity, cur.ZoneCapacity); err != nil {
+			slog.Error("Reload: invalid link capacity, keeping previous", "err", err)
+			cur.LinkCapacity, cur.ZoneCapacity = old.LinkCapacity, old.ZoneCapacity
+		} else {
+			slog.Info("Reload: link capacity updated")
+		}
+	}
+
+	// Applied on every reload, reverting changes made via /api/smoothing
+	prevSmoothing := agg.GetSmoothing()
+	smoothing := model.Smoothing{Alpha: cur.EMAAlpha, MinElapsedMs: cur.SpeedMinElapsed, WindowSeconds: cur.SpeedWindow}
+	if err := agg.SetSmoothing(smoothing); err != nil {
+		slog.Error("Reload: invalid smoothing settings, keeping previous", "err", err)
+	} else if agg.GetSmoothing() != prevSmoothing {
+		slog.Info("Reload: smoothing updated", "ema_alpha", cur.EMAAlpha, "speed_min_elapsed", cur.SpeedMinElapsed, "speed_window", cur.SpeedWindow)
+	}
+
+	if old.MaxDelta != cur.MaxDelta {
+		agg.SetMaxDelta(cur.MaxDelta)
+		slog.Info("Reload: max_delta updated", "bytes", cur.MaxDelta)
+	}
+
+	if old.MaxFlows != cur.MaxFlows || old.MaxClients != cur.MaxClients {
+		agg.SetTableLimits(cur.MaxFlows, cur.MaxClients)
+		slog.Info("Reload: max_flows/max_clients updated", "max_flows", cur.MaxFlows, "max_clients", cur.MaxClients)
+	}
+
+	if old.ElephantBytes != cur.ElephantBytes || old.ElephantRate != cur.ElephantRate || old.ElephantSustain != cur.ElephantSustain {
+		agg.SetElephantThresholds(cur.ElephantBytes, cur.ElephantRate, time.Duration(cur.ElephantSustain)*time.Second)
+		slog.Info("Reload: elephant thresholds updated")
+	}
+
+	if old.AnomalyThreshold != cur.AnomalyThreshold || old.AnomalyMinUpload != cur.AnomalyMinUpload {
+		agg.SetAnomalyThresholds(cur.AnomalyThreshold, cur.AnomalyMinUpload)
+		slog.Info("Reload: anomaly thresholds updated", "threshold", cur.AnomalyThreshold, "min_upload", cur.AnomalyMinUpload)
+	}
+
+	if old.FanoutThreshold != cur.FanoutThreshold || old.FanoutWindow != cur.FanoutWindow {
+		agg.SetFanoutThresholds(cur.FanoutThreshold, time.Duration(cur.FanoutWindow)*time.Second)
+		slog.Info("Reload: fan-out thresholds updated", "threshold", cur.FanoutThreshold, "window", cur.FanoutWindow)
+	}
+
+	if !maps.Equal(old.Plans, cur.Plans) || old.PlanSustain != cur.PlanSustain {
+		if err := agg.SetPlans(cur.Plans, time.Duration(cur.PlanSustain)*time.Second); err != nil {
+			slog.Error("Reload: invalid plans, keeping previous", "err", err)
+			cur.Plans, cur.PlanSustain = old.Plans, old.PlanSustain
+		} else {
+			slog.Info("Reload: plans updated", "plans", len(cur.Plans), "sustain", cur.PlanSustain)
+		}
+	}
+
+	if !old.Alert.Equal(cur.Alert) {
+		if alerts == nil {
+			slog.Warn("Reload: alerting was disabled at startup, restart required to enable")
+		} else if err := alerts.SetConfig(cur.Alert); err != nil {
+			slog.Error("Reload: invalid alert config, keeping previous", "err", err)
+		} else {
+			slog.Info("Reload: alert rules updated")
+		}
+	}
+
+	if !old.Report.Equal(cur.Report) {
+		if reporter == nil {
+			slog.Warn("Reload: reports were disabled at startup, restart required to enable")
+		} else if err := reporter.SetConfig(cur.Report); err != nil {
+			slog.Error("Reload: invalid report config, keeping previous", "err", err)
+			cur.Report = old.Report
+		} else {
+			slog.Info("Reload: report settings updated")
+		}
+	}
+
+	if !old.Shaping.Equal(cur.Shaping) {
+		if shaper == nil {
+			slog.Warn("Reload: shaping was disabled at startup, restart required to enable")
+		} else if err := shaper.SetConfig(cur.Shaping); err != nil {
+			slog.Error("Reload: invalid shaping config, keeping previous", "err", err)
+			cur.Shaping = old.Shaping
+		} else {
+			slog.Info("Reload: shaping limits updated")
+		}
+	}
+	if !old.Federation.Equal(cur.Federation) {
+		fed.SetConfig(cur.Federation)
+		slog.Info("Reload: federation updated", "sites", len(cur.Federation.Sites))
+	}
+	if !old.Scan.Equal(cur.Scan) {
+		scan.SetConfig(cur.Scan)
+		slog.Info("Reload: scan updated", "enabled", cur.Scan.Enabled)
+	}
+
+	// Settings wired at startup only
+	restartOnly := []struct {
+		key     string
+		changed bool
+	}{
+		{"listen", old.Listen != cur.Listen || old.SocketMode != cur.SocketMode},
+		{"listen_tls", old.ListenTLS != cur.ListenTLS || old.CertFile != cur.CertFile || old.KeyFile != cur.KeyFile},
+		{"grpc_listen", old.GRPCListen != cur.GRPCListen || old.GRPCTLS != cur.GRPCTLS},
+		{"metrics_listen", old.MetricsListen != cur.MetricsListen},
+		{"interface", old.Interface != cur.Interface || old.NetNS != cur.NetNS},
+		{"log", old.Log.Format != cur.Log.Format || old.Log.File != cur.Log.File ||
+			old.Log.MaxSize != cur.Log.MaxSize || old.Log.MaxBackups != cur.Log.MaxBackups},
+		{"otel", old.Otel.Endpoint != cur.Otel.Endpoint || old.Otel.Interval != cur.Otel.Interval ||
+			!maps.Equal(old.Otel.Headers, cur.Otel.Headers)},
+		{"ubus", old.Ubus != cur.Ubus},
+		{"hostapd", old.Hostapd != cur.Hostapd},
+		{"wireguard", old.WireGuard != cur.WireGuard},
+		{"openvpn", !slices.Equal(old.OpenVPN, cur.OpenVPN)},
+		{"name_probe", old.NameProbe != cur.NameProbe},
+		{"source", old.Source != cur.Source || old.CaptureSample != cur.CaptureSample || old.MonitorMode != cur.MonitorMode ||
+			old.ConntrackMark != cur.ConntrackMark || old.OffloadFallback != cur.OffloadFallback},
+		{"storage_path", old.StoragePath != cur.StoragePath},
+		{"state_file", old.StateFile != cur.StateFile},
+		{"wal", old.WALDir != cur.WALDir || old.WALInterval != cur.WALInterval || old.WALSegmentSize != cur.WALSegmentSize},
+		{"api_v2", old.APIv2 != cur.APIv2},
+		{"sni_sniff", old.SNISniff != cur.SNISniff},
+		{"rtt_sniff", old.RTTSniff != cur.RTTSniff},
+		{"timezone", old.Timezone != cur.Timezone || old.UsageTimezone != cur.UsageTimezone || old.UsageDays != cur.UsageDays},
+		{"netflow", old.NetFlowCollector != cur.NetFlowCollector || old.NetFlowVersion != cur.NetFlowVersion ||
+			old.NetFlowInterval != cur.NetFlowInterval},
+		{"remote_write", old.RemoteWriteURL != cur.RemoteWriteURL || old.RemoteWriteInterval != cur.RemoteWriteInterval ||
+			old.RemoteWriteUser != cur.RemoteWriteUser || old.RemoteWritePassword != cur.RemoteWritePassword ||
+			old.RemoteWriteToken != cur.RemoteWriteToken},
+	}
+	for _, s := range restartOnly {
+		if s.changed {
+			slog.Warn("Reload: setting changed, restart required to apply", "key", s.key)
+		}
+	}
+}
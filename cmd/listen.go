@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// unixPrefix marks a listen address as a Unix domain socket, e.g. "unix:/run/catchmole.sock"
+const unixPrefix = "unix:"
+
+// Permissions of Unix domain sockets by default: owner and group, e.g. a
+// reverse proxy running in the same group
+const defaultSocketMode = 0o660
+
+// listen opens a TCP listener, or a Unix domain socket for "unix:" addresses.
+// A stale socket left by a crash is replaced; the socket file is removed
+// again when the listener is closed on shutdown.
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	// Created with the final permissions, not briefly with the umask's
+	umask := syscall.Umask(0o777 &^ int(mode.Perm()))
+	l, err := net.Listen("unix", path)
+	syscall.Umask(umask)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return l, nil
+}
+
+// unixDialer connects HTTP clients to a Unix domain socket, whatever the URL host
+func unixDialer(path string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+}
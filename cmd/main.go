@@ -1,152 +1,765 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/BurntSushi/toml"
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/alert"
+	grpcapi "github.com/kisy/catchmole/pkg/api/grpc"
+	"github.com/kisy/catchmole/pkg/dnswatch"
+	"github.com/kisy/catchmole/pkg/export/netflow"
+	"github.com/kisy/catchmole/pkg/federation"
+	"github.com/kisy/catchmole/pkg/geo"
+	"github.com/kisy/catchmole/pkg/history"
+	"github.com/kisy/catchmole/pkg/integrations/hostapd"
+	"github.com/kisy/catchmole/pkg/integrations/openwrt"
+	"github.com/kisy/catchmole/pkg/integrations/tunnel"
+	"github.com/kisy/catchmole/pkg/logging"
 	"github.com/kisy/catchmole/pkg/metrics"
 	"github.com/kisy/catchmole/pkg/monitor"
+	"github.com/kisy/catchmole/pkg/monitor/ebpf"
+	"github.com/kisy/catchmole/pkg/monitor/scanner"
+	"github.com/kisy/catchmole/pkg/report"
+	"github.com/kisy/catchmole/pkg/rttwatch"
+	"github.com/kisy/catchmole/pkg/shaping"
+	"github.com/kisy/catchmole/pkg/sniwatch"
 	"github.com/kisy/catchmole/pkg/stats"
+	"github.com/kisy/catchmole/pkg/storage"
+	"github.com/kisy/catchmole/pkg/telemetry"
+	"github.com/kisy/catchmole/pkg/units"
 	"github.com/kisy/catchmole/web"
 	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/credentials"
 )
 
-type Config struct {
-	Listen          string            `toml:"listen"`
-	Interface       string            `toml:"interface"`
-	IgnoreLAN       bool              `toml:"ignore_lan"`
-	RefreshInterval int               `toml:"interval"`
-	FlowTTL         int               `toml:"flow_ttl"`
-	Devices         map[string]string `toml:"devices"`
-	IpTools         map[string]string `toml:"ip_tools"`
+// Time allowed for in-flight HTTP requests on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// fatal logs an error and exits, for failures before cleanup is registered
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func main() {
-	var configFile string
-	var listenAddr string
-	var enableLAN bool
-	var interval int
-	var ifaceName string
-	var flowTTL int
-
-	flag.StringVar(&configFile, "c", "config.toml", "Path to configuration file")
-	flag.StringVar(&ifaceName, "i", "", "Interface to monitor")
-	flag.StringVar(&listenAddr, "s", "", "Server listen address (overrides config)")
-	flag.BoolVar(&enableLAN, "lan", false, "Enable monitoring of LAN-to-LAN traffic")
-	flag.IntVar(&interval, "interval", 0, "Data refresh interval in seconds (default 1)")
-	flag.IntVar(&flowTTL, "flow-ttl", 0, "Flow cache TTL in seconds (default 60)")
-	flag.Parse()
+	// catchmole top / client / reset query a running daemon
+	if ran, code := runSubcommand(os.Args[1:]); ran {
+		os.Exit(code)
+	}
 
-	// Load Config
-	var config Config
-	config.IgnoreLAN = true // Default to true (ignore LAN traffic)
+	var flags cliFlags
 
-	if _, err := os.Stat(configFile); err == nil {
-		if _, err := toml.DecodeFile(configFile, &config); err != nil {
-			log.Fatalf("Failed to parse config file: %v", err)
-		}
-		log.Printf("Loaded config from %s", configFile)
-	} else if os.IsNotExist(err) && configFile != "config.toml" {
-		// Only error if user explicitly provided a config file that doesn't exist
-		log.Fatalf("Config file not found: %s", configFile)
-	}
+	flag.StringVar(&flags.configFile, "c", "config.toml", "Path to configuration file")
+	flag.StringVar(&flags.ifaceName, "i", "", "Interface to monitor")
+	flag.StringVar(&flags.listenAddr, "s", "", "Server listen address (overrides config)")
+	flag.BoolVar(&flags.enableLAN, "lan", false, "Enable monitoring of LAN-to-LAN traffic")
+	flag.IntVar(&flags.interval, "interval", 0, "Data refresh interval in seconds (default 1)")
+	flag.IntVar(&flags.flowTTL, "flow-ttl", 0, "Flow cache TTL in seconds (default 60)")
+	flag.BoolVar(&flags.paused, "paused", false, "Start with traffic accounting paused (resume via /api/monitor/resume or catchmole resume)")
+	flag.StringVar(&flags.netns, "netns", "", "Network namespace to monitor, e.g. /host/netns mounted from the host's /proc/1/ns/net")
+	flag.StringVar(&flags.mode, "monitor-mode", "", `How the conntrack source reads flows (default hybrid):
+  hybrid  conntrack events plus a table dump every interval; accurate
+          live speeds and connection churn, dump cost grows with the table
+  poll    table dumps only; for kernels with unreliable events (hardware or
+          flow offload), no new/closed connection counts, closed flows
+          linger until flow_ttl
+  events  conntrack events only; cheapest with large tables (100k flows),
+          but the kernel reports bytes only on state changes and DESTROY,
+          so speeds of long-lived flows are bursty`)
+	flag.Parse()
 
-	// Flag overrides config
-	if listenAddr != "" {
-		config.Listen = listenAddr
-	}
-	if interval > 0 {
-		config.RefreshInterval = interval
-	}
-	if flowTTL > 0 {
-		config.FlowTTL = flowTTL
+	// Load Config
+	config, err := loadConfig(flags)
+	if err != nil {
+		fatal("Failed to load config", "err", err)
 	}
-	if ifaceName != "" {
-		config.Interface = ifaceName
+	if err := logging.Setup(config.Log); err != nil {
+		fatal("Failed to set up logging", "err", err)
 	}
+	byteUnits, _ := units.Parse(config.ByteUnits) // Validated by loadConfig
+	units.Set(byteUnits)
 
-	// Default interval
-	if config.RefreshInterval <= 0 {
-		config.RefreshInterval = 1
+	slog.Info("Starting CatchGhost Monitor...")
+
+	// Settings changed via /api/settings override the config file
+	settings, err := storage.OpenSettingsStore(config.SettingsFile)
+	if err != nil {
+		fatal("Failed to load runtime settings", "err", err)
 	}
-	// Default TTL
-	if config.FlowTTL <= 0 {
-		config.FlowTTL = 60
+	settings.SetConfigSettings(config.settings())
+	config.applyRuntime(settings.RuntimeSettings())
+
+	// Exit non-zero after deferred cleanup has run (registered first, runs last)
+	exitCode := 0
+	defer func() { os.Exit(exitCode) }()
+	defer logging.Close()
+
+	if config.Otel.Endpoint != "" {
+		shutdown, err := telemetry.Start(context.Background(), config.Otel)
+		if err != nil {
+			fatal("Failed to set up telemetry export", "err", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				slog.Warn("Failed to flush telemetry", "err", err)
+			}
+		}()
+		slog.Info("Exporting telemetry", "endpoint", config.Otel.Endpoint)
 	}
 
-	if config.Listen == "" {
-		config.Listen = ":8080" // Default
+	// In a container, watch the host's network namespace if one is given
+	if config.NetNS != "" {
+		if !monitor.HasCapability(monitor.CapSysAdmin) {
+			fatal("Cannot monitor another network namespace", "err", monitor.MissingCapability("netns", monitor.CapSysAdmin))
+		}
+		if err := monitor.SetNetNS(config.NetNS); err != nil {
+			fatal("Failed to use network namespace", "path", config.NetNS, "err", err)
+		}
+		slog.Info("Monitoring network namespace", "path", config.NetNS)
 	}
-	if enableLAN {
-		config.IgnoreLAN = false
+	if config.Interface != "" {
+		if _, err := monitor.Netlink().LinkByName(config.Interface); err != nil && monitor.InContainer() && config.NetNS == "" {
+			fatal("Interface not found in the container's network namespace, run it with --network host or set netns (-netns) to the host's namespace",
+				"iface", config.Interface, "err", err)
+		}
 	}
 
-	log.Println("Starting CatchGhost Monitor...")
-
 	// 1. Initialize Neighbor Watcher (IP -> MAC)
 	nw := monitor.NewNeighborWatcher()
 	// nw.Start() -> We now manually trigger refresh in Aggregator
-	// defer nw.Stop()
+	// Netlink updates catch rotating IPv6 addresses between refreshes
+	if err := nw.Subscribe(); err != nil {
+		slog.Warn("Failed to subscribe to neighbor updates", "err", err)
+	}
+	defer nw.Stop()
+
+	// Features lacking capabilities are turned off and listed in /api/meta
+	var degraded []monitor.Degradation
 
-	// 2. Initialize Conntrack Monitor
-	mon := monitor.NewConntrackMonitor(nw)
-	if err := mon.Start(time.Duration(config.RefreshInterval) * time.Second); err != nil {
-		log.Fatalf("Failed to start Conntrack monitor: %v", err)
+	// 2. Initialize Traffic Source (conntrack, or packet capture as fallback)
+	mon, err := startTrafficSource(config, nw, &degraded)
+	if err != nil {
+		fatal("Failed to start traffic source", "err", err)
 	}
-	defer mon.Stop()
+
+	// Watchdog restarts the monitor if the pipeline stalls
+	wd := monitor.NewWatchdog(mon, config.Interface, time.Duration(config.WatchdogTimeout)*time.Second)
+	wd.Start()
 
 	// 3. Initialize Aggregator
 	agg := stats.NewAggregator(mon, nw)
 	if config.Interface != "" {
 		if err := agg.SetInterface(config.Interface); err != nil {
-			log.Printf("Warning: Failed to set interface %s: %v", config.Interface, err)
+			slog.Warn("Failed to set interface", "iface", config.Interface, "err", err)
 		} else {
-			log.Printf("Monitoring specific interface: %s", config.Interface)
+			slog.Info("Monitoring specific interface", "iface", config.Interface)
 		}
 	}
 
 	agg.SetIgnoreLAN(config.IgnoreLAN)
 	if config.IgnoreLAN {
-		log.Println("LAN-to-LAN traffic monitoring DISABLED (default)")
+		slog.Info("LAN-to-LAN traffic monitoring disabled (default)")
 	} else {
-		log.Println("LAN-to-LAN traffic monitoring ENABLED")
+		slog.Info("LAN-to-LAN traffic monitoring enabled")
+	}
+	devices, err := storage.OpenDeviceStore(config.DevicesFile)
+	if err != nil {
+		fatal("Failed to load device names", "err", err)
+	}
+	devices.SetConfigDevices(config.Devices)
+	agg.SetDeviceNames(devices.Names()) // Set static names
+	agg.SetDeviceTags(devices.Tags())
+	agg.SetDevicePlans(devices.Plans())
+	aliases, err := storage.OpenAliasStore(config.AliasesFile)
+	if err != nil {
+		fatal("Failed to load aliases", "err", err)
+	}
+	aliases.SetConfigAliases(config.Aliases)
+	if err := agg.SetAliases(aliases.Aliases()); err != nil {
+		fatal("Invalid aliases config", "err", err)
+	}
+	if len(config.PrivateMACs) > 0 {
+		agg.SetPrivateMACs(config.PrivateMACs)
+		slog.Info("Private clients, flows are not recorded", "macs", len(config.PrivateMACs))
+	}
+	agg.SetGroups(config.Groups)
+	agg.SetIdleGap(time.Duration(config.IdleGap) * time.Minute)
+	if config.ClientRetention > 0 {
+		agg.SetClientRetention(time.Duration(config.ClientRetention) * 24 * time.Hour)
+	}
+	if config.RouterTraffic {
+		agg.SetRouterTraffic(true)
+		slog.Info("Accounting router's own traffic", "client", stats.RouterMAC)
+	}
+	if len(config.DHCPLeases) > 0 {
+		lw := monitor.NewLeaseWatcher(config.DHCPLeases)
+		lw.Start()
+		defer lw.Stop()
+		agg.SetLeaseWatcher(lw)
+		slog.Info("Resolving hostnames from DHCP leases", "files", config.DHCPLeases)
+	}
+	if config.Ubus {
+		if openwrt.Available() {
+			uw := openwrt.NewWatcher()
+			uw.Start()
+			defer uw.Stop()
+			agg.SetUbusWatcher(uw)
+			slog.Info("Reading device metadata from ubus")
+		} else {
+			slog.Warn("ubus not found, OpenWrt integration disabled")
+		}
+	}
+	wireguard := config.WireGuard
+	if wireguard && !tunnel.WireGuardAvailable() {
+		slog.Warn("wg not found, WireGuard peers are not accounted")
+		wireguard = false
+	}
+	if wireguard || len(config.OpenVPN) > 0 {
+		tw := tunnel.NewWatcher(wireguard, config.OpenVPN)
+		tw.Start()
+		defer tw.Stop()
+		agg.SetTunnelWatcher(tw)
+		slog.Info("Accounting VPN peers as clients", "wireguard", wireguard, "openvpn", config.OpenVPN)
+	}
+	if config.NameProbe {
+		agg.SetNameResolver(monitor.NewNameResolver(nw))
+		slog.Info("Naming unnamed clients via mDNS, NetBIOS and LLMNR")
 	}
-	agg.SetDeviceNames(config.Devices) // Set static names
 	agg.SetFlowTTL(time.Duration(config.FlowTTL) * time.Second)
-	log.Printf("Flow cache TTL: %d seconds", config.FlowTTL)
+	agg.SetArchiveSize(config.FlowArchive)
+	agg.SetProtoTTLs(time.Duration(config.TCPTTL)*time.Second, time.Duration(config.UDPTTL)*time.Second, time.Duration(config.ICMPTTL)*time.Second)
+	slog.Info("Flow cache TTL", "seconds", config.FlowTTL, "tcp", config.TCPTTL, "udp", config.UDPTTL, "icmp", config.ICMPTTL)
+	dnsSniff := config.DNSSniff && capable(&degraded, "dns_sniff", "hostnames from PTR lookups only", monitor.CapNetRaw)
+	if dnsSniff || config.DNSPTR {
+		dw := dnswatch.NewWatcher(config.Interface, dnsSniff, config.DNSPTR)
+		if err := dw.Start(); err != nil {
+			slog.Warn("Failed to start DNS sniffing", "err", err)
+		} else {
+			defer dw.Stop()
+			agg.SetDNSWatcher(dw)
+			slog.Info("Remote hostname labeling enabled", "sniff", dnsSniff, "ptr", config.DNSPTR)
+		}
+	}
+	if config.SNISniff && capable(&degraded, "sni_sniff", "flows are not labeled with server names", monitor.CapNetRaw) {
+		sw := sniwatch.NewWatcher(config.Interface)
+		if err := sw.Start(); err != nil {
+			slog.Warn("Failed to start SNI sniffing", "err", err)
+		} else {
+			defer sw.Stop()
+			agg.SetSNIWatcher(sw)
+			slog.Info("TLS/QUIC server name labeling enabled")
+		}
+	}
+	if config.RTTSniff && capable(&degraded, "rtt_sniff", "no latency estimates", monitor.CapNetRaw) {
+		rw := rttwatch.NewWatcher(config.Interface)
+		if err := rw.Start(); err != nil {
+			slog.Warn("Failed to start RTT sniffing", "err", err)
+		} else {
+			defer rw.Stop()
+			agg.SetRTTWatcher(rw)
+			slog.Info("Latency estimation from TCP handshakes enabled")
+		}
+	}
+	if config.GeoIPCountryDB != "" || config.GeoIPASNDB != "" {
+		gr, err := geo.Open(config.GeoIPCountryDB, config.GeoIPASNDB)
+		if err != nil {
+			slog.Warn("Failed to load GeoIP databases", "err", err)
+		} else {
+			defer gr.Close()
+			agg.SetGeoResolver(gr)
+			slog.Info("GeoIP enrichment enabled")
+		}
+	}
+	agg.SetBlockedCountries(config.BlockedCountries)
+	if err := agg.SetPortGroups(config.PortGroups); err != nil {
+		fatal("Invalid port_groups config", "err", err)
+	}
+	if err := agg.SetMarkClasses(config.MarkClasses); err != nil {
+		fatal("Invalid mark_classes config", "err", err)
+	}
+	if err := agg.SetZones(config.Zones, config.IgnoreZones); err != nil {
+		fatal("Invalid zones config", "err", err)
+	}
+	if err := agg.SetLinkCapacity(config.LinkCapacity, config.ZoneCapacity); err != nil {
+		fatal("Invalid link capacity config", "err", err)
+	}
+	if err := agg.SetIgnoreRules(config.IgnoreSubnets, config.IgnorePorts, config.IgnoreMACs); err != nil {
+		fatal("Invalid ignore lists", "err", err)
+	}
+	if err := agg.SetTags(config.Tags, config.ExcludeTags); err != nil {
+		fatal("Invalid tags", "err", err)
+	}
+	if err := agg.SetNAT64Prefixes(config.NAT64Prefixes); err != nil {
+		fatal("Invalid nat64_prefixes", "err", err)
+	}
+	agg.SetMaxDelta(config.MaxDelta)
+	agg.SetTableLimits(config.MaxFlows, config.MaxClients)
+	smoothing := model.Smoothing{Alpha: config.EMAAlpha, MinElapsedMs: config.SpeedMinElapsed, WindowSeconds: config.SpeedWindow}
+	if err := agg.SetSmoothing(smoothing); err != nil {
+		fatal("Invalid smoothing settings", "err", err)
+	}
+	agg.SetElephantThresholds(config.ElephantBytes, config.ElephantRate, time.Duration(config.ElephantSustain)*time.Second)
+	agg.SetAnomalyThresholds(config.AnomalyThreshold, config.AnomalyMinUpload)
+	agg.SetFanoutThresholds(config.FanoutThreshold, time.Duration(config.FanoutWindow)*time.Second)
+	if err := agg.SetPlans(config.Plans, time.Duration(config.PlanSustain)*time.Second); err != nil {
+		fatal("Invalid plans", "err", err)
+	}
+	if flags.paused {
+		agg.Pause()
+	}
+
+	// Restore persisted totals before aggregation starts
+	var totalsSaved, usageSaved time.Time // Of the restored snapshots, for WAL replay
+	if config.StoragePath != "" {
+		store, err := storage.Open(config.StoragePath, agg, time.Duration(config.StorageRetention)*24*time.Hour)
+		if err != nil {
+			fatal("Failed to open storage", "path", config.StoragePath, "err", err)
+		}
+		if err := store.Restore(); err != nil {
+			slog.Warn("Failed to restore stats", "err", err)
+		}
+		totalsSaved = store.SavedAt()
+		store.Start(time.Duration(config.StorageInterval) * time.Second)
+		defer store.Stop()
+		slog.Info("Persisting stats", "path", config.StoragePath, "interval", config.StorageInterval)
+	}
+
+	// Calendar-aligned usage rollups (validated in loadConfig)
+	usage := stats.NewUsageRollup(agg, config.location(config.UsageTimezone), config.UsageDays)
+
+	// Traffic history for graphs and weekly heatmaps
+	hist := history.NewRecorder(agg)
+	hist.SetLocation(config.location(""))
+
+	if config.StateFile != "" {
+		sf := storage.NewStateFile(config.StateFile, agg)
+		sf.SetUsage(usage)
+		sf.SetHistory(hist)
+		sf.SetRestoredTotals(totalsSaved) // The newer of database and state file wins
+		if err := sf.Restore(); err != nil {
+			slog.Warn("Failed to restore state file", "err", err)
+		}
+		if usageSaved = sf.SavedAt(); usageSaved.After(totalsSaved) {
+			totalsSaved = usageSaved
+		}
+		sf.Start(time.Duration(config.StateInterval) * time.Second)
+		defer sf.Stop()
+		slog.Info("Saving state", "path", config.StateFile, "interval", config.StateInterval)
+	}
 
-	log.Printf("Starting Aggregator with refresh interval: %d seconds", config.RefreshInterval)
+	// Deltas since the last snapshot, so a crash leaves no gap
+	if config.WALDir != "" {
+		wal, err := storage.OpenWAL(config.WALDir, agg, time.Duration(config.UsageDays)*24*time.Hour, int64(config.WALSegmentSize)*1024*1024)
+		if err != nil {
+			fatal("Failed to open write-ahead log", "dir", config.WALDir, "err", err)
+		}
+		wal.SetUsage(usage)
+		wal.SetHistory(hist)
+		if err := wal.Replay(totalsSaved, usageSaved); err != nil {
+			slog.Warn("Failed to replay write-ahead log", "err", err)
+		}
+		if err := wal.Start(time.Duration(config.WALInterval) * time.Second); err != nil {
+			fatal("Failed to start write-ahead log", "dir", config.WALDir, "err", err)
+		}
+		defer wal.Stop()
+		slog.Info("Write-ahead log enabled", "dir", config.WALDir, "interval", config.WALInterval)
+	}
+
+	slog.Info("Starting aggregator", "interval", config.RefreshInterval)
 	agg.Start(time.Duration(config.RefreshInterval) * time.Second)
 
+	if config.NetFlowCollector != "" {
+		nf, err := netflow.New(agg, config.NetFlowCollector, config.NetFlowVersion)
+		if err != nil {
+			fatal("Failed to set up NetFlow export", "err", err)
+		}
+		nf.Start(time.Duration(config.NetFlowInterval) * time.Second)
+		defer nf.Stop()
+		slog.Info("Exporting NetFlow", "version", config.NetFlowVersion, "collector", config.NetFlowCollector, "interval", config.NetFlowInterval)
+	}
+
+	usage.Start()
+	defer usage.Stop()
+	slog.Info("Usage rollups", "days", config.UsageDays, "timezone", usage.Location().String())
+	if config.SessionReset != "" {
+		sched, _ := stats.ParseSessionSchedule(config.SessionReset) // Validated by loadConfig
+		loc := config.location(config.SessionResetTimezone)
+		agg.SetSessionReset(sched, loc)
+		slog.Info("Session totals roll over on schedule", "schedule", config.SessionReset, "timezone", loc.String())
+	}
+
+	// Alert notifications
+	var alerts *alert.Engine
+	if config.Alert.Enabled() {
+		alerts, err = alert.NewEngine(agg, usage, config.Alert)
+		if err != nil {
+			fatal("Invalid alert config", "err", err)
+		}
+		alerts.SetWatchdog(wd)
+		alerts.Start()
+		defer alerts.Stop()
+		slog.Info("Alerting enabled")
+	}
+
+	// Wi-Fi associations, after alerts so joins notify right away
+	if config.Hostapd != "" {
+		hw := hostapd.NewWatcher(config.Hostapd, func(mac string) {
+			agg.StationJoined(mac)
+			if alerts != nil {
+				alerts.Trigger()
+			}
+		})
+		if err := hw.Start(); err != nil {
+			slog.Warn("Failed to follow hostapd, Wi-Fi association events disabled", "err", err)
+		} else {
+			defer hw.Stop()
+			agg.SetHostapdWatcher(hw)
+			slog.Info("Following Wi-Fi associations from hostapd", "dir", config.Hostapd)
+		}
+	}
+
+	// Scheduled usage reports
+	var reporter *report.Reporter
+	if config.Report.Schedule != "" {
+		reporter, err = report.NewReporter(agg, usage, config.Report)
+		if err != nil {
+			fatal("Invalid report config", "err", err)
+		}
+		reporter.Start()
+		defer reporter.Stop()
+		slog.Info("Usage reports enabled", "schedule", config.Report.Schedule, "period", config.Report.Period)
+	}
+
+	// Per-client rate limits
+	var shaper *shaping.Controller
+	if config.Shaping.Enabled && capable(&degraded, "shaping", "rate limits are not applied", monitor.CapNetAdmin) {
+		shaper, err = shaping.NewController(agg, config.Shaping)
+		if err != nil {
+			fatal("Invalid shaping config", "err", err)
+		}
+		shaper.Start()
+		defer shaper.Stop()
+		slog.Info("Traffic shaping enabled", "limits", len(config.Shaping.Limits), "tags", len(config.Shaping.Tags))
+	}
+
+	// Active discovery, also started when disabled so a reload can enable it
+	scan := scanner.New(config.Scan, config.Interface)
+	agg.SetScanner(scan)
+	scan.Start()
+	defer scan.Stop()
+	if config.Scan.Enabled {
+		capable(&degraded, "scan", "IPv6 devices are found only once they send traffic", monitor.CapNetRaw)
+		if config.Interface == "" && len(config.Scan.Interfaces) == 0 {
+			slog.Warn("Scan enabled without interface, set interface or scan.interfaces")
+		}
+		slog.Info("Active device discovery enabled")
+	}
+
+	// Federation, also started without sites so a reload can add them
+	fed := federation.NewPoller(agg, config.Federation)
+	fed.Start()
+	defer fed.Stop()
+	if len(config.Federation.Sites) > 0 {
+		slog.Info("Federating remote sites", "sites", len(config.Federation.Sites))
+	}
+
+	hist.Start()
+	defer hist.Stop()
+
 	// 4. Initialize Prometheus Exporter
-	exporter := metrics.NewExporter(agg)
+	exporter := metrics.NewExporter(agg, mon)
 	prometheus.MustRegister(exporter)
+	prometheus.MustRegister(fed)
+	if config.RemoteWriteURL != "" {
+		rw := metrics.NewRemoteWriter(config.RemoteWriteURL, prometheus.DefaultGatherer)
+		rw.SetBasicAuth(config.RemoteWriteUser, config.RemoteWritePassword)
+		rw.SetBearerToken(config.RemoteWriteToken)
+		rw.Start(time.Duration(config.RemoteWriteInterval) * time.Second)
+		defer rw.Stop()
+		slog.Info("Pushing metrics via remote write", "url", config.RemoteWriteURL, "interval", config.RemoteWriteInterval)
+	}
 
 	// 5. Initialize Web Server
-	srv := web.NewServer(agg, config.IpTools, config.FlowTTL)
+	srv := web.NewServer(agg, wd, hist, usage, config.IpTools)
+	srv.SetDeviceStore(devices)
+	srv.SetAliasStore(aliases)
+	srv.SetSettingsStore(settings, mon, config.Interface)
+	srv.SetBackups(storage.NewBackups(agg, usage, hist, devices, aliases))
+	srv.SetShaper(shaper)
+	if capable(&degraded, "capture", "per-client packet captures are unavailable", monitor.CapNetRaw) {
+		srv.SetCaptureInterface(config.Interface)
+	}
+	srv.SetDegraded(degraded)
+	srv.SetReporter(reporter)
+	srv.SetAlerts(alerts)
+	srv.SetFederation(fed)
 	srv.RegisterHandlers()
+	if config.APIv2 {
+		srv.RegisterV2Handlers()
+		slog.Info("API v2 enabled", "prefix", "/api/v2/")
+	}
+	srv.SetAuth(config.AuthUser, config.AuthPassword, config.AuthToken)
+	srv.SetRateLimit(config.APIRateLimit, config.APIBurst)
+	if config.AuditFile != "" {
+		if err := srv.SetAuditFile(config.AuditFile); err != nil {
+			slog.Warn("Failed to open audit file", "path", config.AuditFile, "err", err)
+		}
+	}
+	if srv.AuthEnabled() {
+		slog.Info("Web authentication enabled")
+	}
 
 	// 6. Run Server
-	server := &http.Server{Addr: config.Listen}
+	var servers []*http.Server
+	serverErr := make(chan error, 4)
+	if config.MetricsListen != "" {
+		l, err := listen(config.MetricsListen, config.socketMode)
+		if err != nil {
+			fatal("Failed to listen for metrics", "addr", config.MetricsListen, "err", err)
+		}
+		server := &http.Server{Addr: config.MetricsListen, Handler: srv.MetricsHandler()}
+		servers = append(servers, server)
+		go func() {
+			slog.Info("Metrics server listening", "addr", config.MetricsListen)
+			if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+				serverErr <- err
+			}
+		}()
+	}
+	if config.Listen != "" {
+		l, err := listen(config.Listen, config.socketMode)
+		if err != nil {
+			fatal("Failed to listen", "addr", config.Listen, "err", err)
+		}
+		server := &http.Server{Addr: config.Listen, Handler: srv.Handler()}
+		servers = append(servers, server)
+		go func() {
+			slog.Info("Web server listening", "addr", config.Listen)
+			if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+				serverErr <- err
+			}
+		}()
+	}
+	if config.ListenTLS != "" {
+		cert, err := web.LoadOrCreateCert(config.CertFile, config.KeyFile, config.selfSigned)
+		if err != nil {
+			fatal("Failed to load TLS certificate", "cert", config.CertFile, "key", config.KeyFile, "err", err)
+		}
 
-	go func() {
-		log.Printf("Web server listening on %s", config.Listen)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("HTTP server error: %v", err)
+		server := &http.Server{
+			Addr:      config.ListenTLS,
+			Handler:   srv.Handler(),
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+		}
+		servers = append(servers, server)
+		go func() {
+			slog.Info("Web server listening (HTTPS)", "addr", config.ListenTLS, "cert", config.CertFile)
+			if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				serverErr <- err
+			}
+		}()
+	}
+
+	var gsrv *grpcapi.Server
+	if config.GRPCListen != "" {
+		var creds credentials.TransportCredentials
+		if config.GRPCTLS {
+			cert, err := web.LoadOrCreateCert(config.CertFile, config.KeyFile, config.selfSigned)
+			if err != nil {
+				fatal("Failed to load TLS certificate", "cert", config.CertFile, "key", config.KeyFile, "err", err)
+			}
+			creds = credentials.NewServerTLSFromCert(&cert)
+		}
+		l, err := net.Listen("tcp", config.GRPCListen)
+		if err != nil {
+			fatal("Failed to listen for gRPC", "addr", config.GRPCListen, "err", err)
 		}
-	}()
+		gsrv = grpcapi.NewServer(agg, creds)
+		gsrv.SetAuth(config.AuthUser, config.AuthPassword, config.AuthToken)
+		go func() {
+			slog.Info("gRPC server listening", "addr", config.GRPCListen, "tls", config.GRPCTLS)
+			if err := gsrv.Serve(l); err != nil {
+				serverErr <- err
+			}
+		}()
+	}
 
-	// 7. Wait for interrupt
+	// 7. Wait for interrupt or server failure, reload config on SIGHUP
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+wait:
+	for {
+		select {
+		case err := <-serverErr:
+			slog.Error("Server error", "err", err)
+			exitCode = 1
+			break wait
+		case sig := <-sigCh:
+			if sig != syscall.SIGHUP {
+				slog.Info("Signal received", "signal", sig.String())
+				break wait
+			}
+
+			slog.Info("SIGHUP received, reloading config")
+			newConfig, err := loadConfig(flags)
+			if err != nil {
+				slog.Error("Reload failed, keeping current config", "err", err)
+				continue
+			}
+			// Keep the settings changed via the API on both sides, only config file edits apply
+			settings.SetConfigSettings(newConfig.settings())
+			config.applyRuntime(settings.RuntimeSettings())
+			newConfig.applyRuntime(settings.RuntimeSettings())
+			applyReload(config, newConfig, agg, mon, srv, gsrv, alerts, devices, aliases, shaper, scan, reporter, fed)
+			config = newConfig
+		}
+	}
+
+	slog.Info("Shutting down")
+
+	// Drain HTTP requests
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Warn("HTTP shutdown", "addr", server.Addr, "err", err)
+		}
+	}
+	if gsrv != nil {
+		gsrv.Stop(ctx)
+	}
+
+	// Stop the pipeline in order: no more restarts, close conntrack sockets,
+	// then let the aggregator process what is left
+	wd.Stop()
+	mon.Stop()
+	agg.Stop()
 
-	log.Println("Shutting down...")
-	// Cleanup happens via defers
+	// Persistence flushes the final totals via defers
+}
+
+// capable reports whether c is available, recording feature as degraded if not
+func capable(degraded *[]monitor.Degradation, feature, detail string, c monitor.Capability) bool {
+	if monitor.HasCapability(c) {
+		return true
+	}
+	slog.Warn("Feature degraded, capability missing", "feature", feature, "capability", c.String(), "effect", detail)
+	*degraded = append(*degraded, monitor.Degradation{Feature: feature, Capability: c.String(), Detail: detail})
+	return false
+}
+
+// checkOffload reports whether conntrack counts all traffic of offloaded
+// connections. Flowtables without counters freeze the conntrack counters of
+// the connections they offload; mode "counter" enables them in place.
+func checkOffload(mode string) bool {
+	fts, err := monitor.Flowtables()
+	if err != nil {
+		slog.Debug("Failed to list flowtables", "err", err)
+		return true // No nft, no flowtables
+	}
+
+	counted := true
+	for _, ft := range fts {
+		if ft.Counter {
+			continue
+		}
+		if mode == monitor.OffloadCounter {
+			err := monitor.EnableFlowtableCounter(ft)
+			if err == nil {
+				slog.Info("Enabled flowtable counters", "table", ft.Table, "flowtable", ft.Name)
+				continue
+			}
+			slog.Warn("Failed to enable flowtable counters", "table", ft.Table, "flowtable", ft.Name, "err", err)
+		}
+		slog.Warn("Flowtable offloads connections without counters, their traffic is under-reported",
+			"table", ft.Table, "flowtable", ft.Name, "hardware", ft.Hardware)
+		counted = false
+	}
+	return counted
+}
+
+// startTrafficSource starts the configured source. In auto mode conntrack is
+// preferred and packet capture is used when accounting is off or conntrack fails.
+func startTrafficSource(config *Config, nw *monitor.NeighborWatcher, degraded *[]monitor.Degradation) (monitor.TrafficSource, error) {
+	interval := time.Duration(config.RefreshInterval) * time.Second
+
+	if config.Source == "ebpf" {
+		if !monitor.HasCapability(monitor.CapNetAdmin) {
+			return nil, monitor.MissingCapability("source ebpf", monitor.CapNetAdmin)
+		}
+		if !monitor.HasCapability(monitor.CapBPF) && !monitor.HasCapability(monitor.CapSysAdmin) {
+			return nil, monitor.MissingCapability("source ebpf", monitor.CapBPF)
+		}
+		src := ebpf.NewSource(config.Interface)
+		if err := src.Start(interval); err != nil {
+			return nil, err
+		}
+		slog.Info("Traffic source: eBPF", "iface", config.Interface)
+		return src, nil
+	}
+
+	if config.Source == "conntrack" && !monitor.HasCapability(monitor.CapNetAdmin) {
+		return nil, monitor.MissingCapability("source conntrack", monitor.CapNetAdmin)
+	}
+	if config.Source == "auto" && !monitor.HasCapability(monitor.CapNetAdmin) {
+		if !monitor.HasCapability(monitor.CapNetRaw) {
+			return nil, fmt.Errorf("no traffic source available: %w, %w",
+				monitor.MissingCapability("conntrack", monitor.CapNetAdmin), monitor.MissingCapability("packet capture", monitor.CapNetRaw))
+		}
+		capable(degraded, "conntrack", "traffic from packet capture: no connection states, NAT or zones, more CPU", monitor.CapNetAdmin)
+	} else if config.Source != "packet" {
+		offloadCounted := checkOffload(config.OffloadFallback)
+		if config.Source == "auto" && !monitor.ConntrackAccounting() {
+			slog.Warn("Conntrack accounting (nf_conntrack_acct) is off, falling back to packet capture")
+		} else if config.Source == "auto" && !offloadCounted && config.OffloadFallback == monitor.OffloadCapture {
+			slog.Warn("Flow offload bypasses conntrack counters, falling back to packet capture")
+		} else {
+			if !offloadCounted {
+				*degraded = append(*degraded, monitor.Degradation{Feature: "flow offload",
+					Detail: "traffic of offloaded connections is under-reported, see offload_fallback"})
+			}
+			mon := monitor.NewConntrackMonitor(nw)
+			if err := mon.SetMode(config.MonitorMode); err != nil {
+				return nil, err
+			}
+			if config.ConntrackMark != "" {
+				value, mask, _ := monitor.ParseMark(config.ConntrackMark) // Validated on load
+				mon.SetMarkFilter(value, mask)
+			}
+			err := mon.Start(interval)
+			if err == nil {
+				slog.Info("Traffic source: conntrack", "mode", config.MonitorMode)
+				return mon, nil
+			}
+			if config.Source == "conntrack" {
+				return nil, err
+			}
+			slog.Warn("Conntrack unavailable, falling back to packet capture", "err", err)
+		}
+	}
+
+	if !monitor.HasCapability(monitor.CapNetRaw) {
+		return nil, monitor.MissingCapability("packet capture", monitor.CapNetRaw)
+	}
+	if config.Interface == "" {
+		slog.Warn("Packet capture without interface set, traffic may be counted twice")
+	}
+	src := monitor.NewPacketSource(config.Interface, config.CaptureSample)
+	if err := src.Start(interval); err != nil {
+		return nil, err
+	}
+	slog.Info("Traffic source: packet capture", "iface", config.Interface, "sample", config.CaptureSample)
+	return src, nil
 }
@@ -12,16 +12,65 @@ type ClientStats struct {
 	SessionUpload     uint64    `json:"session_upload"`
 	DownloadSpeed     uint64    `json:"download_speed"`
 	UploadSpeed       uint64    `json:"upload_speed"`
+	DownloadSpeed1m   uint64    `json:"download_speed_1m"` // Load-style averages
+	DownloadSpeed5m   uint64    `json:"download_speed_5m"`
+	DownloadSpeed15m  uint64    `json:"download_speed_15m"`
+	UploadSpeed1m     uint64    `json:"upload_speed_1m"`
+	UploadSpeed5m     uint64    `json:"upload_speed_5m"`
+	UploadSpeed15m    uint64    `json:"upload_speed_15m"`
 	ActiveConnections uint64    `json:"active_connections"`
+	NewConnections    uint64    `json:"new_connections"`    // Conntrack NEW events
+	ClosedConnections uint64    `json:"closed_connections"` // Conntrack DESTROY events
+	FailedConnections uint64    `json:"failed_connections"` // Closed without ever being established
+	NewConnRate       float64   `json:"new_conn_rate"`      // NEW events/sec
+	ClosedConnRate    float64   `json:"closed_conn_rate"`   // DESTROY events/sec
+	FailedConnRate    float64   `json:"failed_conn_rate"`   // Failed connections/sec
+	NewFlows          uint64    `json:"new_flows"`          // Flows first seen with traffic, from any source
+	NewFlowRate       float64   `json:"new_flow_rate"`      // New flows/sec
+	UploadRatio       float64   `json:"upload_ratio"`       // Upload/download bytes over the last 15 minutes
+	AnomalyScore      float64   `json:"anomaly_score"`      // Deviation of the last minute's upload from the client's baseline
+	RTT               float64   `json:"rtt,omitempty"`      // Median TCP handshake round trip in ms, with rtt_sniff
 	LastUpdate        time.Time `json:"last_update"`
 	StartTime         time.Time `json:"start_time"`
+	SessionStart      time.Time `json:"session_start"` // Since when session totals count
 	LastActive        time.Time `json:"last_active"`
+	Online            bool      `json:"online"`                    // In the neighbor table or recently active
+	VLAN              uint16    `json:"vlan,omitempty"`            // Last seen VLAN, 0 if untagged
+	Tags              []string  `json:"tags,omitempty"`            // Device tags from the config or the API, sorted
+	Private           bool      `json:"private,omitempty"`         // Counted in aggregate only, flows are not exposed
+	Plan              string    `json:"plan,omitempty"`            // Expected bandwidth class from the config or the API
+	PlanViolations    uint64    `json:"plan_violations,omitempty"` // Times the plan was exceeded for plan_sustain
+
+	// Totals by IP family, NAT64 traffic counts as IPv4
+	TotalDownload4 uint64 `json:"total_download_v4"`
+	TotalUpload4   uint64 `json:"total_upload_v4"`
+	TotalDownload6 uint64 `json:"total_download_v6"`
+	TotalUpload6   uint64 `json:"total_upload_v6"`
+
+	// Wireless metadata from OpenWrt ubus or hostapd (optional)
+	Hostname    string `json:"hostname,omitempty"`     // DHCP hostname
+	SSID        string `json:"ssid,omitempty"`         // Wireless network
+	APInterface string `json:"ap_interface,omitempty"` // Access point interface
+	Signal      int    `json:"signal,omitempty"`       // Wireless signal in dBm
+	Band        string `json:"band,omitempty"`         // Wi-Fi band, e.g. 5GHz (hostapd)
+	Associated  bool   `json:"associated,omitempty"`   // Associated to an access point right now (hostapd)
+
+	// VPN peers, keyed by a locally administered MAC derived from the peer (optional)
+	Tunnel   string `json:"tunnel,omitempty"`   // WireGuard interface or OpenVPN instance
+	PeerKey  string `json:"peer_key,omitempty"` // WireGuard public key or OpenVPN common name
+	Endpoint string `json:"endpoint,omitempty"` // Public address the peer connects from
 
 	// Internal state for speed calculation
 	TotalUploadLast   uint64    `json:"-"`
 	TotalDownloadLast uint64    `json:"-"`
 	LastSpeedCalc     time.Time `json:"-"`
 
+	// Internal state for connection rate calculation
+	NewConnectionsLast    uint64 `json:"-"`
+	ClosedConnectionsLast uint64 `json:"-"`
+	FailedConnectionsLast uint64 `json:"-"`
+	NewFlowsLast          uint64 `json:"-"`
+
 	// Active Connection Smoothing
 	SmoothedActiveConns float64 `json:"-"`
 	RawActiveConns      uint64  `json:"-"`
@@ -31,6 +80,12 @@ type FlowDetail struct {
 	Protocol          string `json:"protocol"`
 	ClientIP          string `json:"client_ip"`
 	RemoteIP          string `json:"remote_ip"`
+	RemoteHostname    string `json:"remote_hostname,omitempty"` // From sniffed DNS or PTR
+	SNI               string `json:"sni,omitempty"`             // Server name from a sniffed TLS/QUIC ClientHello
+	Service           string `json:"service,omitempty"`         // Router process the flow ends on, else the registrable domain of SNI
+	RemoteCountry     string `json:"remote_country,omitempty"`  // GeoIP ISO country code
+	RemoteASN         uint   `json:"remote_asn,omitempty"`
+	RemoteOrg         string `json:"remote_org,omitempty"`
 	RemotePort        uint16 `json:"remote_port"`
 	TotalDownload     uint64 `json:"total_download"`
 	TotalUpload       uint64 `json:"total_upload"`
@@ -42,6 +97,20 @@ type FlowDetail struct {
 	SessionDuration   uint64 `json:"session_duration"`
 	ActiveConnections uint64 `json:"active_connections"`
 	TTLRemaining      int    `json:"ttl_remaining"`
+	TCPState          string `json:"tcp_state,omitempty"`  // Conntrack TCP state of the most recent flow
+	ProtoInfo         string `json:"proto_info,omitempty"` // Port-less protocols, e.g. ICMP "echo request id 7"
+	Assured           bool   `json:"assured"`              // Any flow is assured (established)
+	SeenReply         bool   `json:"seen_reply"`           // Any flow has seen reply traffic
+	Offload           bool   `json:"offload,omitempty"`    // Any flow is offloaded to a flow table, its bytes may lag
+	Tag               string `json:"tag,omitempty"`        // Traffic tag, e.g. "speedtest"
+	Excluded          bool   `json:"excluded,omitempty"`   // Tag is excluded from usage totals
+	Zone              uint16 `json:"zone,omitempty"`       // Conntrack zone
+
+	RTT float64 `json:"rtt,omitempty"` // Median TCP handshake round trip in ms, with rtt_sniff
+
+	// Recent speeds (bytes/s), oldest first, one sample per interval
+	DownloadHistory []uint64 `json:"download_history,omitempty"`
+	UploadHistory   []uint64 `json:"upload_history,omitempty"`
 }
 
 type GlobalStats struct {
@@ -50,9 +119,403 @@ type GlobalStats struct {
 	DownloadSpeed uint64 `json:"download_speed"` // Bytes/sec
 	UploadSpeed   uint64 `json:"upload_speed"`   // Bytes/sec
 
+	// Load-style averages (Bytes/sec)
+	DownloadSpeed1m  uint64 `json:"download_speed_1m"`
+	DownloadSpeed5m  uint64 `json:"download_speed_5m"`
+	DownloadSpeed15m uint64 `json:"download_speed_15m"`
+	UploadSpeed1m    uint64 `json:"upload_speed_1m"`
+	UploadSpeed5m    uint64 `json:"upload_speed_5m"`
+	UploadSpeed15m   uint64 `json:"upload_speed_15m"`
+
+	// Internet totals by IP family, NAT64 traffic counts as IPv4
+	TotalDownload4 uint64 `json:"total_download_v4"`
+	TotalUpload4   uint64 `json:"total_upload_v4"`
+	TotalDownload6 uint64 `json:"total_download_v6"`
+	TotalUpload6   uint64 `json:"total_upload_v6"`
+
 	// Internal state for speed calculation
 	TotalUploadLast   uint64    `json:"-"`
 	TotalDownloadLast uint64    `json:"-"`
 	LastSpeedCalc     time.Time `json:"-"`
 	ActiveConnections uint64    `json:"active_connections"`
+	OffloadedFlows    uint64    `json:"offloaded_flows,omitempty"` // Offloaded to a flow table, bytes may lag
+
+	// Connection churn from conntrack NEW/DESTROY events
+	NewConnections    uint64  `json:"new_connections"`
+	ClosedConnections uint64  `json:"closed_connections"`
+	FailedConnections uint64  `json:"failed_connections"`
+	NewConnRate       float64 `json:"new_conn_rate"`    // NEW events/sec
+	ClosedConnRate    float64 `json:"closed_conn_rate"` // DESTROY events/sec
+	FailedConnRate    float64 `json:"failed_conn_rate"` // Failed connections/sec
+
+	// Configured link capacity (Bytes/sec) and the percentage in use, 0 if not configured
+	DownloadCapacity    uint64  `json:"download_capacity,omitempty"`
+	UploadCapacity      uint64  `json:"upload_capacity,omitempty"`
+	DownloadUtilization float64 `json:"download_utilization,omitempty"`
+	UploadUtilization   float64 `json:"upload_utilization,omitempty"`
+
+	Paused bool `json:"paused,omitempty"` // Accounting is paused, see /api/monitor
+}
+
+// MonitorState tells whether traffic accounting is paused
+type MonitorState struct {
+	Paused        bool      `json:"paused"`
+	Since         time.Time `json:"since,omitzero"` // Paused at
+	DroppedEvents uint64    `json:"dropped_events"` // Events discarded during the current or last pause
+}
+
+// Smoothing tunes how the reported speeds and connection counts react to changes
+type Smoothing struct {
+	Alpha         float64 `json:"alpha"`          // EMA factor of active connection counts, 0 < alpha <= 1, smaller is smoother
+	MinElapsedMs  int     `json:"min_elapsed_ms"` // Shortest time a speed is calculated over
+	WindowSeconds int     `json:"window_seconds"` // Average client and global speeds over this window, 0 is per interval
+}
+
+// Settings are the monitoring settings adjustable at runtime via /api/settings
+type Settings struct {
+	FlowTTL       int      `json:"flow_ttl"`    // Seconds
+	Interval      int      `json:"interval"`    // Seconds
+	MonitorLAN    bool     `json:"monitor_lan"` // Count LAN-to-LAN traffic, the inverse of ignore_lan
+	Interface     string   `json:"interface"`   // LAN interface, "" for all
+	IgnoreSubnets []string `json:"ignore_subnets"`
+	IgnorePorts   []string `json:"ignore_ports"`
+	IgnoreMACs    []string `json:"ignore_macs"`
+}
+
+// VLANStats sums the clients last seen on one VLAN (0 is untagged)
+type VLANStats struct {
+	VLAN              uint16 `json:"vlan"`
+	ActiveClients     int    `json:"active_clients"`
+	TotalDownload     uint64 `json:"total_download"`
+	TotalUpload       uint64 `json:"total_upload"`
+	DownloadSpeed     uint64 `json:"download_speed"`
+	UploadSpeed       uint64 `json:"upload_speed"`
+	ActiveConnections uint64 `json:"active_connections"`
+}
+
+// ZoneStats holds the internet traffic of one conntrack zone, e.g. one uplink
+// of a multi-WAN router
+type ZoneStats struct {
+	Zone              uint16 `json:"zone"`
+	Name              string `json:"name,omitempty"` // Label from config
+	TotalDownload     uint64 `json:"total_download"`
+	TotalUpload       uint64 `json:"total_upload"`
+	DownloadSpeed     uint64 `json:"download_speed"`
+	UploadSpeed       uint64 `json:"upload_speed"`
+	ActiveConnections uint64 `json:"active_connections"`
+
+	// Configured capacity of the uplink (Bytes/sec) and the percentage in use
+	DownloadCapacity    uint64  `json:"download_capacity,omitempty"`
+	UploadCapacity      uint64  `json:"upload_capacity,omitempty"`
+	DownloadUtilization float64 `json:"download_utilization,omitempty"`
+	UploadUtilization   float64 `json:"upload_utilization,omitempty"`
+}
+
+// CategoryStats holds a client's traffic for one port group category
+type CategoryStats struct {
+	Category      string `json:"category"`
+	TotalDownload uint64 `json:"total_download"`
+	TotalUpload   uint64 `json:"total_upload"`
+}
+
+// ClassStats holds traffic for one conntrack mark class
+type ClassStats struct {
+	Class         string `json:"class"`
+	TotalDownload uint64 `json:"total_download"`
+	TotalUpload   uint64 `json:"total_upload"`
+}
+
+// CountryStats holds internet traffic to and from one country (GeoIP)
+type CountryStats struct {
+	Country       string `json:"country"` // ISO code
+	TotalDownload uint64 `json:"total_download"`
+	TotalUpload   uint64 `json:"total_upload"`
+	Blocked       bool   `json:"blocked,omitempty"` // Listed in blocked_countries
+}
+
+// PresenceSession is a period a client was in use, from its first traffic
+// after being idle to its last traffic before going idle again
+type PresenceSession struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`      // Last traffic so far while active
+	Duration uint64    `json:"duration"` // Seconds
+	Download uint64    `json:"download"`
+	Upload   uint64    `json:"upload"`
+	Active   bool      `json:"active"`
+}
+
+// SessionRecord is a closed session of a client's session totals
+type SessionRecord struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Download uint64    `json:"download"`
+	Upload   uint64    `json:"upload"`
+	Reason   string    `json:"reason"` // scheduled (session_reset) or manual
+}
+
+// ServiceStats is a client's traffic to one well-known service (HTTPS, DNS, ...)
+type ServiceStats struct {
+	Service           string `json:"service"`
+	TotalDownload     uint64 `json:"total_download"`
+	TotalUpload       uint64 `json:"total_upload"`
+	DownloadSpeed     uint64 `json:"download_speed"`
+	UploadSpeed       uint64 `json:"upload_speed"`
+	ActiveConnections uint64 `json:"active_connections"`
+}
+
+// GroupStats aggregates the statistics of a named group of clients
+type GroupStats struct {
+	Name              string   `json:"name"`
+	Members           []string `json:"members"`
+	ActiveClients     int      `json:"active_clients"` // Members currently online
+	TotalDownload     uint64   `json:"total_download"`
+	TotalUpload       uint64   `json:"total_upload"`
+	SessionDownload   uint64   `json:"session_download"`
+	SessionUpload     uint64   `json:"session_upload"`
+	DownloadSpeed     uint64   `json:"download_speed"`
+	UploadSpeed       uint64   `json:"upload_speed"`
+	ActiveConnections uint64   `json:"active_connections"`
+	NewConnections    uint64   `json:"new_connections"`
+	FailedConnections uint64   `json:"failed_connections"`
+}
+
+// RemoteStats aggregates traffic to one remote IP across all clients
+type RemoteStats struct {
+	RemoteIP          string `json:"remote_ip"`
+	RemoteHostname    string `json:"remote_hostname,omitempty"`
+	RemoteCountry     string `json:"remote_country,omitempty"`
+	RemoteASN         uint   `json:"remote_asn,omitempty"`
+	RemoteOrg         string `json:"remote_org,omitempty"`
+	TotalDownload     uint64 `json:"total_download"`
+	TotalUpload       uint64 `json:"total_upload"`
+	DownloadSpeed     uint64 `json:"download_speed"`
+	UploadSpeed       uint64 `json:"upload_speed"`
+	ActiveConnections uint64 `json:"active_connections"`
+	Clients           int    `json:"clients"` // Distinct clients talking to this remote
+}
+
+// TopStats is a ranked, paginated view of clients and remote destinations
+type TopStats struct {
+	By          string        `json:"by"`
+	Window      string        `json:"window,omitempty"`
+	From        time.Time     `json:"from,omitzero"` // Time range ranked by traffic, instead of a window
+	To          time.Time     `json:"to,omitzero"`
+	ClientCount int           `json:"client_count"` // Before pagination
+	Clients     []ClientStats `json:"clients"`
+	RemoteCount int           `json:"remote_count"`
+	Remotes     []RemoteStats `json:"remotes"`
+}
+
+// Flow is a single tracked connection seen from the client side
+type Flow struct {
+	MAC            string    `json:"mac,omitempty"` // Empty for routed traffic without a known client
+	Name           string    `json:"name,omitempty"`
+	Protocol       string    `json:"protocol"`
+	ClientIP       string    `json:"client_ip"`
+	ClientPort     uint16    `json:"client_port"`
+	RemoteIP       string    `json:"remote_ip"`
+	RemotePort     uint16    `json:"remote_port"`
+	RemoteHostname string    `json:"remote_hostname,omitempty"`
+	RemoteCountry  string    `json:"remote_country,omitempty"`
+	RemoteASN      uint      `json:"remote_asn,omitempty"`
+	RemoteOrg      string    `json:"remote_org,omitempty"`
+	SNI            string    `json:"sni,omitempty"`
+	Service        string    `json:"service,omitempty"`
+	TotalDownload  uint64    `json:"total_download"`
+	TotalUpload    uint64    `json:"total_upload"`
+	DownloadSpeed  uint64    `json:"download_speed"`
+	UploadSpeed    uint64    `json:"upload_speed"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	TCPState       string    `json:"tcp_state,omitempty"`
+	Assured        bool      `json:"assured"`
+	Offload        bool      `json:"offload,omitempty"` // Offloaded to a flow table, bytes may lag
+	ProtoInfo      string    `json:"proto_info,omitempty"`
+	Tag            string    `json:"tag,omitempty"`
+	Excluded       bool      `json:"excluded,omitempty"`
+	Zone           uint16    `json:"zone,omitempty"`
+}
+
+// FlowList is a filtered, sorted and paginated view of the flow table
+type FlowList struct {
+	By    string `json:"by"`
+	Count int    `json:"count"` // Matching flows before pagination
+	Flows []Flow `json:"flows"`
+}
+
+// UsagePeriod is the traffic of one calendar period [Start, End)
+type UsagePeriod struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Download uint64    `json:"download"`
+	Upload   uint64    `json:"upload"`
+}
+
+// FlowRecord is a raw tracked flow with cumulative counters, for flow exporters
+type FlowRecord struct {
+	Key         string
+	SrcIP       string
+	DstIP       string
+	SrcPort     uint16
+	DstPort     uint16
+	Proto       uint8
+	OriginBytes uint64
+	ReplyBytes  uint64
+	FirstSeen   time.Time
+	LastSeen    time.Time
+}
+
+// ElephantFlow is a single flow that exceeded the size or sustained rate threshold
+type ElephantFlow struct {
+	MAC           string    `json:"mac"`
+	Name          string    `json:"name"`
+	Protocol      string    `json:"protocol"`
+	ClientIP      string    `json:"client_ip"`
+	ClientPort    uint16    `json:"client_port"`
+	RemoteIP      string    `json:"remote_ip"`
+	RemotePort    uint16    `json:"remote_port"`
+	TotalDownload uint64    `json:"total_download"`
+	TotalUpload   uint64    `json:"total_upload"`
+	DownloadSpeed uint64    `json:"download_speed"`
+	UploadSpeed   uint64    `json:"upload_speed"`
+	PeakSpeed     uint64    `json:"peak_speed"` // Highest combined speed seen
+	Reason        string    `json:"reason"`     // "size" or "rate"
+	FirstSeen     time.Time `json:"first_seen"`
+	DetectedAt    time.Time `json:"detected_at"`
+	LastSeen      time.Time `json:"last_seen"`
+	Active        bool      `json:"active"`
+}
+
+// SiteStats is one catchmole instance of a federated view
+type SiteStats struct {
+	Site       string      `json:"site"`
+	URL        string      `json:"url,omitempty"` // Empty for this instance
+	Online     bool        `json:"online"`        // Polled successfully within the last three intervals
+	LastUpdate time.Time   `json:"last_update"`
+	Error      string      `json:"error,omitempty"` // Last poll error
+	StartTime  time.Time   `json:"start_time"`
+	Global     GlobalStats `json:"global"`
+}
+
+// SiteClient is a client of one site in a federated view
+type SiteClient struct {
+	Site string `json:"site"`
+	ClientStats
+}
+
+// FederatedStats merges this instance with the remote sites. Global sums the
+// totals, speeds and connections of the online sites.
+type FederatedStats struct {
+	Global  GlobalStats  `json:"global"`
+	Sites   []SiteStats  `json:"sites"`
+	Clients []SiteClient `json:"clients"`
+}
+
+// Anomaly is a client uploading far more than its own baseline, e.g. data
+// exfiltration, a misconfigured cloud backup or a compromised IoT device
+type Anomaly struct {
+	MAC            string    `json:"mac"`
+	Name           string    `json:"name"`
+	Score          float64   `json:"score"`           // Highest score since flagged
+	Upload         uint64    `json:"upload"`          // Bytes in the last scored minute
+	Download       uint64    `json:"download"`        // Bytes in the last scored minute
+	BaselineUpload uint64    `json:"baseline_upload"` // Typical upload bytes per minute
+	UploadRatio    float64   `json:"upload_ratio"`    // Upload/download in the last scored minute
+	BaselineRatio  float64   `json:"baseline_ratio"`  // Typical upload/download
+	Reasons        []string  `json:"reasons"`
+	Since          time.Time `json:"since"`
+	LastSeen       time.Time `json:"last_seen"` // Last minute above the threshold
+	Active         bool      `json:"active"`    // The last minute was above the threshold
+}
+
+// ScanDetection is a client connecting to an unusual number of distinct
+// remote IP and port pairs, e.g. a port scan or a worm sweeping hosts
+type ScanDetection struct {
+	MAC      string    `json:"mac"`
+	Name     string    `json:"name"`
+	Kind     string    `json:"kind"`    // "port_scan" (more ports than hosts) or "host_sweep"
+	Targets  int       `json:"targets"` // Most distinct IP and port pairs within the window since flagged
+	Hosts    int       `json:"hosts"`   // Distinct remote IPs at the last flagged tick
+	Ports    int       `json:"ports"`   // Distinct remote ports at the last flagged tick
+	Window   int       `json:"window"`  // Seconds
+	Reason   string    `json:"reason"`
+	Since    time.Time `json:"since"`
+	LastSeen time.Time `json:"last_seen"` // Last tick above the threshold
+	Active   bool      `json:"active"`    // The last tick was above the threshold
+}
+
+// PlanViolation is a client exceeding the bandwidth of its plan for longer
+// than plan_sustain, e.g. during a firmware update storm
+type PlanViolation struct {
+	MAC           string    `json:"mac"`
+	Name          string    `json:"name"`
+	Plan          string    `json:"plan"`
+	DownloadLimit uint64    `json:"download_limit"` // Bytes/sec, 0 = unlimited
+	UploadLimit   uint64    `json:"upload_limit"`
+	Download      uint64    `json:"download"` // Highest speeds of the last violation
+	Upload        uint64    `json:"upload"`
+	Violations    uint64    `json:"violations"`
+	Reason        string    `json:"reason"`
+	FirstSeen     time.Time `json:"first_seen"` // Start of the first violation
+	Since         time.Time `json:"since"`      // Start of the last violation
+	LastSeen      time.Time `json:"last_seen"`  // Last tick above the plan
+	Duration      uint64    `json:"duration"`   // Seconds of the last violation
+	Active        bool      `json:"active"`     // Still above the plan
+}
+
+// ArchivedFlow summarizes a flow that left the table, seen from the client
+type ArchivedFlow struct {
+	MAC            string    `json:"mac"`
+	Name           string    `json:"name"`
+	Protocol       string    `json:"protocol"`
+	ProtoInfo      string    `json:"proto_info,omitempty"`
+	ClientIP       string    `json:"client_ip"`
+	ClientPort     uint16    `json:"client_port"`
+	RemoteIP       string    `json:"remote_ip"`
+	RemotePort     uint16    `json:"remote_port"`
+	RemoteHostname string    `json:"remote_hostname,omitempty"`
+	SNI            string    `json:"sni,omitempty"`
+	Service        string    `json:"service,omitempty"`
+	Tag            string    `json:"tag,omitempty"`
+	TotalDownload  uint64    `json:"total_download"`
+	TotalUpload    uint64    `json:"total_upload"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	Duration       uint64    `json:"duration"` // Seconds
+	Reason         string    `json:"reason"`   // "closed" (conntrack destroy) or "expired" (TTL)
+}
+
+// SpeedTest is a throughput measurement between a client and the router
+type SpeedTest struct {
+	MAC       string    `json:"mac"`
+	ClientIP  string    `json:"client_ip"`
+	Direction string    `json:"direction"` // "download" (to the client) or "upload"
+	Bytes     uint64    `json:"bytes"`
+	Duration  float64   `json:"duration"` // Seconds
+	Speed     uint64    `json:"speed"`    // Bytes/sec
+	Complete  bool      `json:"complete"` // All bytes were transferred
+	Time      time.Time `json:"time"`
+}
+
+// LatencyStats is the TCP handshake round trip between a client and a destination
+type LatencyStats struct {
+	RemoteIP       string    `json:"remote_ip"`
+	RemoteHostname string    `json:"remote_hostname,omitempty"`
+	RTT            float64   `json:"rtt"`     // Median, ms
+	Min            float64   `json:"min"`     // ms
+	Samples        int       `json:"samples"` // Handshakes the median covers
+	LastSeen       time.Time `json:"last_seen"`
+}
+
+// AuditEntry is a request that may have changed state, see /api/audit
+type AuditEntry struct {
+	Time         time.Time `json:"time"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	Query        string    `json:"query,omitempty"`
+	RemoteIP     string    `json:"remote_ip"`
+	ForwardedFor string    `json:"forwarded_for,omitempty"` // Set by a reverse proxy
+	User         string    `json:"user,omitempty"`          // Basic auth user, or "token"
+	MAC          string    `json:"mac,omitempty"`           // Client the request concerned
+	Status       int       `json:"status"`
 }
@@ -0,0 +1,475 @@
+package alert
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/pkg/stats"
+	"github.com/kisy/catchmole/pkg/units"
+)
+
+const (
+	evalInterval = 10 * time.Second
+	maxRecent    = 100 // Fired alerts kept for /api/alerts
+)
+
+// Config holds alert rules and delivery settings (the [alert] TOML table)
+type Config struct {
+	// Delivery (any combination)
+	WebhookURL     string `toml:"webhook_url"`
+	TelegramToken  string `toml:"telegram_token"`
+	TelegramChatID string `toml:"telegram_chat_id"`
+	NtfyURL        string `toml:"ntfy_url"`
+	NtfyToken      string `toml:"ntfy_token"`
+
+	// Rules
+	NewDevice     bool   `toml:"new_device"`     // A MAC not seen before joined
+	DailyBytes    uint64 `toml:"daily_bytes"`    // Client exceeded bytes today (0 = off)
+	UploadRate    uint64 `toml:"upload_rate"`    // Sustained client upload in bytes/sec (0 = off)
+	UploadSustain int    `toml:"upload_sustain"` // Seconds the upload rate must hold
+
+	LinkUtilization float64 `toml:"link_utilization"` // Sustained uplink use in percent of link_capacity (0 = off)
+	LinkSustain     int     `toml:"link_sustain"`     // Seconds the utilization must hold
+
+	Scan     bool `toml:"scan"`     // Client connects to many remote IP and port pairs, see fanout_threshold
+	Plan     bool `toml:"plan"`     // Client exceeds the bandwidth of its plan for plan_sustain
+	Watchdog bool `toml:"watchdog"` // The traffic pipeline stalled, see watchdog_timeout
+	Elephant bool `toml:"elephant"` // A flow of the client became an elephant, see elephant_bytes/elephant_rate
+
+	// Noise control
+	Cooldown   int            `toml:"cooldown"`    // Seconds before the same rule fires again for a client
+	Cooldowns  map[string]int `toml:"cooldowns"`   // Rule -> seconds, overrides cooldown for that rule
+	QuietHours string         `toml:"quiet_hours"` // e.g. "23:00-07:00", alerts are held and summarized afterwards
+}
+
+// Rule names, as used in alerts and cooldowns
+var rules = []string{"new_device", "daily_bytes", "upload_rate", "link_utilization", "scan", "plan", "watchdog", "elephant"}
+
+// Equal reports whether two configs are the same
+func (c Config) Equal(o Config) bool {
+	return c.WebhookURL == o.WebhookURL && c.TelegramToken == o.TelegramToken && c.TelegramChatID == o.TelegramChatID &&
+		c.NtfyURL == o.NtfyURL && c.NtfyToken == o.NtfyToken &&
+		c.NewDevice == o.NewDevice && c.DailyBytes == o.DailyBytes && c.UploadRate == o.UploadRate && c.UploadSustain == o.UploadSustain &&
+		c.LinkUtilization == o.LinkUtilization && c.LinkSustain == o.LinkSustain && c.Scan == o.Scan && c.Plan == o.Plan &&
+		c.Watchdog == o.Watchdog && c.Elephant == o.Elephant &&
+		c.Cooldown == o.Cooldown && maps.Equal(c.Cooldowns, o.Cooldowns) && c.QuietHours == o.QuietHours
+}
+
+// cooldown returns how long a rule stays quiet for a client after firing
+func (c Config) cooldown(rule string) time.Duration {
+	if secs, ok := c.Cooldowns[rule]; ok && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return time.Duration(c.Cooldown) * time.Second
+}
+
+// Enabled reports whether any notifier is configured
+func (c Config) Enabled() bool {
+	return c.WebhookURL != "" || (c.TelegramToken != "" && c.TelegramChatID != "") || c.NtfyURL != ""
+}
+
+// Watchdog reports the health of the traffic pipeline, see monitor.Watchdog
+type Watchdog interface {
+	Healthy() (bool, string)
+}
+
+type alertKey struct {
+	rule string
+	mac  string
+}
+
+// Alert is a fired rule
+type Alert struct {
+	Rule    string    `json:"rule"`
+	MAC     string    `json:"mac"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Engine evaluates rules against the aggregator and delivers notifications.
+// Rules fire when their condition becomes true, not while it stays true.
+// Repeats within the cooldown are suppressed and counted, alerts of one
+// evaluation are sent as a single message, and during quiet hours alerts are
+// held and delivered as one summary when quiet hours end.
+type Engine struct {
+	agg      *stats.Aggregator
+	usage    *stats.UsageRollup
+	watchdog Watchdog // Optional, see SetWatchdog
+
+	mu        sync.Mutex
+	cfg       Config
+	notifiers []Notifier
+	quiet     [2]int // Minutes of day [start, end), equal = disabled
+
+	known       map[string]struct{}    // MACs seen so far
+	seeded      bool                   // known holds the initial client set
+	uploadSince map[string]time.Time   // MAC -> upload above threshold since
+	linkSince   map[string]time.Time   // Link and direction -> utilization above threshold since
+	active      map[alertKey]struct{}  // Conditions true in the last evaluation
+	lastFired   map[alertKey]time.Time // Cooldown tracking
+	suppressed  map[alertKey]int       // Repeats swallowed by the cooldown
+	held        []Alert                // Alerts held during quiet hours
+	recent      []Alert                // Last fired alerts, oldest first
+
+	trigger chan struct{} // Evaluate before the next tick, see Trigger
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewEngine(agg *stats.Aggregator, usage *stats.UsageRollup, cfg Config) (*Engine, error) {
+	e := &Engine{
+		agg:         agg,
+		usage:       usage,
+		known:       make(map[string]struct{}),
+		uploadSince: make(map[string]time.Time),
+		linkSince:   make(map[string]time.Time),
+		active:      make(map[alertKey]struct{}),
+		lastFired:   make(map[alertKey]time.Time),
+		suppressed:  make(map[alertKey]int),
+		trigger:     make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+	if err := e.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SetConfig replaces rules and notifiers, keeping alert state
+func (e *Engine) SetConfig(cfg Config) error {
+	quiet, err := parseQuietHours(cfg.QuietHours)
+	if err != nil {
+		return err
+	}
+	for rule, secs := range cfg.Cooldowns {
+		if !slices.Contains(rules, rule) {
+			return fmt.Errorf("invalid alert cooldowns rule %q (want one of %s)", rule, strings.Join(rules, ", "))
+		}
+		if secs < 0 {
+			return fmt.Errorf("invalid alert cooldowns.%s %d (want seconds >= 0)", rule, secs)
+		}
+	}
+
+	var notifiers []Notifier
+	if cfg.WebhookURL != "" {
+		notifiers = append(notifiers, &webhook{url: cfg.WebhookURL})
+	}
+	if cfg.TelegramToken != "" && cfg.TelegramChatID != "" {
+		notifiers = append(notifiers, &telegram{token: cfg.TelegramToken, chatID: cfg.TelegramChatID})
+	}
+	if cfg.NtfyURL != "" {
+		notifiers = append(notifiers, &ntfy{url: cfg.NtfyURL, token: cfg.NtfyToken})
+	}
+
+	if cfg.UploadSustain <= 0 {
+		cfg.UploadSustain = 300 // Default
+	}
+	if cfg.LinkSustain <= 0 {
+		cfg.LinkSustain = 60 // Default
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 3600 // Default
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cfg = cfg
+	e.notifiers = notifiers
+	e.quiet = quiet
+	return nil
+}
+
+// parseQuietHours parses "HH:MM-HH:MM" into minutes of day
+func parseQuietHours(s string) ([2]int, error) {
+	var q [2]int
+	if s == "" {
+		return q, nil
+	}
+
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return q, fmt.Errorf("invalid quiet_hours %q (want HH:MM-HH:MM)", s)
+	}
+	for i, v := range []string{from, to} {
+		t, err := time.Parse("15:04", strings.TrimSpace(v))
+		if err != nil {
+			return q, fmt.Errorf("invalid quiet_hours %q (want HH:MM-HH:MM)", s)
+		}
+		q[i] = t.Hour()*60 + t.Minute()
+	}
+	return q, nil
+}
+
+func (e *Engine) Start() {
+	e.wg.Go(func() {
+		ticker := time.NewTicker(evalInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				e.evaluate(time.Now())
+			case <-e.trigger:
+				e.evaluate(time.Now())
+			case <-e.stop:
+				return
+			}
+		}
+	})
+}
+
+// SetWatchdog enables the watchdog rule. Call before Start.
+func (e *Engine) SetWatchdog(w Watchdog) {
+	e.watchdog = w
+}
+
+// Trigger evaluates the rules right away instead of at the next tick, e.g.
+// when a device associates, so new_device fires without waiting for traffic
+func (e *Engine) Trigger() {
+	select {
+	case e.trigger <- struct{}{}:
+	default: // Already pending
+	}
+}
+
+func (e *Engine) Stop() {
+	close(e.stop)
+	e.wg.Wait()
+}
+
+// inQuietHours reports whether t falls into quiet hours. Caller holds mu.
+func (e *Engine) inQuietHours(t time.Time) bool {
+	if e.quiet[0] == e.quiet[1] {
+		return false
+	}
+	t = t.In(e.usage.Location())
+	m := t.Hour()*60 + t.Minute()
+	if e.quiet[0] < e.quiet[1] {
+		return m >= e.quiet[0] && m < e.quiet[1]
+	}
+	return m >= e.quiet[0] || m < e.quiet[1] // Wraps midnight
+}
+
+func (e *Engine) evaluate(now time.Time) {
+	clients := e.agg.GetClients()
+	global := e.agg.GetGlobalStats()
+	zones := e.agg.GetZones()
+	scans := e.agg.GetScanDetections()
+	violations := e.agg.GetPlanViolations()
+	elephants, _ := e.agg.GetElephants()
+	healthy, stall := true, ""
+	if e.watchdog != nil {
+		healthy, stall = e.watchdog.Healthy()
+	}
+
+	e.mu.Lock()
+
+	var fired []Alert
+	active := make(map[alertKey]struct{})
+	fire := func(rule, mac, msg string) {
+		k := alertKey{rule, mac}
+		active[k] = struct{}{}
+		if _, ok := e.active[k]; ok {
+			return // Still the same occurrence
+		}
+		if last, ok := e.lastFired[k]; ok && now.Sub(last) < e.cfg.cooldown(rule) {
+			e.suppressed[k]++
+			return
+		}
+		if n := e.suppressed[k]; n > 0 {
+			msg += fmt.Sprintf(" (%d repeats suppressed)", n)
+			delete(e.suppressed, k)
+		}
+		e.lastFired[k] = now
+		fired = append(fired, Alert{Rule: rule, MAC: mac, Message: msg, Time: now})
+	}
+
+	present := make(map[string]struct{}, len(clients))
+	for _, c := range clients {
+		present[c.MAC] = struct{}{}
+		name := c.Name
+		if name == "" || name == c.MAC {
+			name = c.MAC
+		} else {
+			name = fmt.Sprintf("%s (%s)", name, c.MAC)
+		}
+
+		// New device
+		if _, ok := e.known[c.MAC]; !ok {
+			e.known[c.MAC] = struct{}{}
+			if e.seeded && e.cfg.NewDevice {
+				fire("new_device", c.MAC, "New device joined: "+name)
+			}
+		}
+
+		// Daily usage
+		if e.cfg.DailyBytes > 0 {
+			today := e.usage.Today(c.MAC)
+			if used := today.Download + today.Upload; used > e.cfg.DailyBytes {
+				fire("daily_bytes", c.MAC, fmt.Sprintf("%s used %s today (limit %s)", name, units.Bytes(used), units.Bytes(e.cfg.DailyBytes)))
+			}
+		}
+
+		// Sustained upload
+		if e.cfg.UploadRate > 0 {
+			if c.UploadSpeed < e.cfg.UploadRate {
+				delete(e.uploadSince, c.MAC)
+			} else if since, ok := e.uploadSince[c.MAC]; !ok {
+				e.uploadSince[c.MAC] = now
+			} else if held := now.Sub(since); held >= time.Duration(e.cfg.UploadSustain)*time.Second {
+				fire("upload_rate", c.MAC, fmt.Sprintf("%s uploading at %s/s for %s", name, units.Bytes(c.UploadSpeed), held.Round(time.Second)))
+			}
+		}
+	}
+
+	// Port scans and host sweeps
+	if e.cfg.Scan {
+		for _, s := range scans {
+			if !s.Active {
+				continue
+			}
+			name := s.MAC
+			if s.Name != "" && s.Name != s.MAC {
+				name = fmt.Sprintf("%s (%s)", s.Name, s.MAC)
+			}
+			fire("scan", s.MAC, fmt.Sprintf("%s %s: %s", name, strings.ReplaceAll(s.Kind, "_", " "), s.Reason))
+		}
+	}
+
+	// Elephant flows, the largest per client
+	if e.cfg.Elephant {
+		seen := make(map[string]bool)
+		for _, f := range elephants {
+			key := cmp.Or(f.MAC, f.ClientIP) // Routed traffic without a known client
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			name := key
+			if f.Name != "" && f.Name != f.MAC {
+				name = fmt.Sprintf("%s (%s)", f.Name, key)
+			}
+			fire("elephant", key, fmt.Sprintf("%s elephant flow (%s) %s to %s:%d, %s down / %s up at %s/s",
+				name, f.Reason, f.Protocol, f.RemoteIP, f.RemotePort,
+				units.Bytes(f.TotalDownload), units.Bytes(f.TotalUpload), units.Bytes(f.DownloadSpeed+f.UploadSpeed)))
+		}
+	}
+
+	// Stalled traffic pipeline, numbers are frozen meanwhile
+	if e.cfg.Watchdog && !healthy {
+		fire("watchdog", "", "Traffic pipeline stalled: "+stall)
+	}
+
+	// Plan violations
+	if e.cfg.Plan {
+		for _, v := range violations {
+			if !v.Active {
+				continue
+			}
+			name := v.MAC
+			if v.Name != "" && v.Name != v.MAC {
+				name = fmt.Sprintf("%s (%s)", v.Name, v.MAC)
+			}
+			fire("plan", v.MAC, fmt.Sprintf("%s exceeds %s (violation %d)", name, v.Reason, v.Violations))
+		}
+	}
+
+	// Sustained uplink utilization, for the whole WAN and per zone
+	if e.cfg.LinkUtilization > 0 {
+		link := func(key, name string, capacity uint64, utilization float64) {
+			if capacity == 0 || utilization < e.cfg.LinkUtilization {
+				delete(e.linkSince, key)
+			} else if since, ok := e.linkSince[key]; !ok {
+				e.linkSince[key] = now
+			} else if held := now.Sub(since); held >= time.Duration(e.cfg.LinkSustain)*time.Second {
+				fire("link_utilization", key, fmt.Sprintf("%s at %.0f%% of capacity for %s", name, utilization, held.Round(time.Second)))
+			}
+		}
+		link("wan/download", "WAN downlink", global.DownloadCapacity, global.DownloadUtilization)
+		link("wan/upload", "WAN uplink", global.UploadCapacity, global.UploadUtilization)
+		for _, z := range zones {
+			name := cmp.Or(z.Name, fmt.Sprintf("zone %d", z.Zone))
+			key := fmt.Sprintf("zone%d", z.Zone)
+			link(key+"/download", name+" downlink", z.DownloadCapacity, z.DownloadUtilization)
+			link(key+"/upload", name+" uplink", z.UploadCapacity, z.UploadUtilization)
+		}
+	} else {
+		clear(e.linkSince)
+	}
+
+	e.seeded = true
+	e.active = active
+	e.recent = append(e.recent, fired...)
+	if len(e.recent) > maxRecent {
+		e.recent = e.recent[len(e.recent)-maxRecent:]
+	}
+
+	// Forget cooldowns that ran out
+	for k, last := range e.lastFired {
+		if now.Sub(last) >= e.cfg.cooldown(k.rule) && e.suppressed[k] == 0 {
+			delete(e.lastFired, k)
+		}
+	}
+
+	for mac := range e.uploadSince {
+		if _, ok := present[mac]; !ok {
+			delete(e.uploadSince, mac)
+		}
+	}
+
+	// Quiet hours: hold everything, flush as one summary afterwards
+	var send []Alert
+	if e.inQuietHours(now) {
+		e.held = append(e.held, fired...)
+	} else {
+		send = append(e.held, fired...)
+		e.held = nil
+	}
+	notifiers := e.notifiers
+	e.mu.Unlock()
+
+	if len(send) > 0 {
+		deliver(notifiers, send)
+	}
+}
+
+// Recent returns the last fired alerts, newest first, including those held for quiet hours
+func (e *Engine) Recent() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	recent := make([]Alert, 0, len(e.recent))
+	for i := len(e.recent) - 1; i >= 0; i-- {
+		recent = append(recent, e.recent[i])
+	}
+	return recent
+}
+
+// deliver sends alerts as a single message
+func deliver(notifiers []Notifier, alerts []Alert) {
+	sort.SliceStable(alerts, func(i, j int) bool { return alerts[i].Time.Before(alerts[j].Time) })
+
+	title := "catchmole: " + alerts[0].Message
+	lines := make([]string, 0, len(alerts))
+	for _, a := range alerts {
+		lines = append(lines, a.Time.Format("15:04")+" "+a.Message)
+	}
+	if len(alerts) > 1 {
+		title = fmt.Sprintf("catchmole: %d alerts", len(alerts))
+	}
+	message := strings.Join(lines, "\n")
+
+	for _, n := range notifiers {
+		if err := n.Send(title, message); err != nil {
+			slog.Error("Alert delivery failed", "channel", n.Name(), "err", err)
+		}
+	}
+	slog.Warn("Alert", "title", title, "message", strings.ReplaceAll(message, "\n", "; "))
+}
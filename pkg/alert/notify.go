@@ -0,0 +1,106 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notifier delivers an alert message
+type Notifier interface {
+	Name() string
+	Send(title, message string) error
+}
+
+// webhook POSTs a JSON document {"title", "message", "time"}
+type webhook struct {
+	url string
+}
+
+func (w *webhook) Name() string { return "webhook" }
+
+func (w *webhook) Send(title, message string) error {
+	body, err := json.Marshal(struct {
+		Title   string    `json:"title"`
+		Message string    `json:"message"`
+		Time    time.Time `json:"time"`
+	}{title, message, time.Now()})
+	if err != nil {
+		return err
+	}
+	return Post(w.url, "application/json", body, nil)
+}
+
+// telegram sends via the Bot API
+type telegram struct {
+	token  string
+	chatID string
+}
+
+func (t *telegram) Name() string { return "telegram" }
+
+func (t *telegram) Send(title, message string) error {
+	body, err := json.Marshal(struct {
+		ChatID string `json:"chat_id"`
+		Text   string `json:"text"`
+	}{t.chatID, title + "\n\n" + message})
+	if err != nil {
+		return err
+	}
+	return Post("https://api.telegram.org/bot"+t.token+"/sendMessage", "application/json", body, nil)
+}
+
+// ntfy publishes to a topic URL, e.g. https://ntfy.sh/my-router
+type ntfy struct {
+	url   string
+	token string
+}
+
+func (n *ntfy) Name() string { return "ntfy" }
+
+func (n *ntfy) Send(title, message string) error {
+	headers := map[string]string{"Title": title}
+	if n.token != "" {
+		headers["Authorization"] = "Bearer " + n.token
+	}
+	return Post(n.url, "text/plain", []byte(message), headers)
+}
+
+// Post sends body to target, errors never contain the URL as it may hold a
+// token. Shared with the report delivery.
+func Post(target, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return stripURL(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return stripURL(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", strings.TrimSpace(resp.Status))
+	}
+	return nil
+}
+
+// stripURL drops the URL from a request error, it may contain a token
+func stripURL(err error) error {
+	if uerr := (*url.Error)(nil); errors.As(err, &uerr) {
+		return fmt.Errorf("%s: %w", uerr.Op, uerr.Err)
+	}
+	return err
+}
@@ -0,0 +1,1006 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.29.3
+// source: catchmole.proto
+
+package grpcapi
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetGlobalRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetGlobalRequest) Reset() {
+	*x = GetGlobalRequest{}
+	mi := &file_catchmole_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetGlobalRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetGlobalRequest) ProtoMessage() {}
+
+func (x *GetGlobalRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_catchmole_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetGlobalRequest.ProtoReflect.Descriptor instead.
+func (*GetGlobalRequest) Descriptor() ([]byte, []int) {
+	return file_catchmole_proto_rawDescGZIP(), []int{0}
+}
+
+type GlobalStats struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	StartTime         *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	TotalDownload     uint64                 `protobuf:"varint,2,opt,name=total_download,json=totalDownload,proto3" json:"total_download,omitempty"`
+	TotalUpload       uint64                 `protobuf:"varint,3,opt,name=total_upload,json=totalUpload,proto3" json:"total_upload,omitempty"`
+	DownloadSpeed     uint64                 `protobuf:"varint,4,opt,name=download_speed,json=downloadSpeed,proto3" json:"download_speed,omitempty"` // Bytes/sec
+	UploadSpeed       uint64                 `protobuf:"varint,5,opt,name=upload_speed,json=uploadSpeed,proto3" json:"upload_speed,omitempty"`
+	DownloadSpeed_1M  uint64                 `protobuf:"varint,6,opt,name=download_speed_1m,json=downloadSpeed1m,proto3" json:"download_speed_1m,omitempty"`
+	DownloadSpeed_5M  uint64                 `protobuf:"varint,7,opt,name=download_speed_5m,json=downloadSpeed5m,proto3" json:"download_speed_5m,omitempty"`
+	DownloadSpeed_15M uint64                 `protobuf:"varint,8,opt,name=download_speed_15m,json=downloadSpeed15m,proto3" json:"download_speed_15m,omitempty"`
+	UploadSpeed_1M    uint64                 `protobuf:"varint,9,opt,name=upload_speed_1m,json=uploadSpeed1m,proto3" json:"upload_speed_1m,omitempty"`
+	UploadSpeed_5M    uint64                 `protobuf:"varint,10,opt,name=upload_speed_5m,json=uploadSpeed5m,proto3" json:"upload_speed_5m,omitempty"`
+	UploadSpeed_15M   uint64                 `protobuf:"varint,11,opt,name=upload_speed_15m,json=uploadSpeed15m,proto3" json:"upload_speed_15m,omitempty"`
+	ActiveConnections uint64                 `protobuf:"varint,12,opt,name=active_connections,json=activeConnections,proto3" json:"active_connections,omitempty"`
+	NewConnections    uint64                 `protobuf:"varint,13,opt,name=new_connections,json=newConnections,proto3" json:"new_connections,omitempty"`
+	ClosedConnections uint64                 `protobuf:"varint,14,opt,name=closed_connections,json=closedConnections,proto3" json:"closed_connections,omitempty"`
+	FailedConnections uint64                 `protobuf:"varint,15,opt,name=failed_connections,json=failedConnections,proto3" json:"failed_connections,omitempty"`
+	NewConnRate       float64                `protobuf:"fixed64,16,opt,name=new_conn_rate,json=newConnRate,proto3" json:"new_conn_rate,omitempty"` // Events/sec
+	ClosedConnRate    float64                `protobuf:"fixed64,17,opt,name=closed_conn_rate,json=closedConnRate,proto3" json:"closed_conn_rate,omitempty"`
+	FailedConnRate    float64                `protobuf:"fixed64,18,opt,name=failed_conn_rate,json=failedConnRate,proto3" json:"failed_conn_rate,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *GlobalStats) Reset() {
+	*x = GlobalStats{}
+	mi := &file_catchmole_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GlobalStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GlobalStats) ProtoMessage() {}
+
+func (x *GlobalStats) ProtoReflect() protoreflect.Message {
+	mi := &file_catchmole_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GlobalStats.ProtoReflect.Descriptor instead.
+func (*GlobalStats) Descriptor() ([]byte, []int) {
+	return file_catchmole_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GlobalStats) GetStartTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartTime
+	}
+	return nil
+}
+
+func (x *GlobalStats) GetTotalDownload() uint64 {
+	if x != nil {
+		return x.TotalDownload
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetTotalUpload() uint64 {
+	if x != nil {
+		return x.TotalUpload
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetDownloadSpeed() uint64 {
+	if x != nil {
+		return x.DownloadSpeed
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetUploadSpeed() uint64 {
+	if x != nil {
+		return x.UploadSpeed
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetDownloadSpeed_1M() uint64 {
+	if x != nil {
+		return x.DownloadSpeed_1M
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetDownloadSpeed_5M() uint64 {
+	if x != nil {
+		return x.DownloadSpeed_5M
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetDownloadSpeed_15M() uint64 {
+	if x != nil {
+		return x.DownloadSpeed_15M
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetUploadSpeed_1M() uint64 {
+	if x != nil {
+		return x.UploadSpeed_1M
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetUploadSpeed_5M() uint64 {
+	if x != nil {
+		return x.UploadSpeed_5M
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetUploadSpeed_15M() uint64 {
+	if x != nil {
+		return x.UploadSpeed_15M
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetActiveConnections() uint64 {
+	if x != nil {
+		return x.ActiveConnections
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetNewConnections() uint64 {
+	if x != nil {
+		return x.NewConnections
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetClosedConnections() uint64 {
+	if x != nil {
+		return x.ClosedConnections
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetFailedConnections() uint64 {
+	if x != nil {
+		return x.FailedConnections
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetNewConnRate() float64 {
+	if x != nil {
+		return x.NewConnRate
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetClosedConnRate() float64 {
+	if x != nil {
+		return x.ClosedConnRate
+	}
+	return 0
+}
+
+func (x *GlobalStats) GetFailedConnRate() float64 {
+	if x != nil {
+		return x.FailedConnRate
+	}
+	return 0
+}
+
+type ListClientsRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	IncludeInactive bool                   `protobuf:"varint,1,opt,name=include_inactive,json=includeInactive,proto3" json:"include_inactive,omitempty"` // Also list offline clients
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *ListClientsRequest) Reset() {
+	*x = ListClientsRequest{}
+	mi := &file_catchmole_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListClientsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListClientsRequest) ProtoMessage() {}
+
+func (x *ListClientsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_catchmole_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListClientsRequest.ProtoReflect.Descriptor instead.
+func (*ListClientsRequest) Descriptor() ([]byte, []int) {
+	return file_catchmole_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListClientsRequest) GetIncludeInactive() bool {
+	if x != nil {
+		return x.IncludeInactive
+	}
+	return false
+}
+
+type ListClientsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Clients       []*Client              `protobuf:"bytes,1,rep,name=clients,proto3" json:"clients,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListClientsResponse) Reset() {
+	*x = ListClientsResponse{}
+	mi := &file_catchmole_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListClientsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListClientsResponse) ProtoMessage() {}
+
+func (x *ListClientsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_catchmole_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListClientsResponse.ProtoReflect.Descriptor instead.
+func (*ListClientsResponse) Descriptor() ([]byte, []int) {
+	return file_catchmole_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListClientsResponse) GetClients() []*Client {
+	if x != nil {
+		return x.Clients
+	}
+	return nil
+}
+
+type Client struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Mac               string                 `protobuf:"bytes,1,opt,name=mac,proto3" json:"mac,omitempty"`
+	Name              string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	TotalDownload     uint64                 `protobuf:"varint,3,opt,name=total_download,json=totalDownload,proto3" json:"total_download,omitempty"`
+	TotalUpload       uint64                 `protobuf:"varint,4,opt,name=total_upload,json=totalUpload,proto3" json:"total_upload,omitempty"`
+	SessionDownload   uint64                 `protobuf:"varint,5,opt,name=session_download,json=sessionDownload,proto3" json:"session_download,omitempty"`
+	SessionUpload     uint64                 `protobuf:"varint,6,opt,name=session_upload,json=sessionUpload,proto3" json:"session_upload,omitempty"`
+	DownloadSpeed     uint64                 `protobuf:"varint,7,opt,name=download_speed,json=downloadSpeed,proto3" json:"download_speed,omitempty"` // Bytes/sec
+	UploadSpeed       uint64                 `protobuf:"varint,8,opt,name=upload_speed,json=uploadSpeed,proto3" json:"upload_speed,omitempty"`
+	ActiveConnections uint64                 `protobuf:"varint,9,opt,name=active_connections,json=activeConnections,proto3" json:"active_connections,omitempty"`
+	StartTime         *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	LastActive        *timestamppb.Timestamp `protobuf:"bytes,11,opt,name=last_active,json=lastActive,proto3" json:"last_active,omitempty"`
+	Online            bool                   `protobuf:"varint,12,opt,name=online,proto3" json:"online,omitempty"`
+	Hostname          string                 `protobuf:"bytes,13,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Ssid              string                 `protobuf:"bytes,14,opt,name=ssid,proto3" json:"ssid,omitempty"`
+	Vlan              uint32                 `protobuf:"varint,15,opt,name=vlan,proto3" json:"vlan,omitempty"` // 0 if untagged
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *Client) Reset() {
+	*x = Client{}
+	mi := &file_catchmole_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Client) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Client) ProtoMessage() {}
+
+func (x *Client) ProtoReflect() protoreflect.Message {
+	mi := &file_catchmole_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Client.ProtoReflect.Descriptor instead.
+func (*Client) Descriptor() ([]byte, []int) {
+	return file_catchmole_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Client) GetMac() string {
+	if x != nil {
+		return x.Mac
+	}
+	return ""
+}
+
+func (x *Client) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Client) GetTotalDownload() uint64 {
+	if x != nil {
+		return x.TotalDownload
+	}
+	return 0
+}
+
+func (x *Client) GetTotalUpload() uint64 {
+	if x != nil {
+		return x.TotalUpload
+	}
+	return 0
+}
+
+func (x *Client) GetSessionDownload() uint64 {
+	if x != nil {
+		return x.SessionDownload
+	}
+	return 0
+}
+
+func (x *Client) GetSessionUpload() uint64 {
+	if x != nil {
+		return x.SessionUpload
+	}
+	return 0
+}
+
+func (x *Client) GetDownloadSpeed() uint64 {
+	if x != nil {
+		return x.DownloadSpeed
+	}
+	return 0
+}
+
+func (x *Client) GetUploadSpeed() uint64 {
+	if x != nil {
+		return x.UploadSpeed
+	}
+	return 0
+}
+
+func (x *Client) GetActiveConnections() uint64 {
+	if x != nil {
+		return x.ActiveConnections
+	}
+	return 0
+}
+
+func (x *Client) GetStartTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartTime
+	}
+	return nil
+}
+
+func (x *Client) GetLastActive() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastActive
+	}
+	return nil
+}
+
+func (x *Client) GetOnline() bool {
+	if x != nil {
+		return x.Online
+	}
+	return false
+}
+
+func (x *Client) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *Client) GetSsid() string {
+	if x != nil {
+		return x.Ssid
+	}
+	return ""
+}
+
+func (x *Client) GetVlan() uint32 {
+	if x != nil {
+		return x.Vlan
+	}
+	return 0
+}
+
+type StreamFlowsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Proto         string                 `protobuf:"bytes,1,opt,name=proto,proto3" json:"proto,omitempty"`                              // tcp, udp, icmp or a number, empty for all
+	Port          uint32                 `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`                               // Client or remote port
+	Remote        string                 `protobuf:"bytes,3,opt,name=remote,proto3" json:"remote,omitempty"`                            // IP or CIDR
+	MinSpeed      uint64                 `protobuf:"varint,4,opt,name=min_speed,json=minSpeed,proto3" json:"min_speed,omitempty"`       // Bytes/sec, download plus upload
+	By            string                 `protobuf:"bytes,5,opt,name=by,proto3" json:"by,omitempty"`                                    // Sort key, default speed
+	Limit         uint32                 `protobuf:"varint,6,opt,name=limit,proto3" json:"limit,omitempty"`                             // Default 100
+	IntervalMs    uint32                 `protobuf:"varint,7,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"` // Default the refresh interval
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamFlowsRequest) Reset() {
+	*x = StreamFlowsRequest{}
+	mi := &file_catchmole_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamFlowsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamFlowsRequest) ProtoMessage() {}
+
+func (x *StreamFlowsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_catchmole_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamFlowsRequest.ProtoReflect.Descriptor instead.
+func (*StreamFlowsRequest) Descriptor() ([]byte, []int) {
+	return file_catchmole_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *StreamFlowsRequest) GetProto() string {
+	if x != nil {
+		return x.Proto
+	}
+	return ""
+}
+
+func (x *StreamFlowsRequest) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *StreamFlowsRequest) GetRemote() string {
+	if x != nil {
+		return x.Remote
+	}
+	return ""
+}
+
+func (x *StreamFlowsRequest) GetMinSpeed() uint64 {
+	if x != nil {
+		return x.MinSpeed
+	}
+	return 0
+}
+
+func (x *StreamFlowsRequest) GetBy() string {
+	if x != nil {
+		return x.By
+	}
+	return ""
+}
+
+func (x *StreamFlowsRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *StreamFlowsRequest) GetIntervalMs() uint32 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+type FlowList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	By            string                 `protobuf:"bytes,2,opt,name=by,proto3" json:"by,omitempty"`
+	Count         uint32                 `protobuf:"varint,3,opt,name=count,proto3" json:"count,omitempty"` // Matching flows before the limit
+	Flows         []*Flow                `protobuf:"bytes,4,rep,name=flows,proto3" json:"flows,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FlowList) Reset() {
+	*x = FlowList{}
+	mi := &file_catchmole_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FlowList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlowList) ProtoMessage() {}
+
+func (x *FlowList) ProtoReflect() protoreflect.Message {
+	mi := &file_catchmole_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlowList.ProtoReflect.Descriptor instead.
+func (*FlowList) Descriptor() ([]byte, []int) {
+	return file_catchmole_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *FlowList) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *FlowList) GetBy() string {
+	if x != nil {
+		return x.By
+	}
+	return ""
+}
+
+func (x *FlowList) GetCount() uint32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *FlowList) GetFlows() []*Flow {
+	if x != nil {
+		return x.Flows
+	}
+	return nil
+}
+
+type Flow struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Mac            string                 `protobuf:"bytes,1,opt,name=mac,proto3" json:"mac,omitempty"`
+	Name           string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Protocol       string                 `protobuf:"bytes,3,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	ClientIp       string                 `protobuf:"bytes,4,opt,name=client_ip,json=clientIp,proto3" json:"client_ip,omitempty"`
+	ClientPort     uint32                 `protobuf:"varint,5,opt,name=client_port,json=clientPort,proto3" json:"client_port,omitempty"`
+	RemoteIp       string                 `protobuf:"bytes,6,opt,name=remote_ip,json=remoteIp,proto3" json:"remote_ip,omitempty"`
+	RemotePort     uint32                 `protobuf:"varint,7,opt,name=remote_port,json=remotePort,proto3" json:"remote_port,omitempty"`
+	RemoteHostname string                 `protobuf:"bytes,8,opt,name=remote_hostname,json=remoteHostname,proto3" json:"remote_hostname,omitempty"`
+	RemoteCountry  string                 `protobuf:"bytes,9,opt,name=remote_country,json=remoteCountry,proto3" json:"remote_country,omitempty"`
+	RemoteAsn      uint32                 `protobuf:"varint,10,opt,name=remote_asn,json=remoteAsn,proto3" json:"remote_asn,omitempty"`
+	RemoteOrg      string                 `protobuf:"bytes,11,opt,name=remote_org,json=remoteOrg,proto3" json:"remote_org,omitempty"`
+	TotalDownload  uint64                 `protobuf:"varint,12,opt,name=total_download,json=totalDownload,proto3" json:"total_download,omitempty"`
+	TotalUpload    uint64                 `protobuf:"varint,13,opt,name=total_upload,json=totalUpload,proto3" json:"total_upload,omitempty"`
+	DownloadSpeed  uint64                 `protobuf:"varint,14,opt,name=download_speed,json=downloadSpeed,proto3" json:"download_speed,omitempty"`
+	UploadSpeed    uint64                 `protobuf:"varint,15,opt,name=upload_speed,json=uploadSpeed,proto3" json:"upload_speed,omitempty"`
+	FirstSeen      *timestamppb.Timestamp `protobuf:"bytes,16,opt,name=first_seen,json=firstSeen,proto3" json:"first_seen,omitempty"`
+	LastSeen       *timestamppb.Timestamp `protobuf:"bytes,17,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`
+	TcpState       string                 `protobuf:"bytes,18,opt,name=tcp_state,json=tcpState,proto3" json:"tcp_state,omitempty"`
+	Tag            string                 `protobuf:"bytes,19,opt,name=tag,proto3" json:"tag,omitempty"`
+	ProtoInfo      string                 `protobuf:"bytes,20,opt,name=proto_info,json=protoInfo,proto3" json:"proto_info,omitempty"` // Port-less protocols, e.g. ICMP "echo request id 7"
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Flow) Reset() {
+	*x = Flow{}
+	mi := &file_catchmole_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Flow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Flow) ProtoMessage() {}
+
+func (x *Flow) ProtoReflect() protoreflect.Message {
+	mi := &file_catchmole_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Flow.ProtoReflect.Descriptor instead.
+func (*Flow) Descriptor() ([]byte, []int) {
+	return file_catchmole_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Flow) GetMac() string {
+	if x != nil {
+		return x.Mac
+	}
+	return ""
+}
+
+func (x *Flow) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Flow) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *Flow) GetClientIp() string {
+	if x != nil {
+		return x.ClientIp
+	}
+	return ""
+}
+
+func (x *Flow) GetClientPort() uint32 {
+	if x != nil {
+		return x.ClientPort
+	}
+	return 0
+}
+
+func (x *Flow) GetRemoteIp() string {
+	if x != nil {
+		return x.RemoteIp
+	}
+	return ""
+}
+
+func (x *Flow) GetRemotePort() uint32 {
+	if x != nil {
+		return x.RemotePort
+	}
+	return 0
+}
+
+func (x *Flow) GetRemoteHostname() string {
+	if x != nil {
+		return x.RemoteHostname
+	}
+	return ""
+}
+
+func (x *Flow) GetRemoteCountry() string {
+	if x != nil {
+		return x.RemoteCountry
+	}
+	return ""
+}
+
+func (x *Flow) GetRemoteAsn() uint32 {
+	if x != nil {
+		return x.RemoteAsn
+	}
+	return 0
+}
+
+func (x *Flow) GetRemoteOrg() string {
+	if x != nil {
+		return x.RemoteOrg
+	}
+	return ""
+}
+
+func (x *Flow) GetTotalDownload() uint64 {
+	if x != nil {
+		return x.TotalDownload
+	}
+	return 0
+}
+
+func (x *Flow) GetTotalUpload() uint64 {
+	if x != nil {
+		return x.TotalUpload
+	}
+	return 0
+}
+
+func (x *Flow) GetDownloadSpeed() uint64 {
+	if x != nil {
+		return x.DownloadSpeed
+	}
+	return 0
+}
+
+func (x *Flow) GetUploadSpeed() uint64 {
+	if x != nil {
+		return x.UploadSpeed
+	}
+	return 0
+}
+
+func (x *Flow) GetFirstSeen() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FirstSeen
+	}
+	return nil
+}
+
+func (x *Flow) GetLastSeen() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastSeen
+	}
+	return nil
+}
+
+func (x *Flow) GetTcpState() string {
+	if x != nil {
+		return x.TcpState
+	}
+	return ""
+}
+
+func (x *Flow) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *Flow) GetProtoInfo() string {
+	if x != nil {
+		return x.ProtoInfo
+	}
+	return ""
+}
+
+var File_catchmole_proto protoreflect.FileDescriptor
+
+const file_catchmole_proto_rawDesc = "" +
+	"\n" +
+	"\x0fcatchmole.proto\x12\fcatchmole.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x12\n" +
+	"\x10GetGlobalRequest\"\x8a\x06\n" +
+	"\vGlobalStats\x129\n" +
+	"\n" +
+	"start_time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\tstartTime\x12%\n" +
+	"\x0etotal_download\x18\x02 \x01(\x04R\rtotalDownload\x12!\n" +
+	"\ftotal_upload\x18\x03 \x01(\x04R\vtotalUpload\x12%\n" +
+	"\x0edownload_speed\x18\x04 \x01(\x04R\rdownloadSpeed\x12!\n" +
+	"\fupload_speed\x18\x05 \x01(\x04R\vuploadSpeed\x12*\n" +
+	"\x11download_speed_1m\x18\x06 \x01(\x04R\x0fdownloadSpeed1m\x12*\n" +
+	"\x11download_speed_5m\x18\a \x01(\x04R\x0fdownloadSpeed5m\x12,\n" +
+	"\x12download_speed_15m\x18\b \x01(\x04R\x10downloadSpeed15m\x12&\n" +
+	"\x0fupload_speed_1m\x18\t \x01(\x04R\ruploadSpeed1m\x12&\n" +
+	"\x0fupload_speed_5m\x18\n" +
+	" \x01(\x04R\ruploadSpeed5m\x12(\n" +
+	"\x10upload_speed_15m\x18\v \x01(\x04R\x0euploadSpeed15m\x12-\n" +
+	"\x12active_connections\x18\f \x01(\x04R\x11activeConnections\x12'\n" +
+	"\x0fnew_connections\x18\r \x01(\x04R\x0enewConnections\x12-\n" +
+	"\x12closed_connections\x18\x0e \x01(\x04R\x11closedConnections\x12-\n" +
+	"\x12failed_connections\x18\x0f \x01(\x04R\x11failedConnections\x12\"\n" +
+	"\rnew_conn_rate\x18\x10 \x01(\x01R\vnewConnRate\x12(\n" +
+	"\x10closed_conn_rate\x18\x11 \x01(\x01R\x0eclosedConnRate\x12(\n" +
+	"\x10failed_conn_rate\x18\x12 \x01(\x01R\x0efailedConnRate\"?\n" +
+	"\x12ListClientsRequest\x12)\n" +
+	"\x10include_inactive\x18\x01 \x01(\bR\x0fincludeInactive\"E\n" +
+	"\x13ListClientsResponse\x12.\n" +
+	"\aclients\x18\x01 \x03(\v2\x14.catchmole.v1.ClientR\aclients\"\x97\x04\n" +
+	"\x06Client\x12\x10\n" +
+	"\x03mac\x18\x01 \x01(\tR\x03mac\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12%\n" +
+	"\x0etotal_download\x18\x03 \x01(\x04R\rtotalDownload\x12!\n" +
+	"\ftotal_upload\x18\x04 \x01(\x04R\vtotalUpload\x12)\n" +
+	"\x10session_download\x18\x05 \x01(\x04R\x0fsessionDownload\x12%\n" +
+	"\x0esession_upload\x18\x06 \x01(\x04R\rsessionUpload\x12%\n" +
+	"\x0edownload_speed\x18\a \x01(\x04R\rdownloadSpeed\x12!\n" +
+	"\fupload_speed\x18\b \x01(\x04R\vuploadSpeed\x12-\n" +
+	"\x12active_connections\x18\t \x01(\x04R\x11activeConnections\x129\n" +
+	"\n" +
+	"start_time\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\tstartTime\x12;\n" +
+	"\vlast_active\x18\v \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"lastActive\x12\x16\n" +
+	"\x06online\x18\f \x01(\bR\x06online\x12\x1a\n" +
+	"\bhostname\x18\r \x01(\tR\bhostname\x12\x12\n" +
+	"\x04ssid\x18\x0e \x01(\tR\x04ssid\x12\x12\n" +
+	"\x04vlan\x18\x0f \x01(\rR\x04vlan\"\xba\x01\n" +
+	"\x12StreamFlowsRequest\x12\x14\n" +
+	"\x05proto\x18\x01 \x01(\tR\x05proto\x12\x12\n" +
+	"\x04port\x18\x02 \x01(\rR\x04port\x12\x16\n" +
+	"\x06remote\x18\x03 \x01(\tR\x06remote\x12\x1b\n" +
+	"\tmin_speed\x18\x04 \x01(\x04R\bminSpeed\x12\x0e\n" +
+	"\x02by\x18\x05 \x01(\tR\x02by\x12\x14\n" +
+	"\x05limit\x18\x06 \x01(\rR\x05limit\x12\x1f\n" +
+	"\vinterval_ms\x18\a \x01(\rR\n" +
+	"intervalMs\"\x8a\x01\n" +
+	"\bFlowList\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x0e\n" +
+	"\x02by\x18\x02 \x01(\tR\x02by\x12\x14\n" +
+	"\x05count\x18\x03 \x01(\rR\x05count\x12(\n" +
+	"\x05flows\x18\x04 \x03(\v2\x12.catchmole.v1.FlowR\x05flows\"\xa8\x05\n" +
+	"\x04Flow\x12\x10\n" +
+	"\x03mac\x18\x01 \x01(\tR\x03mac\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x1a\n" +
+	"\bprotocol\x18\x03 \x01(\tR\bprotocol\x12\x1b\n" +
+	"\tclient_ip\x18\x04 \x01(\tR\bclientIp\x12\x1f\n" +
+	"\vclient_port\x18\x05 \x01(\rR\n" +
+	"clientPort\x12\x1b\n" +
+	"\tremote_ip\x18\x06 \x01(\tR\bremoteIp\x12\x1f\n" +
+	"\vremote_port\x18\a \x01(\rR\n" +
+	"remotePort\x12'\n" +
+	"\x0fremote_hostname\x18\b \x01(\tR\x0eremoteHostname\x12%\n" +
+	"\x0eremote_country\x18\t \x01(\tR\rremoteCountry\x12\x1d\n" +
+	"\n" +
+	"remote_asn\x18\n" +
+	" \x01(\rR\tremoteAsn\x12\x1d\n" +
+	"\n" +
+	"remote_org\x18\v \x01(\tR\tremoteOrg\x12%\n" +
+	"\x0etotal_download\x18\f \x01(\x04R\rtotalDownload\x12!\n" +
+	"\ftotal_upload\x18\r \x01(\x04R\vtotalUpload\x12%\n" +
+	"\x0edownload_speed\x18\x0e \x01(\x04R\rdownloadSpeed\x12!\n" +
+	"\fupload_speed\x18\x0f \x01(\x04R\vuploadSpeed\x129\n" +
+	"\n" +
+	"first_seen\x18\x10 \x01(\v2\x1a.google.protobuf.TimestampR\tfirstSeen\x127\n" +
+	"\tlast_seen\x18\x11 \x01(\v2\x1a.google.protobuf.TimestampR\blastSeen\x12\x1b\n" +
+	"\ttcp_state\x18\x12 \x01(\tR\btcpState\x12\x10\n" +
+	"\x03tag\x18\x13 \x01(\tR\x03tag\x12\x1d\n" +
+	"\n" +
+	"proto_info\x18\x14 \x01(\tR\tprotoInfo2\xf2\x01\n" +
+	"\tCatchMole\x12F\n" +
+	"\tGetGlobal\x12\x1e.catchmole.v1.GetGlobalRequest\x1a\x19.catchmole.v1.GlobalStats\x12R\n" +
+	"\vListClients\x12 .catchmole.v1.ListClientsRequest\x1a!.catchmole.v1.ListClientsResponse\x12I\n" +
+	"\vStreamFlows\x12 .catchmole.v1.StreamFlowsRequest\x1a\x16.catchmole.v1.FlowList0\x01B0Z.github.com/kisy/catchmole/pkg/api/grpc;grpcapib\x06proto3"
+
+var (
+	file_catchmole_proto_rawDescOnce sync.Once
+	file_catchmole_proto_rawDescData []byte
+)
+
+func file_catchmole_proto_rawDescGZIP() []byte {
+	file_catchmole_proto_rawDescOnce.Do(func() {
+		file_catchmole_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_catchmole_proto_rawDesc), len(file_catchmole_proto_rawDesc)))
+	})
+	return file_catchmole_proto_rawDescData
+}
+
+var file_catchmole_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_catchmole_proto_goTypes = []any{
+	(*GetGlobalRequest)(nil),      // 0: catchmole.v1.GetGlobalRequest
+	(*GlobalStats)(nil),           // 1: catchmole.v1.GlobalStats
+	(*ListClientsRequest)(nil),    // 2: catchmole.v1.ListClientsRequest
+	(*ListClientsResponse)(nil),   // 3: catchmole.v1.ListClientsResponse
+	(*Client)(nil),                // 4: catchmole.v1.Client
+	(*StreamFlowsRequest)(nil),    // 5: catchmole.v1.StreamFlowsRequest
+	(*FlowList)(nil),              // 6: catchmole.v1.FlowList
+	(*Flow)(nil),                  // 7: catchmole.v1.Flow
+	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
+}
+var file_catchmole_proto_depIdxs = []int32{
+	8,  // 0: catchmole.v1.GlobalStats.start_time:type_name -> google.protobuf.Timestamp
+	4,  // 1: catchmole.v1.ListClientsResponse.clients:type_name -> catchmole.v1.Client
+	8,  // 2: catchmole.v1.Client.start_time:type_name -> google.protobuf.Timestamp
+	8,  // 3: catchmole.v1.Client.last_active:type_name -> google.protobuf.Timestamp
+	8,  // 4: catchmole.v1.FlowList.time:type_name -> google.protobuf.Timestamp
+	7,  // 5: catchmole.v1.FlowList.flows:type_name -> catchmole.v1.Flow
+	8,  // 6: catchmole.v1.Flow.first_seen:type_name -> google.protobuf.Timestamp
+	8,  // 7: catchmole.v1.Flow.last_seen:type_name -> google.protobuf.Timestamp
+	0,  // 8: catchmole.v1.CatchMole.GetGlobal:input_type -> catchmole.v1.GetGlobalRequest
+	2,  // 9: catchmole.v1.CatchMole.ListClients:input_type -> catchmole.v1.ListClientsRequest
+	5,  // 10: catchmole.v1.CatchMole.StreamFlows:input_type -> catchmole.v1.StreamFlowsRequest
+	1,  // 11: catchmole.v1.CatchMole.GetGlobal:output_type -> catchmole.v1.GlobalStats
+	3,  // 12: catchmole.v1.CatchMole.ListClients:output_type -> catchmole.v1.ListClientsResponse
+	6,  // 13: catchmole.v1.CatchMole.StreamFlows:output_type -> catchmole.v1.FlowList
+	11, // [11:14] is the sub-list for method output_type
+	8,  // [8:11] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_catchmole_proto_init() }
+func file_catchmole_proto_init() {
+	if File_catchmole_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_catchmole_proto_rawDesc), len(file_catchmole_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_catchmole_proto_goTypes,
+		DependencyIndexes: file_catchmole_proto_depIdxs,
+		MessageInfos:      file_catchmole_proto_msgTypes,
+	}.Build()
+	File_catchmole_proto = out.File
+	file_catchmole_proto_goTypes = nil
+	file_catchmole_proto_depIdxs = nil
+}
@@ -0,0 +1,211 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             v5.29.3
+// source: catchmole.proto
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	CatchMole_GetGlobal_FullMethodName   = "/catchmole.v1.CatchMole/GetGlobal"
+	CatchMole_ListClients_FullMethodName = "/catchmole.v1.CatchMole/ListClients"
+	CatchMole_StreamFlows_FullMethodName = "/catchmole.v1.CatchMole/StreamFlows"
+)
+
+// CatchMoleClient is the client API for CatchMole service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// CatchMole serves the same statistics as the HTTP JSON API.
+type CatchMoleClient interface {
+	// GetGlobal returns the internet traffic totals and speeds.
+	GetGlobal(ctx context.Context, in *GetGlobalRequest, opts ...grpc.CallOption) (*GlobalStats, error)
+	// ListClients returns the tracked LAN clients.
+	ListClients(ctx context.Context, in *ListClientsRequest, opts ...grpc.CallOption) (*ListClientsResponse, error)
+	// StreamFlows sends a filtered view of the flow table every refresh interval.
+	StreamFlows(ctx context.Context, in *StreamFlowsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FlowList], error)
+}
+
+type catchMoleClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCatchMoleClient(cc grpc.ClientConnInterface) CatchMoleClient {
+	return &catchMoleClient{cc}
+}
+
+func (c *catchMoleClient) GetGlobal(ctx context.Context, in *GetGlobalRequest, opts ...grpc.CallOption) (*GlobalStats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GlobalStats)
+	err := c.cc.Invoke(ctx, CatchMole_GetGlobal_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *catchMoleClient) ListClients(ctx context.Context, in *ListClientsRequest, opts ...grpc.CallOption) (*ListClientsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListClientsResponse)
+	err := c.cc.Invoke(ctx, CatchMole_ListClients_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *catchMoleClient) StreamFlows(ctx context.Context, in *StreamFlowsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FlowList], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CatchMole_ServiceDesc.Streams[0], CatchMole_StreamFlows_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamFlowsRequest, FlowList]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CatchMole_StreamFlowsClient = grpc.ServerStreamingClient[FlowList]
+
+// CatchMoleServer is the server API for CatchMole service.
+// All implementations must embed UnimplementedCatchMoleServer
+// for forward compatibility.
+//
+// CatchMole serves the same statistics as the HTTP JSON API.
+type CatchMoleServer interface {
+	// GetGlobal returns the internet traffic totals and speeds.
+	GetGlobal(context.Context, *GetGlobalRequest) (*GlobalStats, error)
+	// ListClients returns the tracked LAN clients.
+	ListClients(context.Context, *ListClientsRequest) (*ListClientsResponse, error)
+	// StreamFlows sends a filtered view of the flow table every refresh interval.
+	StreamFlows(*StreamFlowsRequest, grpc.ServerStreamingServer[FlowList]) error
+	mustEmbedUnimplementedCatchMoleServer()
+}
+
+// UnimplementedCatchMoleServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCatchMoleServer struct{}
+
+func (UnimplementedCatchMoleServer) GetGlobal(context.Context, *GetGlobalRequest) (*GlobalStats, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetGlobal not implemented")
+}
+func (UnimplementedCatchMoleServer) ListClients(context.Context, *ListClientsRequest) (*ListClientsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListClients not implemented")
+}
+func (UnimplementedCatchMoleServer) StreamFlows(*StreamFlowsRequest, grpc.ServerStreamingServer[FlowList]) error {
+	return status.Error(codes.Unimplemented, "method StreamFlows not implemented")
+}
+func (UnimplementedCatchMoleServer) mustEmbedUnimplementedCatchMoleServer() {}
+func (UnimplementedCatchMoleServer) testEmbeddedByValue()                   {}
+
+// UnsafeCatchMoleServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CatchMoleServer will
+// result in compilation errors.
+type UnsafeCatchMoleServer interface {
+	mustEmbedUnimplementedCatchMoleServer()
+}
+
+func RegisterCatchMoleServer(s grpc.ServiceRegistrar, srv CatchMoleServer) {
+	// If the following call panics, it indicates UnimplementedCatchMoleServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&CatchMole_ServiceDesc, srv)
+}
+
+func _CatchMole_GetGlobal_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetGlobalRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CatchMoleServer).GetGlobal(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CatchMole_GetGlobal_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CatchMoleServer).GetGlobal(ctx, req.(*GetGlobalRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CatchMole_ListClients_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListClientsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CatchMoleServer).ListClients(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CatchMole_ListClients_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CatchMoleServer).ListClients(ctx, req.(*ListClientsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CatchMole_StreamFlows_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamFlowsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CatchMoleServer).StreamFlows(m, &grpc.GenericServerStream[StreamFlowsRequest, FlowList]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CatchMole_StreamFlowsServer = grpc.ServerStreamingServer[FlowList]
+
+// CatchMole_ServiceDesc is the grpc.ServiceDesc for CatchMole service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CatchMole_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "catchmole.v1.CatchMole",
+	HandlerType: (*CatchMoleServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetGlobal",
+			Handler:    _CatchMole_GetGlobal_Handler,
+		},
+		{
+			MethodName: "ListClients",
+			Handler:    _CatchMole_ListClients_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamFlows",
+			Handler:       _CatchMole_StreamFlows_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "catchmole.proto",
+}
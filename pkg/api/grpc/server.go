@@ -0,0 +1,267 @@
+// Package grpcapi serves the statistics over gRPC, typed and streaming,
+// alongside the HTTP JSON API.
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative catchmole.proto
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/stats"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Shortest push interval a client may request
+const minStreamInterval = 100 * time.Millisecond
+
+type Server struct {
+	UnimplementedCatchMoleServer
+
+	agg  *stats.Aggregator
+	grpc *grpc.Server
+	stop chan struct{} // Closed on Stop, ends the streams
+
+	authMu       sync.RWMutex
+	authUser     string
+	authPassword string
+	authToken    string
+}
+
+// NewServer creates the gRPC service. creds enables TLS, nil serves plaintext.
+func NewServer(agg *stats.Aggregator, creds credentials.TransportCredentials) *Server {
+	s := &Server{agg: agg, stop: make(chan struct{})}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, h grpc.UnaryHandler) (any, error) {
+			if err := s.authorize(ctx); err != nil {
+				return nil, err
+			}
+			return h(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+			if err := s.authorize(ss.Context()); err != nil {
+				return err
+			}
+			return h(srv, ss)
+		}),
+	}
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+	s.grpc = grpc.NewServer(opts...)
+	RegisterCatchMoleServer(s.grpc, s)
+	return s
+}
+
+// SetAuth uses the web credentials: "authorization" metadata with
+// "Bearer <token>" or "Basic <base64 user:password>". Empty disables a method.
+func (s *Server) SetAuth(user, password, token string) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	s.authUser = user
+	s.authPassword = password
+	s.authToken = token
+}
+
+func (s *Server) authorize(ctx context.Context) error {
+	s.authMu.RLock()
+	user, password, token := s.authUser, s.authPassword, s.authToken
+	s.authMu.RUnlock()
+	if user == "" && token == "" {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		scheme, cred, _ := strings.Cut(v, " ")
+		switch {
+		case strings.EqualFold(scheme, "Bearer") && token != "":
+			if subtle.ConstantTimeCompare([]byte(cred), []byte(token)) == 1 {
+				return nil
+			}
+		case strings.EqualFold(scheme, "Basic") && user != "":
+			raw, err := base64.StdEncoding.DecodeString(cred)
+			if err != nil {
+				continue
+			}
+			u, p, _ := strings.Cut(string(raw), ":")
+			if subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1 &&
+				subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1 {
+				return nil
+			}
+		}
+	}
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	return s.grpc.Serve(l)
+}
+
+// Stop ends streams and waits for pending calls until ctx is done
+func (s *Server) Stop(ctx context.Context) {
+	close(s.stop)
+	done := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.grpc.Stop()
+	}
+}
+
+func (s *Server) GetGlobal(context.Context, *GetGlobalRequest) (*GlobalStats, error) {
+	g := s.agg.GetGlobalStats()
+	return &GlobalStats{
+		StartTime:         timestamppb.New(s.agg.GetStartTime()),
+		TotalDownload:     g.TotalDownload,
+		TotalUpload:       g.TotalUpload,
+		DownloadSpeed:     g.DownloadSpeed,
+		UploadSpeed:       g.UploadSpeed,
+		DownloadSpeed_1M:  g.DownloadSpeed1m,
+		DownloadSpeed_5M:  g.DownloadSpeed5m,
+		DownloadSpeed_15M: g.DownloadSpeed15m,
+		UploadSpeed_1M:    g.UploadSpeed1m,
+		UploadSpeed_5M:    g.UploadSpeed5m,
+		UploadSpeed_15M:   g.UploadSpeed15m,
+		ActiveConnections: g.ActiveConnections,
+		NewConnections:    g.NewConnections,
+		ClosedConnections: g.ClosedConnections,
+		FailedConnections: g.FailedConnections,
+		NewConnRate:       g.NewConnRate,
+		ClosedConnRate:    g.ClosedConnRate,
+		FailedConnRate:    g.FailedConnRate,
+	}, nil
+}
+
+func (s *Server) ListClients(_ context.Context, req *ListClientsRequest) (*ListClientsResponse, error) {
+	resp := &ListClientsResponse{}
+	for _, c := range s.agg.GetClients() {
+		if !c.Online && !req.GetIncludeInactive() {
+			continue
+		}
+		resp.Clients = append(resp.Clients, &Client{
+			Mac:               c.MAC,
+			Name:              c.Name,
+			TotalDownload:     c.TotalDownload,
+			TotalUpload:       c.TotalUpload,
+			SessionDownload:   c.SessionDownload,
+			SessionUpload:     c.SessionUpload,
+			DownloadSpeed:     c.DownloadSpeed,
+			UploadSpeed:       c.UploadSpeed,
+			ActiveConnections: c.ActiveConnections,
+			StartTime:         timestamppb.New(c.StartTime),
+			LastActive:        timestamppb.New(c.LastActive),
+			Online:            c.Online,
+			Hostname:          c.Hostname,
+			Ssid:              c.SSID,
+			Vlan:              uint32(c.VLAN),
+		})
+	}
+	return resp, nil
+}
+
+func (s *Server) StreamFlows(req *StreamFlowsRequest, stream grpc.ServerStreamingServer[FlowList]) error {
+	filter := stats.FlowFilter{
+		Proto:    req.GetProto(),
+		MinSpeed: req.GetMinSpeed(),
+	}
+	if req.GetPort() > 0xffff {
+		return status.Error(codes.InvalidArgument, "invalid port")
+	}
+	filter.Port = uint16(req.GetPort())
+	if v := req.GetRemote(); v != "" {
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(v)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, "invalid remote, want IP or CIDR")
+		}
+		filter.Remote = ipnet
+	}
+	by := req.GetBy()
+	if by == "" {
+		by = "speed"
+	}
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = 100
+	}
+	interval := s.agg.GetInterval()
+	if req.GetIntervalMs() > 0 {
+		interval = max(time.Duration(req.GetIntervalMs())*time.Millisecond, minStreamInterval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		list, err := s.agg.GetFlows(filter, by, limit, 0)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		msg := &FlowList{
+			Time:  timestamppb.Now(),
+			By:    list.By,
+			Count: uint32(list.Count),
+			Flows: make([]*Flow, 0, len(list.Flows)),
+		}
+		for _, f := range list.Flows {
+			msg.Flows = append(msg.Flows, toFlow(f))
+		}
+		if err := stream.Send(msg); err != nil {
+			return err
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.stop:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case <-ticker.C:
+		}
+	}
+}
+
+func toFlow(f model.Flow) *Flow {
+	return &Flow{
+		Mac:            f.MAC,
+		Name:           f.Name,
+		Protocol:       f.Protocol,
+		ClientIp:       f.ClientIP,
+		ClientPort:     uint32(f.ClientPort),
+		RemoteIp:       f.RemoteIP,
+		RemotePort:     uint32(f.RemotePort),
+		RemoteHostname: f.RemoteHostname,
+		RemoteCountry:  f.RemoteCountry,
+		RemoteAsn:      uint32(f.RemoteASN),
+		RemoteOrg:      f.RemoteOrg,
+		TotalDownload:  f.TotalDownload,
+		TotalUpload:    f.TotalUpload,
+		DownloadSpeed:  f.DownloadSpeed,
+		UploadSpeed:    f.UploadSpeed,
+		FirstSeen:      timestamppb.New(f.FirstSeen),
+		LastSeen:       timestamppb.New(f.LastSeen),
+		TcpState:       f.TCPState,
+		ProtoInfo:      f.ProtoInfo,
+		Tag:            f.Tag,
+	}
+}
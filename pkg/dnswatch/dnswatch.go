@@ -0,0 +1,335 @@
+package dnswatch
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/pkg/monitor"
+	"golang.org/x/net/bpf"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Connections outlive DNS TTLs, so keep names at least this long
+	minEntryTTL = 1 * time.Hour
+	// Upper bound of cached IPs
+	maxEntries = 20000
+	// Concurrent PTR lookups
+	maxPTRLookups = 4
+)
+
+type entry struct {
+	Name    string
+	Expires time.Time
+}
+
+// Watcher labels remote IPs with hostnames. It sniffs DNS responses on the LAN
+// interface so labels reflect the names clients actually queried, and falls
+// back to cached reverse (PTR) lookups.
+type Watcher struct {
+	ifaceName string
+	sniff     bool
+	ptr       bool
+
+	mu      sync.RWMutex
+	names   map[string]entry // Key: IP string
+	pending map[string]struct{}
+	ptrSem  chan struct{}
+
+	fd   int
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewWatcher(ifaceName string, sniff, ptr bool) *Watcher {
+	return &Watcher{
+		ifaceName: ifaceName,
+		sniff:     sniff,
+		ptr:       ptr,
+		names:     make(map[string]entry),
+		pending:   make(map[string]struct{}),
+		ptrSem:    make(chan struct{}, maxPTRLookups),
+		fd:        -1,
+		stop:      make(chan struct{}),
+	}
+}
+
+// Start opens the packet socket (if sniffing is enabled) and begins capturing
+func (w *Watcher) Start() error {
+	if !w.sniff {
+		return nil
+	}
+
+	// Capture in the monitored namespace, which may be the host's
+	var fd int
+	err := monitor.InNetNS(func() (err error) {
+		fd, err = openDNSSocket(w.ifaceName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	w.fd = fd
+
+	w.wg.Go(w.captureLoop)
+	return nil
+}
+
+func (w *Watcher) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+	if w.fd >= 0 {
+		unix.Close(w.fd)
+	}
+}
+
+// Lookup returns the hostname for an IP, or "" if not (yet) known.
+// Unknown IPs are queued for a PTR lookup when enabled.
+func (w *Watcher) Lookup(ip string) string {
+	w.mu.RLock()
+	e, ok := w.names[ip]
+	w.mu.RUnlock()
+
+	if ok && time.Now().Before(e.Expires) {
+		return e.Name
+	}
+
+	if w.ptr {
+		w.lookupPTR(ip)
+	}
+	return e.Name // Stale name is better than none
+}
+
+func (w *Watcher) set(ip, name string, ttl time.Duration) {
+	ttl = max(ttl, minEntryTTL)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.names) >= maxEntries {
+		w.evictLocked()
+	}
+	w.names[ip] = entry{Name: name, Expires: time.Now().Add(ttl)}
+}
+
+// evictLocked drops expired entries, or an arbitrary half when none expired
+func (w *Watcher) evictLocked() {
+	now := time.Now()
+	for ip, e := range w.names {
+		if now.After(e.Expires) {
+			delete(w.names, ip)
+		}
+	}
+	if len(w.names) < maxEntries {
+		return
+	}
+	n := 0
+	for ip := range w.names {
+		delete(w.names, ip)
+		if n++; n >= maxEntries/2 {
+			break
+		}
+	}
+}
+
+func (w *Watcher) lookupPTR(ip string) {
+	w.mu.Lock()
+	if _, busy := w.pending[ip]; busy {
+		w.mu.Unlock()
+		return
+	}
+	select {
+	case w.ptrSem <- struct{}{}:
+	default:
+		// Too many lookups in flight, try again on the next request
+		w.mu.Unlock()
+		return
+	}
+	w.pending[ip] = struct{}{}
+	w.mu.Unlock()
+
+	go func() {
+		defer func() {
+			<-w.ptrSem
+			w.mu.Lock()
+			delete(w.pending, ip)
+			w.mu.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		name := ""
+		if names, err := net.DefaultResolver.LookupAddr(ctx, ip); err == nil && len(names) > 0 {
+			name = strings.TrimSuffix(names[0], ".")
+		}
+
+		// Negative results are cached too, to avoid hammering the resolver
+		w.mu.RLock()
+		existing := w.names[ip].Name
+		w.mu.RUnlock()
+		if name == "" {
+			name = existing
+		}
+		w.set(ip, name, minEntryTTL)
+	}()
+}
+
+func (w *Watcher) captureLoop() {
+	buf := make([]byte, 65536)
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		n, _, err := unix.Recvfrom(w.fd, buf, 0)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue // Read timeout, check stop
+			}
+			slog.Error("DNS capture error", "err", err)
+			return
+		}
+		w.handlePacket(buf[:n])
+	}
+}
+
+// handlePacket parses an IP/UDP packet from source port 53 carrying a DNS response
+func (w *Watcher) handlePacket(b []byte) {
+	if len(b) < 1 {
+		return
+	}
+
+	var payload []byte
+	switch b[0] >> 4 {
+	case 4:
+		ihl := int(b[0]&0x0f) * 4
+		if len(b) < ihl+8 {
+			return
+		}
+		payload = b[ihl+8:]
+	case 6:
+		if len(b) < 48 {
+			return
+		}
+		payload = b[48:]
+	default:
+		return
+	}
+
+	var p dnsmessage.Parser
+	hdr, err := p.Start(payload)
+	if err != nil || !hdr.Response || hdr.RCode != dnsmessage.RCodeSuccess {
+		return
+	}
+
+	q, err := p.Question()
+	if err != nil {
+		return
+	}
+	qname := strings.TrimSuffix(q.Name.String(), ".")
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+
+	for {
+		h, err := p.AnswerHeader()
+		if err != nil {
+			return
+		}
+		ttl := time.Duration(h.TTL) * time.Second
+
+		switch h.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return
+			}
+			w.set(net.IP(r.A[:]).String(), qname, ttl)
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return
+			}
+			w.set(net.IP(r.AAAA[:]).String(), qname, ttl)
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// openDNSSocket opens an AF_PACKET socket filtered to UDP packets from port 53
+func openDNSSocket(ifaceName string) (int, error) {
+	proto := monitor.Htons(unix.ETH_P_ALL)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(proto))
+	if err != nil {
+		return -1, fmt.Errorf("failed to open packet socket: %w", err)
+	}
+
+	ifindex := 0 // All interfaces
+	if ifaceName != "" {
+		iface, err := net.InterfaceByName(ifaceName)
+		if err != nil {
+			unix.Close(fd)
+			return -1, err
+		}
+		ifindex = iface.Index
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: ifindex}); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("failed to bind packet socket: %w", err)
+	}
+
+	// Offsets are relative to the network header (SOCK_DGRAM strips link headers)
+	raw, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 0, Size: 1},                                   // 0: version/IHL
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 4},                  // 1: version
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 4, SkipFalse: 5},               // 2: IPv4? else 8
+		bpf.LoadAbsolute{Off: 9, Size: 1},                                   // 3: protocol
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 17, SkipFalse: 9},              // 4: UDP? else reject
+		bpf.LoadMemShift{Off: 0},                                            // 5: X = IHL*4
+		bpf.LoadIndirect{Off: 0, Size: 2},                                   // 6: UDP source port
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 53, SkipTrue: 5, SkipFalse: 6}, // 7
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 6, SkipFalse: 5},               // 8: IPv6? else reject
+		bpf.LoadAbsolute{Off: 6, Size: 1},                                   // 9: next header
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 17, SkipFalse: 3},              // 10: UDP? else reject
+		bpf.LoadAbsolute{Off: 40, Size: 2},                                  // 11: UDP source port
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 53, SkipFalse: 1},              // 12
+		bpf.RetConstant{Val: 65535},                                         // 13: accept
+		bpf.RetConstant{Val: 0},                                             // 14: reject
+	})
+	if err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	filter := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("failed to attach filter: %w", err)
+	}
+
+	// Periodic wakeup so Stop is noticed
+	tv := unix.Timeval{Sec: 1}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	return fd, nil
+}
@@ -0,0 +1,305 @@
+package netflow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/pkg/stats"
+)
+
+const (
+	VersionV9    = 9
+	VersionIPFIX = 10
+
+	templateIPv4 = 256
+	templateIPv6 = 257
+
+	// Keep datagrams below a typical MTU
+	maxPacketSize = 1400
+
+	// Field types (identical in NetFlow v9 and IPFIX)
+	fieldInBytes    = 1
+	fieldProtocol   = 4
+	fieldSrcPort    = 7
+	fieldIPv4Src    = 8
+	fieldDstPort    = 11
+	fieldIPv4Dst    = 12
+	fieldLastSw     = 21 // v9: sysUptime ms
+	fieldFirstSw    = 22 // v9: sysUptime ms
+	fieldIPv6Src    = 27
+	fieldIPv6Dst    = 28
+	fieldStartMilli = 152 // IPFIX: flowStartMilliseconds
+	fieldEndMilli   = 153 // IPFIX: flowEndMilliseconds
+)
+
+type field struct {
+	Type uint16
+	Len  uint16
+}
+
+// record is one unidirectional flow record
+type record struct {
+	Src, Dst         net.IP
+	SrcPort, DstPort uint16
+	Proto            uint8
+	Bytes            uint64
+	First, Last      time.Time
+}
+
+// Exporter sends flow deltas to a NetFlow v9 or IPFIX collector. Every
+// interval (the active timeout) each flow with new traffic is exported as two
+// unidirectional records: original and reply direction. Packet counts are not
+// tracked by catchmole and are not exported.
+type Exporter struct {
+	agg     *stats.Aggregator
+	conn    *net.UDPConn
+	version int
+
+	start    time.Time // Exporter "boot" for v9 sysUptime
+	sequence uint32
+	domainID uint32
+	last     map[string][2]uint64 // Flow key -> exported origin/reply bytes
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func New(agg *stats.Aggregator, collector string, version int) (*Exporter, error) {
+	if version != VersionV9 && version != VersionIPFIX {
+		return nil, fmt.Errorf("unsupported NetFlow version %d (want 9 or 10)", version)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", collector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collector %q: %w", collector, err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	agg.TrackEndedFlows()
+	return &Exporter{
+		agg:     agg,
+		conn:    conn,
+		version: version,
+		start:   time.Now(),
+		last:    make(map[string][2]uint64),
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+func (e *Exporter) Start(interval time.Duration) {
+	e.wg.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := e.export(); err != nil {
+					slog.Error("NetFlow export error", "err", err)
+				}
+			case <-e.stop:
+				return
+			}
+		}
+	})
+}
+
+// Stop flushes pending deltas and closes the socket
+func (e *Exporter) Stop() {
+	close(e.stop)
+	e.wg.Wait()
+	if err := e.export(); err != nil {
+		slog.Error("NetFlow final export error", "err", err)
+	}
+	e.conn.Close()
+}
+
+func (e *Exporter) export() error {
+	// Flows that ended since the last tick carry their final counters, then
+	// the key is free for a new flow
+	ended := e.agg.TakeEndedFlows()
+	flows := e.agg.GetFlowRecords()
+
+	var v4, v6 []record
+	seen := make(map[string]struct{}, len(flows))
+	for i, f := range append(ended, flows...) {
+		prev := e.last[f.Key]
+		if i < len(ended) {
+			delete(e.last, f.Key)
+		} else {
+			seen[f.Key] = struct{}{}
+			e.last[f.Key] = [2]uint64{f.OriginBytes, f.ReplyBytes}
+		}
+
+		dOrig, dReply := f.OriginBytes-prev[0], f.ReplyBytes-prev[1]
+		if f.OriginBytes < prev[0] || f.ReplyBytes < prev[1] {
+			dOrig, dReply = f.OriginBytes, f.ReplyBytes // Flow was reset
+		}
+
+		src, dst := net.ParseIP(f.SrcIP), net.ParseIP(f.DstIP)
+		if src == nil || dst == nil {
+			continue
+		}
+		var recs []record
+		if dOrig > 0 {
+			recs = append(recs, record{src, dst, f.SrcPort, f.DstPort, f.Proto, dOrig, f.FirstSeen, f.LastSeen})
+		}
+		if dReply > 0 {
+			recs = append(recs, record{dst, src, f.DstPort, f.SrcPort, f.Proto, dReply, f.FirstSeen, f.LastSeen})
+		}
+		if src.To4() != nil {
+			v4 = append(v4, recs...)
+		} else {
+			v6 = append(v6, recs...)
+		}
+	}
+
+	// Forget expired flows
+	for key := range e.last {
+		if _, ok := seen[key]; !ok {
+			delete(e.last, key)
+		}
+	}
+
+	if err := e.send(templateIPv4, v4); err != nil {
+		return err
+	}
+	return e.send(templateIPv6, v6)
+}
+
+// send writes records in as many datagrams as needed, each led by its template
+func (e *Exporter) send(templateID uint16, recs []record) error {
+	fields := e.fields(templateID)
+	recLen := 0
+	for _, f := range fields {
+		recLen += int(f.Len)
+	}
+
+	headerLen := 20
+	if e.version == VersionIPFIX {
+		headerLen = 16
+	}
+	templateLen := 4 + 4 + 4*len(fields)
+	perPacket := (maxPacketSize - headerLen - templateLen - 4 - 3) / recLen
+
+	for len(recs) > 0 {
+		n := min(perPacket, len(recs))
+		if _, err := e.conn.Write(e.packet(templateID, fields, recs[:n])); err != nil {
+			return err
+		}
+		recs = recs[n:]
+	}
+	return nil
+}
+
+func (e *Exporter) fields(templateID uint16) []field {
+	src, dst, addrLen := uint16(fieldIPv4Src), uint16(fieldIPv4Dst), uint16(4)
+	if templateID == templateIPv6 {
+		src, dst, addrLen = fieldIPv6Src, fieldIPv6Dst, 16
+	}
+
+	fields := []field{
+		{src, addrLen},
+		{dst, addrLen},
+		{fieldSrcPort, 2},
+		{fieldDstPort, 2},
+		{fieldProtocol, 1},
+		{fieldInBytes, 8},
+	}
+	if e.version == VersionIPFIX {
+		return append(fields, field{fieldStartMilli, 8}, field{fieldEndMilli, 8})
+	}
+	return append(fields, field{fieldFirstSw, 4}, field{fieldLastSw, 4})
+}
+
+func (e *Exporter) packet(templateID uint16, fields []field, recs []record) []byte {
+	now := time.Now()
+	b := make([]byte, 0, maxPacketSize)
+
+	// Header
+	if e.version == VersionIPFIX {
+		b = binary.BigEndian.AppendUint16(b, VersionIPFIX)
+		b = binary.BigEndian.AppendUint16(b, 0) // Length, patched below
+		b = binary.BigEndian.AppendUint32(b, uint32(now.Unix()))
+		b = binary.BigEndian.AppendUint32(b, e.sequence) // Data records sent so far
+		b = binary.BigEndian.AppendUint32(b, e.domainID)
+		e.sequence += uint32(len(recs))
+	} else {
+		b = binary.BigEndian.AppendUint16(b, VersionV9)
+		b = binary.BigEndian.AppendUint16(b, uint16(1+len(recs))) // Template + data records
+		b = binary.BigEndian.AppendUint32(b, e.uptime(now))
+		b = binary.BigEndian.AppendUint32(b, uint32(now.Unix()))
+		b = binary.BigEndian.AppendUint32(b, e.sequence) // Packets sent so far
+		b = binary.BigEndian.AppendUint32(b, e.domainID)
+		e.sequence++
+	}
+
+	// Template set (resent with every packet, collectors may start any time)
+	setID := uint16(0)
+	if e.version == VersionIPFIX {
+		setID = 2
+	}
+	b = binary.BigEndian.AppendUint16(b, setID)
+	b = binary.BigEndian.AppendUint16(b, uint16(4+4+4*len(fields)))
+	b = binary.BigEndian.AppendUint16(b, templateID)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(fields)))
+	for _, f := range fields {
+		b = binary.BigEndian.AppendUint16(b, f.Type)
+		b = binary.BigEndian.AppendUint16(b, f.Len)
+	}
+
+	// Data set
+	setStart := len(b)
+	b = binary.BigEndian.AppendUint16(b, templateID)
+	b = binary.BigEndian.AppendUint16(b, 0) // Length, patched below
+	for _, r := range recs {
+		b = e.appendRecord(b, templateID, r)
+	}
+	for (len(b)-setStart)%4 != 0 {
+		b = append(b, 0) // Padding
+	}
+	binary.BigEndian.PutUint16(b[setStart+2:], uint16(len(b)-setStart))
+
+	if e.version == VersionIPFIX {
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	}
+	return b
+}
+
+func (e *Exporter) appendRecord(b []byte, templateID uint16, r record) []byte {
+	if templateID == templateIPv6 {
+		b = append(b, r.Src.To16()...)
+		b = append(b, r.Dst.To16()...)
+	} else {
+		b = append(b, r.Src.To4()...)
+		b = append(b, r.Dst.To4()...)
+	}
+	b = binary.BigEndian.AppendUint16(b, r.SrcPort)
+	b = binary.BigEndian.AppendUint16(b, r.DstPort)
+	b = append(b, r.Proto)
+	b = binary.BigEndian.AppendUint64(b, r.Bytes)
+
+	if e.version == VersionIPFIX {
+		b = binary.BigEndian.AppendUint64(b, uint64(r.First.UnixMilli()))
+		b = binary.BigEndian.AppendUint64(b, uint64(r.Last.UnixMilli()))
+	} else {
+		b = binary.BigEndian.AppendUint32(b, e.uptime(r.First))
+		b = binary.BigEndian.AppendUint32(b, e.uptime(r.Last))
+	}
+	return b
+}
+
+// uptime returns milliseconds since the exporter started (v9 sysUptime)
+func (e *Exporter) uptime(t time.Time) uint32 {
+	if t.Before(e.start) {
+		return 0 // Flow started before the exporter
+	}
+	return uint32(t.Sub(e.start).Milliseconds())
+}
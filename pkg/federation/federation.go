@@ -0,0 +1,273 @@
+// Package federation polls /api/stats of remote catchmole instances (e.g.
+// secondary AP routers) and merges them with the local stats, labelled by
+// site, for one dashboard and one Prometheus scrape.
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/stats"
+)
+
+const (
+	defaultInterval  = 10 // Seconds
+	defaultLocalSite = "local"
+	requestTimeout   = 5 * time.Second
+)
+
+// Config is the [federation] TOML table
+type Config struct {
+	Sites     map[string]string `toml:"sites"`      // Site name -> base URL of a remote instance, empty = off
+	Interval  int               `toml:"interval"`   // Seconds between polls, default 10
+	Token     string            `toml:"token"`      // Bearer token for the remote auth_token, basic auth goes in the URL
+	LocalSite string            `toml:"local_site"` // Site name of this instance, default "local"
+}
+
+// Equal reports whether two configs are the same
+func (c Config) Equal(o Config) bool {
+	return maps.Equal(c.Sites, o.Sites) && c.Interval == o.Interval && c.Token == o.Token && c.LocalSite == o.LocalSite
+}
+
+// Validate rejects a remote site that would shadow the local one
+func (c Config) Validate() error {
+	local := c.LocalSite
+	if local == "" {
+		local = defaultLocalSite
+	}
+	if _, ok := c.Sites[local]; ok {
+		return fmt.Errorf("invalid federation site %q (same as local_site)", local)
+	}
+	return nil
+}
+
+// remoteStats is the part of a remote /api/stats response that is merged
+type remoteStats struct {
+	StartTime time.Time           `json:"start_time"`
+	Global    model.GlobalStats   `json:"global"`
+	Clients   []model.ClientStats `json:"clients"`
+}
+
+// site is the last poll result of one remote instance
+type site struct {
+	url     string
+	stats   remoteStats
+	updated time.Time // Last successful poll
+	err     string    // Last poll error, "" if it succeeded
+}
+
+// Poller fetches the remote sites periodically
+type Poller struct {
+	agg    *stats.Aggregator
+	client *http.Client
+
+	mu    sync.RWMutex
+	cfg   Config
+	sites map[string]*site
+	reset chan struct{} // Interval or sites changed
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewPoller(agg *stats.Aggregator, cfg Config) *Poller {
+	p := &Poller{
+		agg:    agg,
+		client: &http.Client{Timeout: requestTimeout},
+		sites:  make(map[string]*site),
+		reset:  make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+	p.SetConfig(cfg)
+	return p
+}
+
+// SetConfig replaces the sites and poll settings. Results of kept sites stay.
+func (p *Poller) SetConfig(cfg Config) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.LocalSite == "" {
+		cfg.LocalSite = defaultLocalSite
+	}
+
+	p.mu.Lock()
+	sites := make(map[string]*site, len(cfg.Sites))
+	for name, base := range cfg.Sites {
+		base = strings.TrimSuffix(base, "/")
+		if s, ok := p.sites[name]; ok && s.url == base {
+			sites[name] = s
+		} else {
+			sites[name] = &site{url: base}
+		}
+	}
+	p.cfg = cfg
+	p.sites = sites
+	p.mu.Unlock()
+
+	select {
+	case p.reset <- struct{}{}:
+	default:
+	}
+}
+
+// Enabled reports whether remote sites are configured
+func (p *Poller) Enabled() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.sites) > 0
+}
+
+func (p *Poller) Start() {
+	p.wg.Go(func() {
+		for {
+			p.mu.RLock()
+			interval := time.Duration(p.cfg.Interval) * time.Second
+			p.mu.RUnlock()
+
+			p.pollAll()
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-p.reset:
+				timer.Stop()
+			case <-p.stop:
+				timer.Stop()
+				return
+			}
+		}
+	})
+}
+
+func (p *Poller) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// pollAll fetches all sites concurrently
+func (p *Poller) pollAll() {
+	p.mu.RLock()
+	token := p.cfg.Token
+	targets := maps.Clone(p.sites)
+	p.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for name, s := range targets {
+		wg.Go(func() {
+			rs, err := p.fetch(s.url, token)
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.sites[name] != s {
+				return // Removed or replaced by a reload meanwhile
+			}
+			if err != nil {
+				if s.err == "" {
+					slog.Warn("Federation: site unreachable", "site", name, "url", redact(s.url), "err", err)
+				}
+				s.err = err.Error()
+				return
+			}
+			if s.err != "" {
+				slog.Info("Federation: site reachable again", "site", name)
+			}
+			s.stats, s.updated, s.err = rs, time.Now(), ""
+		})
+	}
+	wg.Wait()
+}
+
+// fetch reads /api/stats of a remote instance
+func (p *Poller) fetch(base, token string) (remoteStats, error) {
+	var rs remoteStats
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/api/stats", nil)
+	if err != nil {
+		return rs, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return rs, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return rs, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rs); err != nil {
+		return rs, fmt.Errorf("invalid stats: %w", err)
+	}
+	return rs, nil
+}
+
+// View is the merged view of this instance and the remote sites
+func (p *Poller) View() model.FederatedStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	interval := time.Duration(p.cfg.Interval) * time.Second
+	view := model.FederatedStats{
+		Sites:   make([]model.SiteStats, 0, len(p.sites)+1),
+		Clients: make([]model.SiteClient, 0),
+	}
+	add := func(s model.SiteStats, clients []model.ClientStats) {
+		if s.Online {
+			g := s.Global
+			view.Global.TotalDownload += g.TotalDownload
+			view.Global.TotalUpload += g.TotalUpload
+			view.Global.DownloadSpeed += g.DownloadSpeed
+			view.Global.UploadSpeed += g.UploadSpeed
+			view.Global.ActiveConnections += g.ActiveConnections
+			for _, c := range clients {
+				view.Clients = append(view.Clients, model.SiteClient{Site: s.Site, ClientStats: c})
+			}
+		}
+		view.Sites = append(view.Sites, s)
+	}
+
+	add(model.SiteStats{
+		Site:       p.cfg.LocalSite,
+		Online:     true,
+		LastUpdate: time.Now(),
+		StartTime:  p.agg.GetStartTime(),
+		Global:     p.agg.GetGlobalStats(),
+	}, p.agg.GetClients())
+
+	for _, name := range slices.Sorted(maps.Keys(p.sites)) {
+		s := p.sites[name]
+		add(model.SiteStats{
+			Site:       name,
+			URL:        redact(s.url),
+			Online:     !s.updated.IsZero() && time.Since(s.updated) < 3*interval,
+			LastUpdate: s.updated,
+			Error:      s.err,
+			StartTime:  s.stats.StartTime,
+			Global:     s.stats.Global,
+		}, s.stats.Clients)
+	}
+	return view
+}
+
+// redact hides the basic auth password of a site URL
+func redact(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "" // Unparsable, may still hold credentials
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,62 @@
+package federation
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	siteUpDesc = prometheus.NewDesc("catchmole_site_up",
+		"Whether the site answered recently (1) or not (0), this instance included", []string{"site"}, nil)
+	siteBpsDesc = prometheus.NewDesc("catchmole_site_bps",
+		"Speed of a site in bytes per second", []string{"site", "direction"}, nil)
+	siteBytesDesc = prometheus.NewDesc("catchmole_site_bytes_total",
+		"Total bytes transferred by a site", []string{"site", "direction"}, nil)
+	siteConnsDesc = prometheus.NewDesc("catchmole_site_active_connections",
+		"Active connections of a site", []string{"site"}, nil)
+	siteDeviceBpsDesc = prometheus.NewDesc("catchmole_site_device_bps",
+		"Speed of a device in bytes per second, by site", []string{"site", "mac", "name", "direction"}, nil)
+	siteDeviceBytesDesc = prometheus.NewDesc("catchmole_site_device_bytes_total",
+		"Total bytes transferred by a device, by site", []string{"site", "mac", "name", "direction"}, nil)
+)
+
+// Describe implements prometheus.Collector
+func (p *Poller) Describe(ch chan<- *prometheus.Desc) {
+	ch <- siteUpDesc
+	ch <- siteBpsDesc
+	ch <- siteBytesDesc
+	ch <- siteConnsDesc
+	ch <- siteDeviceBpsDesc
+	ch <- siteDeviceBytesDesc
+}
+
+// Collect implements prometheus.Collector. Nothing is exported without remote sites.
+func (p *Poller) Collect(ch chan<- prometheus.Metric) {
+	if !p.Enabled() {
+		return
+	}
+
+	view := p.View()
+	for _, s := range view.Sites {
+		up := 0.0
+		if s.Online {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(siteUpDesc, prometheus.GaugeValue, up, s.Site)
+		if !s.Online {
+			continue
+		}
+		g := s.Global
+		ch <- prometheus.MustNewConstMetric(siteBpsDesc, prometheus.GaugeValue, float64(g.DownloadSpeed), s.Site, "download")
+		ch <- prometheus.MustNewConstMetric(siteBpsDesc, prometheus.GaugeValue, float64(g.UploadSpeed), s.Site, "upload")
+		ch <- prometheus.MustNewConstMetric(siteBytesDesc, prometheus.CounterValue, float64(g.TotalDownload), s.Site, "download")
+		ch <- prometheus.MustNewConstMetric(siteBytesDesc, prometheus.CounterValue, float64(g.TotalUpload), s.Site, "upload")
+		ch <- prometheus.MustNewConstMetric(siteConnsDesc, prometheus.GaugeValue, float64(g.ActiveConnections), s.Site)
+	}
+
+	for _, c := range view.Clients {
+		ch <- prometheus.MustNewConstMetric(siteDeviceBpsDesc, prometheus.GaugeValue, float64(c.DownloadSpeed), c.Site, c.MAC, c.Name, "download")
+		ch <- prometheus.MustNewConstMetric(siteDeviceBpsDesc, prometheus.GaugeValue, float64(c.UploadSpeed), c.Site, c.MAC, c.Name, "upload")
+		ch <- prometheus.MustNewConstMetric(siteDeviceBytesDesc, prometheus.CounterValue, float64(c.TotalDownload), c.Site, c.MAC, c.Name, "download")
+		ch <- prometheus.MustNewConstMetric(siteDeviceBytesDesc, prometheus.CounterValue, float64(c.TotalUpload), c.Site, c.MAC, c.Name, "upload")
+	}
+}
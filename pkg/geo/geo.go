@@ -0,0 +1,118 @@
+package geo
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Upper bound of cached lookups
+const maxCacheEntries = 20000
+
+// Info is the GeoIP enrichment of a remote IP
+type Info struct {
+	Country string `json:"country,omitempty"` // ISO code, e.g. "US"
+	ASN     uint   `json:"asn,omitempty"`
+	Org     string `json:"org,omitempty"` // AS organization, e.g. "Cloudflare, Inc."
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+type asnRecord struct {
+	ASN uint   `maxminddb:"autonomous_system_number"`
+	Org string `maxminddb:"autonomous_system_organization"`
+}
+
+// Resolver looks up country and ASN from MaxMind GeoLite2 databases.
+// Either database is optional.
+type Resolver struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+
+	mu    sync.RWMutex
+	cache map[string]Info
+}
+
+// Open loads the GeoLite2-Country (or City) and GeoLite2-ASN databases.
+// Empty paths are skipped.
+func Open(countryPath, asnPath string) (*Resolver, error) {
+	r := &Resolver{cache: make(map[string]Info)}
+
+	if countryPath != "" {
+		db, err := maxminddb.Open(countryPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", countryPath, err)
+		}
+		r.country = db
+	}
+
+	if asnPath != "" {
+		db, err := maxminddb.Open(asnPath)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to open %s: %w", asnPath, err)
+		}
+		r.asn = db
+	}
+
+	return r, nil
+}
+
+func (r *Resolver) Close() {
+	if r.country != nil {
+		r.country.Close()
+	}
+	if r.asn != nil {
+		r.asn.Close()
+	}
+}
+
+// Lookup returns GeoIP info for an IP. Results are cached.
+func (r *Resolver) Lookup(ipStr string) Info {
+	r.mu.RLock()
+	info, ok := r.cache[ipStr]
+	r.mu.RUnlock()
+	if ok {
+		return info
+	}
+
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return info
+	}
+
+	if r.country != nil {
+		var rec countryRecord
+		if err := r.country.Lookup(ip, &rec); err == nil {
+			info.Country = rec.Country.ISOCode
+			if info.Country == "" {
+				info.Country = rec.RegisteredCountry.ISOCode
+			}
+		}
+	}
+	if r.asn != nil {
+		var rec asnRecord
+		if err := r.asn.Lookup(ip, &rec); err == nil {
+			info.ASN = rec.ASN
+			info.Org = rec.Org
+		}
+	}
+
+	r.mu.Lock()
+	if len(r.cache) >= maxCacheEntries {
+		r.cache = make(map[string]Info)
+	}
+	r.cache[ipStr] = info
+	r.mu.Unlock()
+
+	return info
+}
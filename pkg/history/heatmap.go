@@ -0,0 +1,107 @@
+package history
+
+import "time"
+
+// Heatmap accumulates traffic by day of week (0 = Sunday) and hour of day,
+// to show when a device is usually active
+type Heatmap struct {
+	Download [7][24]uint64 `json:"download"`
+	Upload   [7][24]uint64 `json:"upload"`
+}
+
+func (h *Heatmap) add(t time.Time, down, up uint64) {
+	day, hour := t.Weekday(), t.Hour()
+	h.Download[day][hour] += down
+	h.Upload[day][hour] += up
+}
+
+// SetLocation sets the timezone of the heatmap hours (default local). Call before Start.
+func (r *Recorder) SetLocation(loc *time.Location) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loc = loc
+}
+
+// Heatmap returns the weekly traffic pattern of a client (or global if mac is empty)
+func (r *Recorder) Heatmap(mac string) (Heatmap, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s := r.global
+	if mac != "" {
+		var ok bool
+		if s, ok = r.clients[mac]; !ok {
+			return Heatmap{}, false
+		}
+	}
+	return s.week, true
+}
+
+// HeatmapBetween builds the weekly pattern of a client (or global if mac is
+// empty) from the hourly history in [from, to) instead of all time, so
+// ranges reach back 30 days at most
+func (r *Recorder) HeatmapBetween(mac string, from, to time.Time) (Heatmap, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s := r.global
+	if mac != "" {
+		var ok bool
+		if s, ok = r.clients[mac]; !ok {
+			return Heatmap{}, false
+		}
+	}
+	var h Heatmap
+	for _, p := range s.hour.between(from, to, time.Now()) {
+		h.add(p.Time.In(r.loc), p.Download, p.Upload)
+	}
+	return h, true
+}
+
+// Fold sums each block of hours into its first hour, e.g. 6 for quarters of
+// the day. hours must divide 24.
+func (h Heatmap) Fold(hours int) Heatmap {
+	if hours <= 1 {
+		return h
+	}
+	var out Heatmap
+	for day := range 7 {
+		for hour := range 24 {
+			out.Download[day][hour-hour%hours] += h.Download[day][hour]
+			out.Upload[day][hour-hour%hours] += h.Upload[day][hour]
+		}
+	}
+	return out
+}
+
+// ExportHeatmaps returns the heatmaps for persistence. The "" key holds the global one.
+func (r *Recorder) ExportHeatmaps() map[string]Heatmap {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make(map[string]Heatmap, len(r.clients)+1)
+	out[""] = r.global.week
+	for mac, s := range r.clients {
+		out[mac] = s.week
+	}
+	return out
+}
+
+// ImportHeatmaps restores persisted heatmaps
+func (r *Recorder) ImportHeatmaps(heatmaps map[string]Heatmap) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for mac, h := range heatmaps {
+		s := r.global
+		if mac != "" {
+			var ok bool
+			if s, ok = r.clients[mac]; !ok {
+				s = newSeries()
+				r.clients[mac] = s
+			}
+		}
+		s.week = h
+		s.init = false // Totals are restored too, take a new baseline
+	}
+}
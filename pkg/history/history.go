@@ -0,0 +1,204 @@
+package history
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/pkg/stats"
+)
+
+const (
+	// 1-minute resolution for 24h
+	minuteStep = time.Minute
+	minuteSize = 24 * 60
+
+	// 1-hour resolution for 30 days
+	hourStep = time.Hour
+	hourSize = 30 * 24
+
+	sampleInterval = 10 * time.Second
+)
+
+// series holds the time series of one client (or the global total)
+type series struct {
+	minute *ring
+	hour   *ring
+	week   Heatmap
+
+	lastDown uint64 // Last seen cumulative totals
+	lastUp   uint64
+	init     bool
+}
+
+func newSeries() *series {
+	return &series{
+		minute: newRing(minuteStep, minuteSize),
+		hour:   newRing(hourStep, hourSize),
+	}
+}
+
+// record converts cumulative totals into bucket deltas
+func (s *series) record(now time.Time, totalDown, totalUp uint64) {
+	if !s.init || totalDown < s.lastDown || totalUp < s.lastUp {
+		// First sample or counters were reset: start over from here
+		s.lastDown, s.lastUp, s.init = totalDown, totalUp, true
+		return
+	}
+
+	down := totalDown - s.lastDown
+	up := totalUp - s.lastUp
+	s.lastDown, s.lastUp = totalDown, totalUp
+
+	s.minute.add(now, down, up)
+	s.hour.add(now, down, up)
+	s.week.add(now, down, up)
+}
+
+// Recorder samples the aggregator into per-client and global ring buffers
+type Recorder struct {
+	agg *stats.Aggregator
+
+	mu      sync.RWMutex
+	global  *series
+	clients map[string]*series // Key: MAC
+	loc     *time.Location     // Heatmap timezone
+
+	stop chan struct{}
+}
+
+func NewRecorder(agg *stats.Aggregator) *Recorder {
+	return &Recorder{
+		agg:     agg,
+		global:  newSeries(),
+		clients: make(map[string]*series),
+		loc:     time.Local,
+		stop:    make(chan struct{}),
+	}
+}
+
+func (r *Recorder) Start() {
+	go r.run()
+}
+
+func (r *Recorder) Stop() {
+	close(r.stop)
+}
+
+func (r *Recorder) run() {
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	r.sample()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.sample()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *Recorder) sample() {
+	global := r.agg.GetGlobalStats()
+	clients := r.agg.GetClients()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().In(r.loc)
+
+	r.global.record(now, global.TotalDownload, global.TotalUpload)
+
+	for _, c := range clients {
+		s, ok := r.clients[c.MAC]
+		if !ok {
+			s = newSeries()
+			r.clients[c.MAC] = s
+		}
+		s.record(now, c.TotalDownload, c.TotalUpload)
+	}
+
+	// Drop clients with no data within the longest retention
+	for mac, s := range r.clients {
+		if s.hour.count > 0 && now.Sub(s.hour.newest()) > hourStep*hourSize {
+			delete(r.clients, mac)
+		}
+	}
+}
+
+// Query returns traffic points for a client (or global if mac is empty) in [from, to).
+// The finest ring covering from is used; resolution > 0 downsamples the result.
+func (r *Recorder) Query(mac string, from, to time.Time, resolution time.Duration) []Point {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s := r.global
+	if mac != "" {
+		var ok bool
+		if s, ok = r.clients[mac]; !ok {
+			return make([]Point, 0)
+		}
+	}
+
+	now := time.Now()
+	rg := s.covering(from, now)
+	points := rg.between(from, to, now)
+	if resolution > rg.step {
+		points = downsample(points, resolution)
+	}
+	return points
+}
+
+// Totals sums the traffic of every client in [from, to). Clients without
+// traffic in the range are left out.
+func (r *Recorder) Totals(from, to time.Time) map[string]stats.UsageDay {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	totals := make(map[string]stats.UsageDay)
+	for mac, s := range r.clients {
+		var t stats.UsageDay
+		for _, p := range s.covering(from, now).between(from, to, now) {
+			t.Download += p.Download
+			t.Upload += p.Upload
+		}
+		if t.Download+t.Upload > 0 {
+			totals[mac] = t
+		}
+	}
+	return totals
+}
+
+// covering returns the finest ring still holding from
+func (s *series) covering(from, now time.Time) *ring {
+	if from.Before(now.Add(-minuteStep * minuteSize)) {
+		return s.hour
+	}
+	return s.minute
+}
+
+// Replay adds logged traffic of a client (or global if mac is empty) at t,
+// and to the heatmap if heatmap is set. Records must come in chronological
+// order and before Start.
+func (r *Recorder) Replay(mac string, t time.Time, down, up uint64, heatmap bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.global
+	if mac != "" {
+		var ok bool
+		if s, ok = r.clients[mac]; !ok {
+			s = newSeries()
+			r.clients[mac] = s
+		}
+	}
+	t = t.In(r.loc)
+	s.minute.add(t, down, up)
+	s.hour.add(t, down, up)
+	if heatmap {
+		s.week.add(t, down, up)
+	}
+}
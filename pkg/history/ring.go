@@ -0,0 +1,95 @@
+package history
+
+import "time"
+
+// Point is one time bucket of traffic
+type Point struct {
+	Time          time.Time `json:"time"`           // Bucket start
+	Download      uint64    `json:"download"`       // Bytes in bucket
+	Upload        uint64    `json:"upload"`         // Bytes in bucket
+	DownloadSpeed uint64    `json:"download_speed"` // Average Bytes/sec over bucket
+	UploadSpeed   uint64    `json:"upload_speed"`   // Average Bytes/sec over bucket
+}
+
+// ring is a fixed-size circular buffer of time buckets of equal width
+type ring struct {
+	step   time.Duration
+	points []Point
+	head   int // Index of the newest bucket
+	count  int
+}
+
+func newRing(step time.Duration, size int) *ring {
+	return &ring{
+		step:   step,
+		points: make([]Point, size),
+		head:   -1,
+	}
+}
+
+// add accumulates bytes into the bucket containing t
+func (r *ring) add(t time.Time, down, up uint64) {
+	bucket := t.Truncate(r.step)
+
+	if r.count > 0 && r.points[r.head].Time.Equal(bucket) {
+		r.points[r.head].Download += down
+		r.points[r.head].Upload += up
+		return
+	}
+
+	r.head = (r.head + 1) % len(r.points)
+	r.points[r.head] = Point{Time: bucket, Download: down, Upload: up}
+	if r.count < len(r.points) {
+		r.count++
+	}
+}
+
+// newest returns the start of the newest bucket held
+func (r *ring) newest() time.Time {
+	if r.count == 0 {
+		return time.Time{}
+	}
+	return r.points[r.head].Time
+}
+
+// between returns buckets in [from, to) in chronological order, with speeds filled in
+func (r *ring) between(from, to, now time.Time) []Point {
+	out := make([]Point, 0)
+	for i := r.count - 1; i >= 0; i-- {
+		p := r.points[(r.head-i+len(r.points))%len(r.points)]
+		if p.Time.Before(from.Truncate(r.step)) || !p.Time.Before(to) {
+			continue
+		}
+
+		// The newest bucket is still filling up
+		secs := r.step.Seconds()
+		if elapsed := now.Sub(p.Time).Seconds(); elapsed < secs && elapsed > 0 {
+			secs = elapsed
+		}
+		p.DownloadSpeed = uint64(float64(p.Download) / secs)
+		p.UploadSpeed = uint64(float64(p.Upload) / secs)
+		out = append(out, p)
+	}
+	return out
+}
+
+// downsample merges points into buckets of the given resolution
+func downsample(points []Point, resolution time.Duration) []Point {
+	out := make([]Point, 0, len(points))
+	for _, p := range points {
+		bucket := p.Time.Truncate(resolution)
+		if n := len(out); n > 0 && out[n-1].Time.Equal(bucket) {
+			out[n-1].Download += p.Download
+			out[n-1].Upload += p.Upload
+			continue
+		}
+		out = append(out, Point{Time: bucket, Download: p.Download, Upload: p.Upload})
+	}
+
+	secs := resolution.Seconds()
+	for i := range out {
+		out[i].DownloadSpeed = uint64(float64(out[i].Download) / secs)
+		out[i].UploadSpeed = uint64(float64(out[i].Upload) / secs)
+	}
+	return out
+}
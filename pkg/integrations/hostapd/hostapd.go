@@ -0,0 +1,354 @@
+// Package hostapd follows Wi-Fi associations through hostapd's control
+// interface: every AP interface has a datagram socket in the control
+// directory, attached sockets receive AP-STA-CONNECTED/DISCONNECTED events.
+package hostapd
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Control directory rescan and signal refresh
+	refreshInterval = 10 * time.Second
+	callTimeout     = 2 * time.Second
+	// Read deadline on the event socket, so Stop is noticed
+	readTimeout = time.Second
+)
+
+// Station is an associated wireless client
+type Station struct {
+	Interface string    // AP interface, the control socket name (e.g. wlan0)
+	SSID      string    // Wireless network
+	Band      string    // "2.4GHz", "5GHz", "6GHz" or "60GHz", empty if unknown
+	Signal    int       // dBm, 0 if unknown
+	Since     time.Time // Associated at, or when the watcher first saw it
+}
+
+// Watcher keeps the stations associated to every AP interface in dir
+type Watcher struct {
+	dir    string
+	onJoin func(mac string) // Called for each new association (optional)
+
+	mu       sync.RWMutex
+	stations map[string]Station
+	ifaces   map[string]bool // Interfaces being followed
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Local socket names are unique per process
+var seq atomic.Uint64
+
+// NewWatcher follows the control sockets in dir. onJoin, if not nil, is
+// called without waiting for traffic whenever a station associates.
+func NewWatcher(dir string, onJoin func(mac string)) *Watcher {
+	return &Watcher{
+		dir:      dir,
+		onJoin:   onJoin,
+		stations: make(map[string]Station),
+		ifaces:   make(map[string]bool),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start checks the control directory and begins following its interfaces
+func (w *Watcher) Start() error {
+	if _, err := os.Stat(w.dir); err != nil {
+		return err
+	}
+	w.wg.Go(w.run)
+	return nil
+}
+
+func (w *Watcher) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+// Lookup returns the station of a MAC, if associated
+func (w *Watcher) Lookup(mac string) (Station, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	s, ok := w.stations[mac]
+	return s, ok
+}
+
+// Stations returns all associated stations by MAC
+func (w *Watcher) Stations() map[string]Station {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	stations := make(map[string]Station, len(w.stations))
+	for mac, s := range w.stations {
+		stations[mac] = s
+	}
+	return stations
+}
+
+// run follows interfaces as their control sockets appear. Interfaces whose
+// hostapd restarts are picked up again on the next scan.
+func (w *Watcher) run() {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		w.scan()
+		select {
+		case <-ticker.C:
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *Watcher) scan() {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		slog.Debug("hostapd: failed to read control directory", "dir", w.dir, "err", err)
+		return
+	}
+	for _, e := range entries {
+		if e.Type()&os.ModeSocket == 0 || strings.HasPrefix(e.Name(), "global") {
+			continue
+		}
+		iface := e.Name()
+
+		w.mu.Lock()
+		following := w.ifaces[iface]
+		w.ifaces[iface] = true
+		w.mu.Unlock()
+		if !following {
+			w.wg.Go(func() { w.follow(iface) })
+		}
+	}
+}
+
+// follow attaches to the events of an interface until its hostapd goes away
+func (w *Watcher) follow(iface string) {
+	defer func() {
+		w.mu.Lock()
+		delete(w.ifaces, iface)
+		for mac, s := range w.stations {
+			if s.Interface == iface {
+				delete(w.stations, mac)
+			}
+		}
+		w.mu.Unlock()
+	}()
+
+	path := filepath.Join(w.dir, iface)
+	events, err := dial(path)
+	if err != nil {
+		slog.Debug("hostapd: failed to connect", "iface", iface, "err", err)
+		return
+	}
+	defer events.Close()
+	if reply, err := request(events, "ATTACH"); err != nil || reply != "OK" {
+		slog.Debug("hostapd: failed to attach", "iface", iface, "reply", reply, "err", err)
+		return
+	}
+	defer request(events, "DETACH")
+
+	// Replies to commands on the attached socket could interleave with events
+	cmds, err := dial(path)
+	if err != nil {
+		slog.Debug("hostapd: failed to connect", "iface", iface, "err", err)
+		return
+	}
+	defer cmds.Close()
+
+	slog.Info("Following hostapd associations", "iface", iface)
+	ssid, band := w.refresh(cmds, iface)
+	refreshed := time.Now()
+
+	buf := make([]byte, 4096)
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		if time.Since(refreshed) >= refreshInterval {
+			ssid, band = w.refresh(cmds, iface)
+			refreshed = time.Now()
+		}
+
+		events.SetReadDeadline(time.Now().Add(readTimeout))
+		n, err := events.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			slog.Debug("hostapd: event socket closed", "iface", iface, "err", err)
+			return
+		}
+
+		event, mac := parseEvent(string(buf[:n]))
+		switch event {
+		case "AP-STA-CONNECTED":
+			st := Station{Interface: iface, SSID: ssid, Band: band, Since: time.Now()}
+			if info, err := request(cmds, "STA "+mac); err == nil {
+				_, st.Signal = parseStation(info)
+			}
+			w.mu.Lock()
+			_, known := w.stations[mac]
+			w.stations[mac] = st
+			w.mu.Unlock()
+			if !known && w.onJoin != nil {
+				w.onJoin(mac)
+			}
+		case "AP-STA-DISCONNECTED":
+			w.mu.Lock()
+			if s, ok := w.stations[mac]; ok && s.Interface == iface {
+				delete(w.stations, mac) // Unless it roamed to another interface meanwhile
+			}
+			w.mu.Unlock()
+		case "CTRL-EVENT-TERMINATING":
+			return
+		}
+	}
+}
+
+// refresh re-reads the network and the station list of an interface, which
+// also keeps signals current. Stations it misses are dropped.
+func (w *Watcher) refresh(conn *net.UnixConn, iface string) (ssid, band string) {
+	status, err := request(conn, "STATUS")
+	if err != nil {
+		slog.Debug("hostapd: STATUS failed", "iface", iface, "err", err)
+		return "", ""
+	}
+	kv := parseKV(status)
+	ssid = cmp.Or(kv["ssid[0]"], kv["ssid"])
+	freq, _ := strconv.Atoi(kv["freq"])
+	band = bandOf(freq)
+
+	now := time.Now()
+	found := make(map[string]int)
+	for cmd := "STA-FIRST"; ; {
+		info, err := request(conn, cmd)
+		if err != nil {
+			slog.Debug("hostapd: station list failed", "iface", iface, "err", err)
+			return ssid, band
+		}
+		mac, signal := parseStation(info)
+		if mac == "" {
+			break // End of list
+		}
+		if _, dup := found[mac]; dup {
+			break
+		}
+		found[mac] = signal
+		cmd = "STA-NEXT " + mac
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for mac, s := range w.stations {
+		if _, ok := found[mac]; !ok && s.Interface == iface {
+			delete(w.stations, mac)
+		}
+	}
+	for mac, signal := range found {
+		s, ok := w.stations[mac]
+		if !ok || s.Interface != iface {
+			s = Station{Interface: iface, Since: now}
+		}
+		s.SSID, s.Band, s.Signal = ssid, band, signal
+		w.stations[mac] = s
+	}
+	return ssid, band
+}
+
+// dial connects a datagram socket to a control socket. hostapd replies to
+// the sender's address, so the local end is bound to an abstract name.
+func dial(path string) (*net.UnixConn, error) {
+	local := &net.UnixAddr{Name: fmt.Sprintf("@catchmole-hostapd-%d-%d", os.Getpid(), seq.Add(1)), Net: "unixgram"}
+	return net.DialUnix("unixgram", local, &net.UnixAddr{Name: path, Net: "unixgram"})
+}
+
+// request sends a command and returns its reply, skipping stray events
+func request(conn *net.UnixConn, cmd string) (string, error) {
+	conn.SetDeadline(time.Now().Add(callTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		if reply := string(buf[:n]); !strings.HasPrefix(reply, "<") {
+			return strings.TrimSpace(reply), nil
+		}
+	}
+}
+
+// parseEvent splits "<3>AP-STA-CONNECTED 11:22:33:44:55:66 keyid=..." into
+// the event name and the station MAC
+func parseEvent(msg string) (event, mac string) {
+	if _, rest, ok := strings.Cut(msg, ">"); ok && strings.HasPrefix(msg, "<") {
+		msg = rest
+	}
+	fields := strings.Fields(msg)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	if len(fields) > 1 {
+		mac = strings.ToLower(fields[1])
+	}
+	return fields[0], mac
+}
+
+// parseStation reads the MAC line and the signal of a STA reply, "FAIL"
+// and empty replies have no MAC
+func parseStation(info string) (mac string, signal int) {
+	first, rest, _ := strings.Cut(info, "\n")
+	if _, err := net.ParseMAC(first); err != nil {
+		return "", 0
+	}
+	signal, _ = strconv.Atoi(parseKV(rest)["signal"])
+	return strings.ToLower(first), signal
+}
+
+// parseKV reads "key=value" lines
+func parseKV(s string) map[string]string {
+	kv := make(map[string]string)
+	for line := range strings.Lines(s) {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			kv[k] = v
+		}
+	}
+	return kv
+}
+
+// bandOf names the band of a channel frequency in MHz
+func bandOf(freq int) string {
+	switch {
+	case freq <= 0:
+		return ""
+	case freq < 3000:
+		return "2.4GHz"
+	case freq >= 5925 && freq < 7200:
+		return "6GHz"
+	case freq >= 4900 && freq < 5925:
+		return "5GHz"
+	case freq >= 57000:
+		return "60GHz"
+	}
+	return ""
+}
@@ -0,0 +1,190 @@
+// Package openwrt reads device metadata from OpenWrt's ubus: DHCP leases
+// (luci-rpc) and wireless stations (hostapd).
+package openwrt
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	refreshInterval = 10 * time.Second
+	callTimeout     = 5 * time.Second
+)
+
+// Station is what ubus knows about a client
+type Station struct {
+	Hostname  string // DHCP hostname
+	SSID      string // Wireless network, empty for wired clients
+	Interface string // AP interface the client is associated to (e.g. phy0-ap0)
+	Signal    int    // dBm, 0 if unknown
+}
+
+// Watcher polls ubus and maps MACs to stations
+type Watcher struct {
+	mu       sync.RWMutex
+	stations map[string]Station
+
+	stop chan struct{}
+}
+
+// Available reports whether the ubus CLI is installed
+func Available() bool {
+	_, err := exec.LookPath("ubus")
+	return err == nil
+}
+
+func NewWatcher() *Watcher {
+	return &Watcher{
+		stations: make(map[string]Station),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (w *Watcher) Start() {
+	go w.run()
+}
+
+func (w *Watcher) Stop() {
+	close(w.stop)
+}
+
+func (w *Watcher) run() {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	w.Refresh() // Initial load
+
+	for {
+		select {
+		case <-ticker.C:
+			w.Refresh()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Lookup returns the station of a MAC
+func (w *Watcher) Lookup(mac string) (Station, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	s, ok := w.stations[mac]
+	return s, ok
+}
+
+// Refresh re-reads leases and station lists. Sources that fail keep what they
+// returned last time, luci-rpc is missing on images without LuCI.
+func (w *Watcher) Refresh() {
+	stations := make(map[string]Station)
+
+	w.mu.RLock()
+	prev := w.stations
+	w.mu.RUnlock()
+
+	if err := readLeases(stations); err != nil {
+		slog.Debug("ubus: failed to read DHCP leases", "err", err)
+		for mac, p := range prev {
+			if p.Hostname != "" {
+				s := stations[mac]
+				s.Hostname = p.Hostname
+				stations[mac] = s
+			}
+		}
+	}
+	if err := readWireless(stations); err != nil {
+		slog.Debug("ubus: failed to read wireless stations", "err", err)
+		for mac, p := range prev {
+			if p.Interface != "" {
+				s := stations[mac]
+				s.SSID, s.Interface, s.Signal = p.SSID, p.Interface, p.Signal
+				stations[mac] = s
+			}
+		}
+	}
+
+	w.mu.Lock()
+	w.stations = stations
+	w.mu.Unlock()
+}
+
+func readLeases(stations map[string]Station) error {
+	var resp struct {
+		Leases []struct {
+			Hostname string `json:"hostname"`
+			MAC      string `json:"macaddr"`
+		} `json:"dhcp_leases"`
+	}
+	if err := call(&resp, "call", "luci-rpc", "getDHCPLeases"); err != nil {
+		return err
+	}
+
+	for _, l := range resp.Leases {
+		if l.MAC == "" || l.Hostname == "" {
+			continue
+		}
+		mac := strings.ToLower(l.MAC)
+		s := stations[mac]
+		s.Hostname = l.Hostname
+		stations[mac] = s
+	}
+	return nil
+}
+
+func readWireless(stations map[string]Station) error {
+	out, err := ubus("list", "hostapd.*")
+	if err != nil {
+		return err
+	}
+
+	for obj := range strings.FieldsSeq(string(out)) {
+		iface := strings.TrimPrefix(obj, "hostapd.")
+
+		var status struct {
+			SSID string `json:"ssid"`
+		}
+		if err := call(&status, "call", obj, "get_status"); err != nil {
+			slog.Debug("ubus: get_status failed", "object", obj, "err", err)
+		}
+
+		var clients struct {
+			Clients map[string]struct {
+				Signal int `json:"signal"`
+			} `json:"clients"`
+		}
+		if err := call(&clients, "call", obj, "get_clients"); err != nil {
+			slog.Debug("ubus: get_clients failed", "object", obj, "err", err)
+			continue
+		}
+
+		for mac, c := range clients.Clients {
+			mac = strings.ToLower(mac)
+			s := stations[mac]
+			s.SSID = status.SSID
+			s.Interface = iface
+			s.Signal = c.Signal
+			stations[mac] = s
+		}
+	}
+	return nil
+}
+
+// call runs ubus and decodes its JSON output into v
+func call(v any, args ...string) error {
+	out, err := ubus(args...)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(out, v)
+}
+
+func ubus(args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	return exec.CommandContext(ctx, "ubus", args...).Output()
+}
@@ -0,0 +1,325 @@
+// Package tunnel maps the addresses of VPN peers terminated on the router to
+// the peers: WireGuard allowed IPs (wg show) and OpenVPN clients (status
+// files). Tunnels carry no Ethernet headers, so peers have no MAC of their
+// own and are keyed by one derived from their identity.
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/pkg/monitor"
+)
+
+const (
+	refreshInterval = 10 * time.Second
+	callTimeout     = 5 * time.Second
+	// WireGuard rekeys at least every two minutes while traffic flows
+	handshakeTimeout = 3 * time.Minute
+	// Shortest prefixes accepted as a peer's routed subnet
+	minRouteBits4 = 8
+	minRouteBits6 = 16
+)
+
+// Peer is a remote VPN client
+type Peer struct {
+	MAC       string // Client key, see PeerMAC
+	Key       string // WireGuard public key or OpenVPN common name
+	Name      string // Display name
+	Interface string // WireGuard interface, or the OpenVPN status file name
+	Endpoint  string // Public address the peer connects from, empty if unknown
+	Online    bool   // Recent handshake, or listed as connected by OpenVPN
+}
+
+// route maps a routed subnet (site-to-site allowed IPs, OpenVPN iroutes) to a peer
+type route struct {
+	prefix netip.Prefix
+	mac    string
+}
+
+// Watcher polls the VPN sources and maps addresses to peers
+type Watcher struct {
+	wireguard bool
+	openvpn   []string // Status files
+
+	mu     sync.RWMutex
+	peers  map[string]Peer       // MAC -> peer
+	hosts  map[netip.Addr]string // Peer address -> MAC
+	routes []route               // Longest prefix first
+
+	stop chan struct{}
+}
+
+// WireGuardAvailable reports whether the wg CLI is installed
+func WireGuardAvailable() bool {
+	_, err := exec.LookPath("wg")
+	return err == nil
+}
+
+// NewWatcher reads WireGuard peers if wireguard is set and OpenVPN clients
+// from the given status files (status-version 2 or 3)
+func NewWatcher(wireguard bool, openvpn []string) *Watcher {
+	return &Watcher{
+		wireguard: wireguard,
+		openvpn:   openvpn,
+		peers:     make(map[string]Peer),
+		hosts:     make(map[netip.Addr]string),
+		stop:      make(chan struct{}),
+	}
+}
+
+func (w *Watcher) Start() {
+	go w.run()
+}
+
+func (w *Watcher) Stop() {
+	close(w.stop)
+}
+
+func (w *Watcher) run() {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	w.Refresh() // Initial load
+
+	for {
+		select {
+		case <-ticker.C:
+			w.Refresh()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// PeerMAC derives the client key of a peer: a locally administered unicast
+// MAC, stable as long as the peer keeps its key or common name
+func PeerMAC(kind, key string) string {
+	sum := sha256.Sum256([]byte(kind + ":" + key))
+	hw := net.HardwareAddr(sum[:6])
+	hw[0] = hw[0]&^0x01 | 0x02
+	return hw.String()
+}
+
+// Lookup returns the peer an address belongs to
+func (w *Watcher) Lookup(ip string) (Peer, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Peer{}, false
+	}
+	addr = addr.Unmap()
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if mac, ok := w.hosts[addr]; ok {
+		return w.peers[mac], true
+	}
+	for _, r := range w.routes {
+		if r.prefix.Contains(addr) {
+			return w.peers[r.mac], true
+		}
+	}
+	return Peer{}, false
+}
+
+// Peer returns a peer by its client key
+func (w *Watcher) Peer(mac string) (Peer, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	p, ok := w.peers[mac]
+	return p, ok
+}
+
+// Peers returns all known peers by client key
+func (w *Watcher) Peers() map[string]Peer {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	peers := make(map[string]Peer, len(w.peers))
+	for mac, p := range w.peers {
+		peers[mac] = p
+	}
+	return peers
+}
+
+// Refresh re-reads all sources. Sources that fail are skipped.
+func (w *Watcher) Refresh() {
+	t := table{
+		peers: make(map[string]Peer),
+		hosts: make(map[netip.Addr]string),
+	}
+
+	if w.wireguard {
+		if err := t.readWireGuard(); err != nil {
+			slog.Debug("tunnel: failed to read WireGuard peers", "err", err)
+		}
+	}
+	for _, path := range w.openvpn {
+		if err := t.readOpenVPN(path); err != nil {
+			slog.Debug("tunnel: failed to read OpenVPN status", "file", path, "err", err)
+		}
+	}
+	slices.SortFunc(t.routes, func(a, b route) int {
+		return b.prefix.Bits() - a.prefix.Bits()
+	})
+
+	w.mu.Lock()
+	w.peers, w.hosts, w.routes = t.peers, t.hosts, t.routes
+	w.mu.Unlock()
+}
+
+type table struct {
+	peers  map[string]Peer
+	hosts  map[netip.Addr]string
+	routes []route
+}
+
+// add maps an address or subnet to a peer. Default routes (full tunnels
+// to a site) would claim the whole Internet and are skipped, as are their
+// split forms (0.0.0.0/1 + 128.0.0.0/1) and any prefix wider than /8
+// (IPv4) or /16 (IPv6).
+func (t *table) add(mac, s string) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	prefix = prefix.Masked()
+	minBits := minRouteBits4
+	if prefix.Addr().Is6() {
+		minBits = minRouteBits6
+	}
+	switch {
+	case prefix.Bits() < minBits:
+	case prefix.IsSingleIP():
+		t.hosts[prefix.Addr()] = mac
+	default:
+		t.routes = append(t.routes, route{prefix, mac})
+	}
+}
+
+// readWireGuard parses "wg show all dump": a line per interface
+// (interface, private key, public key, listen port, fwmark) followed by a
+// line per peer (interface, public key, preshared key, endpoint, allowed
+// IPs, latest handshake, rx, tx, keepalive)
+func (t *table) readWireGuard() error {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	out, err := monitor.NetAdminCommand(ctx, "wg", "show", "all", "dump").Output()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for line := range strings.Lines(string(out)) {
+		f := strings.Split(strings.TrimSpace(line), "\t")
+		if len(f) != 9 {
+			continue // Interface line
+		}
+		iface, key, endpoint, allowed := f[0], f[1], f[3], f[4]
+		handshake, _ := strconv.ParseInt(f[5], 10, 64)
+
+		p := Peer{
+			MAC:       PeerMAC("wireguard", key),
+			Key:       key,
+			Name:      fmt.Sprintf("%s/%s", iface, key[:min(len(key), 8)]),
+			Interface: iface,
+			Online:    handshake > 0 && now.Sub(time.Unix(handshake, 0)) < handshakeTimeout,
+		}
+		if endpoint != "(none)" {
+			p.Endpoint = endpoint
+		}
+		t.peers[p.MAC] = p
+		if allowed != "(none)" {
+			for s := range strings.SplitSeq(allowed, ",") {
+				t.add(p.MAC, s)
+			}
+		}
+	}
+	return nil
+}
+
+// readOpenVPN parses a status file written with status-version 2 (comma
+// separated) or 3 (tab separated). HEADER lines name the columns.
+func (t *table) readOpenVPN(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	iface := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	// Columns of OpenVPN 2.4+ unless the file says otherwise
+	clientCols := map[string]int{"Common Name": 1, "Real Address": 2, "Virtual Address": 3, "Virtual IPv6 Address": 4}
+	routeCols := map[string]int{"Virtual Address": 1, "Common Name": 2}
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		sep := ","
+		if strings.Contains(line, "\t") {
+			sep = "\t"
+		}
+		fields := strings.Split(line, sep)
+		col := func(cols map[string]int, name string) string {
+			if i, ok := cols[name]; ok && i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+
+		switch fields[0] {
+		case "HEADER":
+			if len(fields) < 2 {
+				continue
+			}
+			cols := make(map[string]int, len(fields))
+			for i, name := range fields[2:] {
+				cols[name] = i + 1
+			}
+			switch fields[1] {
+			case "CLIENT_LIST":
+				clientCols = cols
+			case "ROUTING_TABLE":
+				routeCols = cols
+			}
+		case "CLIENT_LIST":
+			cn := col(clientCols, "Common Name")
+			if cn == "" || cn == "UNDEF" {
+				continue
+			}
+			p := Peer{
+				MAC:       PeerMAC("openvpn", cn),
+				Key:       cn,
+				Name:      cn,
+				Interface: iface,
+				Endpoint:  col(clientCols, "Real Address"),
+				Online:    true,
+			}
+			t.peers[p.MAC] = p
+			t.add(p.MAC, col(clientCols, "Virtual Address"))
+			t.add(p.MAC, col(clientCols, "Virtual IPv6 Address"))
+		case "ROUTING_TABLE":
+			// Also lists iroute subnets behind a client
+			if cn := col(routeCols, "Common Name"); cn != "" {
+				t.add(PeerMAC("openvpn", cn), strings.TrimSuffix(col(routeCols, "Virtual Address"), "C"))
+			}
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,106 @@
+// Package logging configures the process-wide slog logger.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Config is the [log] section of the config file
+type Config struct {
+	Level      string `toml:"level"`       // debug, info, warn or error
+	Format     string `toml:"format"`      // text or json
+	File       string `toml:"file"`        // Log to this file instead of stderr
+	MaxSize    int    `toml:"max_size"`    // Rotate the file at this size in MB
+	MaxBackups int    `toml:"max_backups"` // Rotated files to keep
+}
+
+var (
+	level  = new(slog.LevelVar)
+	output io.Closer // Current log file, if any
+)
+
+// Setup installs the default logger. The standard log package is routed
+// through it too, so third-party output ends up in the same format.
+func Setup(cfg Config) error {
+	lvl, err := parseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stderr
+	var file *rotatingFile
+	if cfg.File != "" {
+		if cfg.MaxSize <= 0 {
+			cfg.MaxSize = 10
+		}
+		if cfg.MaxBackups <= 0 {
+			cfg.MaxBackups = 3
+		}
+		file, err = openRotating(cfg.File, int64(cfg.MaxSize)<<20, cfg.MaxBackups)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		w = file
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch cfg.Format {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		if file != nil {
+			file.Close()
+		}
+		return fmt.Errorf("invalid log format %q (want text or json)", cfg.Format)
+	}
+
+	level.Set(lvl)
+	slog.SetDefault(slog.New(h))
+	log.SetFlags(0) // slog adds its own timestamp
+
+	if output != nil {
+		output.Close()
+	}
+	output = nil
+	if file != nil {
+		output = file
+	}
+	return nil
+}
+
+// SetLevel changes the level of the running logger
+func SetLevel(s string) error {
+	lvl, err := parseLevel(s)
+	if err != nil {
+		return err
+	}
+	level.Set(lvl)
+	return nil
+}
+
+// Close flushes and closes the log file, if any
+func Close() {
+	if output != nil {
+		output.Close()
+		output = nil
+	}
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(strings.ToUpper(s))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", s)
+	}
+	return lvl, nil
+}
@@ -1,255 +1,398 @@
 package metrics
 
 import (
+	"strconv"
 	"time"
 
+	"github.com/kisy/catchmole/pkg/monitor"
 	"github.com/kisy/catchmole/pkg/stats"
+	"github.com/kisy/catchmole/pkg/units"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// Exporter collects CatchMole stats and exports them as Prometheus metrics
-type Exporter struct {
-	agg *stats.Aggregator
-
+// Counters export the aggregator totals as they are: they survive restarts
+// when persistence is enabled and drop to zero on a reset, which Prometheus
+// handles as a counter reset.
+var (
 	// Global metrics
-	globalDownloadBps       prometheus.Gauge
-	globalUploadBps         prometheus.Gauge
-	globalActiveConnections prometheus.Gauge
-	globalActiveDevices     prometheus.Gauge
-	globalBytesTotal        *prometheus.CounterVec
-	uptimeSeconds           prometheus.Gauge
-
-	// Track previous values for delta calculation
-	lastGlobalDownload uint64
-	lastGlobalUpload   uint64
-	lastDeviceBytes    map[string]map[string]uint64 // mac -> direction -> bytes
+	globalDownloadBpsDesc = prometheus.NewDesc("catchmole_global_download_bps",
+		"Global download speed in bytes per second", nil, nil)
+	globalUploadBpsDesc = prometheus.NewDesc("catchmole_global_upload_bps",
+		"Global upload speed in bytes per second", nil, nil)
+	globalSpeedAvgBpsDesc = prometheus.NewDesc("catchmole_global_speed_avg_bps",
+		"Global average speed in bytes per second over a rolling window", []string{"direction", "window"}, nil)
+	globalActiveConnectionsDesc = prometheus.NewDesc("catchmole_global_active_connections",
+		"Total number of active connections", nil, nil)
+	globalOffloadedFlowsDesc = prometheus.NewDesc("catchmole_global_offloaded_flows",
+		"Number of flows offloaded to a flow table, whose bytes may lag", nil, nil)
+	globalActiveDevicesDesc = prometheus.NewDesc("catchmole_global_active_devices",
+		"Number of active devices", nil, nil)
+	globalNewConnRateDesc = prometheus.NewDesc("catchmole_global_new_connections_per_second",
+		"Rate of new connections (conntrack NEW events) per second", nil, nil)
+	globalClosedConnRateDesc = prometheus.NewDesc("catchmole_global_closed_connections_per_second",
+		"Rate of closed connections (conntrack DESTROY events) per second", nil, nil)
+	globalFailedConnRateDesc = prometheus.NewDesc("catchmole_global_failed_connections_per_second",
+		"Rate of connections closed without being established per second", nil, nil)
+	globalFailedConnsTotalDesc = prometheus.NewDesc("catchmole_global_failed_connections_total",
+		"Total connections closed without being established (unreplied or incomplete handshake)", nil, nil)
+	globalConnsTotalDesc = prometheus.NewDesc("catchmole_global_connections_total",
+		"Conntrack connection events since the last reset", []string{"event"}, nil) // event: "new" or "closed"
+	globalBytesTotalDesc = prometheus.NewDesc("catchmole_global_bytes_total",
+		"Total bytes transferred globally (counter, survives restarts)", []string{"direction"}, nil)
+	globalFamilyBytesTotalDesc = prometheus.NewDesc("catchmole_global_family_bytes_total",
+		"Total internet bytes by IP family, NAT64 traffic counts as IPv4", []string{"family", "direction"}, nil)
+	globalUtilizationDesc = prometheus.NewDesc("catchmole_global_utilization_percent",
+		"Share of the configured link capacity in use", []string{"direction"}, nil)
+
+	// Process and source health
+	uptimeSecondsDesc = prometheus.NewDesc("catchmole_uptime_seconds",
+		"CatchMole uptime in seconds", nil, nil)
+	sourceReconnectsTotalDesc = prometheus.NewDesc("catchmole_source_reconnects_total",
+		"Times the traffic source (conntrack or packet capture) sockets were re-opened", nil, nil)
+	sourceCoalescedTotalDesc = prometheus.NewDesc("catchmole_source_events_coalesced_total",
+		"Flow updates merged into a queued update of the same flow while the aggregator lagged", nil, nil)
+	sourceDroppedTotalDesc = prometheus.NewDesc("catchmole_source_events_dropped_total",
+		"Flow events dropped because the event queue was full (accounting loss)", nil, nil)
+	sourceIdleSecondsDesc = prometheus.NewDesc("catchmole_source_idle_seconds",
+		"Seconds since the traffic source delivered an event or completed a poll", nil, nil)
+	eventQueueLengthDesc = prometheus.NewDesc("catchmole_event_queue_length",
+		"Events waiting for the aggregator", nil, nil)
+	eventQueueCapacityDesc = prometheus.NewDesc("catchmole_event_queue_capacity",
+		"Size of the event queue between the traffic source and the aggregator", nil, nil)
+	tickAgeSecondsDesc = prometheus.NewDesc("catchmole_aggregator_tick_age_seconds",
+		"Seconds since the last speed calculation started, grows if the calculation stalls", nil, nil)
+	tickDurationSecondsDesc = prometheus.NewDesc("catchmole_aggregator_tick_duration_seconds",
+		"Duration of the last neighbor refresh and speed calculation", nil, nil)
+	cappedDeltasTotalDesc = prometheus.NewDesc("catchmole_capped_deltas_total",
+		"Byte deltas dropped for exceeding max_delta (accounting loss if legitimate)", nil, nil)
+	cappedBytesTotalDesc = prometheus.NewDesc("catchmole_capped_bytes_total",
+		"Bytes of the deltas dropped for exceeding max_delta", nil, nil)
+	evictedFlowsTotalDesc = prometheus.NewDesc("catchmole_flows_evicted_total",
+		"Flows evicted because the flow table reached max_flows", nil, nil)
+	evictedClientsTotalDesc = prometheus.NewDesc("catchmole_clients_evicted_total",
+		"Clients evicted because the client table reached max_clients", nil, nil)
+	trackedFlowsDesc = prometheus.NewDesc("catchmole_tracked_flows",
+		"Flows currently tracked", nil, nil)
+	trackedClientsDesc = prometheus.NewDesc("catchmole_tracked_clients",
+		"Clients currently tracked", nil, nil)
+	tableLimitDesc = prometheus.NewDesc("catchmole_table_limit",
+		"Configured cap of a table (max_flows, max_clients)", []string{"table"}, nil)
+	monitorPausedDesc = prometheus.NewDesc("catchmole_monitor_paused",
+		"1 while traffic accounting is paused via /api/monitor/pause", nil, nil)
+	pausedEventsDesc = prometheus.NewDesc("catchmole_monitor_paused_events",
+		"Events drained without being counted during the current pause", nil, nil)
+	byteUnitsInfoDesc = prometheus.NewDesc("catchmole_byte_units_info",
+		"Byte units configured for display (byte_units). Metrics stay in bytes, dashboards divide by powers of base to match the web UI and reports", []string{"units", "base"}, nil)
 
 	// Device-level metrics
-	deviceDownloadBps       *prometheus.GaugeVec
-	deviceUploadBps         *prometheus.GaugeVec
-	deviceActiveConnections *prometheus.GaugeVec
-	deviceBytesTotal        *prometheus.CounterVec
-	deviceSessionBytes      *prometheus.GaugeVec
+	deviceDownloadBpsDesc = prometheus.NewDesc("catchmole_device_download_bps",
+		"Device download speed in bytes per second", []string{"mac", "name"}, nil)
+	deviceUploadBpsDesc = prometheus.NewDesc("catchmole_device_upload_bps",
+		"Device upload speed in bytes per second", []string{"mac", "name"}, nil)
+	deviceSpeedAvgBpsDesc = prometheus.NewDesc("catchmole_device_speed_avg_bps",
+		"Device average speed in bytes per second over a rolling window", []string{"mac", "name", "direction", "window"}, nil)
+	deviceRTTSecondsDesc = prometheus.NewDesc("catchmole_device_rtt_seconds",
+		"Median TCP handshake round trip of the device's connections", []string{"mac", "name"}, nil)
+	deviceActiveConnectionsDesc = prometheus.NewDesc("catchmole_device_active_connections",
+		"Number of active connections per device", []string{"mac", "name"}, nil)
+	deviceNewConnRateDesc = prometheus.NewDesc("catchmole_device_new_connections_per_second",
+		"Rate of new connections per device per second", []string{"mac", "name"}, nil)
+	deviceClosedConnRateDesc = prometheus.NewDesc("catchmole_device_closed_connections_per_second",
+		"Rate of closed connections per device per second", []string{"mac", "name"}, nil)
+	deviceFailedConnRateDesc = prometheus.NewDesc("catchmole_device_failed_connections_per_second",
+		"Rate of failed connections per device per second", []string{"mac", "name"}, nil)
+	deviceFailedConnsDesc = prometheus.NewDesc("catchmole_device_failed_connections",
+		"Failed connections per device since it was first seen or reset", []string{"mac", "name"}, nil)
+	deviceConnsTotalDesc = prometheus.NewDesc("catchmole_device_connections_total",
+		"Conntrack connection events per device", []string{"mac", "name", "event"}, nil) // event: "new", "closed" or "failed"
+	clientNewFlowsTotalDesc = prometheus.NewDesc("catchmole_client_new_flows_total",
+		"Flows opened per device since it was first seen or reset, see rate() for flows per second", []string{"mac", "name"}, nil)
+	deviceBytesTotalDesc = prometheus.NewDesc("catchmole_device_bytes_total",
+		"Total bytes transferred by device (counter, survives restarts)", []string{"mac", "name", "direction"}, nil)
+	deviceFamilyBytesTotalDesc = prometheus.NewDesc("catchmole_device_family_bytes_total",
+		"Total bytes transferred by device by IP family", []string{"mac", "name", "family", "direction"}, nil)
+	deviceSessionBytesDesc = prometheus.NewDesc("catchmole_device_session_bytes",
+		"Session bytes transferred by device", []string{"mac", "name", "direction"}, nil)
+	deviceOnlineDesc = prometheus.NewDesc("catchmole_device_online",
+		"Whether the device is in the neighbor table or recently active (1) or not (0)", []string{"mac", "name"}, nil)
+
+	// Protocol, port group category and conntrack mark class metrics
+	protocolBytesTotalDesc = prometheus.NewDesc("catchmole_protocol_bytes_total",
+		"Total bytes by protocol", []string{"protocol", "direction", "mac", "name"}, nil)
+	categoryBytesTotalDesc = prometheus.NewDesc("catchmole_category_bytes_total",
+		"Total bytes by port group category", []string{"category", "direction", "mac", "name"}, nil)
+	classBytesTotalDesc = prometheus.NewDesc("catchmole_class_bytes_total",
+		"Total bytes by conntrack mark class", []string{"class", "direction", "mac", "name"}, nil)
+	globalClassBytesTotalDesc = prometheus.NewDesc("catchmole_global_class_bytes_total",
+		"Total internet bytes by conntrack mark class", []string{"class", "direction"}, nil)
+
+	// GeoIP country metrics
+	countryBytesTotalDesc = prometheus.NewDesc("catchmole_country_bytes_total",
+		"Total internet bytes by remote country", []string{"country", "direction"}, nil)
+	blockedCountryBytesTotalDesc = prometheus.NewDesc("catchmole_blocked_country_bytes_total",
+		"Total bytes of a device to a country listed in blocked_countries", []string{"country", "direction", "mac", "name"}, nil)
+
+	// Conntrack zone metrics
+	zoneBytesTotalDesc = prometheus.NewDesc("catchmole_zone_bytes_total",
+		"Total internet bytes by conntrack zone", []string{"zone", "name", "direction"}, nil)
+	zoneBpsDesc = prometheus.NewDesc("catchmole_zone_bps",
+		"Current internet speed by conntrack zone in bytes per second", []string{"zone", "name", "direction"}, nil)
+	zoneUtilizationDesc = prometheus.NewDesc("catchmole_zone_utilization_percent",
+		"Share of the configured uplink capacity in use by conntrack zone", []string{"zone", "name", "direction"}, nil)
+
+	// Client group metrics
+	groupBytesTotalDesc = prometheus.NewDesc("catchmole_group_bytes_total",
+		"Total bytes by client group", []string{"group", "direction"}, nil)
+	groupBpsDesc = prometheus.NewDesc("catchmole_group_bps",
+		"Current speed by client group in bytes per second", []string{"group", "direction"}, nil)
+	groupActiveConnectionsDesc = prometheus.NewDesc("catchmole_group_active_connections",
+		"Active connections by client group", []string{"group"}, nil)
+	groupActiveClientsDesc = prometheus.NewDesc("catchmole_group_active_clients",
+		"Online member clients by client group", []string{"group"}, nil)
+
+	// Device tag metrics
+	tagBytesTotalDesc = prometheus.NewDesc("catchmole_tag_bytes_total",
+		"Total bytes by device tag", []string{"tag", "direction"}, nil)
+	tagBpsDesc = prometheus.NewDesc("catchmole_tag_bps",
+		"Current speed by device tag in bytes per second", []string{"tag", "direction"}, nil)
+	tagActiveConnectionsDesc = prometheus.NewDesc("catchmole_tag_active_connections",
+		"Active connections by device tag", []string{"tag"}, nil)
+	tagActiveClientsDesc = prometheus.NewDesc("catchmole_tag_active_clients",
+		"Online clients by device tag", []string{"tag"}, nil)
+
+	// VLAN metrics
+	vlanBytesTotalDesc = prometheus.NewDesc("catchmole_vlan_bytes_total",
+		"Total bytes by VLAN (0 is untagged)", []string{"vlan", "direction"}, nil)
+	vlanBpsDesc = prometheus.NewDesc("catchmole_vlan_bps",
+		"Current speed by VLAN in bytes per second", []string{"vlan", "direction"}, nil)
+	vlanActiveClientsDesc = prometheus.NewDesc("catchmole_vlan_active_clients",
+		"Tracked clients by VLAN", []string{"vlan"}, nil)
+)
 
-	// Protocol-level metrics
-	protocolBytesTotal *prometheus.GaugeVec
+var allDescs = []*prometheus.Desc{
+	globalDownloadBpsDesc, globalUploadBpsDesc, globalSpeedAvgBpsDesc, globalActiveConnectionsDesc, globalOffloadedFlowsDesc,
+	globalActiveDevicesDesc, globalNewConnRateDesc, globalClosedConnRateDesc, globalFailedConnRateDesc,
+	globalFailedConnsTotalDesc, globalConnsTotalDesc, globalBytesTotalDesc, globalFamilyBytesTotalDesc,
+	globalUtilizationDesc,
+
+	uptimeSecondsDesc, sourceReconnectsTotalDesc, sourceCoalescedTotalDesc, sourceDroppedTotalDesc,
+	sourceIdleSecondsDesc, eventQueueLengthDesc, eventQueueCapacityDesc, tickAgeSecondsDesc,
+	tickDurationSecondsDesc, cappedDeltasTotalDesc, cappedBytesTotalDesc, evictedFlowsTotalDesc,
+	evictedClientsTotalDesc, trackedFlowsDesc, trackedClientsDesc, tableLimitDesc, monitorPausedDesc,
+	pausedEventsDesc, byteUnitsInfoDesc,
+
+	deviceDownloadBpsDesc, deviceUploadBpsDesc, deviceSpeedAvgBpsDesc, deviceActiveConnectionsDesc, deviceRTTSecondsDesc,
+	deviceNewConnRateDesc, deviceClosedConnRateDesc, deviceFailedConnRateDesc, deviceFailedConnsDesc,
+	deviceConnsTotalDesc, clientNewFlowsTotalDesc, deviceBytesTotalDesc, deviceFamilyBytesTotalDesc,
+	deviceSessionBytesDesc, deviceOnlineDesc,
+
+	protocolBytesTotalDesc, categoryBytesTotalDesc, classBytesTotalDesc, globalClassBytesTotalDesc,
+	countryBytesTotalDesc, blockedCountryBytesTotalDesc,
+	zoneBytesTotalDesc, zoneBpsDesc, zoneUtilizationDesc,
+	groupBytesTotalDesc, groupBpsDesc, groupActiveConnectionsDesc, groupActiveClientsDesc,
+	tagBytesTotalDesc, tagBpsDesc, tagActiveConnectionsDesc, tagActiveClientsDesc,
+	vlanBytesTotalDesc, vlanBpsDesc, vlanActiveClientsDesc,
+}
 
-	startTime time.Time
+// Exporter collects CatchMole stats and exports them as Prometheus metrics.
+// Each scrape takes one snapshot of the aggregator and emits const metrics
+// from it, so series of departed devices disappear by themselves.
+type Exporter struct {
+	agg    *stats.Aggregator
+	source monitor.TrafficSource
 }
 
 // NewExporter creates a new Prometheus exporter
-func NewExporter(agg *stats.Aggregator) *Exporter {
-	return &Exporter{
-		agg:       agg,
-		startTime: time.Now(),
-
-		// Global metrics
-		globalDownloadBps: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "catchmole_global_download_bps",
-			Help: "Global download speed in bytes per second",
-		}),
-		globalUploadBps: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "catchmole_global_upload_bps",
-			Help: "Global upload speed in bytes per second",
-		}),
-		globalActiveConnections: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "catchmole_global_active_connections",
-			Help: "Total number of active connections",
-		}),
-		globalActiveDevices: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "catchmole_global_active_devices",
-			Help: "Number of active devices",
-		}),
-		globalBytesTotal: prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Name: "catchmole_global_bytes_total",
-				Help: "Total bytes transferred globally (counter, survives restarts)",
-			},
-			[]string{"direction"}, // "download" or "upload"
-		),
-		uptimeSeconds: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "catchmole_uptime_seconds",
-			Help: "CatchMole uptime in seconds",
-		}),
-
-		// Initialize delta tracking
-		lastGlobalDownload: 0,
-		lastGlobalUpload:   0,
-		lastDeviceBytes:    make(map[string]map[string]uint64),
-
-		// Device-level metrics
-		deviceDownloadBps: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "catchmole_device_download_bps",
-				Help: "Device download speed in bytes per second",
-			},
-			[]string{"mac", "name"},
-		),
-		deviceUploadBps: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "catchmole_device_upload_bps",
-				Help: "Device upload speed in bytes per second",
-			},
-			[]string{"mac", "name"},
-		),
-		deviceActiveConnections: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "catchmole_device_active_connections",
-				Help: "Number of active connections per device",
-			},
-			[]string{"mac", "name"},
-		),
-		deviceBytesTotal: prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Name: "catchmole_device_bytes_total",
-				Help: "Total bytes transferred by device (counter, survives restarts)",
-			},
-			[]string{"mac", "name", "direction"},
-		),
-		deviceSessionBytes: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "catchmole_device_session_bytes",
-				Help: "Session bytes transferred by device",
-			},
-			[]string{"mac", "name", "direction"},
-		),
-
-		// Protocol-level metrics
-		protocolBytesTotal: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "catchmole_protocol_bytes_total",
-				Help: "Total bytes by protocol",
-			},
-			[]string{"protocol", "direction", "mac", "name"},
-		),
-	}
+func NewExporter(agg *stats.Aggregator, source monitor.TrafficSource) *Exporter {
+	return &Exporter{agg: agg, source: source}
 }
 
 // Describe implements prometheus.Collector
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	e.globalDownloadBps.Describe(ch)
-	e.globalUploadBps.Describe(ch)
-	e.globalActiveConnections.Describe(ch)
-	e.globalActiveDevices.Describe(ch)
-	e.globalBytesTotal.Describe(ch)
-	e.uptimeSeconds.Describe(ch)
-
-	e.deviceDownloadBps.Describe(ch)
-	e.deviceUploadBps.Describe(ch)
-	e.deviceActiveConnections.Describe(ch)
-	e.deviceBytesTotal.Describe(ch)
-	e.deviceSessionBytes.Describe(ch)
-
-	e.protocolBytesTotal.Describe(ch)
+	for _, d := range allDescs {
+		ch <- d
+	}
 }
 
 // Collect implements prometheus.Collector
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	// Reset dynamic metrics (devices may come and go)
-	e.deviceDownloadBps.Reset()
-	e.deviceUploadBps.Reset()
-	e.deviceActiveConnections.Reset()
-	e.deviceBytesTotal.Reset()
-	e.deviceSessionBytes.Reset()
-	e.protocolBytesTotal.Reset()
-
-	// Collect global stats
-	globalStats := e.agg.GetGlobalStats()
-	e.globalDownloadBps.Set(float64(globalStats.DownloadSpeed))
-	e.globalUploadBps.Set(float64(globalStats.UploadSpeed))
-	e.globalActiveConnections.Set(float64(globalStats.ActiveConnections))
-
-	// Calculate and add deltas for global bytes (Counter)
-	if globalStats.TotalDownload > e.lastGlobalDownload {
-		delta := globalStats.TotalDownload - e.lastGlobalDownload
-		e.globalBytesTotal.WithLabelValues("download").Add(float64(delta))
-		e.lastGlobalDownload = globalStats.TotalDownload
-	}
-	if globalStats.TotalUpload > e.lastGlobalUpload {
-		delta := globalStats.TotalUpload - e.lastGlobalUpload
-		e.globalBytesTotal.WithLabelValues("upload").Add(float64(delta))
-		e.lastGlobalUpload = globalStats.TotalUpload
-	}
-
-	// Collect device stats
-	clients := e.agg.GetClients()
-	e.globalActiveDevices.Set(float64(len(clients)))
-
-	for _, client := range clients {
-		mac := client.MAC
-		name := client.Name
+	snap := e.agg.MetricsSnapshot()
+
+	gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+	}
+	counter := func(desc *prometheus.Desc, v uint64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), labels...)
+	}
+	// Download and upload, the direction is the last label
+	pair := func(desc *prometheus.Desc, vt prometheus.ValueType, down, up uint64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, vt, float64(down), append(labels, "download")...)
+		ch <- prometheus.MustNewConstMetric(desc, vt, float64(up), append(labels, "upload")...)
+	}
+	// Same for metrics with the direction second, before mac and name
+	pairOf := func(desc *prometheus.Desc, down, up uint64, key, mac, name string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(down), key, "download", mac, name)
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(up), key, "upload", mac, name)
+	}
+
+	// Global stats
+	g := snap.Global
+	gauge(globalDownloadBpsDesc, float64(g.DownloadSpeed))
+	gauge(globalUploadBpsDesc, float64(g.UploadSpeed))
+	gauge(globalSpeedAvgBpsDesc, float64(g.DownloadSpeed1m), "download", "1m")
+	gauge(globalSpeedAvgBpsDesc, float64(g.DownloadSpeed5m), "download", "5m")
+	gauge(globalSpeedAvgBpsDesc, float64(g.DownloadSpeed15m), "download", "15m")
+	gauge(globalSpeedAvgBpsDesc, float64(g.UploadSpeed1m), "upload", "1m")
+	gauge(globalSpeedAvgBpsDesc, float64(g.UploadSpeed5m), "upload", "5m")
+	gauge(globalSpeedAvgBpsDesc, float64(g.UploadSpeed15m), "upload", "15m")
+	gauge(globalActiveConnectionsDesc, float64(g.ActiveConnections))
+	gauge(globalOffloadedFlowsDesc, float64(g.OffloadedFlows))
+	gauge(globalActiveDevicesDesc, float64(len(snap.Clients)))
+	gauge(globalNewConnRateDesc, g.NewConnRate)
+	gauge(globalClosedConnRateDesc, g.ClosedConnRate)
+	gauge(globalFailedConnRateDesc, g.FailedConnRate)
+	counter(globalFailedConnsTotalDesc, g.FailedConnections)
+	counter(globalConnsTotalDesc, g.NewConnections, "new")
+	counter(globalConnsTotalDesc, g.ClosedConnections, "closed")
+	pair(globalBytesTotalDesc, prometheus.CounterValue, g.TotalDownload, g.TotalUpload)
+	pair(globalFamilyBytesTotalDesc, prometheus.CounterValue, g.TotalDownload4, g.TotalUpload4, "ipv4")
+	pair(globalFamilyBytesTotalDesc, prometheus.CounterValue, g.TotalDownload6, g.TotalUpload6, "ipv6")
+	if g.DownloadCapacity > 0 {
+		gauge(globalUtilizationDesc, g.DownloadUtilization, "download")
+	}
+	if g.UploadCapacity > 0 {
+		gauge(globalUtilizationDesc, g.UploadUtilization, "upload")
+	}
+
+	// Device stats
+	for _, c := range snap.Clients {
+		mac := c.MAC
+		name := c.Name
 		if name == "" {
 			name = mac // Fallback to MAC if no name
 		}
 
-		// Device-level metrics
-		e.deviceDownloadBps.WithLabelValues(mac, name).Set(float64(client.DownloadSpeed))
-		e.deviceUploadBps.WithLabelValues(mac, name).Set(float64(client.UploadSpeed))
-		e.deviceActiveConnections.WithLabelValues(mac, name).Set(float64(client.ActiveConnections))
-
-		// Calculate and add deltas for device bytes (Counter)
-		if e.lastDeviceBytes[mac] == nil {
-			e.lastDeviceBytes[mac] = make(map[string]uint64)
+		gauge(deviceDownloadBpsDesc, float64(c.DownloadSpeed), mac, name)
+		gauge(deviceUploadBpsDesc, float64(c.UploadSpeed), mac, name)
+		gauge(deviceSpeedAvgBpsDesc, float64(c.DownloadSpeed1m), mac, name, "download", "1m")
+		gauge(deviceSpeedAvgBpsDesc, float64(c.DownloadSpeed5m), mac, name, "download", "5m")
+		gauge(deviceSpeedAvgBpsDesc, float64(c.DownloadSpeed15m), mac, name, "download", "15m")
+		gauge(deviceSpeedAvgBpsDesc, float64(c.UploadSpeed1m), mac, name, "upload", "1m")
+		gauge(deviceSpeedAvgBpsDesc, float64(c.UploadSpeed5m), mac, name, "upload", "5m")
+		gauge(deviceSpeedAvgBpsDesc, float64(c.UploadSpeed15m), mac, name, "upload", "15m")
+		gauge(deviceActiveConnectionsDesc, float64(c.ActiveConnections), mac, name)
+		if c.RTT > 0 {
+			gauge(deviceRTTSecondsDesc, c.RTT/1000, mac, name)
 		}
-
-		// Download
-		lastDownload := e.lastDeviceBytes[mac]["download"]
-		if client.TotalDownload > lastDownload {
-			delta := client.TotalDownload - lastDownload
-			e.deviceBytesTotal.WithLabelValues(mac, name, "download").Add(float64(delta))
-			e.lastDeviceBytes[mac]["download"] = client.TotalDownload
+		gauge(deviceNewConnRateDesc, c.NewConnRate, mac, name)
+		gauge(deviceClosedConnRateDesc, c.ClosedConnRate, mac, name)
+		gauge(deviceFailedConnRateDesc, c.FailedConnRate, mac, name)
+		gauge(deviceFailedConnsDesc, float64(c.FailedConnections), mac, name)
+		counter(deviceConnsTotalDesc, c.NewConnections, mac, name, "new")
+		counter(deviceConnsTotalDesc, c.ClosedConnections, mac, name, "closed")
+		counter(deviceConnsTotalDesc, c.FailedConnections, mac, name, "failed")
+		counter(clientNewFlowsTotalDesc, c.NewFlows, mac, name)
+		pair(deviceBytesTotalDesc, prometheus.CounterValue, c.TotalDownload, c.TotalUpload, mac, name)
+		pair(deviceFamilyBytesTotalDesc, prometheus.CounterValue, c.TotalDownload4, c.TotalUpload4, mac, name, "ipv4")
+		pair(deviceFamilyBytesTotalDesc, prometheus.CounterValue, c.TotalDownload6, c.TotalUpload6, mac, name, "ipv6")
+		pair(deviceSessionBytesDesc, prometheus.GaugeValue, c.SessionDownload, c.SessionUpload, mac, name)
+		online := 0.0
+		if c.Online {
+			online = 1
 		}
+		gauge(deviceOnlineDesc, online, mac, name)
 
-		// Upload
-		lastUpload := e.lastDeviceBytes[mac]["upload"]
-		if client.TotalUpload > lastUpload {
-			delta := client.TotalUpload - lastUpload
-			e.deviceBytesTotal.WithLabelValues(mac, name, "upload").Add(float64(delta))
-			e.lastDeviceBytes[mac]["upload"] = client.TotalUpload
+		for protocol, bc := range c.Protocols {
+			pairOf(protocolBytesTotalDesc, bc.Download, bc.Upload, protocol, mac, name)
 		}
-
-		// Get session stats
-		clientWithSession := e.agg.GetClientWithSession(mac)
-		if clientWithSession != nil {
-			e.deviceSessionBytes.WithLabelValues(mac, name, "download").Set(float64(clientWithSession.SessionDownload))
-			e.deviceSessionBytes.WithLabelValues(mac, name, "upload").Set(float64(clientWithSession.SessionUpload))
+		for _, cat := range c.Categories {
+			pairOf(categoryBytesTotalDesc, cat.TotalDownload, cat.TotalUpload, cat.Category, mac, name)
 		}
-
-		// Collect flow-level stats for protocol breakdown
-		clientProtocolStats := make(map[string]map[string]uint64)
-		flows, _, _ := e.agg.GetFlowsByMAC(mac)
-		for _, flow := range flows {
-			if clientProtocolStats[flow.Protocol] == nil {
-				clientProtocolStats[flow.Protocol] = make(map[string]uint64)
-			}
-			clientProtocolStats[flow.Protocol]["download"] += flow.TotalDownload
-			clientProtocolStats[flow.Protocol]["upload"] += flow.TotalUpload
+		for _, cl := range c.Classes {
+			pairOf(classBytesTotalDesc, cl.TotalDownload, cl.TotalUpload, cl.Class, mac, name)
+		}
+		// Per device only for blocked countries to bound cardinality
+		for _, cs := range c.BlockedCountries {
+			pairOf(blockedCountryBytesTotalDesc, cs.TotalDownload, cs.TotalUpload, cs.Country, mac, name)
 		}
+	}
+
+	for _, cl := range snap.GlobalClasses {
+		pair(globalClassBytesTotalDesc, prometheus.GaugeValue, cl.TotalDownload, cl.TotalUpload, cl.Class)
+	}
+	for _, cs := range snap.Countries {
+		pair(countryBytesTotalDesc, prometheus.GaugeValue, cs.TotalDownload, cs.TotalUpload, cs.Country)
+	}
 
-		// Export protocol stats for this device
-		for protocol, directions := range clientProtocolStats {
-			for direction, bytes := range directions {
-				e.protocolBytesTotal.WithLabelValues(protocol, direction, mac, name).Set(float64(bytes))
-			}
+	// Conntrack zones (uplinks on multi-WAN routers)
+	for _, z := range snap.Zones {
+		zone := strconv.Itoa(int(z.Zone))
+		pair(zoneBytesTotalDesc, prometheus.GaugeValue, z.TotalDownload, z.TotalUpload, zone, z.Name)
+		pair(zoneBpsDesc, prometheus.GaugeValue, z.DownloadSpeed, z.UploadSpeed, zone, z.Name)
+		if z.DownloadCapacity > 0 {
+			gauge(zoneUtilizationDesc, z.DownloadUtilization, zone, z.Name, "download")
 		}
+		if z.UploadCapacity > 0 {
+			gauge(zoneUtilizationDesc, z.UploadUtilization, zone, z.Name, "upload")
+		}
+	}
+
+	// Client groups
+	for _, gs := range snap.Groups {
+		pair(groupBytesTotalDesc, prometheus.GaugeValue, gs.TotalDownload, gs.TotalUpload, gs.Name)
+		pair(groupBpsDesc, prometheus.GaugeValue, gs.DownloadSpeed, gs.UploadSpeed, gs.Name)
+		gauge(groupActiveConnectionsDesc, float64(gs.ActiveConnections), gs.Name)
+		gauge(groupActiveClientsDesc, float64(gs.ActiveClients), gs.Name)
 	}
 
-	// Uptime
-	e.uptimeSeconds.Set(time.Since(e.startTime).Seconds())
+	// Device tags, a client with several tags counts in each
+	for _, t := range snap.Tags {
+		pair(tagBytesTotalDesc, prometheus.GaugeValue, t.TotalDownload, t.TotalUpload, t.Name)
+		pair(tagBpsDesc, prometheus.GaugeValue, t.DownloadSpeed, t.UploadSpeed, t.Name)
+		gauge(tagActiveConnectionsDesc, float64(t.ActiveConnections), t.Name)
+		gauge(tagActiveClientsDesc, float64(t.ActiveClients), t.Name)
+	}
 
-	// Collect all metrics
-	e.globalDownloadBps.Collect(ch)
-	e.globalUploadBps.Collect(ch)
-	e.globalActiveConnections.Collect(ch)
-	e.globalActiveDevices.Collect(ch)
-	e.globalBytesTotal.Collect(ch)
-	e.uptimeSeconds.Collect(ch)
+	// VLANs
+	for _, v := range snap.VLANs {
+		vlan := strconv.Itoa(int(v.VLAN))
+		pair(vlanBytesTotalDesc, prometheus.GaugeValue, v.TotalDownload, v.TotalUpload, vlan)
+		pair(vlanBpsDesc, prometheus.GaugeValue, v.DownloadSpeed, v.UploadSpeed, vlan)
+		gauge(vlanActiveClientsDesc, float64(v.ActiveClients), vlan)
+	}
 
-	e.deviceDownloadBps.Collect(ch)
-	e.deviceUploadBps.Collect(ch)
-	e.deviceActiveConnections.Collect(ch)
-	e.deviceBytesTotal.Collect(ch)
-	e.deviceSessionBytes.Collect(ch)
+	// Aggregator health
+	gauge(uptimeSecondsDesc, snap.Time.Sub(snap.Start).Seconds())
+	counter(cappedDeltasTotalDesc, snap.CappedDeltas)
+	counter(cappedBytesTotalDesc, snap.CappedBytes)
+	counter(evictedFlowsTotalDesc, snap.EvictedFlows)
+	counter(evictedClientsTotalDesc, snap.EvictedClients)
+	gauge(trackedFlowsDesc, float64(snap.TrackedFlows))
+	gauge(trackedClientsDesc, float64(snap.TrackedClients))
+	gauge(tableLimitDesc, float64(snap.MaxFlows), "flows")
+	gauge(tableLimitDesc, float64(snap.MaxClients), "clients")
+	paused := 0.0
+	if snap.Monitor.Paused {
+		paused = 1
+	}
+	gauge(monitorPausedDesc, paused)
+	gauge(pausedEventsDesc, float64(snap.Monitor.DroppedEvents))
+	byteUnits := units.Current()
+	gauge(byteUnitsInfoDesc, 1, string(byteUnits), strconv.FormatUint(byteUnits.Base(), 10))
+	if !snap.LastTick.IsZero() {
+		gauge(tickAgeSecondsDesc, snap.Time.Sub(snap.LastTick).Seconds())
+		gauge(tickDurationSecondsDesc, snap.TickDuration.Seconds())
+	}
 
-	e.protocolBytesTotal.Collect(ch)
+	// Traffic source health, read outside the aggregator lock
+	counter(sourceReconnectsTotalDesc, e.source.Reconnects())
+	coalesced, dropped := e.source.BufferStats()
+	counter(sourceCoalescedTotalDesc, coalesced)
+	counter(sourceDroppedTotalDesc, dropped)
+	if last := e.source.LastActivity(); !last.IsZero() {
+		gauge(sourceIdleSecondsDesc, time.Since(last).Seconds())
+	}
+	events := e.source.Events()
+	gauge(eventQueueLengthDesc, float64(len(events)))
+	gauge(eventQueueCapacityDesc, float64(cap(events)))
 }
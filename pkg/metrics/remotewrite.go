@@ -0,0 +1,227 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Series per request, well below the usual receiver limits
+const maxSeriesPerRequest = 2000
+
+type label struct {
+	name, value string
+}
+
+type series struct {
+	labels []label // Sorted by name, including __name__
+	value  float64
+}
+
+// RemoteWriter pushes the catchmole_* series to a Prometheus remote-write
+// endpoint (Prometheus, VictoriaMetrics, Mimir) for routers that can't be
+// scraped. Failed pushes are dropped: counters are cumulative, the next
+// push carries the current totals.
+type RemoteWriter struct {
+	url      string
+	gatherer prometheus.Gatherer
+	client   *http.Client
+
+	user, password string
+	token          string
+
+	external []label // job and instance
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewRemoteWriter(url string, gatherer prometheus.Gatherer) *RemoteWriter {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "catchmole"
+	}
+	return &RemoteWriter{
+		url:      url,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		external: []label{{"instance", instance}, {"job", "catchmole"}},
+		stop:     make(chan struct{}),
+	}
+}
+
+// SetBasicAuth authenticates pushes with HTTP basic auth
+func (w *RemoteWriter) SetBasicAuth(user, password string) {
+	w.user, w.password = user, password
+}
+
+// SetBearerToken authenticates pushes with a bearer token
+func (w *RemoteWriter) SetBearerToken(token string) {
+	w.token = token
+}
+
+func (w *RemoteWriter) Start(interval time.Duration) {
+	w.wg.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := w.Push(); err != nil {
+					slog.Error("Remote write error", "err", err)
+				}
+			case <-w.stop:
+				return
+			}
+		}
+	})
+}
+
+// Stop pushes the final values
+func (w *RemoteWriter) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+	if err := w.Push(); err != nil {
+		slog.Error("Remote write final push error", "err", err)
+	}
+}
+
+// Push gathers the current values and sends them in batches
+func (w *RemoteWriter) Push() error {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	var all []series
+	for _, mf := range families {
+		if strings.HasPrefix(mf.GetName(), "catchmole_") {
+			all = w.appendFamily(all, mf)
+		}
+	}
+
+	ts := time.Now().UnixMilli()
+	for batch := range slices.Chunk(all, maxSeriesPerRequest) {
+		if err := w.send(encodeWriteRequest(batch, ts)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendFamily flattens a metric family into series. Histograms and summaries
+// become their _sum, _count (and _bucket) series like in the text format.
+func (w *RemoteWriter) appendFamily(out []series, mf *dto.MetricFamily) []series {
+	name := mf.GetName()
+	for _, m := range mf.GetMetric() {
+		base := make([]label, 0, len(m.GetLabel())+len(w.external)+2)
+		base = append(base, w.external...)
+		for _, lp := range m.GetLabel() {
+			base = append(base, label{lp.GetName(), lp.GetValue()})
+		}
+		add := func(name string, value float64, extra ...label) {
+			labels := append(slices.Clone(base), label{"__name__", name})
+			labels = append(labels, extra...)
+			slices.SortFunc(labels, func(a, b label) int { return strings.Compare(a.name, b.name) })
+			out = append(out, series{labels: labels, value: value})
+		}
+
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			add(name, m.GetCounter().GetValue())
+		case dto.MetricType_GAUGE:
+			add(name, m.GetGauge().GetValue())
+		case dto.MetricType_UNTYPED:
+			add(name, m.GetUntyped().GetValue())
+		case dto.MetricType_SUMMARY:
+			s := m.GetSummary()
+			add(name+"_sum", s.GetSampleSum())
+			add(name+"_count", float64(s.GetSampleCount()))
+			for _, q := range s.GetQuantile() {
+				add(name, q.GetValue(), label{"quantile", strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)})
+			}
+		case dto.MetricType_HISTOGRAM:
+			h := m.GetHistogram()
+			add(name+"_sum", h.GetSampleSum())
+			add(name+"_count", float64(h.GetSampleCount()))
+			for _, b := range h.GetBucket() {
+				add(name+"_bucket", float64(b.GetCumulativeCount()), label{"le", strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)})
+			}
+			add(name+"_bucket", float64(h.GetSampleCount()), label{"le", "+Inf"})
+		}
+	}
+	return out
+}
+
+func (w *RemoteWriter) send(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(snappy.Encode(nil, body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "catchmole")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	} else if w.user != "" {
+		req.SetBasicAuth(w.user, w.password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// encodeWriteRequest builds a prometheus.WriteRequest protobuf:
+// repeated TimeSeries timeseries = 1 { repeated Label labels = 1; repeated Sample samples = 2 }
+func encodeWriteRequest(list []series, ts int64) []byte {
+	var out, tsBuf, buf []byte
+	for _, s := range list {
+		tsBuf = tsBuf[:0]
+		for _, l := range s.labels {
+			buf = buf[:0]
+			buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+			buf = protowire.AppendString(buf, l.name)
+			buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+			buf = protowire.AppendString(buf, l.value)
+			tsBuf = protowire.AppendTag(tsBuf, 1, protowire.BytesType)
+			tsBuf = protowire.AppendBytes(tsBuf, buf)
+		}
+
+		buf = buf[:0]
+		buf = protowire.AppendTag(buf, 1, protowire.Fixed64Type)
+		buf = protowire.AppendFixed64(buf, math.Float64bits(s.value))
+		buf = protowire.AppendTag(buf, 2, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, uint64(ts))
+		tsBuf = protowire.AppendTag(tsBuf, 2, protowire.BytesType)
+		tsBuf = protowire.AppendBytes(tsBuf, buf)
+
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, tsBuf)
+	}
+	return out
+}
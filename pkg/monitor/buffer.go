@@ -0,0 +1,168 @@
+package monitor
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+// Events waiting for the aggregator before new flows are dropped
+const maxBufferedEvents = 65536
+
+// eventKey identifies the flow of an event for coalescing
+type eventKey struct {
+	src, dst         netip.Addr
+	srcPort, dstPort uint16
+	proto            uint8
+	icmpType         uint8
+	icmpID           uint16
+	flowID           uint32
+	zone             uint16
+}
+
+func keyOf(ev FlowEvent) eventKey {
+	src, _ := netip.AddrFromSlice(ev.SrcIP)
+	dst, _ := netip.AddrFromSlice(ev.DstIP)
+	return eventKey{
+		src: src.Unmap(), dst: dst.Unmap(),
+		srcPort: ev.SrcPort, dstPort: ev.DstPort, proto: ev.Proto,
+		icmpType: ev.ICMPType, icmpID: ev.ICMPID,
+		flowID: ev.FlowID, zone: ev.Zone,
+	}
+}
+
+// EventBuffer sits between a traffic source and the aggregator. The source
+// never blocks: while the aggregator lags, queued updates of the same flow are
+// merged into one event with the summed byte deltas instead of being dropped.
+// Only when the queue is full are events of other flows dropped.
+type EventBuffer struct {
+	out       chan FlowEvent
+	telemetry SourceTelemetry
+
+	mu      sync.Mutex
+	queue   []FlowEvent
+	updates map[eventKey]int // Queue index of the last update of a flow, if nothing later for it is queued
+	closed  bool
+	wake    chan struct{}
+
+	coalesced atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// NewEventBuffer starts delivering to the channel returned by Events
+func NewEventBuffer(telemetry SourceTelemetry) *EventBuffer {
+	b := &EventBuffer{
+		out:       make(chan FlowEvent, 1024),
+		telemetry: telemetry,
+		updates:   make(map[eventKey]int),
+		wake:      make(chan struct{}, 1),
+	}
+	go b.deliver()
+	return b
+}
+
+// Events returns the channel the aggregator reads. It is closed by Close
+// after the queued events were delivered.
+func (b *EventBuffer) Events() <-chan FlowEvent {
+	return b.out
+}
+
+// Emit queues an event without blocking
+func (b *EventBuffer) Emit(ev FlowEvent) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+
+	k := keyOf(ev)
+	if ev.Type == EventUpdate {
+		if i, ok := b.updates[k]; ok {
+			merge(&b.queue[i], ev)
+			b.mu.Unlock()
+			b.coalesced.Add(1)
+			eventsCoalesced.Add(context.Background(), 1, b.telemetry.attrs)
+			return
+		}
+	}
+	if len(b.queue) >= maxBufferedEvents {
+		b.mu.Unlock()
+		b.dropped.Add(1)
+		eventsDropped.Add(context.Background(), 1, b.telemetry.attrs)
+		return
+	}
+
+	b.queue = append(b.queue, ev)
+	if ev.Type == EventUpdate {
+		b.updates[k] = len(b.queue) - 1
+	} else {
+		// Keep the order: later updates must not merge into earlier ones
+		delete(b.updates, k)
+	}
+	b.mu.Unlock()
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// merge folds a later update of the same flow into a queued one
+func merge(into *FlowEvent, ev FlowEvent) {
+	into.OriginBytes += ev.OriginBytes
+	into.ReplyBytes += ev.ReplyBytes
+	into.RxBytes += ev.RxBytes
+	into.TxBytes += ev.TxBytes
+	into.TCPState = ev.TCPState
+	into.SeenReply = ev.SeenReply
+	into.Assured = ev.Assured
+	into.Offload = ev.Offload
+	into.Mark = ev.Mark
+	into.Display = ev.Display
+	// Timestamp stays at the first event, it measures the queueing delay
+}
+
+// Stats returns the events merged into queued ones and the events dropped
+func (b *EventBuffer) Stats() (coalesced, dropped uint64) {
+	return b.coalesced.Load(), b.dropped.Load()
+}
+
+// Close delivers the queued events and closes the Events channel.
+// Later events are discarded.
+func (b *EventBuffer) Close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver hands queued events to the aggregator in batches, blocking while it is busy
+func (b *EventBuffer) deliver() {
+	for range b.wake {
+		for {
+			b.mu.Lock()
+			batch := b.queue
+			b.queue = nil
+			clear(b.updates)
+			closed := b.closed
+			b.mu.Unlock()
+
+			if len(batch) == 0 {
+				if closed {
+					close(b.out)
+					return
+				}
+				break
+			}
+			for _, ev := range batch {
+				b.out <- ev
+				eventsEmitted.Add(context.Background(), 1, b.telemetry.attrs)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Capability is a Linux capability catchmole may need
+type Capability int
+
+const (
+	CapNetAdmin Capability = unix.CAP_NET_ADMIN // Conntrack, nftables, tc
+	CapNetRaw   Capability = unix.CAP_NET_RAW   // Packet sockets
+	CapSysAdmin Capability = unix.CAP_SYS_ADMIN // Entering another netns
+	CapBPF      Capability = unix.CAP_BPF       // Loading eBPF programs (Linux 5.8+)
+)
+
+func (c Capability) String() string {
+	switch c {
+	case CapNetAdmin:
+		return "CAP_NET_ADMIN"
+	case CapNetRaw:
+		return "CAP_NET_RAW"
+	case CapSysAdmin:
+		return "CAP_SYS_ADMIN"
+	case CapBPF:
+		return "CAP_BPF"
+	}
+	return "CAP_" + strconv.Itoa(int(c))
+}
+
+// Degradation is a feature that is off or limited for lack of a capability
+// or of system support (Capability empty)
+type Degradation struct {
+	Feature    string `json:"feature"`
+	Capability string `json:"capability,omitempty"`
+	Detail     string `json:"detail"`
+}
+
+// effectiveCaps reads the effective capability set once, all set if unknown
+var effectiveCaps = sync.OnceValue(func() uint64 {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return ^uint64(0)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "CapEff:"); ok {
+			if caps, err := strconv.ParseUint(strings.TrimSpace(v), 16, 64); err == nil {
+				return caps
+			}
+		}
+	}
+	return ^uint64(0)
+})
+
+// HasCapability reports whether the process has c in its effective set
+func HasCapability(c Capability) bool {
+	return effectiveCaps()&(1<<uint(c)) != 0
+}
+
+// NetAdminCommand is exec.CommandContext for tools that configure the network
+// (nft, wg). File capabilities granted with setcap are not inherited across
+// exec, so CAP_NET_ADMIN is raised into the ambient set for the child.
+func NetAdminCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if HasCapability(CapNetAdmin) {
+		cmd.SysProcAttr = &syscall.SysProcAttr{AmbientCaps: []uintptr{uintptr(CapNetAdmin)}}
+	}
+	return cmd
+}
+
+// Capabilities returns whether each capability catchmole uses is available
+func Capabilities() map[string]bool {
+	caps := make(map[string]bool)
+	for _, c := range []Capability{CapNetAdmin, CapNetRaw, CapSysAdmin, CapBPF} {
+		caps[c.String()] = HasCapability(c)
+	}
+	return caps
+}
+
+// MissingCapability is the error for a feature that cannot run without c
+func MissingCapability(feature string, c Capability) error {
+	return fmt.Errorf("%s needs %s (%s)", feature, c, capabilityHint(c.String()))
+}
+
+// capabilityHint tells how to grant a capability
+func capabilityHint(capability string) string {
+	if InContainer() {
+		return "start the container with --cap-add " + capability[len("CAP_"):]
+	}
+	return "run as root or grant " + capability
+}
@@ -3,9 +3,12 @@ package monitor
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ti-mo/conntrack"
@@ -26,7 +29,20 @@ type FlowEvent struct {
 	OriginBytes uint64
 	ReplyBytes  uint64
 
+	// Conntrack state as of this event
+	TCPState  uint8 // TCP conntrack state (0 if not TCP)
+	SeenReply bool  // Reply direction has seen traffic
+	Assured   bool  // Connection is assured (TCP handshake completed)
+	Offload   bool  // Connection is offloaded to a flow table, counters may lag
+
+	// ICMP type and identifier, set if the source decodes them (ICMP is true)
+	ICMP     bool
+	ICMPType uint8
+	ICMPID   uint16
+
 	FlowID    uint32 // Conntrack Flow ID
+	Mark      uint32 // Conntrack mark (0 for packet sources)
+	Zone      uint16 // Conntrack zone (0 for packet sources and the default zone)
 	Display   string // For debug
 	Timestamp time.Time
 	Type      EventType
@@ -37,20 +53,71 @@ type EventType int
 const (
 	EventUpdate EventType = iota
 	EventDestroy
+	EventNew
+)
+
+// TrafficSource produces flow events with byte deltas for the aggregator.
+// Implementations: ConntrackMonitor (default) and PacketSource (AF_PACKET capture).
+type TrafficSource interface {
+	Start(pollInterval time.Duration) error
+	Stop()
+	// Restart tears down and re-opens the underlying sockets, keeping flow state
+	Restart() error
+	SetPollInterval(pollInterval time.Duration) error
+	Events() <-chan FlowEvent
+	// LastActivity is the time of the last event or successful poll
+	LastActivity() time.Time
+	// Reconnects counts restarts of the underlying sockets
+	Reconnects() uint64
+	// BufferStats counts events merged while the aggregator lagged and events lost
+	BufferStats() (coalesced, dropped uint64)
+}
+
+// Flusher is a TrafficSource backed by a kernel table that can be cleared,
+// so counters of existing connections restart from zero
+type Flusher interface {
+	Flush() error
+}
+
+// Conntrack monitor modes
+const (
+	ModeHybrid = "hybrid" // Events plus periodic dumps
+	ModePoll   = "poll"   // Periodic dumps only
+	ModeEvents = "events" // Events only
 )
 
+// Dumps may take at most this share of the time. Larger tables are dumped
+// less often than every poll interval instead of keeping a core busy.
+const dumpDutyCycle = 0.1
+
 type flowState struct {
 	LastOriginBytes uint64
 	LastReplyBytes  uint64
+	LastTCPState    uint8
+	LastOffload     bool
 }
 
 type ConntrackMonitor struct {
 	nw     *NeighborWatcher
-	output chan FlowEvent
+	events *EventBuffer
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
+	// Current listen/poll loop (replaced on Restart)
+	runMu        sync.Mutex
+	runCancel    context.CancelFunc
+	runWg        sync.WaitGroup
+	pollInterval time.Duration
+	mode         string
+	markValue    uint32 // Track only flows whose mark&markMask is markValue, mask 0 tracks all
+	markMask     uint32
+
+	// Unix nanos of the last received event or successful dump
+	lastActivity atomic.Int64
+	reconnects   atomic.Uint64
+	telemetry    SourceTelemetry
+
 	// 状态差分机制
 	mu        sync.Mutex
 	lastState map[uint32]*flowState // Key: FlowID
@@ -58,72 +125,222 @@ type ConntrackMonitor struct {
 
 func NewConntrackMonitor(nw *NeighborWatcher) *ConntrackMonitor {
 	ctx, cancel := context.WithCancel(context.Background())
+	telemetry := NewSourceTelemetry("conntrack")
 	return &ConntrackMonitor{
 		nw:        nw,
-		output:    make(chan FlowEvent, 1024),
+		events:    NewEventBuffer(telemetry),
 		ctx:       ctx,
 		cancel:    cancel,
 		lastState: make(map[uint32]*flowState),
+		telemetry: telemetry,
+		mode:      ModeHybrid,
 	}
 }
 
-func (m *ConntrackMonitor) Start(pollInterval time.Duration) error {
-	c, err := conntrack.Dial(nil)
+// SetMode selects how flows are read (ModeHybrid, ModePoll or ModeEvents).
+// Call before Start.
+func (m *ConntrackMonitor) SetMode(mode string) error {
+	switch mode {
+	case "":
+		mode = ModeHybrid
+	case ModeHybrid, ModePoll, ModeEvents:
+	default:
+		return fmt.Errorf("invalid monitor mode %q (want hybrid, poll or events)", mode)
+	}
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	m.mode = mode
+	return nil
+}
+
+// SetMarkFilter restricts tracking to flows whose conntrack mark matches
+// value under mask, so dumps of large tables return only the flows of
+// interest. A zero mask tracks all flows. Call before Start.
+func (m *ConntrackMonitor) SetMarkFilter(value, mask uint32) {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	m.markValue, m.markMask = value, mask
+}
+
+// ParseMark parses a conntrack mark with an optional mask, e.g. "0x10/0xf0"
+func ParseMark(spec string) (value, mask uint32, err error) {
+	v, mk, hasMask := strings.Cut(strings.TrimSpace(spec), "/")
+	n, err := strconv.ParseUint(strings.TrimSpace(v), 0, 32)
 	if err != nil {
-		return fmt.Errorf("failed to dial conntrack: %w", err)
+		return 0, 0, fmt.Errorf("invalid mark %q", spec)
+	}
+	mask = 0xffffffff
+	if hasMask {
+		mn, err := strconv.ParseUint(strings.TrimSpace(mk), 0, 32)
+		if err != nil || mn == 0 {
+			return 0, 0, fmt.Errorf("invalid mark mask %q", spec)
+		}
+		mask = uint32(mn)
+	}
+	return uint32(n), mask, nil
+}
+
+func (m *ConntrackMonitor) Start(pollInterval time.Duration) error {
+	// Use configured interval
+	if pollInterval <= 0 {
+		pollInterval = 1 * time.Second // Default
 	}
 
-	// Listen returns (errChan, error)
-	// We need to supply evChan
-	evCh := make(chan conntrack.Event, 2048)
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	m.pollInterval = pollInterval
+	return m.run()
+}
 
-	// Increase socket buffer size to avoid "no buffer space available" on high traffic
-	if err := c.SetReadBuffer(2097152); err != nil { // 2MB
-		c.Close()
-		return fmt.Errorf("failed to set read buffer: %w", err)
+// Restart tears down the conntrack sockets and starts a fresh listen/poll loop.
+// Flow state is kept so deltas continue seamlessly.
+func (m *ConntrackMonitor) Restart() error {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+
+	if m.runCancel != nil {
+		m.runCancel()
+	}
+	m.runWg.Wait()
+	if err := m.run(); err != nil {
+		return err
 	}
+	m.reconnects.Add(1)
+	return nil
+}
 
-	// GroupsCT includes New, Update, Destroy, etc.
-	errCh, err := c.Listen(evCh, 4, netfilter.GroupsCT)
-	if err != nil {
-		c.Close()
-		return fmt.Errorf("failed to listen to conntrack: %w", err)
+// Reconnects returns how often the conntrack sockets were re-opened
+func (m *ConntrackMonitor) Reconnects() uint64 {
+	return m.reconnects.Load()
+}
+
+// SetPollInterval changes the dump interval, restarting the loop
+func (m *ConntrackMonitor) SetPollInterval(pollInterval time.Duration) error {
+	if pollInterval <= 0 {
+		pollInterval = 1 * time.Second // Default
+	}
+
+	m.runMu.Lock()
+	m.pollInterval = pollInterval
+	m.runMu.Unlock()
+	return m.Restart()
+}
+
+// LastActivity returns the time of the last conntrack event or successful dump
+func (m *ConntrackMonitor) LastActivity() time.Time {
+	ns := m.lastActivity.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (m *ConntrackMonitor) markActivity() {
+	m.lastActivity.Store(time.Now().UnixNano())
+}
+
+// run dials conntrack and starts the listen/poll loop. Caller holds runMu.
+func (m *ConntrackMonitor) run() error {
+	// Event subscription, nil channels in poll mode
+	var (
+		c     *conntrack.Conn
+		evCh  chan conntrack.Event
+		errCh chan error
+	)
+	if m.mode != ModePoll {
+		var err error
+		if c, err = conntrack.Dial(conntrackConfig()); err != nil {
+			return accessError("failed to dial conntrack", "CAP_NET_ADMIN", err)
+		}
+
+		evCh = make(chan conntrack.Event, 2048)
+
+		// Increase socket buffer size to avoid "no buffer space available" on high traffic
+		if err := c.SetReadBuffer(2097152); err != nil { // 2MB
+			c.Close()
+			return fmt.Errorf("failed to set read buffer: %w", err)
+		}
+
+		// GroupsCT includes New, Update, Destroy, etc.
+		if errCh, err = c.Listen(evCh, 4, netfilter.GroupsCT); err != nil {
+			c.Close()
+			return accessError("failed to listen to conntrack", "CAP_NET_ADMIN", err)
+		}
 	}
 
-	// Dial a second connection for polling (Dump)
-	pc, err := conntrack.Dial(nil)
+	// Dial a second connection for polling (Dump), events mode uses it for the initial resync
+	pc, err := conntrack.Dial(conntrackConfig())
 	if err != nil {
-		c.Close()
-		return fmt.Errorf("failed to dial polling conntrack: %w", err)
+		if c != nil {
+			c.Close()
+		}
+		return accessError("failed to dial polling conntrack", "CAP_NET_ADMIN", err)
 	}
 
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.runCancel = cancel
+	mode := m.mode
+	interval := m.pollInterval
+
+	m.runWg.Add(1)
 	m.wg.Go(func() {
-		defer c.Close()
+		defer m.runWg.Done()
+		if c != nil {
+			defer c.Close()
+		}
 		defer pc.Close()
 
+		// Resync counters right away, events may have been missed before a reconnect
+		m.resync(pc)
+
 		// Polling Ticker
-		// Use configured interval
-		if pollInterval <= 0 {
-			pollInterval = 1 * time.Second // Default
+		var tick <-chan time.Time
+		if mode != ModeEvents {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
 		}
-		ticker := time.NewTicker(pollInterval)
-		defer ticker.Stop()
+		var nextDump time.Time
+		var elapsed time.Duration
+		throttled := false
 
 		for {
 			select {
-			case <-m.ctx.Done():
+			case <-ctx.Done():
 				return
-			case <-ticker.C:
-				m.poll(pc)
+			case now := <-tick:
+				if now.Before(nextDump) {
+					if !throttled {
+						slog.Warn("Conntrack dumps are slow for the poll interval, skipping some",
+							"interval", interval, "dump_time", elapsed)
+						throttled = true
+					}
+					continue
+				}
+				start := time.Now()
+				var n int
+				if mode == ModePoll {
+					// No DESTROY events, forget closed flows on every dump
+					n = m.resync(pc)
+				} else {
+					n = m.poll(pc)
+				}
+				elapsed = time.Since(start)
+				nextDump = start.Add(time.Duration(float64(elapsed) / dumpDutyCycle))
+				if throttled && float64(elapsed) < float64(interval)*dumpDutyCycle {
+					slog.Info("Conntrack dumps back to every poll interval", "flows", n)
+					throttled = false
+				}
 			case err := <-errCh:
-				// Handle error (maybe log it)
-				log.Printf("Conntrack listen error: %v\n", err)
-				// If fatal, we might need to break or reconnect?
-				// For now just log.
+				// Listen workers stop on socket errors (e.g. ENOBUFS on overrun), so re-dial
+				slog.Error("Conntrack listen error, reconnecting", "err", err)
+				m.wg.Go(func() { ReconnectLoop(m.ctx, "Conntrack", m.Restart) })
+				return
 			case ev, ok := <-evCh:
 				if !ok {
 					return
 				}
+				m.markActivity()
 				m.processEvent(ev)
 			}
 		}
@@ -132,60 +349,175 @@ func (m *ConntrackMonitor) Start(pollInterval time.Duration) error {
 	return nil
 }
 
-func (m *ConntrackMonitor) poll(c *conntrack.Conn) {
-	flows, err := c.Dump(nil)
+// poll dumps the table and emits the counter deltas, returning the number of flows
+func (m *ConntrackMonitor) poll(c *conntrack.Conn) int {
+	defer m.telemetry.StartPoll(m.ctx, "dump")()
+
+	flows, err := m.dump(c)
 	if err != nil {
-		log.Printf("Conntrack dump error: %v\n", err)
-		return
+		slog.Error("Conntrack dump error", "err", err)
+		return 0
 	}
+	m.markActivity()
 
-	for _, flow := range flows {
-		f := flow // Copy for pointer
+	for i := range flows {
 		// Create a synthetic event
-		ev := conntrack.Event{
-			Type: conntrack.EventUpdate,
-			Flow: &f,
+		m.processEvent(conntrack.Event{Type: conntrack.EventUpdate, Flow: &flows[i]})
+	}
+	return len(flows)
+}
+
+// resync dumps the table to bring lastState up to date and forgets flows
+// whose DESTROY events were lost
+func (m *ConntrackMonitor) resync(c *conntrack.Conn) int {
+	defer m.telemetry.StartPoll(m.ctx, "resync")()
+
+	flows, err := m.dump(c)
+	if err != nil {
+		slog.Error("Conntrack resync dump error", "err", err)
+		return 0
+	}
+	m.markActivity()
+
+	alive := make(map[uint32]struct{}, len(flows))
+	for i := range flows {
+		alive[flows[i].ID] = struct{}{}
+		m.processEvent(conntrack.Event{Type: conntrack.EventUpdate, Flow: &flows[i]})
+	}
+
+	m.mu.Lock()
+	for fid := range m.lastState {
+		if _, ok := alive[fid]; !ok {
+			delete(m.lastState, fid)
+		}
+	}
+	m.mu.Unlock()
+	return len(flows)
+}
+
+// Flush deletes the tracked entries (those matching the mark filter if set)
+// from the kernel table. Connections continue as new entries, though NATed
+// connections may break if their mapping changes.
+func (m *ConntrackMonitor) Flush() error {
+	c, err := conntrack.Dial(conntrackConfig())
+	if err != nil {
+		return accessError("failed to dial conntrack", "CAP_NET_ADMIN", err)
+	}
+	defer c.Close()
+
+	if m.markMask == 0 {
+		err = c.Flush()
+	} else {
+		err = c.FlushFilter(conntrack.NewFilter().Mark(m.markValue).MarkMask(m.markMask))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to flush conntrack: %w", err)
+	}
+
+	// DESTROY events of the flushed entries carry no more deltas
+	m.mu.Lock()
+	clear(m.lastState)
+	m.mu.Unlock()
+	slog.Info("Flushed conntrack table")
+	return nil
+}
+
+// dump reads the conntrack table, filtered in the kernel by the mark filter if set
+func (m *ConntrackMonitor) dump(c *conntrack.Conn) ([]conntrack.Flow, error) {
+	if m.markMask == 0 {
+		return c.Dump(nil)
+	}
+	return c.DumpFilter(conntrack.NewFilter().Mark(m.markValue).MarkMask(m.markMask), nil)
+}
+
+// ReconnectLoop retries restart with backoff until it succeeds or ctx is done
+func ReconnectLoop(ctx context.Context, name string, restart func() error) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := restart()
+		if err == nil {
+			slog.Info("Reconnected", "source", name)
+			return
+		}
+		slog.Warn("Reconnect failed", "source", name, "err", err, "retry_in", backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
 		}
-		m.processEvent(ev)
+		backoff = min(backoff*2, 30*time.Second)
 	}
 }
 
 func (m *ConntrackMonitor) Stop() {
 	m.cancel()
 	m.wg.Wait()
-	close(m.output)
+	m.events.Close()
 }
 
 func (m *ConntrackMonitor) Events() <-chan FlowEvent {
-	return m.output
+	return m.events.Events()
+}
+
+func (m *ConntrackMonitor) BufferStats() (coalesced, dropped uint64) {
+	return m.events.Stats()
 }
 
 func (m *ConntrackMonitor) processEvent(ev conntrack.Event) {
 	// Extract counters
 	fid := ev.Flow.ID
+
+	// Events aren't filtered by the kernel
+	if m.markMask != 0 && ev.Flow.Mark&m.markMask != m.markValue {
+		if ev.Type == conntrack.EventDestroy {
+			m.mu.Lock()
+			delete(m.lastState, fid) // The mark changed after the flow was tracked
+			m.mu.Unlock()
+		}
+		return
+	}
 	curOrig := ev.Flow.CountersOrig.Bytes
 	curReply := ev.Flow.CountersReply.Bytes
 
 	eventType := EventUpdate
-	if ev.Type == conntrack.EventDestroy {
+	switch ev.Type {
+	case conntrack.EventNew:
+		eventType = EventNew
+	case conntrack.EventDestroy:
 		eventType = EventDestroy
 	}
 
+	var tcpState uint8
+	if ev.Flow.ProtoInfo.TCP != nil {
+		tcpState = ev.Flow.ProtoInfo.TCP.State
+	}
+	offload := ev.Flow.Status.Offload()
+
 	// Status Differential Calculation
 	m.mu.Lock()
 	last, exists := m.lastState[fid]
 
 	var deltaOrig, deltaReply uint64
+	stateChanged := false
 	if !exists {
 		// First time seeing this FlowID: Conservative strategy, Delta = 0
 		// This avoids false spikes on program restart
 		m.lastState[fid] = &flowState{
 			LastOriginBytes: curOrig,
 			LastReplyBytes:  curReply,
+			LastTCPState:    tcpState,
+			LastOffload:     offload,
 		}
 		deltaOrig = 0
 		deltaReply = 0
 	} else {
+		if tcpState != last.LastTCPState || offload != last.LastOffload {
+			stateChanged = true
+			last.LastTCPState = tcpState
+			last.LastOffload = offload
+		}
+
 		// Calculate Delta (both Listen and Poll events handled the same way)
 		// Check Origin Counters
 		if curOrig >= last.LastOriginBytes {
@@ -231,30 +563,45 @@ func (m *ConntrackMonitor) processEvent(ev conntrack.Event) {
 	m.mu.Unlock()
 
 	// Only send event if there's actual data change
-	if deltaOrig == 0 && deltaReply == 0 {
+	// NEW/DESTROY and TCP state transitions are always forwarded
+	if deltaOrig == 0 && deltaReply == 0 && eventType == EventUpdate && !stateChanged {
 		return
 	}
 
 	// Prepare event with DELTA values (not cumulative)
 	srcSlice := ev.Flow.TupleOrig.IP.SourceAddress.AsSlice()
 	dstSlice := ev.Flow.TupleOrig.IP.DestinationAddress.AsSlice()
+	dstPort := ev.Flow.TupleOrig.Proto.DestinationPort
+
+	// Port forwards (DNAT): the original destination is the router's WAN
+	// address, the real endpoint is the source of the reply tuple. Hairpin
+	// NAT then shows up as LAN-to-LAN traffic.
+	if ev.Flow.Status.DstNAT() && ev.Flow.TupleReply.IP.SourceAddress.IsValid() {
+		dstSlice = ev.Flow.TupleReply.IP.SourceAddress.AsSlice()
+		dstPort = ev.Flow.TupleReply.Proto.SourcePort
+	}
 
 	e := FlowEvent{
 		SrcIP:       net.IP(srcSlice[:]),
 		DstIP:       net.IP(dstSlice[:]),
 		SrcPort:     ev.Flow.TupleOrig.Proto.SourcePort,
-		DstPort:     ev.Flow.TupleOrig.Proto.DestinationPort,
+		DstPort:     dstPort,
 		Proto:       ev.Flow.TupleOrig.Proto.Protocol,
 		OriginBytes: deltaOrig,  // DELTA, not cumulative
 		ReplyBytes:  deltaReply, // DELTA, not cumulative
+		TCPState:    tcpState,
+		SeenReply:   ev.Flow.Status.SeenReply(),
+		Assured:     ev.Flow.Status.Assured(),
+		Offload:     offload,
+		ICMP:        ev.Flow.TupleOrig.Proto.ICMPv4 || ev.Flow.TupleOrig.Proto.ICMPv6,
+		ICMPType:    ev.Flow.TupleOrig.Proto.ICMPType,
+		ICMPID:      ev.Flow.TupleOrig.Proto.ICMPID,
 		FlowID:      fid,
+		Mark:        ev.Flow.Mark,
+		Zone:        ev.Flow.Zone,
 		Timestamp:   time.Now(),
 		Type:        eventType,
 	}
 
-	select {
-	case m.output <- e:
-	default:
-		// Drop event if channel full to avoid blocking
-	}
+	m.events.Emit(e)
 }
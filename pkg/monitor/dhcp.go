@@ -0,0 +1,151 @@
+package monitor
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LeaseWatcher maps MACs to hostnames from DHCP lease files.
+// Supports dnsmasq (OpenWrt /tmp/dhcp.leases) and ISC dhcpd (dhcpd.leases) formats.
+// Files are re-read when their modification time changes.
+type LeaseWatcher struct {
+	paths []string
+
+	mu        sync.RWMutex
+	macToName map[string]string
+	modTimes  map[string]time.Time
+
+	stop chan struct{}
+}
+
+func NewLeaseWatcher(paths []string) *LeaseWatcher {
+	return &LeaseWatcher{
+		paths:     paths,
+		macToName: make(map[string]string),
+		modTimes:  make(map[string]time.Time),
+		stop:      make(chan struct{}),
+	}
+}
+
+func (lw *LeaseWatcher) Start() {
+	go lw.run()
+}
+
+func (lw *LeaseWatcher) Stop() {
+	close(lw.stop)
+}
+
+func (lw *LeaseWatcher) run() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	lw.Refresh() // Initial load
+
+	for {
+		select {
+		case <-ticker.C:
+			lw.Refresh()
+		case <-lw.stop:
+			return
+		}
+	}
+}
+
+// Refresh re-reads all lease files if any of them changed
+func (lw *LeaseWatcher) Refresh() {
+	changed := false
+	modTimes := make(map[string]time.Time)
+	for _, p := range lw.paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		modTimes[p] = fi.ModTime()
+
+		lw.mu.RLock()
+		last, seen := lw.modTimes[p]
+		lw.mu.RUnlock()
+		if !seen || !last.Equal(fi.ModTime()) {
+			changed = true
+		}
+	}
+
+	lw.mu.RLock()
+	if len(modTimes) != len(lw.modTimes) {
+		changed = true // A file appeared or disappeared
+	}
+	lw.mu.RUnlock()
+
+	if !changed {
+		return
+	}
+
+	newMap := make(map[string]string)
+	for _, p := range lw.paths {
+		if err := parseLeaseFile(p, newMap); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to read lease file", "path", p, "err", err)
+		}
+	}
+
+	lw.mu.Lock()
+	lw.macToName = newMap
+	lw.modTimes = modTimes
+	lw.mu.Unlock()
+}
+
+// GetHostname returns the DHCP hostname for a MAC, or "" if unknown
+func (lw *LeaseWatcher) GetHostname(mac string) string {
+	lw.mu.RLock()
+	defer lw.mu.RUnlock()
+	return lw.macToName[mac]
+}
+
+func parseLeaseFile(path string, m map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// ISC dhcpd block state
+	var blockMAC, blockName string
+	inBlock := false
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "lease ") && strings.HasSuffix(line, "{"):
+			inBlock = true
+			blockMAC, blockName = "", ""
+		case inBlock && line == "}":
+			// Later leases in the file supersede earlier ones
+			if blockMAC != "" && blockName != "" {
+				m[blockMAC] = blockName
+			}
+			inBlock = false
+		case inBlock:
+			fields := strings.Fields(strings.TrimSuffix(line, ";"))
+			if len(fields) >= 3 && fields[0] == "hardware" && fields[1] == "ethernet" {
+				blockMAC = strings.ToLower(fields[2])
+			} else if len(fields) >= 2 && fields[0] == "client-hostname" {
+				blockName = strings.Trim(strings.Join(fields[1:], " "), `"`)
+			}
+		default:
+			// dnsmasq: <expiry> <mac> <ip> <hostname> <client-id>
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[3] != "*" && strings.Count(fields[1], ":") == 5 {
+				m[strings.ToLower(fields[1])] = fields[3]
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,512 @@
+// Package ebpf implements a TrafficSource that counts bytes per 5-tuple in
+// kernel maps from a tc (clsact) program, without conntrack or packet copies.
+package ebpf
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cebpf "github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/kisy/catchmole/pkg/monitor"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	maxKernelFlows = 65536
+	// Kernel entries without traffic for this long are deleted
+	kernelFlowIdle = 2 * time.Minute
+	// tc filter priority, distinct so other filters on the device are left alone
+	filterPriority = 0xca7
+	filterName     = "catchmole"
+)
+
+// Stack layout of the tc program
+const (
+	keyOff = -40 // flowKey, 40 bytes
+	hdrOff = -64 // IP header scratch, 20 bytes
+	valOff = -80 // flowValue, 16 bytes
+)
+
+// flowKey mirrors the kernel map key. IPv4 addresses are stored v4-mapped.
+type flowKey struct {
+	Src, Dst [16]byte
+	SrcPort  [2]byte // Network byte order
+	DstPort  [2]byte
+	Proto    uint8
+	_        [3]byte
+}
+
+type flowValue struct {
+	Bytes   uint64
+	Packets uint64
+}
+
+type kernelFlow struct {
+	bytes    uint64
+	lastSeen time.Time
+}
+
+// Source attaches to the ingress and egress hooks of the LAN interface.
+// Counters are read every poll interval and fed to a monitor.FlowTable,
+// which turns them into the same FlowEvents the other sources emit.
+type Source struct {
+	ifaceName string
+
+	events *monitor.EventBuffer
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Current attachment and poll loop (replaced on Restart)
+	runMu        sync.Mutex
+	runCancel    context.CancelFunc
+	runWg        sync.WaitGroup
+	pollInterval time.Duration
+	counters     *cebpf.Map
+	prog         *cebpf.Program
+	filters      []*netlink.BpfFilter
+	qdisc        netlink.Qdisc // Set when we created clsact
+	ifindex      int
+
+	lastActivity atomic.Int64 // UnixNano
+	reconnects   atomic.Uint64
+	telemetry    monitor.SourceTelemetry
+
+	last  map[flowKey]*kernelFlow // Poll loop only
+	flows *monitor.FlowTable
+}
+
+func NewSource(ifaceName string) *Source {
+	ctx, cancel := context.WithCancel(context.Background())
+	telemetry := monitor.NewSourceTelemetry("ebpf")
+	return &Source{
+		ifaceName: ifaceName,
+		events:    monitor.NewEventBuffer(telemetry),
+		ctx:       ctx,
+		cancel:    cancel,
+		last:      make(map[flowKey]*kernelFlow),
+		flows:     monitor.NewFlowTable(),
+		telemetry: telemetry,
+	}
+}
+
+func (s *Source) Start(pollInterval time.Duration) error {
+	if pollInterval <= 0 {
+		pollInterval = 1 * time.Second // Default
+	}
+	if s.ifaceName == "" {
+		return errors.New("eBPF source requires an interface")
+	}
+
+	// Kernels before 5.11 charge BPF memory to RLIMIT_MEMLOCK
+	if err := rlimit.RemoveMemlock(); err != nil {
+		slog.Warn("Failed to raise memlock limit", "err", err)
+	}
+
+	counters, err := cebpf.NewMap(&cebpf.MapSpec{
+		Name:       "catchmole_flows",
+		Type:       cebpf.LRUHash,
+		KeySize:    uint32(binary.Size(flowKey{})),
+		ValueSize:  uint32(binary.Size(flowValue{})),
+		MaxEntries: maxKernelFlows,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create flow map: %w", err)
+	}
+
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	s.counters = counters
+	s.pollInterval = pollInterval
+	if err := s.run(); err != nil {
+		counters.Close()
+		return err
+	}
+	return nil
+}
+
+// Restart re-attaches the program, e.g. after the interface was recreated.
+// Kernel counters are kept.
+func (s *Source) Restart() error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	s.halt()
+	if err := s.run(); err != nil {
+		return err
+	}
+	s.reconnects.Add(1)
+	return nil
+}
+
+// Reconnects returns how often the program was re-attached
+func (s *Source) Reconnects() uint64 {
+	return s.reconnects.Load()
+}
+
+// SetPollInterval changes how often the kernel counters are read
+func (s *Source) SetPollInterval(pollInterval time.Duration) error {
+	if pollInterval <= 0 {
+		pollInterval = 1 * time.Second // Default
+	}
+
+	s.runMu.Lock()
+	s.pollInterval = pollInterval
+	s.runMu.Unlock()
+	return s.Restart()
+}
+
+// LastActivity returns the time counters last changed
+func (s *Source) LastActivity() time.Time {
+	ns := s.lastActivity.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (s *Source) markActivity() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (s *Source) Stop() {
+	s.cancel()
+	s.wg.Wait()
+
+	s.runMu.Lock()
+	s.halt()
+	if s.counters != nil {
+		s.counters.Close()
+	}
+	s.runMu.Unlock()
+	s.events.Close()
+}
+
+func (s *Source) Events() <-chan monitor.FlowEvent {
+	return s.events.Events()
+}
+
+func (s *Source) BufferStats() (coalesced, dropped uint64) {
+	return s.events.Stats()
+}
+
+// run attaches the program and starts the poll loop. Caller holds runMu.
+func (s *Source) run() error {
+	if err := s.attach(); err != nil {
+		s.detach()
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.runCancel = cancel
+
+	s.runWg.Add(1)
+	s.wg.Go(func() {
+		defer s.runWg.Done()
+		s.pollLoop(ctx, s.pollInterval)
+	})
+	return nil
+}
+
+// halt stops the poll loop and detaches. Caller holds runMu.
+func (s *Source) halt() {
+	if s.runCancel != nil {
+		s.runCancel()
+	}
+	s.runWg.Wait()
+	s.detach()
+}
+
+func (s *Source) pollLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// Recreated interfaces (PPPoE, WireGuard) lose their filters
+			if link, err := monitor.Netlink().LinkByName(s.ifaceName); err != nil || link.Attrs().Index != s.ifindex {
+				slog.Warn("eBPF: interface changed, re-attaching", "iface", s.ifaceName)
+				s.wg.Go(func() { monitor.ReconnectLoop(s.ctx, "eBPF source", s.Restart) })
+				return
+			}
+
+			done := s.telemetry.StartPoll(ctx, "collect")
+			if err := s.collect(now); err != nil {
+				slog.Error("eBPF: failed to read counters", "err", err)
+			}
+			for _, ev := range s.flows.Flush(now) {
+				s.events.Emit(ev)
+			}
+			done()
+		}
+	}
+}
+
+// collect feeds counter deltas since the last poll into the flow table
+func (s *Source) collect(now time.Time) error {
+	var key flowKey
+	var val flowValue
+	var stale []flowKey
+	changed := false
+
+	seen := make(map[flowKey]bool, len(s.last))
+	iter := s.counters.Iterate()
+	for iter.Next(&key, &val) {
+		seen[key] = true
+		prev, ok := s.last[key]
+		if !ok {
+			prev = &kernelFlow{lastSeen: now}
+			s.last[key] = prev
+		}
+
+		delta := val.Bytes - prev.bytes
+		if val.Bytes < prev.bytes {
+			delta = val.Bytes // Entry was evicted and recreated
+		}
+		prev.bytes = val.Bytes
+
+		if delta == 0 {
+			if now.Sub(prev.lastSeen) > kernelFlowIdle {
+				stale = append(stale, key)
+			}
+			continue
+		}
+		prev.lastSeen = now
+		changed = true
+
+		s.flows.Account(net.IP(key.Src[:]), net.IP(key.Dst[:]),
+			binary.BigEndian.Uint16(key.SrcPort[:]), binary.BigEndian.Uint16(key.DstPort[:]),
+			key.Proto, delta)
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	for _, k := range stale {
+		if err := s.counters.Delete(k); err != nil && !errors.Is(err, cebpf.ErrKeyNotExist) {
+			slog.Warn("eBPF: failed to delete idle flow", "err", err)
+		}
+		delete(s.last, k)
+	}
+	// Entries evicted by the LRU map
+	for k := range s.last {
+		if !seen[k] {
+			delete(s.last, k)
+		}
+	}
+
+	if changed {
+		s.markActivity()
+	}
+	return nil
+}
+
+// attach loads the program for the link type and hooks it on ingress and egress
+func (s *Source) attach() error {
+	link, err := monitor.Netlink().LinkByName(s.ifaceName)
+	if err != nil {
+		return fmt.Errorf("failed to find interface %s: %w", s.ifaceName, err)
+	}
+	s.ifindex = link.Attrs().Index
+
+	l3off := int32(0) // PPP, WireGuard and tun carry no link header
+	if link.Attrs().EncapType == "ether" {
+		l3off = 14
+	}
+
+	s.prog, err = cebpf.NewProgram(&cebpf.ProgramSpec{
+		Name:         filterName,
+		Type:         cebpf.SchedCLS,
+		Instructions: buildProgram(s.counters, l3off),
+		License:      "GPL",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load program: %w", err)
+	}
+
+	qdisc := &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: s.ifindex,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+	if err := monitor.Netlink().QdiscAdd(qdisc); err == nil {
+		s.qdisc = qdisc
+	} else if !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("failed to add clsact qdisc: %w", err)
+	}
+
+	for _, parent := range []uint32{netlink.HANDLE_MIN_INGRESS, netlink.HANDLE_MIN_EGRESS} {
+		filter := &netlink.BpfFilter{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: s.ifindex,
+				Parent:    parent,
+				Handle:    1,
+				Protocol:  unix.ETH_P_ALL,
+				Priority:  filterPriority,
+			},
+			Fd:           s.prog.FD(),
+			Name:         filterName,
+			DirectAction: true,
+		}
+		if err := monitor.Netlink().FilterReplace(filter); err != nil {
+			return fmt.Errorf("failed to attach filter: %w", err)
+		}
+		s.filters = append(s.filters, filter)
+	}
+
+	slog.Info("eBPF: attached", "iface", s.ifaceName, "ifindex", s.ifindex)
+	return nil
+}
+
+// detach removes our filters and the clsact qdisc if we created it
+func (s *Source) detach() {
+	for _, f := range s.filters {
+		if err := monitor.Netlink().FilterDel(f); err != nil && !errors.Is(err, unix.ENODEV) {
+			slog.Warn("eBPF: failed to remove filter", "err", err)
+		}
+	}
+	s.filters = nil
+
+	if s.qdisc != nil {
+		if err := monitor.Netlink().QdiscDel(s.qdisc); err != nil && !errors.Is(err, unix.ENODEV) {
+			slog.Warn("eBPF: failed to remove clsact qdisc", "err", err)
+		}
+		s.qdisc = nil
+	}
+	if s.prog != nil {
+		s.prog.Close()
+		s.prog = nil
+	}
+}
+
+// buildProgram assembles the tc classifier. It parses the IPv4/IPv6 header at
+// l3off, fills a flowKey on the stack and adds skb->len to its counters.
+// It always returns TC_ACT_UNSPEC so traffic is never altered.
+func buildProgram(counters *cebpf.Map, l3off int32) asm.Instructions {
+	// skb->protocol is in network byte order
+	ethIPv4 := int32(binary.NativeEndian.Uint16([]byte{0x08, 0x00}))
+	ethIPv6 := int32(binary.NativeEndian.Uint16([]byte{0x86, 0xdd}))
+
+	loadBytes := func(off int32, stackOff int32, n int32) asm.Instructions {
+		return asm.Instructions{
+			asm.Mov.Reg(asm.R1, asm.R6),
+			asm.Mov.Imm(asm.R2, off),
+			asm.Mov.Reg(asm.R3, asm.RFP),
+			asm.Add.Imm(asm.R3, stackOff),
+			asm.Mov.Imm(asm.R4, n),
+			asm.FnSkbLoadBytes.Call(),
+			asm.JNE.Imm(asm.R0, 0, "exit"),
+		}
+	}
+
+	insns := asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1), // skb
+		asm.Mov.Imm(asm.R1, 0),
+		asm.StoreMem(asm.RFP, keyOff, asm.R1, asm.DWord),
+		asm.StoreMem(asm.RFP, keyOff+8, asm.R1, asm.DWord),
+		asm.StoreMem(asm.RFP, keyOff+16, asm.R1, asm.DWord),
+		asm.StoreMem(asm.RFP, keyOff+24, asm.R1, asm.DWord),
+		asm.StoreMem(asm.RFP, keyOff+32, asm.R1, asm.DWord),
+		asm.LoadMem(asm.R2, asm.R6, 16, asm.Word), // skb->protocol
+		asm.JEq.Imm(asm.R2, ethIPv4, "ipv4"),
+		asm.JEq.Imm(asm.R2, ethIPv6, "ipv6"),
+		asm.Ja.Label("exit"),
+	}
+
+	// IPv4: header into scratch, addresses v4-mapped into the key
+	ipv4 := loadBytes(l3off, hdrOff, 20)
+	ipv4[0] = ipv4[0].WithSymbol("ipv4")
+	insns = append(insns, ipv4...)
+	insns = append(insns,
+		asm.StoreImm(asm.RFP, keyOff+10, 0xffff, asm.Half),
+		asm.LoadMem(asm.R1, asm.RFP, hdrOff+12, asm.Word),
+		asm.StoreMem(asm.RFP, keyOff+12, asm.R1, asm.Word),
+		asm.StoreImm(asm.RFP, keyOff+26, 0xffff, asm.Half),
+		asm.LoadMem(asm.R1, asm.RFP, hdrOff+16, asm.Word),
+		asm.StoreMem(asm.RFP, keyOff+28, asm.R1, asm.Word),
+		asm.LoadMem(asm.R1, asm.RFP, hdrOff+9, asm.Byte),
+		asm.StoreMem(asm.RFP, keyOff+36, asm.R1, asm.Byte),
+		// Only the first fragment carries ports
+		asm.LoadMem(asm.R1, asm.RFP, hdrOff+6, asm.Half),
+		asm.HostTo(asm.BE, asm.R1, asm.Half),
+		asm.And.Imm(asm.R1, 0x1fff),
+		asm.JNE.Imm(asm.R1, 0, "count"),
+		asm.LoadMem(asm.R7, asm.RFP, hdrOff, asm.Byte),
+		asm.And.Imm(asm.R7, 0x0f),
+		asm.LSh.Imm(asm.R7, 2),
+		asm.Add.Imm(asm.R7, l3off),
+		asm.Ja.Label("ports"),
+	)
+
+	// IPv6: next header from the fixed header, addresses straight into the key.
+	// Extension headers are accounted without ports.
+	ipv6 := loadBytes(l3off, hdrOff, 8)
+	ipv6[0] = ipv6[0].WithSymbol("ipv6")
+	insns = append(insns, ipv6...)
+	insns = append(insns, loadBytes(l3off+8, keyOff, 32)...)
+	insns = append(insns,
+		asm.LoadMem(asm.R1, asm.RFP, hdrOff+6, asm.Byte),
+		asm.StoreMem(asm.RFP, keyOff+36, asm.R1, asm.Byte),
+		asm.Mov.Imm(asm.R7, l3off+40),
+	)
+
+	insns = append(insns,
+		// TCP, UDP and SCTP ports, copied into the key in network order.
+		// On a short packet the helper zeroes them.
+		asm.LoadMem(asm.R1, asm.RFP, keyOff+36, asm.Byte).WithSymbol("ports"),
+		asm.JEq.Imm(asm.R1, unix.IPPROTO_TCP, "l4"),
+		asm.JEq.Imm(asm.R1, unix.IPPROTO_UDP, "l4"),
+		asm.JEq.Imm(asm.R1, unix.IPPROTO_SCTP, "l4"),
+		asm.Ja.Label("count"),
+		asm.Mov.Reg(asm.R1, asm.R6).WithSymbol("l4"),
+		asm.Mov.Reg(asm.R2, asm.R7),
+		asm.Mov.Reg(asm.R3, asm.RFP),
+		asm.Add.Imm(asm.R3, keyOff+32),
+		asm.Mov.Imm(asm.R4, 4),
+		asm.FnSkbLoadBytes.Call(),
+
+		// Bytes from the IP header on, like conntrack counts
+		asm.LoadMem(asm.R9, asm.R6, 0, asm.Word).WithSymbol("count"), // skb->len
+		asm.Add.Imm(asm.R9, -l3off),
+		asm.LoadMapPtr(asm.R1, counters.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, keyOff),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "insert"),
+		asm.AddAtomic.Mem(asm.R0, asm.R9, asm.DWord, 0),
+		asm.Mov.Imm(asm.R1, 1),
+		asm.AddAtomic.Mem(asm.R0, asm.R1, asm.DWord, 8),
+		asm.Ja.Label("exit"),
+
+		asm.StoreMem(asm.RFP, valOff, asm.R9, asm.DWord).WithSymbol("insert"),
+		asm.Mov.Imm(asm.R1, 1),
+		asm.StoreMem(asm.RFP, valOff+8, asm.R1, asm.DWord),
+		asm.LoadMapPtr(asm.R1, counters.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, keyOff),
+		asm.Mov.Reg(asm.R3, asm.RFP),
+		asm.Add.Imm(asm.R3, valOff),
+		asm.Mov.Imm(asm.R4, int32(cebpf.UpdateNoExist)),
+		asm.FnMapUpdateElem.Call(),
+
+		asm.Mov.Imm(asm.R0, -1).WithSymbol("exit"), // TC_ACT_UNSPEC
+		asm.Return(),
+	)
+	return insns
+}
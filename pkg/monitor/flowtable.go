@@ -0,0 +1,130 @@
+package monitor
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Flows without traffic for this long are reported destroyed
+	flowTableIdle = 2 * time.Minute
+	// Upper bound of tracked flows, new flows are dropped beyond it
+	maxFlowTableFlows = 65536
+)
+
+type flowKey struct {
+	src, dst         [16]byte
+	srcPort, dstPort uint16
+	proto            uint8
+}
+
+func (k flowKey) reverse() flowKey {
+	return flowKey{src: k.dst, dst: k.src, srcPort: k.dstPort, dstPort: k.srcPort, proto: k.proto}
+}
+
+type tableFlow struct {
+	id          uint32
+	key         flowKey // Original direction (first traffic seen)
+	ipv4        bool
+	originBytes uint64
+	replyBytes  uint64
+	lastOrigin  uint64
+	lastReply   uint64
+	lastSeen    time.Time
+	announced   bool
+}
+
+// FlowTable builds bidirectional flows from directional byte counts for
+// sources without conntrack. The first direction seen becomes the original
+// direction; Flush turns the accumulated counts into FlowEvents.
+type FlowTable struct {
+	mu     sync.Mutex
+	flows  map[flowKey]*tableFlow
+	nextID uint32
+}
+
+func NewFlowTable() *FlowTable {
+	return &FlowTable{flows: make(map[flowKey]*tableFlow)}
+}
+
+// Account adds bytes sent from src to dst
+func (t *FlowTable) Account(src, dst net.IP, srcPort, dstPort uint16, proto uint8, bytes uint64) {
+	key := flowKey{srcPort: srcPort, dstPort: dstPort, proto: proto}
+	copy(key.src[:], src.To16())
+	copy(key.dst[:], dst.To16())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if f, ok := t.flows[key]; ok {
+		f.originBytes += bytes
+		f.lastSeen = time.Now()
+		return
+	}
+	if f, ok := t.flows[key.reverse()]; ok {
+		f.replyBytes += bytes
+		f.lastSeen = time.Now()
+		return
+	}
+	if len(t.flows) >= maxFlowTableFlows {
+		return
+	}
+
+	t.nextID++
+	t.flows[key] = &tableFlow{
+		id:          t.nextID,
+		key:         key,
+		ipv4:        src.To4() != nil,
+		originBytes: bytes,
+		lastSeen:    time.Now(),
+	}
+}
+
+// Flush returns deltas for active flows and destroys idle ones
+func (t *FlowTable) Flush(now time.Time) []FlowEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	events := make([]FlowEvent, 0, len(t.flows))
+	for key, f := range t.flows {
+		evType := EventUpdate
+		switch {
+		case now.Sub(f.lastSeen) > flowTableIdle:
+			evType = EventDestroy
+			delete(t.flows, key)
+		case !f.announced:
+			evType = EventNew
+			f.announced = true
+		case f.originBytes == f.lastOrigin && f.replyBytes == f.lastReply:
+			continue
+		}
+
+		events = append(events, f.event(evType, now))
+		f.lastOrigin = f.originBytes
+		f.lastReply = f.replyBytes
+	}
+	return events
+}
+
+func (f *tableFlow) event(evType EventType, now time.Time) FlowEvent {
+	src, dst := net.IP(f.key.src[:]), net.IP(f.key.dst[:])
+	if f.ipv4 {
+		src, dst = src.To4(), dst.To4()
+	}
+
+	return FlowEvent{
+		SrcIP:       src,
+		DstIP:       dst,
+		SrcPort:     f.key.srcPort,
+		DstPort:     f.key.dstPort,
+		Proto:       f.key.proto,
+		OriginBytes: f.originBytes - f.lastOrigin, // DELTA, not cumulative
+		ReplyBytes:  f.replyBytes - f.lastReply,
+		SeenReply:   f.replyBytes > 0,
+		Assured:     f.replyBytes > 0, // No handshake tracking, a reply is as good as it gets
+		FlowID:      f.id,
+		Timestamp:   now,
+		Type:        evType,
+	}
+}
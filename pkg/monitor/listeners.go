@@ -0,0 +1,162 @@
+package monitor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Listener is a local port accepting TCP connections or UDP datagrams
+type Listener struct {
+	Proto uint8
+	Port  uint16
+}
+
+// Socket states in /proc/net/{tcp,udp}
+const (
+	tcpListen   = 0x0a
+	udpUnconned = 0x07
+)
+
+// Listeners returns the listening sockets of the monitored namespace and the
+// name of the owning process. Kernel sockets (e.g. WireGuard) have no process
+// and map to "". Sockets bound to loopback are skipped, LAN clients can't
+// reach them.
+func Listeners() (map[Listener]string, error) {
+	inodes := make(map[uint64]Listener)
+	var kernel []Listener
+	err := InNetNS(func() error {
+		// thread-self: the namespace of this (locked) thread, not the process
+		for _, t := range []struct {
+			file  string
+			proto uint8
+			state uint64
+		}{
+			{"tcp", unix.IPPROTO_TCP, tcpListen},
+			{"tcp6", unix.IPPROTO_TCP, tcpListen},
+			{"udp", unix.IPPROTO_UDP, udpUnconned},
+			{"udp6", unix.IPPROTO_UDP, udpUnconned},
+		} {
+			if err := readSockets("/proc/thread-self/net/"+t.file, t.proto, t.state, inodes, &kernel); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[Listener]string, len(inodes)+len(kernel))
+	for _, l := range kernel {
+		names[l] = ""
+	}
+	for _, l := range inodes {
+		names[l] = ""
+	}
+	if len(inodes) == 0 {
+		return names, nil
+	}
+
+	// Socket inodes to processes, via the fd links of every process
+	procs, _ := filepath.Glob("/proc/[0-9]*/fd")
+	for _, fdDir := range procs {
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue // Gone, or not ours to read
+		}
+		var comm string
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(link[len("socket:["):], "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			l, ok := inodes[inode]
+			if !ok || names[l] != "" {
+				continue
+			}
+			if comm == "" {
+				b, err := os.ReadFile(filepath.Join(filepath.Dir(fdDir), "comm"))
+				if err != nil {
+					break
+				}
+				comm = strings.TrimSpace(string(b))
+			}
+			names[l] = comm
+		}
+	}
+	return names, nil
+}
+
+// readSockets adds the sockets in state from a /proc/net table to inodes, or
+// to kernel if no process owns them
+func readSockets(path string, proto uint8, state uint64, inodes map[uint64]Listener, kernel *[]Listener) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Scan() // Header
+	for sc.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		if st, err := strconv.ParseUint(fields[3], 16, 8); err != nil || st != state {
+			continue
+		}
+		addr, port, ok := strings.Cut(fields[1], ":")
+		if !ok || isLoopbackHex(addr) {
+			continue
+		}
+		p, err := strconv.ParseUint(port, 16, 16)
+		if err != nil {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			continue
+		}
+		l := Listener{Proto: proto, Port: uint16(p)}
+		if inode == 0 {
+			*kernel = append(*kernel, l)
+		} else {
+			inodes[inode] = l
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// isLoopbackHex reports whether a /proc/net address is loopback. Addresses
+// are printed as 32-bit words in host byte order.
+func isLoopbackHex(s string) bool {
+	if len(s)%8 != 0 {
+		return false
+	}
+	ip := make(net.IP, 0, len(s)/2)
+	for i := 0; i < len(s); i += 8 {
+		w, err := strconv.ParseUint(s[i:i+8], 16, 32)
+		if err != nil {
+			return false
+		}
+		ip = binary.NativeEndian.AppendUint32(ip, uint32(w))
+	}
+	return ip.IsLoopback()
+}
@@ -0,0 +1,302 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	nameTTL       = 6 * time.Hour    // Answered names are asked again after this
+	nameRetry     = 15 * time.Minute // Silent devices are asked again after this
+	nameTimeout   = 2 * time.Second  // Per query
+	maxNameProbes = 4                // Concurrent probes
+
+	mdnsPort    = 5353
+	netbiosPort = 137
+	llmnrPort   = 5355
+)
+
+type nameEntry struct {
+	Name    string
+	Expires time.Time
+}
+
+// NameResolver names clients that have no configured or DHCP name by asking
+// them directly: a reverse mDNS query (phones, printers, TVs, Macs), a
+// NetBIOS node status request and a reverse LLMNR query (Windows, Samba).
+// Queries are unicast to the client's addresses from the neighbor table.
+type NameResolver struct {
+	nw *NeighborWatcher
+
+	mu      sync.RWMutex
+	names   map[string]nameEntry // Key: MAC
+	pending map[string]struct{}
+	sem     chan struct{}
+}
+
+func NewNameResolver(nw *NeighborWatcher) *NameResolver {
+	return &NameResolver{
+		nw:      nw,
+		names:   make(map[string]nameEntry),
+		pending: make(map[string]struct{}),
+		sem:     make(chan struct{}, maxNameProbes),
+	}
+}
+
+// Lookup returns the name a client answered with, or "" if not (yet) known.
+// Unknown or expired MACs are probed in the background.
+func (r *NameResolver) Lookup(mac string) string {
+	r.mu.RLock()
+	e, ok := r.names[mac]
+	r.mu.RUnlock()
+
+	if !ok || time.Now().After(e.Expires) {
+		r.probe(mac)
+	}
+	return e.Name // Stale name is better than none
+}
+
+func (r *NameResolver) probe(mac string) {
+	ips := r.nw.IPs(mac)
+	if len(ips) == 0 {
+		return // Offline, ask once it is back
+	}
+
+	r.mu.Lock()
+	if _, busy := r.pending[mac]; busy {
+		r.mu.Unlock()
+		return
+	}
+	select {
+	case r.sem <- struct{}{}:
+	default:
+		// Too many probes in flight, try again on the next lookup
+		r.mu.Unlock()
+		return
+	}
+	r.pending[mac] = struct{}{}
+	r.mu.Unlock()
+
+	go func() {
+		defer func() {
+			<-r.sem
+			r.mu.Lock()
+			delete(r.pending, mac)
+			r.mu.Unlock()
+		}()
+
+		name, method := queryName(ips)
+		ttl := nameTTL
+		if name == "" {
+			ttl = nameRetry
+		} else {
+			slog.Debug("Resolved client name", "mac", mac, "name", name, "via", method)
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if name == "" {
+			name = r.names[mac].Name
+		}
+		r.names[mac] = nameEntry{Name: name, Expires: time.Now().Add(ttl)}
+	}()
+}
+
+// queryName tries the addresses (IPv4 first) and methods in turn until one answers
+func queryName(ips []string) (name, method string) {
+	var v4, v6 []net.IP
+	for _, s := range ips {
+		ip := net.ParseIP(s)
+		switch {
+		case ip == nil:
+		case ip.To4() != nil:
+			v4 = append(v4, ip)
+		case !ip.IsLinkLocalUnicast(): // Link-local ones need a zone the neighbor table lacks
+			v6 = append(v6, ip)
+		}
+	}
+
+	for _, ip := range append(v4, v6...) {
+		if name := reverseQuery(ip, mdnsPort); name != "" {
+			return name, "mdns"
+		}
+		if ip.To4() != nil {
+			if name := nodeStatus(ip); name != "" {
+				return name, "netbios"
+			}
+		}
+		if name := reverseQuery(ip, llmnrPort); name != "" {
+			return name, "llmnr"
+		}
+	}
+	return "", ""
+}
+
+// reverseQuery sends a PTR query for ip to the host itself. mDNS responders
+// answer queries from a port other than 5353 with a unicast reply.
+func reverseQuery(ip net.IP, port int) string {
+	qname, err := dnsmessage.NewName(reverseName(ip))
+	if err != nil {
+		return ""
+	}
+	id := uint16(rand.Uint32())
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id},
+		Questions: []dnsmessage.Question{{Name: qname, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}
+	req, err := msg.Pack()
+	if err != nil {
+		return ""
+	}
+	resp, err := exchange(ip, port, req)
+	if err != nil {
+		return ""
+	}
+
+	var p dnsmessage.Parser
+	hdr, err := p.Start(resp)
+	if err != nil || !hdr.Response || hdr.ID != id || hdr.RCode != dnsmessage.RCodeSuccess {
+		return ""
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return ""
+	}
+	for {
+		h, err := p.AnswerHeader()
+		if err != nil {
+			return ""
+		}
+		if h.Type != dnsmessage.TypePTR {
+			if err := p.SkipAnswer(); err != nil {
+				return ""
+			}
+			continue
+		}
+		ptr, err := p.PTRResource()
+		if err != nil {
+			return ""
+		}
+		name := strings.TrimSuffix(ptr.PTR.String(), ".")
+		if i := len(name) - len(".local"); i > 0 && strings.EqualFold(name[i:], ".local") {
+			name = name[:i]
+		}
+		return cleanName(name)
+	}
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name of ip
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	const hex = "0123456789abcdef"
+	var b strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(hex[ip[i]&0x0f])
+		b.WriteByte('.')
+		b.WriteByte(hex[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String()
+}
+
+// nodeStatus sends a NetBIOS node status request (RFC 1002 4.2.17) and
+// returns the first unique workstation name
+func nodeStatus(ip net.IP) string {
+	req := make([]byte, 0, 50)
+	req = binary.BigEndian.AppendUint16(req, uint16(rand.Uint32())) // ID
+	req = append(req, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0)                 // Flags, one question
+	// Wildcard name "*" padded with NULs, first-level encoded
+	req = append(req, 32, 'C', 'K')
+	for range 15 {
+		req = append(req, 'A', 'A')
+	}
+	req = append(req, 0, 0, 0x21, 0, 1) // Type NBSTAT, class IN
+
+	resp, err := exchange(ip, netbiosPort, req)
+	if err != nil || len(resp) < 12 || resp[0] != req[0] || resp[1] != req[1] {
+		return ""
+	}
+
+	// Skip the resource record name
+	off := 12
+	for off < len(resp) {
+		l := int(resp[off])
+		if l == 0 {
+			off++
+			break
+		}
+		if l&0xc0 == 0xc0 {
+			off += 2
+			break
+		}
+		off += 1 + l
+	}
+	off += 10 // Type, class, TTL, data length
+	if off >= len(resp) {
+		return ""
+	}
+	count := int(resp[off])
+	off++
+
+	for range count {
+		if off+18 > len(resp) {
+			break
+		}
+		entry := resp[off : off+18]
+		off += 18
+		suffix := entry[15]
+		group := entry[16]&0x80 != 0
+		if suffix == 0x00 && !group {
+			return cleanName(strings.TrimRight(string(entry[:15]), " \x00"))
+		}
+	}
+	return ""
+}
+
+// exchange sends a UDP request in the monitored namespace and waits for the reply
+func exchange(ip net.IP, port int, req []byte) ([]byte, error) {
+	var conn *net.UDPConn
+	err := InNetNS(func() (err error) {
+		conn, err = net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: port})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(nameTimeout))
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 9000) // mDNS allows jumbo-sized replies
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// cleanName keeps a name only if it is printable and of sensible length
+func cleanName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 63 {
+		return ""
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return ""
+		}
+	}
+	return name
+}
@@ -1,23 +1,44 @@
 package monitor
 
 import (
+	"log/slog"
+	"net"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
+// IPv6 bindings outlive their neighbor entry this long. Privacy addresses
+// rotate daily but keep carrying long-lived connections after NDP forgets them.
+const ipv6BindingRetention = 24 * time.Hour
+
+// A binding refreshed within this window is considered present (Refresh runs every 5s)
+const neighborPresence = 30 * time.Second
+
+type binding struct {
+	mac  string
+	seen time.Time
+	v6   bool
+}
+
 // NeighborWatcher watches for IP to MAC mappings
-// For simplicity, we just parse /proc/net/arp periodically
+// For simplicity, we just list the neighbor tables periodically
 type NeighborWatcher struct {
-	ipToMac map[string]string
+	ipToMac map[string]binding
+	macs    map[string]struct{} // MACs of all known bindings
+	vlans   map[string]uint16   // MAC -> VLAN ID, from VLAN interfaces and the bridge fdb
 	mu      sync.RWMutex
 	stop    chan struct{}
 }
 
 func NewNeighborWatcher() *NeighborWatcher {
 	return &NeighborWatcher{
-		ipToMac: make(map[string]string),
+		ipToMac: make(map[string]binding),
+		macs:    make(map[string]struct{}),
+		vlans:   make(map[string]uint16),
 		stop:    make(chan struct{}),
 	}
 }
@@ -46,56 +67,165 @@ func (nw *NeighborWatcher) run() {
 	}
 }
 
+// Subscribe listens for netlink neighbor updates so new bindings (e.g. a freshly
+// generated IPv6 privacy address announced via NDP) are learned immediately
+// instead of on the next Refresh.
+func (nw *NeighborWatcher) Subscribe() error {
+	ch := make(chan netlink.NeighUpdate, 256)
+	if err := neighSubscribe(ch, nw.stop); err != nil {
+		return err
+	}
+
+	go func() {
+		for u := range ch {
+			if u.Type != unix.RTM_NEWNEIGH || !validNeigh(u.Neigh) {
+				continue
+			}
+			nw.mu.Lock()
+			nw.bind(u.IP, u.HardwareAddr.String(), time.Now())
+			nw.mu.Unlock()
+		}
+		slog.Warn("Neighbor subscription closed")
+	}()
+	return nil
+}
+
 func (nw *NeighborWatcher) Refresh() {
-	newMap := make(map[string]string)
+	var neighs []netlink.Neigh
 
 	// IPv4
-	neighs4, err := netlink.NeighList(0, netlink.FAMILY_V4)
+	neighs4, err := Netlink().NeighList(0, netlink.FAMILY_V4)
 	if err == nil {
-		nw.processNeighs(neighs4, newMap)
+		neighs = append(neighs, neighs4...)
 	}
 
 	// IPv6
-	neighs6, err := netlink.NeighList(0, netlink.FAMILY_V6)
+	neighs6, err := Netlink().NeighList(0, netlink.FAMILY_V6)
 	if err == nil {
-		nw.processNeighs(neighs6, newMap)
+		neighs = append(neighs, neighs6...)
+	}
+
+	// VLAN of the neighbor's interface, overridden by VLAN-aware bridge fdb entries
+	links := linkVLANs()
+	vlans := make(map[string]uint16)
+	for _, n := range neighs {
+		if id, ok := links[n.LinkIndex]; ok && validNeigh(n) {
+			vlans[n.HardwareAddr.String()] = id
+		}
+	}
+	for mac, id := range fdbVLANs() {
+		vlans[mac] = id
 	}
 
+	now := time.Now()
+
 	nw.mu.Lock()
-	nw.ipToMac = newMap
-	nw.mu.Unlock()
-}
+	defer nw.mu.Unlock()
 
-func (nw *NeighborWatcher) processNeighs(neighs []netlink.Neigh, m map[string]string) {
 	for _, n := range neighs {
-		// Filter out invalid states
-		// NUD_INCOMPLETE = 0x01
-		// NUD_REACHABLE  = 0x02
-		// NUD_STALE      = 0x04
-		// NUD_DELAY      = 0x08
-		// NUD_PROBE      = 0x10
-		// NUD_FAILED     = 0x20
-		// NUD_NOARP      = 0x40
-		// NUD_PERMANENT  = 0x80
-
-		// We generally want cleaning valid entries.
-		// Reachable, Stale, Permanent, Delay, Probe are good candidates.
-		// Incomplete and Failed should be ignored.
-		if n.State&(netlink.NUD_INCOMPLETE|netlink.NUD_FAILED) != 0 {
+		if validNeigh(n) {
+			nw.bind(n.IP, n.HardwareAddr.String(), now)
+		}
+	}
+	nw.vlans = vlans
+
+	// IPv4 mirrors the neighbor table; IPv6 bindings are sticky
+	nw.macs = make(map[string]struct{})
+	for ip, b := range nw.ipToMac {
+		if (!b.v6 && b.seen.Before(now)) || now.Sub(b.seen) > ipv6BindingRetention {
+			delete(nw.ipToMac, ip)
 			continue
 		}
+		nw.macs[b.mac] = struct{}{}
+	}
+}
 
-		if len(n.HardwareAddr) == 6 {
-			mac := n.HardwareAddr.String()
-			if mac != "00:00:00:00:00:00" {
-				m[n.IP.String()] = mac
-			}
+// bind records an IP to MAC binding. Caller holds mu.
+func (nw *NeighborWatcher) bind(ip net.IP, mac string, seen time.Time) {
+	nw.ipToMac[ip.String()] = binding{mac: mac, seen: seen, v6: ip.To4() == nil}
+	nw.macs[mac] = struct{}{}
+}
+
+func validNeigh(n netlink.Neigh) bool {
+	// Filter out invalid states
+	// NUD_INCOMPLETE = 0x01
+	// NUD_REACHABLE  = 0x02
+	// NUD_STALE      = 0x04
+	// NUD_DELAY      = 0x08
+	// NUD_PROBE      = 0x10
+	// NUD_FAILED     = 0x20
+	// NUD_NOARP      = 0x40
+	// NUD_PERMANENT  = 0x80
+
+	// We generally want cleaning valid entries.
+	// Reachable, Stale, Permanent, Delay, Probe are good candidates.
+	// Incomplete and Failed should be ignored.
+	if n.State&(netlink.NUD_INCOMPLETE|netlink.NUD_FAILED) != 0 {
+		return false
+	}
+
+	return len(n.HardwareAddr) == 6 && n.HardwareAddr.String() != "00:00:00:00:00:00"
+}
+
+// PresentMACs returns the MACs currently in the neighbor table. Sticky IPv6
+// bindings only count while their entry is still being refreshed.
+func (nw *NeighborWatcher) PresentMACs() map[string]bool {
+	nw.mu.RLock()
+	defer nw.mu.RUnlock()
+
+	cutoff := time.Now().Add(-neighborPresence)
+	present := make(map[string]bool, len(nw.macs))
+	for _, b := range nw.ipToMac {
+		if b.seen.After(cutoff) {
+			present[b.mac] = true
+		}
+	}
+	return present
+}
+
+// IPs returns the addresses currently bound to a MAC
+func (nw *NeighborWatcher) IPs(mac string) []string {
+	nw.mu.RLock()
+	defer nw.mu.RUnlock()
+
+	var ips []string
+	for ip, b := range nw.ipToMac {
+		if b.mac == mac {
+			ips = append(ips, ip)
 		}
 	}
+	slices.Sort(ips)
+	return ips
 }
 
 func (nw *NeighborWatcher) GetMAC(ip string) string {
 	nw.mu.RLock()
 	defer nw.mu.RUnlock()
-	return nw.ipToMac[ip]
+
+	if b, ok := nw.ipToMac[ip]; ok {
+		return b.mac
+	}
+
+	// SLAAC (EUI-64) addresses embed the MAC, attribute them to a known device
+	// even before NDP has seen this particular address
+	if mac := eui64MAC(ip); mac != "" {
+		if _, known := nw.macs[mac]; known {
+			return mac
+		}
+	}
+	return ""
+}
+
+// eui64MAC extracts the MAC from an EUI-64 based IPv6 interface identifier
+func eui64MAC(ipStr string) string {
+	ip := net.ParseIP(ipStr)
+	if ip == nil || ip.To4() != nil {
+		return ""
+	}
+	iid := ip[8:]
+	if iid[3] != 0xff || iid[4] != 0xfe {
+		return ""
+	}
+	mac := net.HardwareAddr{iid[0] ^ 0x02, iid[1], iid[2], iid[5], iid[6], iid[7]}
+	return mac.String()
 }
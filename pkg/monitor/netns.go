@@ -0,0 +1,102 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+
+	mdnetlink "github.com/mdlayher/netlink"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// Network namespace that is monitored, the process' own unless SetNetNS was
+// called (e.g. to watch the host from a container)
+var (
+	nsHandle = &netlink.Handle{} // Zero handle: sockets in the current namespace
+	nsFD     = netns.None()
+)
+
+// SetNetNS monitors the network namespace at path, e.g. the host's
+// /proc/1/ns/net mounted into a container. Call before starting any watcher.
+func SetNetNS(path string) error {
+	ns, err := netns.GetFromPath(path)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %s: %w", path, err)
+	}
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		ns.Close()
+		return accessError("failed to enter netns "+path, "CAP_SYS_ADMIN", err)
+	}
+	nsHandle, nsFD = h, ns
+	return nil
+}
+
+// Netlink returns the handle for netlink requests in the monitored namespace
+func Netlink() *netlink.Handle {
+	return nsHandle
+}
+
+// InNetNS runs fn on a thread switched to the monitored namespace, for sockets
+// and /proc/sys files that follow the calling thread's namespace
+func InNetNS(fn func() error) error {
+	if !nsFD.IsOpen() {
+		return fn()
+	}
+
+	runtime.LockOSThread()
+	orig, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer orig.Close()
+	if err := netns.Set(nsFD); err != nil {
+		runtime.UnlockOSThread()
+		return accessError("failed to enter netns", "CAP_SYS_ADMIN", err)
+	}
+	defer func() {
+		// A thread left in the other namespace stays locked and exits with the goroutine
+		if netns.Set(orig) == nil {
+			runtime.UnlockOSThread()
+		}
+	}()
+	return fn()
+}
+
+// conntrackConfig dials conntrack in the monitored namespace
+func conntrackConfig() *mdnetlink.Config {
+	if !nsFD.IsOpen() {
+		return nil
+	}
+	return &mdnetlink.Config{NetNS: int(nsFD)}
+}
+
+// neighSubscribe subscribes to neighbor updates in the monitored namespace
+func neighSubscribe(ch chan<- netlink.NeighUpdate, done <-chan struct{}) error {
+	if !nsFD.IsOpen() {
+		return netlink.NeighSubscribe(ch, done)
+	}
+	return netlink.NeighSubscribeAt(nsFD, ch, done)
+}
+
+// InContainer reports whether catchmole runs inside a Docker or Podman container
+func InContainer() bool {
+	for _, f := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(f); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// accessError explains permission errors with the capability that is missing
+func accessError(msg, capability string, err error) error {
+	if !errors.Is(err, unix.EPERM) && !errors.Is(err, unix.EACCES) {
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	return fmt.Errorf("%s: %w (%s)", msg, err, capabilityHint(capability))
+}
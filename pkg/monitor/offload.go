@@ -0,0 +1,119 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Flow offload modes, what to do about flowtables without counters
+const (
+	OffloadWarn    = "warn"    // Report under-counted traffic
+	OffloadCounter = "counter" // Enable the flowtable counters
+	OffloadCapture = "capture" // Count traffic from packet capture instead of conntrack
+)
+
+// Flowtable is an nftables flowtable. Connections it offloads bypass the
+// forward path, their conntrack counters only keep up if it has counters.
+type Flowtable struct {
+	Family   string
+	Table    string
+	Name     string
+	Hardware bool // flags offload, the NIC or switch forwards the packets
+	Counter  bool
+}
+
+// Flowtables lists the flowtables of all tables via nft, in the monitored namespace
+func Flowtables() ([]Flowtable, error) {
+	var out []byte
+	err := InNetNS(func() (err error) {
+		out, err = NetAdminCommand(context.Background(), "nft", "list", "flowtables").Output()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("nft list flowtables: %w", err)
+	}
+	return parseFlowtables(out), nil
+}
+
+// parseFlowtables reads the output of "nft list flowtables", e.g.
+//
+//	table inet fw4 {
+//		flowtable ft {
+//			hook ingress priority filter
+//			devices = { lan1, wan }
+//			flags offload
+//			counter
+//		}
+//	}
+func parseFlowtables(out []byte) []Flowtable {
+	var list []Flowtable
+	var family, table string
+	var cur *Flowtable
+
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(sc.Text()), ";"))
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "table" && len(fields) >= 3:
+			family, table = fields[1], fields[2]
+		case fields[0] == "flowtable" && len(fields) >= 2:
+			list = append(list, Flowtable{Family: family, Table: table, Name: fields[1]})
+			cur = &list[len(list)-1]
+		case cur == nil:
+		case fields[0] == "}":
+			cur = nil
+		case fields[0] == "flags":
+			for _, f := range fields[1:] {
+				if strings.TrimSuffix(f, ",") == "offload" {
+					cur.Hardware = true
+				}
+			}
+		case fields[0] == "counter":
+			cur.Counter = true
+		}
+	}
+	return list
+}
+
+// EnableFlowtableCounter adds the counter flag to a flowtable, so the kernel
+// keeps updating conntrack counters of the connections it offloads
+func EnableFlowtableCounter(ft Flowtable) error {
+	return InNetNS(func() error { return enableFlowtableCounter(ft) })
+}
+
+func enableFlowtableCounter(ft Flowtable) error {
+	out, err := NetAdminCommand(context.Background(), "nft", "list", "flowtable", ft.Family, ft.Table, ft.Name).Output()
+	if err != nil {
+		return fmt.Errorf("nft list flowtable: %w", err)
+	}
+
+	// Re-declare the flowtable as listed plus the flag, which updates it in place
+	var script strings.Builder
+	done := false
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		if !done && strings.TrimSpace(line) == "}" {
+			script.WriteString("\t\tcounter\n")
+			done = true
+		}
+		script.WriteString(line)
+		script.WriteByte('\n')
+	}
+	if !done {
+		return fmt.Errorf("unexpected nft output for flowtable %s", ft.Name)
+	}
+
+	cmd := NetAdminCommand(context.Background(), "nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script.String())
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("nft: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
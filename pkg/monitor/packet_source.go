@@ -0,0 +1,314 @@
+package monitor
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Headers are all we need, the IP length field carries the packet size
+	packetSnapLen = 128
+)
+
+// PacketSource is a TrafficSource for kernels without conntrack accounting.
+// It captures packet headers on an AF_PACKET socket and builds its own flow
+// table; the first packet of a flow defines the original direction.
+// With sampleRate N only every Nth packet is counted, scaled by N.
+type PacketSource struct {
+	ifaceName  string
+	sampleRate uint64
+
+	events *EventBuffer
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Current capture loop (replaced on Restart)
+	runMu        sync.Mutex
+	runCancel    context.CancelFunc
+	runWg        sync.WaitGroup
+	pollInterval time.Duration
+
+	lastActivity atomic.Int64 // UnixNano
+	reconnects   atomic.Uint64
+	packets      uint64 // Capture loop only
+	telemetry    SourceTelemetry
+
+	flows *FlowTable
+}
+
+func NewPacketSource(ifaceName string, sampleRate int) *PacketSource {
+	if sampleRate <= 0 {
+		sampleRate = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	telemetry := NewSourceTelemetry("packet")
+	return &PacketSource{
+		ifaceName:  ifaceName,
+		sampleRate: uint64(sampleRate),
+		events:     NewEventBuffer(telemetry),
+		ctx:        ctx,
+		cancel:     cancel,
+		flows:      NewFlowTable(),
+		telemetry:  telemetry,
+	}
+}
+
+func (p *PacketSource) Start(pollInterval time.Duration) error {
+	if pollInterval <= 0 {
+		pollInterval = 1 * time.Second // Default
+	}
+
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+	p.pollInterval = pollInterval
+	return p.run()
+}
+
+// Restart reopens the capture socket. The flow table is kept.
+func (p *PacketSource) Restart() error {
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+
+	if p.runCancel != nil {
+		p.runCancel()
+	}
+	p.runWg.Wait()
+	if err := p.run(); err != nil {
+		return err
+	}
+	p.reconnects.Add(1)
+	return nil
+}
+
+// Reconnects returns how often the capture socket was re-opened
+func (p *PacketSource) Reconnects() uint64 {
+	return p.reconnects.Load()
+}
+
+// SetPollInterval changes how often deltas are emitted
+func (p *PacketSource) SetPollInterval(pollInterval time.Duration) error {
+	if pollInterval <= 0 {
+		pollInterval = 1 * time.Second // Default
+	}
+
+	p.runMu.Lock()
+	p.pollInterval = pollInterval
+	p.runMu.Unlock()
+	return p.Restart()
+}
+
+// LastActivity returns the time of the last captured packet
+func (p *PacketSource) LastActivity() time.Time {
+	ns := p.lastActivity.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (p *PacketSource) markActivity() {
+	p.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (p *PacketSource) Stop() {
+	p.cancel()
+	p.wg.Wait()
+	p.events.Close()
+}
+
+func (p *PacketSource) Events() <-chan FlowEvent {
+	return p.events.Events()
+}
+
+func (p *PacketSource) BufferStats() (coalesced, dropped uint64) {
+	return p.events.Stats()
+}
+
+// run opens the capture socket and starts the capture and flush loops. Caller holds runMu.
+func (p *PacketSource) run() error {
+	var fd int
+	err := InNetNS(func() (err error) {
+		fd, err = openCaptureSocket(p.ifaceName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(p.ctx)
+	p.runCancel = cancel
+
+	p.runWg.Add(2)
+	p.wg.Go(func() {
+		defer p.runWg.Done()
+		defer unix.Close(fd)
+		p.captureLoop(ctx, fd)
+	})
+	p.wg.Go(func() {
+		defer p.runWg.Done()
+
+		ticker := time.NewTicker(p.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				done := p.telemetry.StartPoll(ctx, "flush")
+				for _, ev := range p.flows.Flush(time.Now()) {
+					p.events.Emit(ev)
+				}
+				done()
+			}
+		}
+	})
+
+	return nil
+}
+
+func (p *PacketSource) captureLoop(ctx context.Context, fd int) {
+	buf := make([]byte, packetSnapLen)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue // Read timeout, check stop
+			}
+			slog.Error("Packet capture error, reconnecting", "err", err)
+			p.wg.Go(func() { ReconnectLoop(p.ctx, "Packet capture", p.Restart) })
+			return
+		}
+		p.markActivity()
+
+		p.packets++
+		if p.packets%p.sampleRate != 0 {
+			continue
+		}
+		p.handlePacket(buf[:n])
+	}
+}
+
+// handlePacket parses the IP and transport headers and accounts the packet to its flow
+func (p *PacketSource) handlePacket(b []byte) {
+	var src, dst net.IP
+	var srcPort, dstPort uint16
+	var proto uint8
+	var size uint64
+	var l4 []byte
+
+	if len(b) < 1 {
+		return
+	}
+	switch b[0] >> 4 {
+	case 4:
+		ihl := int(b[0]&0x0f) * 4
+		if ihl < 20 || len(b) < ihl {
+			return
+		}
+		size = uint64(binary.BigEndian.Uint16(b[2:4]))
+		proto = b[9]
+		src, dst = net.IP(b[12:16]), net.IP(b[16:20])
+		// Only the first fragment carries ports
+		if binary.BigEndian.Uint16(b[6:8])&0x1fff == 0 {
+			l4 = b[ihl:]
+		}
+	case 6:
+		if len(b) < 40 {
+			return
+		}
+		size = uint64(binary.BigEndian.Uint16(b[4:6])) + 40
+		proto = b[6] // Extension headers are accounted without ports
+		src, dst = net.IP(b[8:24]), net.IP(b[24:40])
+		l4 = b[40:]
+	default:
+		return
+	}
+
+	switch proto {
+	case 6, 17, 132: // TCP, UDP, SCTP
+		if len(l4) >= 4 {
+			srcPort = binary.BigEndian.Uint16(l4[0:2])
+			dstPort = binary.BigEndian.Uint16(l4[2:4])
+		}
+	}
+
+	p.flows.Account(src, dst, srcPort, dstPort, proto, size*p.sampleRate)
+}
+
+// openCaptureSocket opens an AF_PACKET socket truncating packets to their headers
+func openCaptureSocket(ifaceName string) (int, error) {
+	proto := Htons(unix.ETH_P_ALL)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(proto))
+	if err != nil {
+		return -1, accessError("failed to open packet socket", "CAP_NET_RAW", err)
+	}
+
+	ifindex := 0 // All interfaces
+	if ifaceName != "" {
+		iface, err := net.InterfaceByName(ifaceName)
+		if err != nil {
+			unix.Close(fd)
+			return -1, err
+		}
+		ifindex = iface.Index
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: ifindex}); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("failed to bind packet socket: %w", err)
+	}
+
+	// Kernel-side truncation, only headers are copied to userspace
+	filter := []unix.SockFilter{{Code: 0x06, K: packetSnapLen}} // ret #snaplen
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("failed to attach filter: %w", err)
+	}
+
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, 4<<20); err != nil {
+		slog.Warn("Failed to set capture buffer", "err", err)
+	}
+
+	// Periodic wakeup so Stop is noticed
+	tv := unix.Timeval{Sec: 1}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	return fd, nil
+}
+
+// ConntrackAccounting reports whether the kernel exposes conntrack byte counters
+func ConntrackAccounting() bool {
+	var b []byte
+	err := InNetNS(func() (err error) {
+		b, err = os.ReadFile("/proc/sys/net/netfilter/nf_conntrack_acct")
+		return err
+	})
+	return err == nil && strings.TrimSpace(string(b)) == "1"
+}
+
+// Htons converts v to network byte order, as AF_PACKET sockets take the protocol
+func Htons(v uint16) uint16 {
+	return binary.NativeEndian.Uint16(binary.BigEndian.AppendUint16(nil, v))
+}
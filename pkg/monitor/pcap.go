@@ -0,0 +1,160 @@
+package monitor
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	pcapSnapLen = 262144 // GRO can hand up packets much larger than the MTU
+	linkTypeRaw = 101    // Packets start with the IPv4 or IPv6 header
+)
+
+// PcapStats summarizes a capture
+type PcapStats struct {
+	Packets   uint64
+	Bytes     int64 // Written to the pcap, headers included
+	Truncated bool  // Stopped at maxBytes
+}
+
+// CapturePcap writes the IP packets from or to any of ips on the interface to
+// w in pcap format, until ctx is done or about maxBytes were written. Nothing
+// is written if the capture socket cannot be opened.
+func CapturePcap(ctx context.Context, w io.Writer, ifaceName string, ips []string, maxBytes int64) (PcapStats, error) {
+	var st PcapStats
+	match := make(map[netip.Addr]bool, len(ips))
+	for _, s := range ips {
+		if ip, err := netip.ParseAddr(s); err == nil {
+			match[ip.Unmap()] = true
+		}
+	}
+
+	var fd int
+	err := InNetNS(func() (err error) {
+		fd, err = openPcapSocket(ifaceName)
+		return err
+	})
+	if err != nil {
+		return st, err
+	}
+	defer unix.Close(fd)
+
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4) // Microsecond timestamps
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], linkTypeRaw)
+	if _, err := w.Write(hdr); err != nil {
+		return st, err
+	}
+	st.Bytes = int64(len(hdr))
+
+	// Push packets out while the capture runs, e.g. to an HTTP response
+	flush := func() {}
+	if f, ok := w.(interface{ Flush() }); ok {
+		flush = f.Flush
+	}
+	lastFlush := time.Now()
+
+	buf := make([]byte, pcapSnapLen)
+	rec := make([]byte, 16)
+	for ctx.Err() == nil {
+		// MSG_TRUNC returns the original length of oversized packets
+		n, _, err := unix.Recvfrom(fd, buf, unix.MSG_TRUNC)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue // Read timeout, check ctx
+			}
+			return st, fmt.Errorf("capture: %w", err)
+		}
+		pkt := buf[:min(n, len(buf))]
+		if !matchPacket(pkt, match) {
+			continue
+		}
+
+		if st.Bytes+int64(len(rec)+len(pkt)) > maxBytes {
+			st.Truncated = true
+			break
+		}
+		now := time.Now()
+		binary.LittleEndian.PutUint32(rec[0:], uint32(now.Unix()))
+		binary.LittleEndian.PutUint32(rec[4:], uint32(now.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(rec[8:], uint32(len(pkt)))
+		binary.LittleEndian.PutUint32(rec[12:], uint32(n))
+		if _, err := w.Write(rec); err != nil {
+			return st, err
+		}
+		if _, err := w.Write(pkt); err != nil {
+			return st, err
+		}
+		st.Packets++
+		st.Bytes += int64(len(rec) + len(pkt))
+
+		if now.Sub(lastFlush) >= time.Second {
+			flush()
+			lastFlush = now
+		}
+	}
+	flush()
+	return st, nil
+}
+
+// matchPacket reports whether the source or destination of an IP packet is in match
+func matchPacket(b []byte, match map[netip.Addr]bool) bool {
+	if len(b) < 1 {
+		return false
+	}
+	var src, dst netip.Addr
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 {
+			return false
+		}
+		src, dst = netip.AddrFrom4([4]byte(b[12:16])), netip.AddrFrom4([4]byte(b[16:20]))
+	case 6:
+		if len(b) < 40 {
+			return false
+		}
+		src, dst = netip.AddrFrom16([16]byte(b[8:24])), netip.AddrFrom16([16]byte(b[24:40]))
+	default:
+		return false
+	}
+	return match[src] || match[dst]
+}
+
+// openPcapSocket opens an AF_PACKET socket delivering whole IP packets of the interface
+func openPcapSocket(ifaceName string) (int, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return -1, err
+	}
+
+	proto := Htons(unix.ETH_P_ALL)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		return -1, accessError("failed to open packet socket", "CAP_NET_RAW", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: iface.Index}); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("failed to bind packet socket: %w", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, 4<<20); err != nil {
+		slog.Warn("Failed to set capture buffer", "err", err)
+	}
+	// Periodic wakeup so the end of the capture is noticed
+	tv := unix.Timeval{Usec: 200000}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
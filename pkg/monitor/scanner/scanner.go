@@ -0,0 +1,336 @@
+// Package scanner actively probes the LAN so quiet devices show up in the
+// neighbor table. Every host of the IPv4 subnets gets a UDP datagram, which
+// makes the kernel resolve it with ARP; IPv6 hosts answer a ping to all-nodes
+// and are then probed the same way. Probing also ages out departed devices,
+// whose stale entries would otherwise linger for minutes.
+package scanner
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/pkg/monitor"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultInterval = 60 * time.Second
+	defaultMaxHosts = 1024
+
+	probeGap = 2 * time.Millisecond // Between probes, keeps a /22 sweep around 2s
+	// Wait after probing for the kernel to finish resolution. Stale entries
+	// are confirmed after delay_first_probe_time (5s) and unicast probes.
+	settle = 8 * time.Second
+)
+
+// Config is the [scan] TOML table
+type Config struct {
+	Enabled    bool     `toml:"enabled"`
+	Interval   int      `toml:"interval"`   // Seconds between sweeps, default 60
+	Interfaces []string `toml:"interfaces"` // Default: the monitored interface
+	MaxHosts   int      `toml:"max_hosts"`  // Larger IPv4 subnets are skipped, default 1024
+}
+
+// Equal reports whether two configs are the same
+func (c Config) Equal(o Config) bool {
+	return c.Enabled == o.Enabled && c.Interval == o.Interval &&
+		slices.Equal(c.Interfaces, o.Interfaces) && c.MaxHosts == o.MaxHosts
+}
+
+// Scanner sweeps the LAN periodically and remembers the devices that answered
+type Scanner struct {
+	defaultIface string
+
+	mu       sync.RWMutex
+	cfg      Config
+	devices  map[string]bool // MACs reachable after the last sweep
+	lastScan time.Time
+	warned   map[string]bool // Oversized subnets already logged
+
+	kick chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates a scanner, iface is used when the config names no interfaces
+func New(cfg Config, iface string) *Scanner {
+	s := &Scanner{
+		defaultIface: iface,
+		devices:      make(map[string]bool),
+		warned:       make(map[string]bool),
+		kick:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+	s.SetConfig(cfg)
+	return s
+}
+
+// SetConfig applies a new config from the next sweep on, which starts right away
+func (s *Scanner) SetConfig(cfg Config) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = int(defaultInterval / time.Second)
+	}
+	if cfg.MaxHosts <= 0 {
+		cfg.MaxHosts = defaultMaxHosts
+	}
+	if len(cfg.Interfaces) == 0 && s.defaultIface != "" {
+		cfg.Interfaces = []string{s.defaultIface}
+	}
+
+	s.mu.Lock()
+	s.cfg = cfg
+	if !cfg.Enabled {
+		s.devices = make(map[string]bool)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Devices returns the MACs that answered the last sweep
+func (s *Scanner) Devices() map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.devices)
+}
+
+// LastScan returns when the last sweep finished, zero before the first
+func (s *Scanner) LastScan() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastScan
+}
+
+func (s *Scanner) Start() {
+	s.wg.Go(s.run)
+}
+
+func (s *Scanner) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *Scanner) run() {
+	for {
+		s.mu.RLock()
+		cfg := s.cfg
+		s.mu.RUnlock()
+
+		if cfg.Enabled {
+			s.sweep(cfg)
+		}
+
+		timer := time.NewTimer(time.Duration(cfg.Interval) * time.Second)
+		select {
+		case <-timer.C:
+		case <-s.kick:
+			timer.Stop()
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// sweep probes every interface, waits for the kernel to resolve the targets
+// and collects the reachable neighbors
+func (s *Scanner) sweep(cfg Config) {
+	start := time.Now()
+	links := make([]netlink.Link, 0, len(cfg.Interfaces))
+	probed := 0
+	for _, name := range cfg.Interfaces {
+		link, err := monitor.Netlink().LinkByName(name)
+		if err != nil {
+			slog.Warn("Scan: interface not found", "iface", name, "err", err)
+			continue
+		}
+		links = append(links, link)
+
+		n, err := s.probe(link, cfg.MaxHosts)
+		if err != nil {
+			slog.Warn("Scan: probing failed", "iface", name, "err", err)
+		}
+		probed += n
+	}
+	if len(links) == 0 {
+		return
+	}
+
+	select {
+	case <-time.After(settle):
+	case <-s.stop:
+		return
+	}
+
+	devices := make(map[string]bool)
+	for _, link := range links {
+		neighs, err := monitor.Netlink().NeighList(link.Attrs().Index, netlink.FAMILY_ALL)
+		if err != nil {
+			slog.Warn("Scan: failed to list neighbors", "iface", link.Attrs().Name, "err", err)
+			continue
+		}
+		for _, n := range neighs {
+			if n.State&netlink.NUD_REACHABLE != 0 && len(n.HardwareAddr) == 6 {
+				devices[n.HardwareAddr.String()] = true
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.devices = devices
+	s.lastScan = time.Now()
+	s.mu.Unlock()
+	slog.Debug("Scan: sweep done", "probed", probed, "devices", len(devices), "took", time.Since(start).Round(time.Millisecond))
+}
+
+// probe sends one datagram to every IPv4 host and known IPv6 neighbor of the link
+func (s *Scanner) probe(link netlink.Link, maxHosts int) (int, error) {
+	name := link.Attrs().Name
+	addrs, err := monitor.Netlink().AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return 0, err
+	}
+	var targets []net.IP
+	for _, addr := range addrs {
+		hosts, ok := subnetHosts(addr.IPNet, maxHosts)
+		if !ok {
+			s.warnOnce(addr.IPNet.String(), "Scan: subnet larger than max_hosts, skipped", "iface", name, "subnet", addr.IPNet, "max_hosts", maxHosts)
+			continue
+		}
+		targets = append(targets, hosts...)
+	}
+
+	err = monitor.InNetNS(func() error {
+		// All IPv6 hosts answer a ping to ff02::1, replying makes them solicit us
+		// and the kernel creates their neighbor entries
+		if err := pingAllNodes(name, link.Attrs().Index); err != nil {
+			slog.Debug("Scan: IPv6 all-nodes ping failed", "iface", name, "err", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case <-time.After(time.Second):
+	case <-s.stop:
+		return 0, nil
+	}
+	if neighs, err := monitor.Netlink().NeighList(link.Attrs().Index, netlink.FAMILY_V6); err == nil {
+		for _, n := range neighs {
+			if n.State&(netlink.NUD_FAILED|netlink.NUD_NOARP|netlink.NUD_PERMANENT) == 0 && !n.IP.IsMulticast() {
+				targets = append(targets, n.IP)
+			}
+		}
+	}
+
+	sent := 0
+	err = monitor.InNetNS(func() error {
+		fd4, err := probeSocket(unix.AF_INET, name)
+		if err != nil {
+			return err
+		}
+		defer unix.Close(fd4)
+		fd6, err := probeSocket(unix.AF_INET6, name)
+		if err != nil {
+			return err
+		}
+		defer unix.Close(fd6)
+
+		for _, ip := range targets {
+			select {
+			case <-s.stop:
+				return nil
+			default:
+			}
+			// Port 9 (discard); errors such as EHOSTUNREACH for absent hosts are expected
+			if ip4 := ip.To4(); ip4 != nil {
+				unix.Sendto(fd4, []byte{0}, unix.MSG_DONTWAIT, &unix.SockaddrInet4{Port: 9, Addr: [4]byte(ip4)})
+			} else {
+				sa := &unix.SockaddrInet6{Port: 9, Addr: [16]byte(ip.To16())}
+				if ip.IsLinkLocalUnicast() {
+					sa.ZoneId = uint32(link.Attrs().Index)
+				}
+				unix.Sendto(fd6, []byte{0}, unix.MSG_DONTWAIT, sa)
+			}
+			sent++
+			time.Sleep(probeGap)
+		}
+		return nil
+	})
+	return sent, err
+}
+
+// probeSocket opens a UDP socket bound to the interface
+func probeSocket(domain int, iface string) (int, error) {
+	fd, err := unix.Socket(domain, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	if err := unix.BindToDevice(fd, iface); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("bind to %s: %w", iface, err)
+	}
+	return fd, nil
+}
+
+// pingAllNodes sends an ICMPv6 echo request to ff02::1 on the link
+func pingAllNodes(iface string, index int) error {
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMPV6)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	if err := unix.BindToDevice(fd, iface); err != nil {
+		return err
+	}
+	// Type 128 (echo request), the kernel fills in the checksum
+	echo := []byte{128, 0, 0, 0, 0x63, 0x6d, 0, 1}
+	sa := &unix.SockaddrInet6{Addr: [16]byte(net.IPv6linklocalallnodes), ZoneId: uint32(index)}
+	return unix.Sendto(fd, echo, 0, sa)
+}
+
+// subnetHosts lists the host addresses of an IPv4 subnet, without the
+// network and broadcast addresses; false if there are more than max
+func subnetHosts(ipnet *net.IPNet, max int) ([]net.IP, bool) {
+	ip4 := ipnet.IP.To4()
+	ones, bits := ipnet.Mask.Size()
+	if ip4 == nil || bits != 32 {
+		return nil, true
+	}
+	size := uint64(1) << (bits - ones)
+	if size > uint64(max)+2 {
+		return nil, false
+	}
+	base := uint32(ip4[0])<<24 | uint32(ip4[1])<<16 | uint32(ip4[2])<<8 | uint32(ip4[3])
+	base &= ^uint32(size - 1)
+
+	var hosts []net.IP
+	for i := uint32(1); uint64(i)+1 < size; i++ {
+		v := base + i
+		host := net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).To4()
+		if !host.Equal(ip4) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, true
+}
+
+func (s *Scanner) warnOnce(key, msg string, args ...any) {
+	s.mu.Lock()
+	seen := s.warned[key]
+	s.warned[key] = true
+	s.mu.Unlock()
+	if !seen {
+		slog.Warn(msg, args...)
+	}
+}
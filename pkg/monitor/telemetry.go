@@ -0,0 +1,51 @@
+package monitor
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Internal observability, exported when telemetry is enabled
+var (
+	meter  = otel.Meter("github.com/kisy/catchmole/pkg/monitor")
+	tracer = otel.Tracer("github.com/kisy/catchmole/pkg/monitor")
+
+	eventsEmitted, _ = meter.Int64Counter("catchmole.monitor.events",
+		metric.WithDescription("Flow events handed to the aggregator"))
+	eventsDropped, _ = meter.Int64Counter("catchmole.monitor.events.dropped",
+		metric.WithDescription("Flow events dropped because the aggregator fell behind"))
+	eventsCoalesced, _ = meter.Int64Counter("catchmole.monitor.events.coalesced",
+		metric.WithDescription("Flow updates merged into a queued update of the same flow"))
+	pollDuration, _ = meter.Float64Histogram("catchmole.monitor.poll.duration",
+		metric.WithDescription("Duration of conntrack dumps and flow table flushes"), metric.WithUnit("s"))
+)
+
+// SourceTelemetry records the health of one traffic source
+type SourceTelemetry struct {
+	attrs metric.MeasurementOption
+	name  string
+}
+
+func NewSourceTelemetry(source string) SourceTelemetry {
+	return SourceTelemetry{
+		attrs: metric.WithAttributeSet(attribute.NewSet(attribute.String("source", source))),
+		name:  source,
+	}
+}
+
+// StartPoll traces one poll operation (e.g. "dump") and records its duration
+// when the returned function is called
+func (t SourceTelemetry) StartPoll(ctx context.Context, op string) func() {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, t.name+"."+op, trace.WithAttributes(attribute.String("source", t.name)))
+	return func() {
+		pollDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+			attribute.String("source", t.name), attribute.String("op", op)))
+		span.End()
+	}
+}
@@ -0,0 +1,45 @@
+package monitor
+
+import (
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// linkVLANs maps the index of each VLAN interface (e.g. br-lan.10) to its VLAN ID
+func linkVLANs() map[int]uint16 {
+	links, err := Netlink().LinkList()
+	if err != nil {
+		return nil
+	}
+	vlans := make(map[int]uint16)
+	for _, l := range links {
+		if v, ok := l.(*netlink.Vlan); ok {
+			vlans[v.Attrs().Index] = uint16(v.VlanId)
+		}
+	}
+	return vlans
+}
+
+// fdbVLANs maps MACs learned by VLAN-aware bridges to their VLAN ID
+func fdbVLANs() map[string]uint16 {
+	entries, err := Netlink().NeighList(0, unix.AF_BRIDGE)
+	if err != nil {
+		return nil
+	}
+	vlans := make(map[string]uint16)
+	for _, n := range entries {
+		// Permanent entries are the bridge's own ports
+		if n.Vlan == 0 || n.State&netlink.NUD_PERMANENT != 0 || len(n.HardwareAddr) != 6 {
+			continue
+		}
+		vlans[n.HardwareAddr.String()] = uint16(n.Vlan)
+	}
+	return vlans
+}
+
+// VLAN returns the VLAN a MAC was last seen on, 0 if untagged or unknown
+func (nw *NeighborWatcher) VLAN(mac string) uint16 {
+	nw.mu.RLock()
+	defer nw.mu.RUnlock()
+	return nw.vlans[mac]
+}
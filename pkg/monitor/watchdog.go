@@ -0,0 +1,138 @@
+package monitor
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Watchdog detects a stalled traffic pipeline: no events or successful dumps
+// for longer than the timeout while the interface is still moving traffic.
+// On a stall it marks itself unhealthy and restarts the monitor loop, at
+// most once per timeout, until an event or dump arrives after the stall.
+type Watchdog struct {
+	mon       TrafficSource
+	ifaceName string
+	timeout   time.Duration
+
+	mu          sync.RWMutex
+	started     time.Time // Grace period before the first activity
+	stalled     bool
+	stalledAt   time.Time
+	restartedAt time.Time
+	reason      string
+	lastRxBytes uint64
+	lastTxBytes uint64
+
+	stop chan struct{}
+}
+
+func NewWatchdog(mon TrafficSource, ifaceName string, timeout time.Duration) *Watchdog {
+	if timeout <= 0 {
+		timeout = 30 * time.Second // Default
+	}
+	return &Watchdog{
+		mon:       mon,
+		ifaceName: ifaceName,
+		timeout:   timeout,
+		stop:      make(chan struct{}),
+	}
+}
+
+func (w *Watchdog) Start() {
+	go w.run()
+}
+
+func (w *Watchdog) Stop() {
+	close(w.stop)
+}
+
+// Healthy reports whether the pipeline is live, with a reason when it is not
+func (w *Watchdog) Healthy() (bool, string) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return !w.stalled, w.reason
+}
+
+func (w *Watchdog) run() {
+	// Check several times per timeout window
+	ticker := time.NewTicker(w.timeout / 3)
+	defer ticker.Stop()
+
+	w.ifaceTraffic() // Seed interface counters
+	w.mu.Lock()
+	w.started = time.Now()
+	w.mu.Unlock()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *Watchdog) check() {
+	last := w.mon.LastActivity()
+	trafficSeen := w.ifaceTraffic()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Only a real event or dump ends a stall, restarts don't count
+	if w.stalled && last.After(w.stalledAt) {
+		slog.Info("Watchdog: pipeline recovered", "stalled_for", time.Since(w.stalledAt).Round(time.Second))
+		w.stalled = false
+		w.reason = ""
+	}
+
+	idle := time.Since(last)
+	if last.Before(w.started) {
+		idle = time.Since(w.started)
+	}
+	if idle <= w.timeout {
+		return
+	}
+
+	if !trafficSeen {
+		// Nothing is moving on the interface, silence is expected
+		return
+	}
+
+	if !w.stalled {
+		w.stalled = true
+		w.stalledAt = time.Now()
+	}
+	w.reason = fmt.Sprintf("no conntrack events or dumps for %s while interface has traffic", idle.Round(time.Second))
+	if time.Since(w.restartedAt) < w.timeout {
+		return // Give the last restart time to deliver
+	}
+	slog.Warn("Watchdog: pipeline stalled, restarting monitor", "reason", w.reason)
+
+	w.restartedAt = time.Now()
+	if err := w.mon.Restart(); err != nil {
+		slog.Error("Watchdog: monitor restart failed", "err", err)
+	}
+}
+
+// ifaceTraffic reports whether the interface counters moved since the last call.
+// Without a configured interface traffic is assumed.
+func (w *Watchdog) ifaceTraffic() bool {
+	if w.ifaceName == "" {
+		return true
+	}
+
+	link, err := Netlink().LinkByName(w.ifaceName)
+	if err != nil || link.Attrs().Statistics == nil {
+		return true
+	}
+
+	st := link.Attrs().Statistics
+	moved := st.RxBytes != w.lastRxBytes || st.TxBytes != w.lastTxBytes
+	w.lastRxBytes = st.RxBytes
+	w.lastTxBytes = st.TxBytes
+	return moved
+}
@@ -0,0 +1,145 @@
+package report
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kisy/catchmole/pkg/alert"
+	"github.com/kisy/catchmole/pkg/units"
+)
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes":  units.Bytes,
+	"change": formatChange,
+	"date":   func(t time.Time) string { return t.Format("2006-01-02") },
+	"lastDay": func(t time.Time) string {
+		return t.AddDate(0, 0, -1).Format("2006-01-02")
+	},
+}).Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<h2>catchmole {{.Period}} report, {{date .Start}} – {{lastDay .End}}</h2>
+<p>Total: ↓ {{bytes .TotalDownload}} ↑ {{bytes .TotalUpload}} ({{change .Change}} vs. previous period)</p>
+<table cellpadding="4" style="border-collapse: collapse">
+<tr style="text-align: left"><th>Device</th><th>Download</th><th>Upload</th><th>Change</th><th>Top destinations</th></tr>
+{{range .Clients}}<tr style="border-top: 1px solid #ddd; vertical-align: top">
+<td>{{.Name}}<br><small>{{.MAC}}</small></td>
+<td>{{bytes .TotalDownload}}</td>
+<td>{{bytes .TotalUpload}}</td>
+<td>{{change .Change}}</td>
+<td>{{range .Destinations}}{{.Destination}} ({{bytes .TotalDownload}} / {{bytes .TotalUpload}})<br>{{end}}</td>
+</tr>
+{{end}}</table>
+</body></html>
+`))
+
+// RenderHTML renders the report as an HTML page
+func RenderHTML(rep Report) ([]byte, error) {
+	var b bytes.Buffer
+	if err := htmlTemplate.Execute(&b, rep); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// deliver sends the report to every configured channel
+func deliver(cfg Config, rep Report) []error {
+	var errs []error
+	if cfg.WebhookURL != "" {
+		if err := sendWebhook(cfg.WebhookURL, rep); err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %w", err))
+		}
+	}
+	if cfg.SMTPHost != "" && len(cfg.SMTPTo) > 0 {
+		if err := sendMail(cfg, rep); err != nil {
+			errs = append(errs, fmt.Errorf("smtp: %w", err))
+		}
+	}
+	return errs
+}
+
+func sendWebhook(url string, rep Report) error {
+	body, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	return alert.Post(url, "application/json", body, nil)
+}
+
+func sendMail(cfg Config, rep Report) error {
+	page, err := RenderHTML(rep)
+	if err != nil {
+		return err
+	}
+	from := cfg.SMTPFrom
+	if from == "" {
+		from = cfg.SMTPUser
+	}
+	subject := fmt.Sprintf("catchmole %s report %s", rep.Period, rep.Start.Format("2006-01-02"))
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n", from, strings.Join(cfg.SMTPTo, ", "),
+		mime.QEncoding.Encode("utf-8", subject), time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.Write(bytes.ReplaceAll(page, []byte("\n"), []byte("\r\n")))
+
+	addr := net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort))
+	var auth smtp.Auth
+	if cfg.SMTPUser != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	if cfg.SMTPPort != 465 {
+		return smtp.SendMail(addr, auth, from, cfg.SMTPTo, msg.Bytes()) // STARTTLS when offered
+	}
+
+	// Implicit TLS (SMTPS)
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr, &tls.Config{ServerName: cfg.SMTPHost})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, to := range cfg.SMTPTo {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func formatChange(v *float64) string {
+	if v == nil {
+		return "–"
+	}
+	return fmt.Sprintf("%+.0f%%", *v)
+}
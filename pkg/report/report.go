@@ -0,0 +1,417 @@
+// Package report sends periodic usage summaries per client: totals, a
+// comparison with the previous period and the top destinations, by email
+// (HTML) or webhook (JSON).
+package report
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/stats"
+)
+
+const (
+	sampleInterval  = 30 * time.Second
+	defaultTop      = 5
+	maxDestinations = 500 // Per client and period, the rest is counted as "other"
+)
+
+// Config is the [report] TOML table
+type Config struct {
+	Schedule string `toml:"schedule"` // Cron expression in the report timezone, e.g. "0 20 * * 0" (Sunday 20:00), empty = off
+	Period   string `toml:"period"`   // "weekly" (default) or "monthly"
+	Top      int    `toml:"top"`      // Destinations per client, default 5
+	Timezone string `toml:"timezone"` // Schedule and period boundaries, default the usage timezone
+
+	WebhookURL string `toml:"webhook_url"` // Receives the report as JSON
+
+	SMTPHost     string   `toml:"smtp_host"`
+	SMTPPort     int      `toml:"smtp_port"` // Default 587 (STARTTLS), 465 uses implicit TLS
+	SMTPUser     string   `toml:"smtp_user"`
+	SMTPPassword string   `toml:"smtp_password"`
+	SMTPFrom     string   `toml:"smtp_from"`
+	SMTPTo       []string `toml:"smtp_to"`
+}
+
+// Equal reports whether two configs are the same
+func (c Config) Equal(o Config) bool {
+	return c.Schedule == o.Schedule && c.Period == o.Period && c.Top == o.Top && c.Timezone == o.Timezone && c.WebhookURL == o.WebhookURL &&
+		c.SMTPHost == o.SMTPHost && c.SMTPPort == o.SMTPPort && c.SMTPUser == o.SMTPUser &&
+		c.SMTPPassword == o.SMTPPassword && c.SMTPFrom == o.SMTPFrom && slices.Equal(c.SMTPTo, o.SMTPTo)
+}
+
+// Report is the usage summary of one period
+type Report struct {
+	Period           string              `json:"period"`
+	Start            time.Time           `json:"start"`
+	End              time.Time           `json:"end"`
+	TotalDownload    uint64              `json:"total_download"`
+	TotalUpload      uint64              `json:"total_upload"`
+	PreviousDownload uint64              `json:"previous_download"`
+	PreviousUpload   uint64              `json:"previous_upload"`
+	Change           *float64            `json:"change_percent,omitempty"` // Total vs. the previous period, absent without previous usage
+	Clients          []ClientReport      `json:"clients"`
+	Usage            []model.UsagePeriod `json:"usage,omitempty"` // Breakdown of a custom range
+}
+
+// ClientReport is the usage of one client in the period
+type ClientReport struct {
+	MAC              string        `json:"mac"`
+	Name             string        `json:"name"`
+	TotalDownload    uint64        `json:"total_download"`
+	TotalUpload      uint64        `json:"total_upload"`
+	PreviousDownload uint64        `json:"previous_download"`
+	PreviousUpload   uint64        `json:"previous_upload"`
+	Change           *float64      `json:"change_percent,omitempty"`
+	Destinations     []Destination `json:"destinations"`
+}
+
+// Destination is traffic to one remote host, by hostname when known
+type Destination struct {
+	Destination   string `json:"destination"`
+	TotalDownload uint64 `json:"total_download"`
+	TotalUpload   uint64 `json:"total_upload"`
+}
+
+// destinations accumulates traffic per client and remote host within one period
+type destinations struct {
+	start   time.Time
+	clients map[string]map[string]*Destination
+}
+
+// Reporter samples destinations and sends reports on schedule. Totals come
+// from the daily usage rollup; destinations are counted while catchmole runs,
+// so after a restart they cover only the rest of the period.
+type Reporter struct {
+	agg   *stats.Aggregator
+	usage *stats.UsageRollup
+
+	mu        sync.Mutex
+	cfg       Config
+	loc       *time.Location // Of the schedule and periods
+	schedule  *schedule
+	current   *destinations
+	previous  *destinations        // The period before, for reports sent after it ended
+	lastFlows map[string][2]uint64 // Flow -> download, upload at the last sample
+	lastRun   time.Time            // Minute of the last scheduled run
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewReporter(agg *stats.Aggregator, usage *stats.UsageRollup, cfg Config) (*Reporter, error) {
+	r := &Reporter{
+		agg:       agg,
+		usage:     usage,
+		lastFlows: make(map[string][2]uint64),
+		stop:      make(chan struct{}),
+	}
+	if err := r.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetConfig replaces the schedule and delivery settings
+func (r *Reporter) SetConfig(cfg Config) error {
+	if cfg.Period == "" {
+		cfg.Period = "weekly" // Default
+	}
+	if cfg.Period != "weekly" && cfg.Period != "monthly" {
+		return fmt.Errorf("invalid report period %q (want weekly or monthly)", cfg.Period)
+	}
+	if cfg.Top <= 0 {
+		cfg.Top = defaultTop
+	}
+	if cfg.SMTPPort <= 0 {
+		cfg.SMTPPort = 587 // Default
+	}
+	loc := r.usage.Location()
+	if cfg.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return fmt.Errorf("invalid report timezone %q: %w", cfg.Timezone, err)
+		}
+	}
+	var sched *schedule
+	if cfg.Schedule != "" {
+		var err error
+		if sched, err = parseSchedule(cfg.Schedule); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cfg.Period != r.cfg.Period || loc != r.loc {
+		r.current, r.previous = nil, nil
+	}
+	r.cfg = cfg
+	r.loc = loc
+	r.schedule = sched
+	return nil
+}
+
+func (r *Reporter) Start() {
+	r.wg.Go(func() {
+		ticker := time.NewTicker(sampleInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				now := time.Now()
+				r.sample(now)
+				r.runScheduled(now)
+			case <-r.stop:
+				return
+			}
+		}
+	})
+}
+
+func (r *Reporter) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+// runScheduled sends the report if the schedule fires this minute
+func (r *Reporter) runScheduled(now time.Time) {
+	r.mu.Lock()
+	now = now.In(r.loc)
+	minute := now.Truncate(time.Minute)
+	due := r.schedule != nil && r.schedule.match(now) && !minute.Equal(r.lastRun)
+	if due {
+		r.lastRun = minute
+	}
+	cfg := r.cfg
+	r.mu.Unlock()
+	if !due {
+		return
+	}
+
+	rep := r.Generate(now)
+	errs := deliver(cfg, rep)
+	for _, err := range errs {
+		slog.Error("Report delivery failed", "err", err)
+	}
+	if len(errs) > 0 {
+		return
+	}
+	slog.Info("Usage report sent", "period", rep.Period, "start", rep.Start, "clients", len(rep.Clients))
+}
+
+// sample adds the traffic since the last sample to the destinations of the current period
+func (r *Reporter) sample(now time.Time) {
+	list, err := r.agg.GetFlows(stats.FlowFilter{}, "speed", 0, 0)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	start, _ := r.periodOf(now)
+	if r.current == nil || !r.current.start.Equal(start) {
+		if r.current != nil {
+			r.previous = r.current
+		}
+		r.current = &destinations{start: start, clients: make(map[string]map[string]*Destination)}
+	}
+
+	seen := make(map[string][2]uint64, len(list.Flows))
+	for _, f := range list.Flows {
+		if f.MAC == "" {
+			continue
+		}
+		key := fmt.Sprintf("%s|%s|%d|%s|%d|%d", f.Protocol, f.ClientIP, f.ClientPort, f.RemoteIP, f.RemotePort, f.FirstSeen.UnixNano())
+		cur := [2]uint64{f.TotalDownload, f.TotalUpload}
+		seen[key] = cur
+		prev, ok := r.lastFlows[key]
+		if !ok || cur[0] < prev[0] || cur[1] < prev[1] {
+			prev = [2]uint64{} // New or reset flow
+		}
+		down, up := cur[0]-prev[0], cur[1]-prev[1]
+		if down == 0 && up == 0 {
+			continue
+		}
+
+		mac := r.agg.PrimaryMAC(f.MAC)
+		dests, ok := r.current.clients[mac]
+		if !ok {
+			dests = make(map[string]*Destination)
+			r.current.clients[mac] = dests
+		}
+		name := f.RemoteHostname
+		if name == "" {
+			name = f.RemoteIP
+		}
+		if _, ok := dests[name]; !ok && len(dests) >= maxDestinations {
+			name = "other"
+		}
+		d, ok := dests[name]
+		if !ok {
+			d = &Destination{Destination: name}
+			dests[name] = d
+		}
+		d.TotalDownload += down
+		d.TotalUpload += up
+	}
+	r.lastFlows = seen
+}
+
+// periodOf returns the week (from Monday) or month containing the day of t
+// in the report timezone. Caller holds mu.
+func (r *Reporter) periodOf(t time.Time) (start, end time.Time) {
+	t = t.In(r.loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if r.cfg.Period == "monthly" {
+		start = day.AddDate(0, 0, 1-day.Day())
+		return start, start.AddDate(0, 1, 0)
+	}
+	start = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	return start, start.AddDate(0, 0, 7)
+}
+
+// Generate builds the report for the period containing the day before now:
+// sent on Sunday evening it covers the week up to that Sunday, sent on Monday
+// morning or on the 1st the week or month that just ended.
+func (r *Reporter) Generate(now time.Time) Report {
+	r.mu.Lock()
+	period := r.cfg.Period
+	start, end := r.periodOf(now.AddDate(0, 0, -1))
+	r.mu.Unlock()
+
+	prevStart := start.AddDate(0, 0, -7)
+	if period == "monthly" {
+		prevStart = start.AddDate(0, -1, 0)
+	}
+	return r.generate(period, start, end, prevStart)
+}
+
+// GenerateRange builds a report for the days in [from, to), compared with as
+// many days before. Destinations are counted per report period, so they
+// cover the whole periods the range overlaps. breakdown ("daily", "weekly"
+// or "monthly") adds the global usage over the range per period.
+func (r *Reporter) GenerateRange(from, to time.Time, breakdown string) (Report, error) {
+	loc := r.usage.Location()
+	day := func(t time.Time) time.Time {
+		t = t.In(loc)
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+	start, end := day(from), day(to)
+	if end.Before(to) {
+		end = end.AddDate(0, 0, 1) // Include the day to falls into
+	}
+	days := int(end.Sub(start).Hours()/24 + 0.5)
+
+	rep := r.generate("custom", start, end, start.AddDate(0, 0, -days))
+	if breakdown != "" {
+		usage, err := r.usage.QueryBetween("", breakdown, start, end)
+		if err != nil {
+			return Report{}, err
+		}
+		rep.Usage = usage
+	}
+	return rep, nil
+}
+
+func (r *Reporter) generate(period string, start, end, prevStart time.Time) Report {
+	names := make(map[string]string)
+	for _, c := range r.agg.GetClients() {
+		names[c.MAC] = c.Name
+	}
+
+	r.mu.Lock()
+	cfg := r.cfg
+	dests := make(map[string]map[string]*Destination)
+	for _, d := range []*destinations{r.previous, r.current} {
+		if d == nil || !d.start.Before(end) {
+			continue
+		}
+		if _, dEnd := r.periodOf(d.start); !dEnd.After(start) {
+			continue
+		}
+		for mac, byDest := range d.clients {
+			if dests[mac] == nil {
+				dests[mac] = make(map[string]*Destination, len(byDest))
+			}
+			for name, v := range byDest {
+				sum, ok := dests[mac][name]
+				if !ok {
+					sum = &Destination{Destination: name}
+					dests[mac][name] = sum
+				}
+				sum.TotalDownload += v.TotalDownload
+				sum.TotalUpload += v.TotalUpload
+			}
+		}
+	}
+	top := make(map[string][]Destination, len(dests))
+	for mac, byDest := range dests {
+		list := make([]Destination, 0, len(byDest))
+		for _, d := range byDest {
+			list = append(list, *d)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].TotalDownload+list[i].TotalUpload > list[j].TotalDownload+list[j].TotalUpload
+		})
+		top[mac] = list[:min(cfg.Top, len(list))]
+	}
+	r.mu.Unlock()
+
+	global, clients := r.usage.Totals(start, end)
+	prevGlobal, prevClients := r.usage.Totals(prevStart, start)
+
+	rep := Report{
+		Period:           period,
+		Start:            start,
+		End:              end,
+		TotalDownload:    global.Download,
+		TotalUpload:      global.Upload,
+		PreviousDownload: prevGlobal.Download,
+		PreviousUpload:   prevGlobal.Upload,
+		Change:           change(global, prevGlobal),
+		Clients:          make([]ClientReport, 0, len(clients)),
+	}
+	for mac, u := range clients {
+		name := names[mac]
+		if name == "" {
+			name = mac
+		}
+		prev := prevClients[mac]
+		rep.Clients = append(rep.Clients, ClientReport{
+			MAC:              mac,
+			Name:             name,
+			TotalDownload:    u.Download,
+			TotalUpload:      u.Upload,
+			PreviousDownload: prev.Download,
+			PreviousUpload:   prev.Upload,
+			Change:           change(u, prev),
+			Destinations:     append([]Destination{}, top[mac]...),
+		})
+	}
+	sort.Slice(rep.Clients, func(i, j int) bool {
+		a, b := rep.Clients[i], rep.Clients[j]
+		if a.TotalDownload+a.TotalUpload != b.TotalDownload+b.TotalUpload {
+			return a.TotalDownload+a.TotalUpload > b.TotalDownload+b.TotalUpload
+		}
+		return a.MAC < b.MAC
+	})
+	return rep
+}
+
+// change is the total of cur relative to prev in percent, nil without prev
+func change(cur, prev stats.UsageDay) *float64 {
+	p := prev.Download + prev.Upload
+	if p == 0 {
+		return nil
+	}
+	v := (float64(cur.Download+cur.Upload) - float64(p)) / float64(p) * 100
+	return &v
+}
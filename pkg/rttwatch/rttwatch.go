@@ -0,0 +1,271 @@
+// Package rttwatch estimates latency passively from TCP handshakes: the time
+// between a SYN and its SYN-ACK passing the router is the round trip to the
+// side that answers.
+package rttwatch
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/pkg/monitor"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// SYNs not answered within this are dropped
+	pendingTTL = 5 * time.Second
+	// Upper bound of handshakes in progress
+	maxPending = 8192
+	// Upper bound of samples between two Drain calls
+	maxSamples = 8192
+	// A SYN seen again this soon crossed another interface, retransmissions
+	// follow after at least a second
+	dupWindow = 100 * time.Millisecond
+)
+
+// Sample is the handshake round trip of one connection
+type Sample struct {
+	Client     netip.Addr // Sent the SYN
+	Server     netip.Addr // Answered with the SYN-ACK
+	ServerPort uint16
+	RTT        time.Duration
+}
+
+// flowKey is a connection as sent by the client
+type flowKey struct {
+	src, dst netip.AddrPort
+}
+
+type handshake struct {
+	sent          time.Time
+	retransmitted bool // Ambiguous which SYN is answered, no sample
+}
+
+// Watcher sniffs SYN and SYN-ACK segments on the LAN interface. Only these
+// pass the socket filter, so the cost doesn't grow with traffic.
+type Watcher struct {
+	ifaceName string
+
+	mu      sync.Mutex
+	pending map[flowKey]*handshake
+	samples []Sample
+
+	fd   int
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewWatcher(ifaceName string) *Watcher {
+	return &Watcher{
+		ifaceName: ifaceName,
+		pending:   make(map[flowKey]*handshake),
+		fd:        -1,
+		stop:      make(chan struct{}),
+	}
+}
+
+// Start opens the packet socket and begins capturing
+func (w *Watcher) Start() error {
+	var fd int
+	err := monitor.InNetNS(func() (err error) {
+		fd, err = openSocket(w.ifaceName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	w.fd = fd
+
+	w.wg.Go(w.captureLoop)
+	return nil
+}
+
+func (w *Watcher) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+	if w.fd >= 0 {
+		unix.Close(w.fd)
+	}
+}
+
+// Drain returns the samples taken since the last call
+func (w *Watcher) Drain() []Sample {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := w.samples
+	w.samples = nil
+	return s
+}
+
+func (w *Watcher) captureLoop() {
+	buf := make([]byte, 128)
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		n, _, err := unix.Recvfrom(w.fd, buf, 0)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue // Read timeout, check stop
+			}
+			slog.Error("RTT capture error", "err", err)
+			return
+		}
+		w.handlePacket(buf[:n], time.Now())
+	}
+}
+
+// handlePacket parses an IP packet carrying a TCP segment with SYN set
+func (w *Watcher) handlePacket(b []byte, now time.Time) {
+	if len(b) < 1 {
+		return
+	}
+
+	var src, dst netip.Addr
+	var l4 []byte
+	switch b[0] >> 4 {
+	case 4:
+		ihl := int(b[0]&0x0f) * 4
+		if len(b) < 20 || len(b) < ihl {
+			return
+		}
+		src = netip.AddrFrom4([4]byte(b[12:16]))
+		dst = netip.AddrFrom4([4]byte(b[16:20]))
+		l4 = b[ihl:]
+	case 6:
+		if len(b) < 40 {
+			return
+		}
+		src = netip.AddrFrom16([16]byte(b[8:24]))
+		dst = netip.AddrFrom16([16]byte(b[24:40]))
+		l4 = b[40:]
+	default:
+		return
+	}
+	if len(l4) < 14 {
+		return
+	}
+	sport := uint16(l4[0])<<8 | uint16(l4[1])
+	dport := uint16(l4[2])<<8 | uint16(l4[3])
+	const ack = 0x10 // SYN is set, the filter passes nothing else
+	flags := l4[13]
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if flags&ack == 0 {
+		// SYN, from the client
+		k := flowKey{netip.AddrPortFrom(src, sport), netip.AddrPortFrom(dst, dport)}
+		if h, ok := w.pending[k]; ok && now.Sub(h.sent) < pendingTTL {
+			if now.Sub(h.sent) > dupWindow {
+				h.retransmitted = true
+			}
+			return
+		}
+		if len(w.pending) >= maxPending {
+			w.expire(now)
+			if len(w.pending) >= maxPending {
+				return // Busy, sample the handshakes that fit
+			}
+		}
+		w.pending[k] = &handshake{sent: now}
+		return
+	}
+
+	// SYN-ACK, from the server
+	k := flowKey{netip.AddrPortFrom(dst, dport), netip.AddrPortFrom(src, sport)}
+	h, ok := w.pending[k]
+	if !ok {
+		return
+	}
+	delete(w.pending, k)
+	rtt := now.Sub(h.sent)
+	if h.retransmitted || rtt > pendingTTL || len(w.samples) >= maxSamples {
+		return
+	}
+	w.samples = append(w.samples, Sample{Client: dst, Server: src, ServerPort: sport, RTT: rtt})
+}
+
+// expire drops unanswered SYNs. Caller holds mu.
+func (w *Watcher) expire(now time.Time) {
+	for k, h := range w.pending {
+		if now.Sub(h.sent) > pendingTTL {
+			delete(w.pending, k)
+		}
+	}
+}
+
+// openSocket opens an AF_PACKET socket filtered to TCP segments with SYN set
+func openSocket(ifaceName string) (int, error) {
+	proto := monitor.Htons(unix.ETH_P_ALL)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		return -1, fmt.Errorf("failed to open packet socket: %w", err)
+	}
+
+	ifindex := 0 // All interfaces
+	if ifaceName != "" {
+		iface, err := net.InterfaceByName(ifaceName)
+		if err != nil {
+			unix.Close(fd)
+			return -1, err
+		}
+		ifindex = iface.Index
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: ifindex}); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("failed to bind packet socket: %w", err)
+	}
+
+	// Offsets are relative to the network header (SOCK_DGRAM strips link headers).
+	// Out of bounds loads reject the packet.
+	raw, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 0, Size: 1},                                       // 0: version/IHL
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 4},                      // 1: version
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 4, SkipFalse: 5},                   // 2: IPv4? else 8
+		bpf.LoadAbsolute{Off: 9, Size: 1},                                       // 3: protocol
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 6, SkipFalse: 9},                   // 4: TCP? else reject
+		bpf.LoadMemShift{Off: 0},                                                // 5: X = IHL*4
+		bpf.LoadIndirect{Off: 13, Size: 1},                                      // 6: TCP flags
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x02, SkipTrue: 5, SkipFalse: 6}, // 7: SYN? accept
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 6, SkipFalse: 5},                   // 8: IPv6? else reject
+		bpf.LoadAbsolute{Off: 6, Size: 1},                                       // 9: next header
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 6, SkipFalse: 3},                   // 10: TCP? else reject
+		bpf.LoadAbsolute{Off: 40 + 13, Size: 1},                                 // 11: TCP flags
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x02, SkipFalse: 1},              // 12: SYN?
+		bpf.RetConstant{Val: 128},                                               // 13: accept headers
+		bpf.RetConstant{Val: 0},                                                 // 14: reject
+	})
+	if err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	filter := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("failed to attach filter: %w", err)
+	}
+
+	// Periodic wakeup so Stop is noticed
+	tv := unix.Timeval{Sec: 1}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	return fd, nil
+}
@@ -0,0 +1,118 @@
+package shaping
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/kisy/catchmole/pkg/monitor"
+)
+
+// clientRule is the input for the rules of one limited client
+type clientRule struct {
+	mac   string
+	macs  []string // Primary MAC and aliases
+	ips   []string
+	limit Limit
+}
+
+// render builds an nft script replacing the whole table, so the kernel swaps
+// the rules atomically. Each client gets one policer per direction; packets
+// above the rate are dropped and TCP backs off.
+func render(table string, rules []clientRule) string {
+	var b strings.Builder
+	// Adding first makes the delete safe when the table does not exist yet
+	fmt.Fprintf(&b, "table inet %s\ndelete table inet %s\n", table, table)
+	if len(rules) == 0 {
+		return b.String()
+	}
+
+	var chain strings.Builder
+	fmt.Fprintf(&b, "table inet %s {\n", table)
+	for _, r := range rules {
+		if r.limit.Upload > 0 {
+			fmt.Fprintf(&chain, "\t\tether saddr { %s } %s\n", strings.Join(r.macs, ", "), policer(r.limit.Upload))
+		}
+		if r.limit.Download == 0 {
+			continue
+		}
+		var v4, v6 []string
+		for _, s := range r.ips {
+			ip, err := netip.ParseAddr(s)
+			if err != nil {
+				continue
+			}
+			if ip = ip.Unmap(); ip.Is4() {
+				v4 = append(v4, ip.String())
+			} else {
+				v6 = append(v6, ip.String())
+			}
+		}
+		if len(v4) == 0 && len(v6) == 0 {
+			continue
+		}
+		// IPv4 and IPv6 share the policer through a named limit
+		name := limitName(r.mac)
+		fmt.Fprintf(&b, "\tlimit %s {\n\t\trate over %d bytes/second burst %d bytes\n\t}\n", name, r.limit.Download, burst(r.limit.Download))
+		if len(v4) > 0 {
+			fmt.Fprintf(&chain, "\t\tip daddr { %s } limit name %s drop\n", strings.Join(v4, ", "), name)
+		}
+		if len(v6) > 0 {
+			fmt.Fprintf(&chain, "\t\tip6 daddr { %s } limit name %s drop\n", strings.Join(v6, ", "), name)
+		}
+	}
+	b.WriteString("\tchain forward {\n\t\ttype filter hook forward priority filter; policy accept;\n")
+	b.WriteString(chain.String())
+	b.WriteString("\t}\n}\n")
+	return b.String()
+}
+
+func policer(rate uint64) string {
+	return fmt.Sprintf("limit rate over %d bytes/second burst %d bytes drop", rate, burst(rate))
+}
+
+// burst allows a tenth of a second at the limit, enough for TCP to ramp up
+func burst(rate uint64) uint64 {
+	return max(rate/10, 64*1024)
+}
+
+func limitName(mac string) string {
+	return "dl_" + strings.ReplaceAll(mac, ":", "")
+}
+
+// validTable reports whether name is safe to use as an nft table identifier
+func validTable(name string) bool {
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9', r == '_':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// loadRuleset runs nft with the script on stdin, in the monitored namespace
+func loadRuleset(ctx context.Context, ruleset string) error {
+	return monitor.InNetNS(func() error {
+		cmd := monitor.NetAdminCommand(ctx, "nft", "-f", "-")
+		cmd.Stdin = strings.NewReader(ruleset)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("nft: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	})
+}
+
+// removeTable deletes the table and its rules
+func removeTable(table string) error {
+	return loadRuleset(context.Background(), render(table, nil))
+}
@@ -0,0 +1,297 @@
+package shaping
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/pkg/stats"
+)
+
+// Client addresses move (DHCP, IPv6 privacy addresses), rules are rebuilt this often
+const syncInterval = 10 * time.Second
+
+// Config is the [shaping] TOML table
+type Config struct {
+	Enabled bool              `toml:"enabled"`
+	Table   string            `toml:"table"`  // nftables table in the inet family
+	Limits  map[string]string `toml:"limits"` // MAC -> "down/up" in Mbps, 0 = unlimited
+	Tags    map[string]string `toml:"tags"`   // Device tag -> "down/up" in Mbps, e.g. guest = "10/10"
+}
+
+// Equal reports whether two configs are the same
+func (c Config) Equal(o Config) bool {
+	return c.Enabled == o.Enabled && c.Table == o.Table && maps.Equal(c.Limits, o.Limits) && maps.Equal(c.Tags, o.Tags)
+}
+
+// Limit is a rate limit in bytes/s per direction, 0 = unlimited
+type Limit struct {
+	Download uint64 `json:"download"`
+	Upload   uint64 `json:"upload"`
+}
+
+func (l Limit) isZero() bool {
+	return l.Download == 0 && l.Upload == 0
+}
+
+// ParseLimit parses "down/up" in Mbps, e.g. "10/2"
+func ParseLimit(spec string) (Limit, error) {
+	down, up, ok := strings.Cut(spec, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q (want down/up in Mbps, e.g. 10/2)", spec)
+	}
+	var l Limit
+	for i, v := range []string{down, up} {
+		mbps, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || mbps < 0 {
+			return Limit{}, fmt.Errorf("invalid limit %q (want down/up in Mbps, e.g. 10/2)", spec)
+		}
+		bps := uint64(mbps * 1e6 / 8)
+		if i == 0 {
+			l.Download = bps
+		} else {
+			l.Upload = bps
+		}
+	}
+	return l, nil
+}
+
+// ClientLimit is the limit in effect for a client
+type ClientLimit struct {
+	MAC           string `json:"mac"`
+	Name          string `json:"name"`
+	Limit         Limit  `json:"limit"`
+	Source        string `json:"source"` // "api", "config" or "tag:<tag>"
+	DownloadSpeed uint64 `json:"download_speed"`
+	UploadSpeed   uint64 `json:"upload_speed"`
+}
+
+// Controller rate limits clients with nftables. Limits come from the config
+// (per MAC or per device tag) and from the API; the API wins over the config
+// and a MAC limit over tag limits. Upload is matched by source MAC, download
+// by the client's current addresses from the neighbor table.
+type Controller struct {
+	agg *stats.Aggregator
+
+	mu      sync.Mutex
+	enabled bool
+	table   string
+	limits  map[string]Limit // From the config
+	tags    map[string]Limit
+	runtime map[string]Limit // Set via the API, kept until restart
+	applied string           // Last loaded ruleset
+
+	kick chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewController(agg *stats.Aggregator, cfg Config) (*Controller, error) {
+	c := &Controller{
+		agg:     agg,
+		runtime: make(map[string]Limit),
+		kick:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	if err := c.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetConfig replaces the configured limits, runtime limits are kept.
+// Disabling removes all rules.
+func (c *Controller) SetConfig(cfg Config) error {
+	if cfg.Table == "" {
+		cfg.Table = "catchmole" // Default
+	}
+	if !validTable(cfg.Table) {
+		return fmt.Errorf("invalid shaping table %q (want letters, digits and underscores)", cfg.Table)
+	}
+	limits := make(map[string]Limit, len(cfg.Limits))
+	for spec, rate := range cfg.Limits {
+		hw, err := net.ParseMAC(strings.TrimSpace(spec))
+		if err != nil {
+			return fmt.Errorf("invalid shaping MAC %q", spec)
+		}
+		l, err := ParseLimit(rate)
+		if err != nil {
+			return err
+		}
+		limits[hw.String()] = l
+	}
+	tags := make(map[string]Limit, len(cfg.Tags))
+	for tag, rate := range cfg.Tags {
+		l, err := ParseLimit(rate)
+		if err != nil {
+			return err
+		}
+		tags[strings.ToLower(strings.TrimSpace(tag))] = l
+	}
+
+	c.mu.Lock()
+	if c.table != "" && c.table != cfg.Table {
+		// Renamed, drop the rules in the old table
+		if err := removeTable(c.table); err != nil {
+			slog.Warn("Shaping: failed to remove old table", "table", c.table, "err", err)
+		}
+		c.applied = ""
+	}
+	c.enabled = cfg.Enabled
+	c.table = cfg.Table
+	c.limits = limits
+	c.tags = tags
+	c.mu.Unlock()
+
+	c.trigger()
+	return nil
+}
+
+// SetLimit sets a runtime limit for a client, a zero limit removes it
+func (c *Controller) SetLimit(mac string, l Limit) {
+	c.mu.Lock()
+	if l.isZero() {
+		delete(c.runtime, mac)
+	} else {
+		c.runtime[mac] = l
+	}
+	c.mu.Unlock()
+
+	c.trigger()
+}
+
+// Limits returns the limits in effect for tracked clients, sorted by MAC
+func (c *Controller) Limits() []ClientLimit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var list []ClientLimit
+	for _, client := range c.agg.GetClients() {
+		l, source := c.limitFor(client.MAC, client.Tags)
+		if l.isZero() {
+			continue
+		}
+		list = append(list, ClientLimit{
+			MAC:           client.MAC,
+			Name:          client.Name,
+			Limit:         l,
+			Source:        source,
+			DownloadSpeed: client.DownloadSpeed,
+			UploadSpeed:   client.UploadSpeed,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].MAC < list[j].MAC })
+	return list
+}
+
+// limitFor resolves the limit of a client. With several limited tags the
+// lowest rate per direction applies. Caller holds mu.
+func (c *Controller) limitFor(mac string, tags []string) (Limit, string) {
+	if !c.enabled {
+		return Limit{}, ""
+	}
+	if l, ok := c.runtime[mac]; ok {
+		return l, "api"
+	}
+	if l, ok := c.limits[mac]; ok {
+		return l, "config"
+	}
+	var limit Limit
+	var source string
+	for _, tag := range tags {
+		l, ok := c.tags[tag]
+		if !ok {
+			continue
+		}
+		limit.Download = lowest(limit.Download, l.Download)
+		limit.Upload = lowest(limit.Upload, l.Upload)
+		if source == "" {
+			source = "tag:" + tag
+		}
+	}
+	return limit, source
+}
+
+// lowest picks the stricter of two rates, 0 being unlimited
+func lowest(a, b uint64) uint64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func (c *Controller) trigger() {
+	select {
+	case c.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Controller) Start() {
+	c.wg.Go(func() {
+		ticker := time.NewTicker(syncInterval)
+		defer ticker.Stop()
+
+		c.sync()
+		for {
+			select {
+			case <-ticker.C:
+			case <-c.kick:
+			case <-c.stop:
+				return
+			}
+			c.sync()
+		}
+	})
+}
+
+// Stop removes the rules, limits only apply while catchmole runs
+func (c *Controller) Stop() {
+	close(c.stop)
+	c.wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := removeTable(c.table); err != nil {
+		slog.Warn("Shaping: failed to remove table", "table", c.table, "err", err)
+	}
+}
+
+// sync rebuilds the ruleset and loads it if it changed
+func (c *Controller) sync() {
+	clients := c.agg.GetClients()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var rules []clientRule
+	for _, client := range clients {
+		l, _ := c.limitFor(client.MAC, client.Tags)
+		if l.isZero() {
+			continue
+		}
+		macs := append([]string{client.MAC}, c.agg.ClientAliases(client.MAC)...)
+		rules = append(rules, clientRule{mac: client.MAC, macs: macs, ips: c.agg.ClientIPs(client.MAC), limit: l})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].mac < rules[j].mac })
+
+	ruleset := render(c.table, rules)
+	if ruleset == c.applied {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := loadRuleset(ctx, ruleset); err != nil {
+		slog.Error("Shaping: failed to load nftables rules", "err", err)
+		return
+	}
+	c.applied = ruleset
+	slog.Info("Shaping: rules updated", "table", c.table, "clients", len(rules))
+}
@@ -0,0 +1,121 @@
+package sniwatch
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+const maxHelloSize = 16 << 10
+
+// tlsServerName extracts the server name from a TLS record carrying (the
+// start of) a ClientHello. done is false if b ends before the name was found.
+func tlsServerName(b []byte) (name string, done bool) {
+	// Record: type 22 (handshake), version 3.x, length
+	if len(b) < 5 || b[0] != 0x16 || b[1] != 3 {
+		return "", true
+	}
+	return handshakeServerName(b[5:])
+}
+
+// handshakeServerName extracts the server_name extension from a handshake
+// message that may be cut short. done is false if more data may reveal it.
+func handshakeServerName(b []byte) (name string, done bool) {
+	if len(b) < 4 {
+		return "", false
+	}
+	if b[0] != 1 { // ClientHello
+		return "", true
+	}
+	n := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+	complete := 4+n <= len(b)
+	if complete {
+		b = b[:4+n]
+	}
+	r := reader{b: b[4:]}
+
+	r.skip(2 + 32)     // Version, random
+	r.skip(r.uint8())  // Session ID
+	r.skip(r.uint16()) // Cipher suites
+	r.skip(r.uint8())  // Compression methods
+	extLen := r.uint16()
+	if r.short {
+		return "", complete
+	}
+	if extLen > len(r.b) {
+		extLen = len(r.b)
+	}
+	ext := reader{b: r.b[:extLen]}
+	for {
+		typ, length := ext.uint16(), ext.uint16()
+		data := ext.bytes(length)
+		if ext.short {
+			return "", complete // No server name, or it's in the part not seen yet
+		}
+		if typ != 0 { // server_name
+			continue
+		}
+
+		// ServerNameList of (type, name), type 0 is host_name
+		list := reader{b: data}
+		list.skip(2)
+		for {
+			nameType, n := list.uint8(), list.uint16()
+			host := list.bytes(n)
+			if list.short {
+				return "", true
+			}
+			if nameType == 0 {
+				return normalize(string(host)), true
+			}
+		}
+	}
+}
+
+// normalize lowercases a host name and rejects anything that isn't one
+func normalize(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" || len(host) > 253 {
+		return ""
+	}
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return ""
+		}
+	}
+	return host
+}
+
+// reader reads big-endian fields, short is set once it runs out of data
+type reader struct {
+	b     []byte
+	short bool
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.short || n > len(r.b) {
+		r.short = true
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *reader) uint8() int {
+	if v := r.bytes(1); v != nil {
+		return int(v[0])
+	}
+	return 0
+}
+
+func (r *reader) uint16() int {
+	if v := r.bytes(2); v != nil {
+		return int(binary.BigEndian.Uint16(v))
+	}
+	return 0
+}
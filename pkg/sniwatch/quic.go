@@ -0,0 +1,228 @@
+package sniwatch
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Initial packets are encrypted with keys derived from the destination
+// connection ID, so anyone on the path can read them (RFC 9001 section 5.2)
+var (
+	quicV1Salt = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+		0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+	quicV2Salt = []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93,
+		0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9}
+)
+
+const (
+	quicV1 = 0x00000001
+	quicV2 = 0x6b3343cf // RFC 9369
+)
+
+var errNotInitial = errors.New("not a QUIC Initial packet")
+
+type cryptoFrame struct {
+	offset uint64
+	data   []byte
+}
+
+// decryptInitial returns the CRYPTO frames of the client Initial packet at the
+// start of a UDP datagram
+func decryptInitial(b []byte) ([]cryptoFrame, error) {
+	if len(b) < 7 || b[0]&0xc0 != 0xc0 { // Long header, fixed bit
+		return nil, errNotInitial
+	}
+	version := binary.BigEndian.Uint32(b[1:5])
+	typ := b[0] >> 4 & 3
+	var salt []byte
+	var labelPrefix string
+	switch {
+	case version == quicV1 && typ == 0:
+		salt, labelPrefix = quicV1Salt, "quic "
+	case version == quicV2 && typ == 1:
+		salt, labelPrefix = quicV2Salt, "quicv2 "
+	default:
+		return nil, errNotInitial
+	}
+
+	r := reader{b: b[5:]}
+	dcid := r.bytes(r.uint8())
+	r.skip(r.uint8()) // Source connection ID
+	tokenLen, _ := r.varint()
+	r.skip(int(tokenLen))
+	length, _ := r.varint()
+	if r.short || len(dcid) > 20 || length > uint64(len(r.b)) || length < 20 {
+		return nil, errNotInitial
+	}
+	pnOffset := len(b) - len(r.b)
+	end := pnOffset + int(length)
+
+	initial, err := hkdf.Extract(sha256.New, dcid, salt)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := expandLabel(initial, "client in", sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	key, err := expandLabel(secret, labelPrefix+"key", 16)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := expandLabel(secret, labelPrefix+"iv", 12)
+	if err != nil {
+		return nil, err
+	}
+	hpKey, err := expandLabel(secret, labelPrefix+"hp", 16)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove header protection, the sample starts 4 bytes into the packet number
+	hp, err := aes.NewCipher(hpKey)
+	if err != nil {
+		return nil, err
+	}
+	mask := make([]byte, aes.BlockSize)
+	hp.Encrypt(mask, b[pnOffset+4:pnOffset+4+aes.BlockSize])
+	header := append([]byte(nil), b[:pnOffset+4]...)
+	header[0] ^= mask[0] & 0x0f
+	pnLen := int(header[0]&3) + 1
+	var pn uint64
+	for i := range pnLen {
+		header[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(header[pnOffset+i])
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := iv
+	for i := range 8 {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	payload, err := aead.Open(nil, nonce, b[pnOffset+pnLen:end], header[:pnOffset+pnLen])
+	if err != nil {
+		return nil, err
+	}
+	return parseFrames(payload), nil
+}
+
+// parseFrames returns the CRYPTO frames of a decrypted Initial payload. Client
+// Initials also carry PADDING, PING and ACK; parsing stops at anything else.
+func parseFrames(b []byte) []cryptoFrame {
+	var frames []cryptoFrame
+	r := reader{b: b}
+	for len(r.b) > 0 {
+		typ, _ := r.varint()
+		switch typ {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x02, 0x03: // ACK
+			r.varint() // Largest acknowledged
+			r.varint() // Delay
+			ranges, _ := r.varint()
+			r.varint() // First range
+			for i := uint64(0); i < ranges && !r.short; i++ {
+				r.varint() // Gap
+				r.varint() // Range
+			}
+			if typ == 0x03 {
+				r.varint() // ECN counts
+				r.varint()
+				r.varint()
+			}
+		case 0x06: // CRYPTO
+			offset, _ := r.varint()
+			n, _ := r.varint()
+			if n > uint64(len(r.b)) {
+				return frames
+			}
+			frames = append(frames, cryptoFrame{offset: offset, data: r.bytes(int(n))})
+		default:
+			return frames
+		}
+		if r.short {
+			return frames
+		}
+	}
+	return frames
+}
+
+// expandLabel is HKDF-Expand-Label from TLS 1.3 with an empty context
+func expandLabel(secret []byte, label string, length int) ([]byte, error) {
+	label = "tls13 " + label
+	info := make([]byte, 0, 4+len(label))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+	return hkdf.Expand(sha256.New, secret, string(info), length)
+}
+
+// cryptoStream reassembles the CRYPTO frames of a connection's Initial
+// packets, which browsers split and reorder
+type cryptoStream struct {
+	frames  []cryptoFrame
+	size    int
+	expires time.Time
+}
+
+var errTooLarge = errors.New("ClientHello too large")
+
+func (cs *cryptoStream) addFrames(frames []cryptoFrame) error {
+	for _, f := range frames {
+		if f.offset+uint64(len(f.data)) > maxHelloSize {
+			return errTooLarge
+		}
+		cs.frames = append(cs.frames, cryptoFrame{offset: f.offset, data: append([]byte(nil), f.data...)})
+		cs.size += len(f.data)
+		if cs.size > 2*maxHelloSize {
+			return errTooLarge // Retransmissions, give up
+		}
+	}
+	return nil
+}
+
+// contiguous returns the stream data received without gaps from offset 0
+func (cs *cryptoStream) contiguous() []byte {
+	sort.Slice(cs.frames, func(i, j int) bool { return cs.frames[i].offset < cs.frames[j].offset })
+	var b []byte
+	for _, f := range cs.frames {
+		if f.offset > uint64(len(b)) {
+			break
+		}
+		if end := f.offset + uint64(len(f.data)); end > uint64(len(b)) {
+			b = append(b, f.data[uint64(len(b))-f.offset:]...)
+		}
+	}
+	return b
+}
+
+// varint reads a QUIC variable-length integer
+func (r *reader) varint() (uint64, bool) {
+	first := r.bytes(1)
+	if first == nil {
+		return 0, false
+	}
+	n := 1 << (first[0] >> 6)
+	v := uint64(first[0] & 0x3f)
+	rest := r.bytes(n - 1)
+	if rest == nil && n > 1 {
+		return 0, false
+	}
+	for _, c := range rest {
+		v = v<<8 | uint64(c)
+	}
+	return v, true
+}
@@ -0,0 +1,317 @@
+// Package sniwatch labels connections with the server name clients ask for in
+// TLS ClientHellos (TCP) and QUIC Initial packets (UDP) to port 443.
+package sniwatch
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/pkg/monitor"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Flows are labeled on the next speed calculation, names are only kept until then
+	entryTTL = 2 * time.Minute
+	// Upper bound of labeled connections
+	maxEntries = 20000
+	// QUIC ClientHellos may span several Initial packets
+	pendingTTL = 5 * time.Second
+	maxPending = 1024
+)
+
+// flowKey is a connection as sent by the client
+type flowKey struct {
+	proto    uint8
+	src, dst netip.AddrPort
+}
+
+type entry struct {
+	name    string
+	expires time.Time
+}
+
+// Watcher sniffs ClientHellos on the LAN interface. Only the start of each
+// connection passes the socket filter, so the cost doesn't grow with traffic.
+type Watcher struct {
+	ifaceName string
+
+	mu      sync.Mutex
+	names   map[flowKey]entry
+	pending map[flowKey]*cryptoStream // Incomplete QUIC ClientHellos
+
+	fd   int
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewWatcher(ifaceName string) *Watcher {
+	return &Watcher{
+		ifaceName: ifaceName,
+		names:     make(map[flowKey]entry),
+		pending:   make(map[flowKey]*cryptoStream),
+		fd:        -1,
+		stop:      make(chan struct{}),
+	}
+}
+
+// Start opens the packet socket and begins capturing
+func (w *Watcher) Start() error {
+	var fd int
+	err := monitor.InNetNS(func() (err error) {
+		fd, err = openSocket(w.ifaceName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	w.fd = fd
+
+	w.wg.Go(w.captureLoop)
+	return nil
+}
+
+func (w *Watcher) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+	if w.fd >= 0 {
+		unix.Close(w.fd)
+	}
+}
+
+// Lookup returns the server name of the connection from src to dst, or "" if
+// no ClientHello was seen
+func (w *Watcher) Lookup(proto uint8, srcIP string, srcPort uint16, dstIP string, dstPort uint16) string {
+	src, err1 := netip.ParseAddr(srcIP)
+	dst, err2 := netip.ParseAddr(dstIP)
+	if err1 != nil || err2 != nil {
+		return ""
+	}
+	k := flowKey{proto, netip.AddrPortFrom(src.Unmap(), srcPort), netip.AddrPortFrom(dst.Unmap(), dstPort)}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	e, ok := w.names[k]
+	if !ok || time.Now().After(e.expires) {
+		return ""
+	}
+	return e.name
+}
+
+func (w *Watcher) set(k flowKey, name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if len(w.names) >= maxEntries {
+		for k, e := range w.names {
+			if now.After(e.expires) {
+				delete(w.names, k)
+			}
+		}
+		if len(w.names) >= maxEntries {
+			return // Busy, label the rest once entries expire
+		}
+	}
+	w.names[k] = entry{name: name, expires: now.Add(entryTTL)}
+}
+
+func (w *Watcher) captureLoop() {
+	buf := make([]byte, 65536)
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		n, _, err := unix.Recvfrom(w.fd, buf, 0)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue // Read timeout, check stop
+			}
+			slog.Error("SNI capture error", "err", err)
+			return
+		}
+		w.handlePacket(buf[:n])
+	}
+}
+
+// handlePacket parses an IP packet carrying TCP or UDP to port 443
+func (w *Watcher) handlePacket(b []byte) {
+	if len(b) < 1 {
+		return
+	}
+
+	var k flowKey
+	var l4 []byte
+	switch b[0] >> 4 {
+	case 4:
+		ihl := int(b[0]&0x0f) * 4
+		if len(b) < 20 || len(b) < ihl {
+			return
+		}
+		k.proto = b[9]
+		k.src = netip.AddrPortFrom(netip.AddrFrom4([4]byte(b[12:16])), 0)
+		k.dst = netip.AddrPortFrom(netip.AddrFrom4([4]byte(b[16:20])), 0)
+		l4 = b[ihl:]
+	case 6:
+		if len(b) < 40 {
+			return
+		}
+		k.proto = b[6] // Extension headers aren't followed, the filter rejects them
+		k.src = netip.AddrPortFrom(netip.AddrFrom16([16]byte(b[8:24])), 0)
+		k.dst = netip.AddrPortFrom(netip.AddrFrom16([16]byte(b[24:40])), 0)
+		l4 = b[40:]
+	default:
+		return
+	}
+	if len(l4) < 8 {
+		return
+	}
+	k.src = netip.AddrPortFrom(k.src.Addr(), uint16(l4[0])<<8|uint16(l4[1]))
+	k.dst = netip.AddrPortFrom(k.dst.Addr(), uint16(l4[2])<<8|uint16(l4[3]))
+
+	switch k.proto {
+	case unix.IPPROTO_TCP:
+		off := int(l4[12]>>4) * 4
+		if len(l4) < off {
+			return
+		}
+		// GRO usually merges a ClientHello split over segments. If not, the
+		// name is still found when it is in the first segment.
+		if name, _ := tlsServerName(l4[off:]); name != "" {
+			w.set(k, name)
+		}
+	case unix.IPPROTO_UDP:
+		w.handleQUIC(k, l4[8:])
+	}
+}
+
+// handleQUIC adds the CRYPTO frames of a client Initial packet to the
+// connection's ClientHello and labels the connection once the name is known
+func (w *Watcher) handleQUIC(k flowKey, b []byte) {
+	frames, err := decryptInitial(b)
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	now := time.Now()
+	cs, ok := w.pending[k]
+	if !ok || now.After(cs.expires) {
+		if !ok && len(w.pending) >= maxPending {
+			for k, cs := range w.pending {
+				if now.After(cs.expires) {
+					delete(w.pending, k)
+				}
+			}
+			if len(w.pending) >= maxPending {
+				w.mu.Unlock()
+				return
+			}
+		}
+		cs = &cryptoStream{expires: now.Add(pendingTTL)}
+		w.pending[k] = cs
+	}
+	if err := cs.addFrames(frames); err != nil {
+		delete(w.pending, k)
+		w.mu.Unlock()
+		return
+	}
+	name, done := handshakeServerName(cs.contiguous())
+	if done {
+		delete(w.pending, k)
+	}
+	w.mu.Unlock()
+
+	if name != "" {
+		w.set(k, name)
+	}
+}
+
+// openSocket opens an AF_PACKET socket filtered to the first bytes of TLS and
+// QUIC connections: TCP segments to port 443 starting a handshake record and
+// UDP datagrams to port 443 with a QUIC long header
+func openSocket(ifaceName string) (int, error) {
+	proto := monitor.Htons(unix.ETH_P_ALL)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		return -1, fmt.Errorf("failed to open packet socket: %w", err)
+	}
+
+	ifindex := 0 // All interfaces
+	if ifaceName != "" {
+		iface, err := net.InterfaceByName(ifaceName)
+		if err != nil {
+			unix.Close(fd)
+			return -1, err
+		}
+		ifindex = iface.Index
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: ifindex}); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("failed to bind packet socket: %w", err)
+	}
+
+	// Offsets are relative to the network header (SOCK_DGRAM strips link headers).
+	// Out of bounds loads reject the packet.
+	raw, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 0, Size: 1},                                     // 0: version/IHL
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 4},                    // 1: version
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 4, SkipFalse: 4},                 // 2: IPv4? else 7
+		bpf.LoadMemShift{Off: 0},                                              // 3: X = IHL*4
+		bpf.LoadAbsolute{Off: 9, Size: 1},                                     // 4: protocol
+		bpf.StoreScratch{Src: bpf.RegA, N: 0},                                 // 5
+		bpf.Jump{Skip: 4},                                                     // 6: to 11
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 6, SkipFalse: 18},                // 7: IPv6? else reject
+		bpf.LoadAbsolute{Off: 6, Size: 1},                                     // 8: next header
+		bpf.StoreScratch{Src: bpf.RegA, N: 0},                                 // 9
+		bpf.LoadConstant{Dst: bpf.RegX, Val: 40},                              // 10: X = header length
+		bpf.LoadIndirect{Off: 2, Size: 2},                                     // 11: destination port
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 443, SkipFalse: 13},              // 12: else reject
+		bpf.LoadScratch{Dst: bpf.RegA, N: 0},                                  // 13: protocol
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 17, SkipTrue: 8},                 // 14: UDP? to 23
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 6, SkipFalse: 10},                // 15: TCP? else reject
+		bpf.LoadIndirect{Off: 12, Size: 1},                                    // 16: data offset
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 4},                    // 17
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftLeft, Val: 2},                     // 18: TCP header length
+		bpf.ALUOpX{Op: bpf.ALUOpAdd},                                          // 19
+		bpf.TAX{},                                                             // 20: X = payload offset
+		bpf.LoadIndirect{Off: 0, Size: 1},                                     // 21: TLS record type
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x16, SkipTrue: 2, SkipFalse: 3}, // 22: handshake?
+		bpf.LoadIndirect{Off: 8, Size: 1},                                     // 23: QUIC first byte
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x80, SkipFalse: 1},            // 24: long header?
+		bpf.RetConstant{Val: 65535},                                           // 25: accept
+		bpf.RetConstant{Val: 0},                                               // 26: reject
+	})
+	if err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	filter := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("failed to attach filter: %w", err)
+	}
+
+	// Periodic wakeup so Stop is noticed
+	tv := unix.Timeval{Sec: 1}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	return fd, nil
+}
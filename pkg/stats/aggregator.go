@@ -1,34 +1,141 @@
 package stats
 
 import (
+	"cmp"
+	"context"
 	"fmt"
+	"log/slog"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/dnswatch"
+	"github.com/kisy/catchmole/pkg/geo"
+	"github.com/kisy/catchmole/pkg/integrations/hostapd"
+	"github.com/kisy/catchmole/pkg/integrations/openwrt"
+	"github.com/kisy/catchmole/pkg/integrations/tunnel"
 	"github.com/kisy/catchmole/pkg/monitor"
+	"github.com/kisy/catchmole/pkg/monitor/scanner"
+	"github.com/kisy/catchmole/pkg/rttwatch"
+	"github.com/kisy/catchmole/pkg/sniwatch"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/net/publicsuffix"
 )
 
 type Aggregator struct {
-	mon *monitor.ConntrackMonitor
+	mon monitor.TrafficSource
 	nw  *monitor.NeighborWatcher
 
 	mu      sync.RWMutex
 	clients map[string]*model.ClientStats
-	flows   map[string]*FlowTracker // Key: FlowHash
+
+	// Flows are sharded with their own locks, API reads use the snapshot
+	// published by each speed calculation
+	shards    [flowShards]flowShard
+	flowCount atomic.Int64 // Flows in all shards, held to max_flows
+	snapshot  atomic.Pointer[[]flowView]
 
 	globalTotalDownload uint64
 	globalTotalUpload   uint64
 	globalSmoothedConns float64
+	offloadedFlows      uint64 // Flows offloaded to a flow table at the last speed tick
+
+	// Internet totals by IP family, see family.go
+	globalTotalDownload4 uint64
+	globalTotalUpload4   uint64
+	globalTotalDownload6 uint64
+	globalTotalUpload6   uint64
+	nat64Prefixes        []*net.IPNet
+	nat64Hosts           map[string]time.Time // IPv4 host -> last NAT64 flow to it
+
+	// Connection churn (NEW/DESTROY events)
+	globalNewConns        uint64
+	globalClosedConns     uint64
+	globalFailedConns     uint64
+	globalNewConnsLast    uint64
+	globalClosedConnsLast uint64
+	globalFailedConnsLast uint64
+	globalNewConnRate     float64
+	globalClosedConnRate  float64
+	globalFailedConnRate  float64
+	globalLastRateCalc    time.Time
+
+	// Rolling windows for 1m/5m/15m speed averages
+	globalWindow  speedWindow
+	clientWindows map[string]*speedWindow
+	globalAvg     [6]uint64 // Down 1m/5m/15m, Up 1m/5m/15m
+
+	// Upload anomaly detection, see anomaly.go
+	uploadBaselines  map[string]*uploadBaseline
+	anomalyThreshold float64
+	anomalyMinUpload uint64
+
+	// Connection fan-out (scan) detection, see fanout.go
+	fanouts         map[string]*fanout
+	fanoutThreshold int
+	fanoutWindow    time.Duration
+
+	// Expected bandwidth of devices, see plan.go
+	plans       map[string]linkCapacity // Plan -> rates
+	devicePlans map[string]string       // MAC -> plan
+	planStates  map[string]*planState
+	planSustain time.Duration
+
+	speedTests     map[string][]model.SpeedTest // MAC -> results, oldest first, see speedtest.go
+	speedTestConns map[string]time.Time         // Flow key -> registered, until the flow shows up
+
+	// Session rollover, see sessionreset.go
+	sessionReset     *SessionSchedule
+	nextSessionReset time.Time
+	sessionRecords   map[string][]model.SessionRecord // MAC -> closed sessions, oldest first, not persisted
+
+	// Handshake latency, see latency.go
+	clientRTT map[string]*rttWindow
+	remoteRTT map[string]map[string]*rttWindow // MAC -> remote IP
 
 	startTime time.Time
 
+	// Bumped when totals change for reasons other than traffic (restore,
+	// import, alias merge), see TotalsEpoch
+	totalsEpoch atomic.Uint64
+
+	// Events are drained but not counted while paused, see pause.go
+	paused       atomic.Bool
+	pausedAt     time.Time
+	pausedEvents atomic.Uint64
+
+	tunnels atomic.Pointer[tunnel.Watcher] // VPN peers (optional), read without mu, see tunnel.go
+
 	staticNames map[string]string
+	deviceTags  map[string][]string   // MAC -> sorted tags
+	leases      *monitor.LeaseWatcher // DHCP hostnames (optional)
+	ubus        *openwrt.Watcher      // OpenWrt leases and wireless stations (optional)
+	hostapd     *hostapd.Watcher      // Wi-Fi associations (optional)
+	names       *monitor.NameResolver // Names clients answer mDNS/NetBIOS/LLMNR with (optional)
+	dns         *dnswatch.Watcher     // Remote hostnames (optional)
+	sni         *sniwatch.Watcher     // TLS/QUIC server names (optional)
+	rtt         *rttwatch.Watcher     // TCP handshake round trips (optional)
+	geo         *geo.Resolver         // Remote GeoIP (optional)
+	scanner     *scanner.Scanner      // Active LAN discovery (optional)
 
 	ignoreLAN bool
+	ignore    ignoreRules // Configured exclusions
+
+	// Traffic tags, flows with excluded tags are not added to usage totals
+	tags         []tagRule
+	excludedTags map[string]bool
+
+	// Clients counted in aggregate only, see private.go
+	privateMACs map[string]bool
+
+	// Router's own traffic (optional)
+	routerTraffic bool
+	routerIPs     map[string]bool
+	localServices map[monitor.Listener]string // Router listening ports -> process name
+	servicesAt    time.Time                   // Last refresh of localServices
 
 	// Interface Filtering
 	interfaceName  string
@@ -36,7 +143,79 @@ type Aggregator struct {
 	lanSubnets     []net.IPNet // Subnets of the monitored interface
 
 	// Config
-	flowTTL time.Duration
+	clientRetention time.Duration // Evict clients offline longer than this (0 keeps them)
+	flowTTL         time.Duration
+	protoTTL        map[uint8]time.Duration // Per-protocol overrides of flowTTL
+	interval        time.Duration
+	intervalCh      chan time.Duration // Signals interval changes to the calc loop
+	stop            chan struct{}
+	wg              sync.WaitGroup
+
+	speedTick uint64        // Calculation count, indexes the flow speed rings
+	lastTick  time.Time     // Start of the last calculation
+	tickTook  time.Duration // and how long it took
+
+	// Deltas above maxDelta are dropped as counter glitches
+	maxDelta     uint64
+	cappedDeltas uint64
+	cappedBytes  uint64
+
+	// Table caps against floods and scans, see SetTableLimits
+	maxFlows       int
+	maxClients     int
+	evictedFlows   uint64
+	evictedClients uint64
+	lastLimitWarn  time.Time
+
+	// Elephant Flow Detection
+	elephantBytes   uint64
+	elephantRate    uint64
+	elephantSustain time.Duration
+	recentElephants []model.ElephantFlow
+
+	// Finished flows, oldest first
+	archive     []model.ArchivedFlow
+	archiveSize int
+
+	// Final counters of finished flows for exporters, see TakeEndedFlows
+	endedFlows []model.FlowRecord
+	trackEnded bool
+
+	// Port Group Categories
+	portGroups       []portGroup
+	clientCategories map[string]map[string]*model.CategoryStats // MAC -> Category -> Stats
+
+	// Conntrack mark classes
+	markClasses   []markClass
+	clientClasses map[string]map[string]*model.ClassStats // MAC -> Class -> Stats
+	globalClasses map[string]*model.ClassStats
+
+	// GeoIP countries of internet traffic
+	clientCountries  map[string]map[string]*model.CountryStats // MAC -> Country -> Stats
+	globalCountries  map[string]*model.CountryStats
+	blockedCountries map[string]bool
+
+	smoothing model.Smoothing
+
+	// Wake/sleep sessions
+	presence map[string]*presence
+	idleGap  time.Duration
+
+	// Client Groups
+	groups map[string][]string // Name -> member MACs
+
+	// Conntrack zones
+	zones       map[uint16]*model.ZoneStats
+	zoneNames   map[uint16]string
+	ignoreZones map[uint16]bool
+
+	// MAC aliases: alias -> primary, readable without mu, and primary -> aliases
+	aliases   atomic.Pointer[map[string]string]
+	aliasesOf map[string][]string
+
+	// Uplink capacity for utilization percentages
+	linkCapacity linkCapacity
+	zoneCapacity map[uint16]linkCapacity
 }
 
 type FlowTracker struct {
@@ -51,6 +230,29 @@ type FlowTracker struct {
 	DstPort uint16
 	Proto   uint8
 
+	// ICMP type and identifier, if the source decoded them
+	ICMP     bool
+	ICMPType uint8
+	ICMPID   uint16
+
+	Family     uint8 // 4 or 6, NAT64 flows count as 4
+	Translated bool  // Router's IPv4 side of a NAT64 flow, not counted
+
+	// Latest conntrack state
+	TCPState  uint8
+	SeenReply bool
+	Assured   bool
+	Offload   bool // Offloaded to a flow table, bytes may arrive late
+
+	Tag string // Traffic tag (e.g. "speedtest"), set when the flow is created
+
+	Mark  uint32 // Latest conntrack mark
+	Class string // Traffic class of Mark
+	Zone  uint16 // Conntrack zone
+
+	Country string // GeoIP country of the remote side of internet flows
+	SNI     string // Server name from the ClientHello, if sniffed
+
 	ClientMAC string // Associated MAC (if any)
 	Direction string // "upload" (client is src) or "download" (client is dst)
 
@@ -70,36 +272,95 @@ type FlowTracker struct {
 	SpeedTotalOriginLast uint64
 	SpeedTotalReplyLast  uint64
 	SpeedLastCalc        time.Time
+
+	// Elephant Detection
+	PeakSpeed      uint64
+	RateAboveSince time.Time
+	ElephantAt     time.Time
+	ElephantReason string
+
+	History *speedRing // Recent speeds for sparklines, nil until the flow has traffic
 }
 
-func NewAggregator(mon *monitor.ConntrackMonitor, nw *monitor.NeighborWatcher) *Aggregator {
-	return &Aggregator{
-		mon:         mon,
-		nw:          nw,
-		clients:     make(map[string]*model.ClientStats),
-		flows:       make(map[string]*FlowTracker),
-		startTime:   time.Now(),
-		staticNames: make(map[string]string),
-		flowTTL:     60 * time.Second, // Default
+func NewAggregator(mon monitor.TrafficSource, nw *monitor.NeighborWatcher) *Aggregator {
+	a := &Aggregator{
+		mon:              mon,
+		nw:               nw,
+		clients:          make(map[string]*model.ClientStats),
+		startTime:        time.Now(),
+		staticNames:      make(map[string]string),
+		flowTTL:          60 * time.Second, // Default
+		maxDelta:         defaultMaxDelta,
+		maxFlows:         defaultMaxFlows,
+		maxClients:       defaultMaxClients,
+		smoothing:        defaultSmoothing(),
+		clientCategories: make(map[string]map[string]*model.CategoryStats),
+		clientClasses:    make(map[string]map[string]*model.ClassStats),
+		presence:         make(map[string]*presence),
+		idleGap:          defaultIdleGap,
+		archiveSize:      defaultArchiveSize,
+		globalClasses:    make(map[string]*model.ClassStats),
+		clientCountries:  make(map[string]map[string]*model.CountryStats),
+		globalCountries:  make(map[string]*model.CountryStats),
+		zones:            make(map[uint16]*model.ZoneStats),
+		clientWindows:    make(map[string]*speedWindow),
+		uploadBaselines:  make(map[string]*uploadBaseline),
+		anomalyThreshold: defaultAnomalyThreshold,
+		anomalyMinUpload: defaultAnomalyMinUpload,
+		fanouts:          make(map[string]*fanout),
+		fanoutThreshold:  defaultFanoutThreshold,
+		fanoutWindow:     defaultFanoutWindow,
+		planStates:       make(map[string]*planState),
+		planSustain:      defaultPlanSustain,
+		speedTests:       make(map[string][]model.SpeedTest),
+		speedTestConns:   make(map[string]time.Time),
+		excludedTags:     map[string]bool{TagRouterSpeedTest: true},
+		sessionRecords:   make(map[string][]model.SessionRecord),
+		clientRTT:        make(map[string]*rttWindow),
+		remoteRTT:        make(map[string]map[string]*rttWindow),
+		nat64Hosts:       make(map[string]time.Time),
+		intervalCh:       make(chan time.Duration, 1),
+		stop:             make(chan struct{}),
 	}
+	a.clearFlows()
+	a.SetNAT64Prefixes(nil)
+	a.registerTelemetry()
+	return a
 }
 
 // processLoop runs in background
 func (a *Aggregator) processLoop() {
 	for ev := range a.mon.Events() {
+		if a.paused.Load() {
+			a.pausedEvents.Add(1)
+			continue
+		}
 		a.handleEvent(ev)
+		eventLatency.Record(context.Background(), time.Since(ev.Timestamp).Seconds())
 	}
 }
 
 func (a *Aggregator) handleEvent(ev monitor.FlowEvent) {
-	key := fmt.Sprintf("%s:%d->%s:%d:%d", ev.SrcIP, ev.SrcPort, ev.DstIP, ev.DstPort, ev.Proto)
+	key := flowKey(ev)
+	shard := a.shardFor(key)
+
+	// State changes of tracked flows touch only their shard. mu is taken for
+	// events that update client and global counters, before the shard lock.
+	if ev.Type == monitor.EventUpdate && ev.OriginBytes == 0 && ev.ReplyBytes == 0 {
+		shard.mu.Lock()
+		if ft, ok := shard.flows[key]; ok {
+			ft.setState(ev)
+		}
+		shard.mu.Unlock()
+		return
+	}
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
 	// 1. Interface Filter (Subnet Based)
 	if a.interfaceName != "" {
-		if !a.checkFlowSubnet(ev.SrcIP, ev.DstIP) {
+		if !a.checkFlowSubnet(ev.SrcIP, ev.DstIP) && !a.isRouterIP(ev.SrcIP) && !a.isRouterIP(ev.DstIP) {
 			return
 		}
 	}
@@ -112,36 +373,45 @@ func (a *Aggregator) handleEvent(ev monitor.FlowEvent) {
 		return
 	}
 
-	ft, exists := a.flows[key]
+	// Configured ignore lists
+	if a.isIgnored(ev) {
+		return
+	}
+
+	// Connection churn accounting
+	if ev.Type == monitor.EventNew || ev.Type == monitor.EventDestroy {
+		a.countConnEvent(ev)
+	}
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	ft, exists := shard.flows[key]
+
+	// Destroyed flows leave the table right away, after their final bytes are
+	// counted, instead of lingering as active connections until the TTL
+	if ev.Type == monitor.EventDestroy {
+		defer a.removeFlow(shard, key)
+	}
+
+	// Events without byte deltas carry no traffic, only state changes
+	if ev.OriginBytes == 0 && ev.ReplyBytes == 0 {
+		if exists {
+			ft.setState(ev)
+		}
+		return
+	}
 
 	if !exists {
 		// New Flow Initialization
 		srcIP := ev.SrcIP.String()
 		dstIP := ev.DstIP.String()
 
-		srcMac := a.nw.GetMAC(srcIP)
-		dstMac := a.nw.GetMAC(dstIP)
+		srcMac := a.macOf(srcIP)
+		dstMac := a.macOf(dstIP)
 
 		// Filter LAN-to-LAN if enabled (ignoreLAN is true)
-		if a.ignoreLAN && len(a.lanSubnets) > 0 {
-			srcInSubnet := false
-			dstInSubnet := false
-
-			for _, sn := range a.lanSubnets {
-				if sn.Contains(ev.SrcIP) {
-					srcInSubnet = true
-				}
-				if sn.Contains(ev.DstIP) {
-					dstInSubnet = true
-				}
-			}
-
-			if srcInSubnet && dstInSubnet {
-				// Internal traffic, ignore
-				return
-			}
-		} else if a.ignoreLAN && srcMac != "" && dstMac != "" {
-			// Fallback (MAC based check)
+		if a.isIgnoredLAN(ev.SrcIP, ev.DstIP, srcMac, dstMac) {
 			return
 		}
 
@@ -155,58 +425,192 @@ func (a *Aggregator) handleEvent(ev monitor.FlowEvent) {
 			SrcPort:   ev.SrcPort,
 			DstPort:   ev.DstPort,
 			Proto:     ev.Proto,
+			ICMP:      ev.ICMP,
+			ICMPType:  ev.ICMPType,
+			ICMPID:    ev.ICMPID,
+			Family:    a.flowFamily(ev.SrcIP, ev.DstIP),
+			Tag:       a.tagFor(srcIP, dstIP),
+			Mark:      ev.Mark,
+			Class:     a.classify(ev.Mark),
+			Zone:      ev.Zone,
+			Country:   a.countryOf(srcIP, dstIP, srcMac, dstMac),
+		}
+		if _, ok := a.speedTestConns[key]; ok {
+			ft.Tag = TagRouterSpeedTest
+			delete(a.speedTestConns, key)
 		}
-		a.flows[key] = ft
+		if a.maxFlows > 0 && a.flowCount.Load() >= int64(a.maxFlows) {
+			a.makeRoomForFlow(shard)
+		}
+		shard.flows[key] = ft
+		a.flowCount.Add(1)
+		a.countNewFlow(srcMac, dstMac)
+		a.recordFanout(srcMac, dstMac, dstIP, ev.DstPort)
 		// Note: Monitor sends Delta=0 for first seen flows, so no data accumulated here
 	}
 
 	// Update existing flow
 	ft.LastSeen = time.Now()
+	ft.setState(ev)
+	// Firewall rules may mark a connection after its first packets
+	if ev.Mark != ft.Mark {
+		ft.Mark = ev.Mark
+		ft.Class = a.classify(ev.Mark)
+	}
 
 	// Note: ev.OriginBytes and ev.ReplyBytes are now DELTA values from monitor layer
 	// No need to calculate delta here, just accumulate
 	deltaOrig := ev.OriginBytes
 	deltaReply := ev.ReplyBytes
 
-	// Safety Cap: If delta is unreasonably large (> 1GB), it's likely an error
-	const SafeCap = 1 * 1024 * 1024 * 1024 // 1GB
-
-	if deltaOrig > SafeCap {
-		// log.Printf("[WARN] Huge Origin Delta detected: %d (Flow %d). Ignoring.", deltaOrig, ft.FlowID)
-		deltaOrig = 0
-	}
-	if deltaReply > SafeCap {
-		// log.Printf("[WARN] Huge Reply Delta detected: %d (Flow %d). Ignoring.", deltaReply, ft.FlowID)
-		deltaReply = 0
-	}
+	// Safety Cap: If delta is unreasonably large, it's likely an error
+	deltaOrig = a.capDelta(ft, deltaOrig)
+	deltaReply = a.capDelta(ft, deltaReply)
 
 	// Accumulate totals
 	ft.TotalOriginBytes += deltaOrig
 	ft.TotalReplyBytes += deltaReply
 
+	if a.excludedTags[ft.Tag] || a.translated(ft) {
+		return // Tracked, but kept out of usage totals
+	}
 	a.updateStats(ft, deltaOrig, deltaReply)
 }
 
+// removeFlow deletes a flow from its shard. Caller holds mu and the shard lock.
+func (a *Aggregator) removeFlow(shard *flowShard, key string) {
+	if ft, ok := shard.flows[key]; ok {
+		a.retireElephant(ft)
+		a.archiveFlow(ft, "closed")
+		delete(shard.flows, key)
+		a.flowCount.Add(-1)
+	}
+}
+
+// setState records the latest conntrack state carried by an event
+func (ft *FlowTracker) setState(ev monitor.FlowEvent) {
+	ft.TCPState = ev.TCPState
+	ft.SeenReply = ev.SeenReply
+	ft.Assured = ev.Assured
+	ft.Offload = ev.Offload
+}
+
+// isIgnoredLAN reports whether a flow is LAN-to-LAN traffic that should be skipped
+func (a *Aggregator) isIgnoredLAN(src, dst net.IP, srcMac, dstMac string) bool {
+	if !a.ignoreLAN {
+		return false
+	}
+
+	if len(a.lanSubnets) > 0 {
+		srcInSubnet := false
+		dstInSubnet := false
+
+		for _, sn := range a.lanSubnets {
+			if sn.Contains(src) {
+				srcInSubnet = true
+			}
+			if sn.Contains(dst) {
+				dstInSubnet = true
+			}
+		}
+
+		// Internal traffic, ignore
+		return srcInSubnet && dstInSubnet
+	}
+
+	// Fallback (MAC based check)
+	return srcMac != "" && dstMac != ""
+}
+
+// countNewFlow attributes a newly tracked flow to its clients. Unlike NEW
+// events this works with every traffic source. Caller holds mu.
+func (a *Aggregator) countNewFlow(srcMac, dstMac string) {
+	if srcMac != "" {
+		a.getClient(srcMac).NewFlows++
+	}
+	if dstMac != "" && dstMac != srcMac {
+		a.getClient(dstMac).NewFlows++
+	}
+}
+
+// countConnEvent attributes a conntrack NEW/DESTROY event to global and client counters
+func (a *Aggregator) countConnEvent(ev monitor.FlowEvent) {
+	srcMac := a.macOf(ev.SrcIP.String())
+	dstMac := a.macOf(ev.DstIP.String())
+
+	if a.isIgnoredLAN(ev.SrcIP, ev.DstIP, srcMac, dstMac) {
+		return
+	}
+
+	isNew := ev.Type == monitor.EventNew
+	failed := !isNew && isFailedConn(ev)
+	count := func(c *model.ClientStats) {
+		if isNew {
+			c.NewConnections++
+		} else {
+			c.ClosedConnections++
+		}
+		if failed {
+			c.FailedConnections++
+		}
+	}
+
+	if srcMac != "" {
+		count(a.getClient(srcMac))
+	}
+	if dstMac != "" && dstMac != srcMac {
+		count(a.getClient(dstMac))
+	}
+
+	if isNew {
+		a.globalNewConns++
+		a.recordFanout(srcMac, dstMac, ev.DstIP.String(), ev.DstPort)
+	} else {
+		a.globalClosedConns++
+	}
+	if failed {
+		a.globalFailedConns++
+	}
+}
+
+// isFailedConn reports whether a destroyed flow never became established:
+// unreplied flows (SYN-only, unanswered UDP) and TCP flows that never completed the handshake.
+func isFailedConn(ev monitor.FlowEvent) bool {
+	if !ev.SeenReply {
+		return true
+	}
+	return ev.Proto == 6 && !ev.Assured
+}
+
 func (a *Aggregator) updateStats(ft *FlowTracker, deltaOrig, deltaReply uint64) {
 	// Attribute to clients
 	// If Src is Client: Orig is Upload, Reply is Download
 
 	// If Dst is Client: Orig is Download, Reply is Upload
 
-	srcMac := a.nw.GetMAC(ft.SrcIP)
-	dstMac := a.nw.GetMAC(ft.DstIP)
+	srcMac := a.macOf(ft.SrcIP)
+	dstMac := a.macOf(ft.DstIP)
 
 	isSrcLocal := srcMac != ""
 	isDstLocal := dstMac != "" && dstMac != srcMac
 
+	// Service port is the destination of the original direction
+	category := a.categorize(ft.Proto, ft.DstPort)
+
 	if isSrcLocal {
 		c := a.getClient(srcMac)
 		c.SessionUpload += deltaOrig
 		c.TotalUpload += deltaOrig
 		c.SessionDownload += deltaReply
 		c.TotalDownload += deltaReply
+		addFamilyBytes(c, ft.Family, deltaReply, deltaOrig)
 		c.LastActive = time.Now()
 		// Optimization: Active connections calculated in speed loop
+		if !a.privateMACs[srcMac] {
+			a.addCategoryBytes(srcMac, category, deltaReply, deltaOrig)
+			a.addClassBytes(srcMac, ft.Class, deltaReply, deltaOrig)
+			a.addCountryBytes(srcMac, ft.Country, deltaReply, deltaOrig)
+		}
 	}
 
 	if isDstLocal {
@@ -217,7 +621,13 @@ func (a *Aggregator) updateStats(ft *FlowTracker, deltaOrig, deltaReply uint64)
 		c.TotalDownload += deltaOrig
 		c.SessionUpload += deltaReply
 		c.TotalUpload += deltaReply
+		addFamilyBytes(c, ft.Family, deltaOrig, deltaReply)
 		c.LastActive = time.Now()
+		if !a.privateMACs[dstMac] {
+			a.addCategoryBytes(dstMac, category, deltaOrig, deltaReply)
+			a.addClassBytes(dstMac, ft.Class, deltaOrig, deltaReply)
+			a.addCountryBytes(dstMac, ft.Country, deltaOrig, deltaReply)
+		}
 	}
 
 	// Update Global Stats (Internet Traffic Only)
@@ -225,16 +635,33 @@ func (a *Aggregator) updateStats(ft *FlowTracker, deltaOrig, deltaReply uint64)
 	// If both Local: Internal traffic (ignored for Global)
 	// If neither Local: Routed traffic not involving us (ignored)
 
+	// The router's own traffic is kept out, tunnels would count forwarded traffic twice
+	if srcMac == RouterMAC || dstMac == RouterMAC {
+		return
+	}
+
 	if isSrcLocal && !isDstLocal {
 		// LAN -> WAN
 		// Orig = Upload (Out), Reply = Download (In)
 		a.globalTotalUpload += deltaOrig
 		a.globalTotalDownload += deltaReply
+		a.addGlobalFamilyBytes(ft.Family, deltaReply, deltaOrig)
+		if len(a.markClasses) > 0 {
+			addClass(a.globalClasses, ft.Class, deltaReply, deltaOrig)
+		}
+		a.addZoneBytes(ft.Zone, deltaReply, deltaOrig)
+		addCountry(a.globalCountries, ft.Country, deltaReply, deltaOrig)
 	} else if isDstLocal && !isSrcLocal {
 		// WAN -> LAN
 		// Orig = Download (In), Reply = Upload (Out)
 		a.globalTotalDownload += deltaOrig
 		a.globalTotalUpload += deltaReply
+		a.addGlobalFamilyBytes(ft.Family, deltaOrig, deltaReply)
+		if len(a.markClasses) > 0 {
+			addClass(a.globalClasses, ft.Class, deltaOrig, deltaReply)
+		}
+		a.addZoneBytes(ft.Zone, deltaOrig, deltaReply)
+		addCountry(a.globalCountries, ft.Country, deltaOrig, deltaReply)
 	}
 }
 
@@ -242,42 +669,128 @@ func (a *Aggregator) getClient(mac string) *model.ClientStats {
 	if c, ok := a.clients[mac]; ok {
 		return c
 	}
-
-	name := mac
-	if n, ok := a.staticNames[mac]; ok {
-		name = n
+	if a.maxClients > 0 && len(a.clients) >= a.maxClients {
+		a.makeRoomForClient()
 	}
 
+	now := time.Now()
 	c := &model.ClientStats{
-		MAC:       mac,
-		Name:      name,
-		StartTime: time.Now(),
+		MAC:          mac,
+		Name:         a.resolveName(mac, mac),
+		Tags:         a.deviceTags[mac],
+		Plan:         a.devicePlans[mac],
+		StartTime:    now,
+		SessionStart: now,
+		Private:      a.privateMACs[mac],
 	}
 	a.clients[mac] = c
 	return c
 }
 
+// resolveName picks a display name: static config, then DHCP hostname, then fallback
+func (a *Aggregator) resolveName(mac, fallback string) string {
+	if n, ok := a.staticNames[mac]; ok {
+		return n
+	}
+	if a.leases != nil {
+		if n := a.leases.GetHostname(mac); n != "" {
+			return n
+		}
+	}
+	if a.ubus != nil {
+		if st, ok := a.ubus.Lookup(mac); ok && st.Hostname != "" {
+			return st.Hostname
+		}
+	}
+	if a.names != nil {
+		if n := a.names.Lookup(mac); n != "" {
+			return n
+		}
+	}
+	if w := a.tunnels.Load(); w != nil {
+		if p, ok := w.Peer(mac); ok {
+			return p.Name
+		}
+	}
+	return fallback
+}
+
+// applyStation copies ubus and hostapd metadata into a client, hostapd's
+// association state wins. Caller holds mu.
+func (a *Aggregator) applyStation(c *model.ClientStats) {
+	if a.ubus != nil {
+		st, _ := a.ubus.Lookup(c.MAC)
+		c.Hostname = st.Hostname
+		c.SSID = st.SSID
+		c.APInterface = st.Interface
+		c.Signal = st.Signal
+	}
+	if a.hostapd == nil {
+		return
+	}
+	st, ok := a.hostapd.Lookup(c.MAC)
+	c.Associated = ok
+	c.Band = st.Band
+	if ok {
+		c.SSID = st.SSID
+		c.APInterface = st.Interface
+		if st.Signal != 0 {
+			c.Signal = st.Signal
+		}
+	} else if a.ubus == nil {
+		c.SSID, c.APInterface, c.Signal = "", "", 0
+	}
+}
+
 // Public Methods
 
 func (a *Aggregator) GetGlobalStats() model.GlobalStats {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
+	return a.globalStats()
+}
 
+// globalStats is GetGlobalStats for callers holding mu
+func (a *Aggregator) globalStats() model.GlobalStats {
 	// Sum up speeds
 	var dlSpeed, ulSpeed, conns uint64
 	for _, c := range a.clients {
+		if c.MAC == RouterMAC {
+			continue // Not part of the global totals
+		}
 		dlSpeed += c.DownloadSpeed
 		ulSpeed += c.UploadSpeed
 		conns += c.ActiveConnections
 	}
 
-	return model.GlobalStats{
+	gs := model.GlobalStats{
 		TotalDownload:     a.globalTotalDownload,
 		TotalUpload:       a.globalTotalUpload,
+		TotalDownload4:    a.globalTotalDownload4,
+		TotalUpload4:      a.globalTotalUpload4,
+		TotalDownload6:    a.globalTotalDownload6,
+		TotalUpload6:      a.globalTotalUpload6,
 		DownloadSpeed:     dlSpeed,
 		UploadSpeed:       ulSpeed,
 		ActiveConnections: uint64(a.globalSmoothedConns + 0.5),
+		OffloadedFlows:    a.offloadedFlows,
+		DownloadSpeed1m:   a.globalAvg[0],
+		DownloadSpeed5m:   a.globalAvg[1],
+		DownloadSpeed15m:  a.globalAvg[2],
+		UploadSpeed1m:     a.globalAvg[3],
+		UploadSpeed5m:     a.globalAvg[4],
+		UploadSpeed15m:    a.globalAvg[5],
+		NewConnections:    a.globalNewConns,
+		ClosedConnections: a.globalClosedConns,
+		FailedConnections: a.globalFailedConns,
+		NewConnRate:       a.globalNewConnRate,
+		ClosedConnRate:    a.globalClosedConnRate,
+		FailedConnRate:    a.globalFailedConnRate,
 	}
+	gs.DownloadCapacity, gs.UploadCapacity = a.linkCapacity.Download, a.linkCapacity.Upload
+	gs.DownloadUtilization, gs.UploadUtilization = a.linkCapacity.utilization(dlSpeed, ulSpeed)
+	gs.Paused = a.paused.Load()
+	return gs
 }
 
 func (a *Aggregator) GetClients() []model.ClientStats {
@@ -307,31 +820,183 @@ func (a *Aggregator) Reset() error {
 	a.startTime = time.Now()
 	a.globalTotalDownload = 0
 	a.globalTotalUpload = 0
+	a.globalTotalDownload4, a.globalTotalUpload4 = 0, 0
+	a.globalTotalDownload6, a.globalTotalUpload6 = 0, 0
+	a.globalNewConns = 0
+	a.globalClosedConns = 0
+	a.globalFailedConns = 0
+	a.globalNewConnsLast = 0
+	a.globalClosedConnsLast = 0
+	a.globalFailedConnsLast = 0
+	a.globalNewConnRate = 0
+	a.globalClosedConnRate = 0
+	a.globalFailedConnRate = 0
 	a.clients = make(map[string]*model.ClientStats)
 	// Clear flows
-	a.flows = make(map[string]*FlowTracker)
+	a.clearFlows()
+	a.recentElephants = nil
+	a.archive = nil
+	a.clientCategories = make(map[string]map[string]*model.CategoryStats)
+	a.clientClasses = make(map[string]map[string]*model.ClassStats)
+	a.presence = make(map[string]*presence)
+	a.globalClasses = make(map[string]*model.ClassStats)
+	a.clientCountries = make(map[string]map[string]*model.CountryStats)
+	a.globalCountries = make(map[string]*model.CountryStats)
+	a.zones = make(map[uint16]*model.ZoneStats)
+	a.clientWindows = make(map[string]*speedWindow)
+	a.uploadBaselines = make(map[string]*uploadBaseline)
+	a.fanouts = make(map[string]*fanout)
+	a.planStates = make(map[string]*planState)
+	a.sessionRecords = make(map[string][]model.SessionRecord)
+	a.globalWindow = speedWindow{}
+	a.globalAvg = [6]uint64{}
 	return nil
 }
 
+// RestoreTotals seeds cumulative totals from persisted state (e.g. after a restart)
+func (a *Aggregator) RestoreTotals(startTime time.Time, global model.GlobalStats, clients []model.ClientStats) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.restoreTotals(startTime, global, clients)
+}
+
+// ReplaceTotals is RestoreTotals that also drops the clients missing from
+// clients, so the global totals and the clients come from one snapshot
+// (e.g. an imported backup)
+func (a *Aggregator) ReplaceTotals(startTime time.Time, global model.GlobalStats, clients []model.ClientStats) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	keep := make(map[string]bool, len(clients))
+	for _, c := range clients {
+		keep[c.MAC] = true
+		keep[a.PrimaryMAC(c.MAC)] = true
+	}
+	for mac := range a.clients {
+		if !keep[mac] {
+			a.dropClient(mac)
+		}
+	}
+	a.restoreTotals(startTime, global, clients)
+}
+
+// restoreTotals seeds the totals. Caller holds mu.
+func (a *Aggregator) restoreTotals(startTime time.Time, global model.GlobalStats, clients []model.ClientStats) {
+	a.startTime = startTime
+	a.globalTotalDownload = global.TotalDownload
+	a.globalTotalUpload = global.TotalUpload
+	a.globalTotalDownload4, a.globalTotalUpload4 = global.TotalDownload4, global.TotalUpload4
+	a.globalTotalDownload6, a.globalTotalUpload6 = global.TotalDownload6, global.TotalUpload6
+
+	for _, rc := range clients {
+		// Saved while the router's own traffic was accounted
+		if rc.MAC == RouterMAC && !a.routerTraffic {
+			continue
+		}
+		c := a.getClient(rc.MAC)
+		if _, ok := a.staticNames[rc.MAC]; !ok && rc.Name != "" {
+			c.Name = rc.Name
+		}
+		c.TotalDownload = rc.TotalDownload
+		c.TotalUpload = rc.TotalUpload
+		c.TotalDownload4, c.TotalUpload4 = rc.TotalDownload4, rc.TotalUpload4
+		c.TotalDownload6, c.TotalUpload6 = rc.TotalDownload6, rc.TotalUpload6
+		c.StartTime = rc.StartTime
+		c.LastActive = rc.LastActive
+		// Restored totals are not new traffic, start speeds and averages over
+		c.LastSpeedCalc = time.Time{}
+		delete(a.clientWindows, rc.MAC)
+		delete(a.uploadBaselines, rc.MAC)
+	}
+	a.globalWindow = speedWindow{}
+	a.totalsEpoch.Add(1)
+	// Totals saved before an alias was declared
+	for _, aliases := range a.aliasesOf {
+		for _, alias := range aliases {
+			if _, ok := a.clients[alias]; ok {
+				a.mergeClient(alias, a.PrimaryMAC(alias))
+			}
+		}
+	}
+}
+
+// TotalsEpoch changes whenever totals were replaced or merged rather than
+// grown by traffic, consumers of total deltas re-baseline on a change
+func (a *Aggregator) TotalsEpoch() uint64 {
+	return a.totalsEpoch.Load()
+}
+
 // Start begins the aggregation process
 func (a *Aggregator) Start(interval time.Duration) {
-	go a.processLoop()
-	go a.cleanupAndCalculate(interval)
+	a.mu.Lock()
+	a.interval = interval
+	a.mu.Unlock()
+
+	a.wg.Go(a.processLoop)
+	a.wg.Go(func() { a.cleanupAndCalculate(interval) })
+}
+
+// Stop ends the calculation loop and waits until all pending events are
+// processed. Stop the traffic source first so its event channel closes.
+func (a *Aggregator) Stop() {
+	close(a.stop)
+	a.wg.Wait()
+}
+
+// SetInterval changes the refresh interval of the running aggregator
+func (a *Aggregator) SetInterval(interval time.Duration) {
+	a.mu.Lock()
+	a.interval = interval
+	a.mu.Unlock()
+
+	// Replace any pending change
+	select {
+	case <-a.intervalCh:
+	default:
+	}
+	a.intervalCh <- interval
+}
+
+func (a *Aggregator) GetInterval() time.Duration {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.interval
 }
 
 func (a *Aggregator) cleanupAndCalculate(interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case d := <-a.intervalCh:
+			ticker.Reset(d)
+			continue
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		ctx, span := tracer.Start(context.Background(), "aggregator.tick")
+
 		// 1. Refresh ARP/Neighbors (No cache)
 		a.nw.Refresh()
 
 		// 2. Refresh Subnets (No cache)
 		a.refreshSubnets()
+		a.refreshRouterIPs()
 
 		// 3. Calculate Stats
 		a.calculateSpeedStats()
+
+		took := time.Since(start)
+		a.mu.Lock()
+		a.lastTick, a.tickTook = start, took
+		a.mu.Unlock()
+
+		tickDuration.Record(ctx, took.Seconds())
+		span.End()
 	}
 }
 
@@ -340,12 +1005,12 @@ func (a *Aggregator) refreshSubnets() {
 		return
 	}
 
-	link, err := netlink.LinkByName(a.interfaceName)
+	link, err := monitor.Netlink().LinkByName(a.interfaceName)
 	if err != nil {
 		return
 	}
 
-	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	addrs, err := monitor.Netlink().AddrList(link, netlink.FAMILY_ALL)
 	if err != nil {
 		return
 	}
@@ -368,6 +1033,12 @@ func (a *Aggregator) SetFlowTTL(ttl time.Duration) {
 	a.flowTTL = ttl
 }
 
+func (a *Aggregator) GetFlowTTL() time.Duration {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.flowTTL
+}
+
 func (a *Aggregator) calculateSpeedStats() {
 	// Re-implemented speed calc logic here or use the old logic?
 	// The new updateStats at line 459 was wiping everything? That looks wrong/placeholder.
@@ -375,123 +1046,274 @@ func (a *Aggregator) calculateSpeedStats() {
 	// Since I can't see the old speedCalcLoop, I will assume I need to restore/merge logic.
 
 	a.mu.Lock()
-	defer a.mu.Unlock()
 
 	now := time.Now()
 	// seconds := now.Sub(a.lastCalcTime).Seconds() // Need state?
+	a.rolloverSessions(now)
+
+	present := a.nw.PresentMACs()
+	for mac := range present {
+		present[a.PrimaryMAC(mac)] = true
+	}
+	a.addDiscovered(present)
+	a.addAssociated(present)
+	a.addTunnelPeers(present)
+	minElapsed := time.Duration(a.smoothing.MinElapsedMs) * time.Millisecond
+	window := time.Duration(a.smoothing.WindowSeconds) * time.Second
 
 	// 1. Reset current raw counts for all clients
 	for _, c := range a.clients {
-		c.RawActiveConns = 0
+		c.Online = c.MAC == RouterMAC || present[c.MAC] || now.Sub(c.LastActive) < onlineWindow
+		if a.expired(c, now) {
+			a.evictClient(c.MAC)
+			continue
+		}
+
+		// Pick up DHCP lease changes
+		c.Name = a.resolveName(c.MAC, c.Name)
+		a.applyStation(c)
+		a.applyTunnel(c)
+		a.trackPresence(c, now)
+		// Keep the last known VLAN while the client is out of the neighbor table
+		if vlan := a.nw.VLAN(c.MAC); vlan != 0 {
+			c.VLAN = vlan
+		}
 		// Calculate Speed
 		if c.LastSpeedCalc.IsZero() {
 			c.LastSpeedCalc = now
 			c.TotalUploadLast = c.TotalUpload
 			c.TotalDownloadLast = c.TotalDownload
+			c.NewConnectionsLast = c.NewConnections
+			c.ClosedConnectionsLast = c.ClosedConnections
+			c.FailedConnectionsLast = c.FailedConnections
+			c.NewFlowsLast = c.NewFlows
 			continue
 		}
 
 		duration := now.Sub(c.LastSpeedCalc)
-		if duration.Seconds() >= 0.5 {
-			// Avoid division by zero (shouldn't happen with check above)
+		if duration >= minElapsed {
 			secs := duration.Seconds()
 			c.UploadSpeed = uint64(float64(c.TotalUpload-c.TotalUploadLast) / secs)
 			c.DownloadSpeed = uint64(float64(c.TotalDownload-c.TotalDownloadLast) / secs)
 
 			c.TotalUploadLast = c.TotalUpload
 			c.TotalDownloadLast = c.TotalDownload
+
+			c.NewConnRate = float64(safeSub(c.NewConnections, c.NewConnectionsLast)) / secs
+			c.ClosedConnRate = float64(safeSub(c.ClosedConnections, c.ClosedConnectionsLast)) / secs
+			c.FailedConnRate = float64(safeSub(c.FailedConnections, c.FailedConnectionsLast)) / secs
+			c.NewFlowRate = float64(safeSub(c.NewFlows, c.NewFlowsLast)) / secs
+			c.NewConnectionsLast = c.NewConnections
+			c.ClosedConnectionsLast = c.ClosedConnections
+			c.FailedConnectionsLast = c.FailedConnections
+			c.NewFlowsLast = c.NewFlows
+
 			c.LastSpeedCalc = now
 		}
-	}
 
-	// 2. Count Active Connections (Raw)
-	var globalRawActiveCount uint64
-	for key, f := range a.flows {
-		// Cleanup Timeout (use Configured TTL)
-		ttl := a.flowTTL
-		if ttl <= 0 {
-			ttl = 60 * time.Second
+		// Rolling Averages
+		w, ok := a.clientWindows[c.MAC]
+		if !ok {
+			w = &speedWindow{}
+			a.clientWindows[c.MAC] = w
 		}
-		if now.Sub(f.LastSeen) > ttl {
-			delete(a.flows, key)
-			continue
+		w.add(now, c.TotalDownload, c.TotalUpload)
+		c.DownloadSpeed1m, c.UploadSpeed1m = w.average(1 * time.Minute)
+		c.DownloadSpeed5m, c.UploadSpeed5m = w.average(5 * time.Minute)
+		c.DownloadSpeed15m, c.UploadSpeed15m = w.average(15 * time.Minute)
+		// Optionally report an average instead of the last interval's speed
+		if window > 0 {
+			c.DownloadSpeed, c.UploadSpeed = w.average(window)
 		}
+		a.scoreUpload(c, now)
+		a.checkPlan(c, now)
+	}
+	a.checkFanouts(now)
+	a.expireNAT64Hosts(now)
+	a.collectRTT(now)
+
+	// Global Rolling Averages
+	a.globalWindow.add(now, a.globalTotalDownload, a.globalTotalUpload)
+	a.globalAvg[0], a.globalAvg[3] = a.globalWindow.average(1 * time.Minute)
+	a.globalAvg[1], a.globalAvg[4] = a.globalWindow.average(5 * time.Minute)
+	a.globalAvg[2], a.globalAvg[5] = a.globalWindow.average(15 * time.Minute)
+
+	// Global Connection Rates
+	if a.globalLastRateCalc.IsZero() {
+		a.globalLastRateCalc = now
+		a.globalNewConnsLast = a.globalNewConns
+		a.globalClosedConnsLast = a.globalClosedConns
+		a.globalFailedConnsLast = a.globalFailedConns
+	} else if elapsed := now.Sub(a.globalLastRateCalc); elapsed >= minElapsed {
+		secs := elapsed.Seconds()
+		a.globalNewConnRate = float64(safeSub(a.globalNewConns, a.globalNewConnsLast)) / secs
+		a.globalClosedConnRate = float64(safeSub(a.globalClosedConns, a.globalClosedConnsLast)) / secs
+		a.globalFailedConnRate = float64(safeSub(a.globalFailedConns, a.globalFailedConnsLast)) / secs
+		a.globalNewConnsLast = a.globalNewConns
+		a.globalClosedConnsLast = a.globalClosedConns
+		a.globalFailedConnsLast = a.globalFailedConns
+		a.globalLastRateCalc = now
+	}
 
-		// Calculate Flow Speed
-		if f.SpeedLastCalc.IsZero() {
-			f.SpeedLastCalc = now
-			f.SpeedTotalOriginLast = f.TotalOriginBytes
-			f.SpeedTotalReplyLast = f.TotalReplyBytes
-		} else {
-			fduration := now.Sub(f.SpeedLastCalc)
-			if fduration.Seconds() >= 0.5 {
-				fsecs := fduration.Seconds()
+	a.speedTick++
+	tick := a.speedTick
+	ttls := a.flowTTLs()
+	thresholds := a.elephantThresholds()
+	routerIPs := a.routerIPs
+	services := a.localServices
+	sni := a.sni
+	private := a.privateMACs
+	a.mu.Unlock()
 
-				// Origin Speed (Upload/Download depends on direction)
-				origSpeed := uint64(float64(f.TotalOriginBytes-f.SpeedTotalOriginLast) / fsecs)
-				replySpeed := uint64(float64(f.TotalReplyBytes-f.SpeedTotalReplyLast) / fsecs)
+	// 2. Walk the flow shards without holding mu, so events keep flowing
+	views := make([]flowView, 0, len(a.flowSnapshot()))
+	active := make(map[string]uint64)
+	var offloaded uint64
+	var expired, detected []FlowTracker
+	for i := range a.shards {
+		s := &a.shards[i]
+		s.mu.Lock()
+		for key, f := range s.flows {
+			// Cleanup Timeout (use Configured TTL)
+			if now.Sub(f.LastSeen) > ttls.forProto(f.Proto) {
+				expired = append(expired, *f)
+				delete(s.flows, key)
+				a.flowCount.Add(-1)
+				continue
+			}
 
-				f.OrigSpeed = origSpeed
-				f.ReplySpeed = replySpeed
+			f.updateSpeed(now, minElapsed)
+			f.recordSpeed(tick)
+			if f.Offload {
+				offloaded++
+			}
 
-				f.SpeedTotalOriginLast = f.TotalOriginBytes
-				f.SpeedTotalReplyLast = f.TotalReplyBytes
-				f.SpeedLastCalc = now
+			srcMAC, dstMAC := a.resolveMAC(routerIPs, f.SrcIP), a.resolveMAC(routerIPs, f.DstIP)
+			isPrivate := private[srcMAC] || private[dstMAC]
+			if !isPrivate {
+				if sni != nil && f.SNI == "" && f.DstPort == 443 && now.Sub(f.FirstSeen) < sniLabelWindow {
+					f.SNI = sni.Lookup(f.Proto, f.SrcIP, f.SrcPort, f.DstIP, f.DstPort)
+				}
+				if checkElephant(f, now, thresholds) {
+					detected = append(detected, *f)
+				}
 			}
-		}
 
-		globalRawActiveCount++
+			v := flowView{
+				FlowTracker:  *f,
+				SrcMAC:       srcMAC,
+				DstMAC:       dstMAC,
+				LocalService: localService(routerIPs, services, f),
+				Private:      isPrivate,
+			}
+			if v.SrcMAC != "" {
+				active[v.SrcMAC]++
+			}
+			if v.DstMAC != "" {
+				active[v.DstMAC]++
+			}
+			views = append(views, v)
+		}
+		s.mu.Unlock()
+	}
 
-		srcMac := a.nw.GetMAC(f.SrcIP)
-		dstMac := a.nw.GetMAC(f.DstIP)
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-		if c, ok := a.clients[srcMac]; ok {
-			c.RawActiveConns++
-		}
-		if c, ok := a.clients[dstMac]; ok {
-			c.RawActiveConns++
-		}
+	for i := range expired {
+		a.retireElephant(&expired[i])
+		a.archiveFlow(&expired[i], "expired")
 	}
+	for i := range detected {
+		a.logElephant(&detected[i])
+	}
+	a.publishFlows(views)
+	globalRawActiveCount := uint64(len(views))
+	a.offloadedFlows = offloaded
 
-	// 3. Apply Smoothing (EMA)
-	// Alpha factor (0 < alpha <= 1). smaller = smoother.
-	// Using 0.2 for "Industry Standard" like variance reduction
-	const alpha = 0.2
+	// 3. Apply Smoothing (EMA), smaller alpha is smoother
+	alpha := a.smoothing.Alpha
 
 	for _, c := range a.clients {
-		// Initial: if 0, may be startup.
-		// To avoid "slow ramp up" from 0, if Smoothed is 0, we can seed it with Raw.
-		if c.SmoothedActiveConns == 0 && c.RawActiveConns > 0 {
-			c.SmoothedActiveConns = float64(c.RawActiveConns)
-		} else {
-			c.SmoothedActiveConns = (alpha * float64(c.RawActiveConns)) + ((1 - alpha) * c.SmoothedActiveConns)
-		}
+		c.RawActiveConns = active[c.MAC]
+		c.SmoothedActiveConns = ema(c.SmoothedActiveConns, c.RawActiveConns, alpha)
 		c.ActiveConnections = uint64(c.SmoothedActiveConns + 0.5) // Round
 	}
 
 	// Global Smoothing
-	if a.globalSmoothedConns == 0 && globalRawActiveCount > 0 {
-		a.globalSmoothedConns = float64(globalRawActiveCount)
-	} else {
-		a.globalSmoothedConns = (alpha * float64(globalRawActiveCount)) + ((1 - alpha) * a.globalSmoothedConns)
+	a.globalSmoothedConns = ema(a.globalSmoothedConns, globalRawActiveCount, alpha)
+}
+
+// updateSpeed recalculates the flow speed from its cumulative counters,
+// if at least minElapsed passed since the last calculation
+func (f *FlowTracker) updateSpeed(now time.Time, minElapsed time.Duration) {
+	if f.SpeedLastCalc.IsZero() {
+		f.SpeedLastCalc = now
+		f.SpeedTotalOriginLast = f.TotalOriginBytes
+		f.SpeedTotalReplyLast = f.TotalReplyBytes
+		return
+	}
+
+	fduration := now.Sub(f.SpeedLastCalc)
+	if fduration < minElapsed {
+		return
+	}
+	fsecs := fduration.Seconds()
+
+	// Origin Speed (Upload/Download depends on direction)
+	f.OrigSpeed = uint64(float64(f.TotalOriginBytes-f.SpeedTotalOriginLast) / fsecs)
+	f.ReplySpeed = uint64(float64(f.TotalReplyBytes-f.SpeedTotalReplyLast) / fsecs)
+
+	f.SpeedTotalOriginLast = f.TotalOriginBytes
+	f.SpeedTotalReplyLast = f.TotalReplyBytes
+	f.SpeedLastCalc = now
+}
+
+// GetFlowRecords returns all tracked flows with cumulative counters
+func (a *Aggregator) GetFlowRecords() []model.FlowRecord {
+	flows := a.flowSnapshot()
+	list := make([]model.FlowRecord, 0, len(flows))
+	for i := range flows {
+		f := &flows[i]
+		if f.Private {
+			continue
+		}
+		list = append(list, f.record())
+	}
+	return list
+}
+
+// record is the flow as exported
+func (f *FlowTracker) record() model.FlowRecord {
+	return model.FlowRecord{
+		Key:         f.Key,
+		SrcIP:       f.SrcIP,
+		DstIP:       f.DstIP,
+		SrcPort:     f.SrcPort,
+		DstPort:     f.DstPort,
+		Proto:       f.Proto,
+		OriginBytes: f.TotalOriginBytes,
+		ReplyBytes:  f.TotalReplyBytes,
+		FirstSeen:   f.FirstSeen,
+		LastSeen:    f.LastSeen,
 	}
 }
 
 // Additional methods for Client Detail API
 func (a *Aggregator) GetFlowsByMAC(mac string) ([]model.FlowDetail, int, []string) {
-	a.mu.RLock()
-	defer a.mu.RUnlock()
-
 	var flows []model.FlowDetail
 	var ips []string
 	ipSet := make(map[string]struct{}) // Use a set to collect unique IPs
 
 	// Temporary map for aggregation
-	// Key: Proto + RemoteIP + RemotePort
+	// Key: Proto + RemoteIP + RemotePort, port-less protocols (GRE, ESP, ...)
+	// also by LocalIP since several tunnels to one remote are told apart only by it
 	type aggKey struct {
 		Proto      uint8
 		RemoteIP   string
 		RemotePort uint16
+		LocalIP    string
+		Zone       uint16
 	}
 	type aggVal struct {
 		TotalDownload   uint64
@@ -502,13 +1324,25 @@ func (a *Aggregator) GetFlowsByMAC(mac string) ([]model.FlowDetail, int, []strin
 		UploadSpeed     uint64
 		ActiveConns     int
 		LocalIP         string
+		Tag             string
+		SNI             string
+		LocalService    string
+		History         speedHistory
 		FirstSeen       time.Time
 		LastSeen        time.Time
+		TCPState        uint8
+		ProtoInfo       string
+		Assured         bool
+		SeenReply       bool
+		Offload         bool
 	}
 
 	aggregated := make(map[aggKey]*aggVal)
+	var privateConns int
 
-	for _, f := range a.flows {
+	snapshot := a.flowSnapshot()
+	for i := range snapshot {
+		f := &snapshot[i]
 		// Calculate stats for this flow first
 		isSrc := false
 		isDst := false
@@ -516,13 +1350,10 @@ func (a *Aggregator) GetFlowsByMAC(mac string) ([]model.FlowDetail, int, []strin
 		// Identify if this flow belongs to the requested MAC
 		// And determine local/remote perspective
 
-		srcMac := a.nw.GetMAC(f.SrcIP)
-		dstMac := a.nw.GetMAC(f.DstIP)
-
-		if srcMac == mac {
+		if f.SrcMAC == mac {
 			isSrc = true
 		}
-		if dstMac == mac {
+		if f.DstMAC == mac {
 			isDst = true
 		}
 
@@ -538,6 +1369,10 @@ func (a *Aggregator) GetFlowsByMAC(mac string) ([]model.FlowDetail, int, []strin
 		if isDst {
 			ipSet[f.DstIP] = struct{}{}
 		}
+		if f.Private {
+			privateConns++ // Only counted, remote ends are not exposed
+			continue
+		}
 
 		// Determine Remote Tuple and Local IP
 		var remoteIP string
@@ -575,6 +1410,10 @@ func (a *Aggregator) GetFlowsByMAC(mac string) ([]model.FlowDetail, int, []strin
 			Proto:      f.Proto,
 			RemoteIP:   remoteIP,
 			RemotePort: remotePort,
+			Zone:       f.Zone,
+		}
+		if !hasPorts(f.Proto) {
+			k.LocalIP = localIP
 		}
 
 		val, exists := aggregated[k]
@@ -583,6 +1422,7 @@ func (a *Aggregator) GetFlowsByMAC(mac string) ([]model.FlowDetail, int, []strin
 				FirstSeen: f.FirstSeen,
 				LastSeen:  f.LastSeen,
 				LocalIP:   localIP,
+				Tag:       f.Tag,
 			}
 			aggregated[k] = val
 		}
@@ -609,18 +1449,48 @@ func (a *Aggregator) GetFlowsByMAC(mac string) ([]model.FlowDetail, int, []strin
 		if f.FirstSeen.Before(val.FirstSeen) {
 			val.FirstSeen = f.FirstSeen
 		}
-		if f.LastSeen.After(val.LastSeen) {
+		if !f.LastSeen.Before(val.LastSeen) {
 			val.LastSeen = f.LastSeen
+			val.TCPState = f.TCPState
+			val.ProtoInfo = f.protoInfo()
+		}
+		val.Assured = val.Assured || f.Assured
+		val.SeenReply = val.SeenReply || f.SeenReply
+		val.Offload = val.Offload || f.Offload
+		if val.SNI == "" {
+			val.SNI = f.SNI
+		}
+		if val.LocalService == "" {
+			val.LocalService = f.LocalService
+		}
+		if f.History != nil {
+			val.History.add(a.ringOf(f.Key), !isSrc)
 		}
 	}
 
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	ttls := a.flowTTLs()
+
 	// Convert Map to Slice
 	var totalActiveConns int
 	for k, v := range aggregated {
+		downHistory, upHistory := v.History.series()
+		var gi geo.Info
+		if a.geo != nil {
+			gi = a.geo.Lookup(k.RemoteIP)
+		}
 		flows = append(flows, model.FlowDetail{
 			Protocol:          getProtocolName(k.Proto),
 			ClientIP:          v.LocalIP,
 			RemoteIP:          k.RemoteIP,
+			RemoteHostname:    a.remoteHostname(k.RemoteIP),
+			SNI:               v.SNI,
+			Service:           cmp.Or(v.LocalService, sniService(v.SNI)),
+			RemoteCountry:     gi.Country,
+			RemoteASN:         gi.ASN,
+			RemoteOrg:         gi.Org,
 			RemotePort:        k.RemotePort,
 			TotalDownload:     v.TotalDownload,
 			TotalUpload:       v.TotalUpload,
@@ -630,11 +1500,24 @@ func (a *Aggregator) GetFlowsByMAC(mac string) ([]model.FlowDetail, int, []strin
 			UploadSpeed:       v.UploadSpeed,
 			ActiveConnections: uint64(v.ActiveConns),
 			Duration:          uint64(v.LastSeen.Sub(v.FirstSeen).Seconds()),
-			TTLRemaining:      int(a.flowTTL.Seconds() - time.Since(v.LastSeen).Seconds()),
+			TTLRemaining:      int(ttls.forProto(k.Proto).Seconds() - time.Since(v.LastSeen).Seconds()),
+			TCPState:          getTCPStateName(k.Proto, v.TCPState),
+			ProtoInfo:         v.ProtoInfo,
+			Assured:           v.Assured,
+			SeenReply:         v.SeenReply,
+			Offload:           v.Offload,
+			Tag:               v.Tag,
+			Excluded:          a.excludedTags[v.Tag],
+			Zone:              k.Zone,
+			RTT:               a.remoteRTT[mac][k.RemoteIP].median(),
+			DownloadHistory:   downHistory,
+			UploadHistory:     upHistory,
 		})
 		totalActiveConns += v.ActiveConns
 	}
 
+	totalActiveConns += privateConns
+
 	// Convert IP set to slice
 	for ip := range ipSet {
 		ips = append(ips, ip)
@@ -660,21 +1543,18 @@ func (a *Aggregator) ResetClientByMAC(mac string) error {
 
 	// Delete Client
 	delete(a.clients, mac)
+	delete(a.clientCategories, mac)
+	delete(a.clientClasses, mac)
+	delete(a.clientCountries, mac)
+	delete(a.clientWindows, mac)
+	delete(a.uploadBaselines, mac)
+	delete(a.fanouts, mac)
+	delete(a.planStates, mac)
+	delete(a.presence, mac)
+	a.dropArchived(mac)
 
 	// Delete Flows
-	var flowsToDelete []string
-	for k, f := range a.flows {
-		srcMac := a.nw.GetMAC(f.SrcIP)
-		dstMac := a.nw.GetMAC(f.DstIP)
-
-		if srcMac == mac || dstMac == mac {
-			flowsToDelete = append(flowsToDelete, k)
-		}
-	}
-
-	for _, k := range flowsToDelete {
-		delete(a.flows, k)
-	}
+	a.dropFlows(mac)
 
 	return nil
 }
@@ -685,27 +1565,14 @@ func (a *Aggregator) ResetSessionByMAC(mac string) error {
 
 	// Reset Client Session Stats
 	if c, ok := a.clients[mac]; ok {
-		c.SessionDownload = 0
-		c.SessionUpload = 0
+		a.closeSession(c, time.Now(), "manual")
 	}
 
 	// Reset Flow Session Offsets for this client
 	// User requested to "Clear/Recalculate", so we delete the flow trackers.
 	// Active flows will be recreated on next event with Duration=0 and Session=0.
 	// Inactive flows will just vanish.
-	var flowsToDelete []string
-	for k, f := range a.flows {
-		srcMac := a.nw.GetMAC(f.SrcIP)
-		dstMac := a.nw.GetMAC(f.DstIP)
-
-		if srcMac == mac || dstMac == mac {
-			flowsToDelete = append(flowsToDelete, k)
-		}
-	}
-
-	for _, k := range flowsToDelete {
-		delete(a.flows, k)
-	}
+	a.dropFlows(mac)
 
 	return nil
 }
@@ -720,30 +1587,151 @@ func getProtocolName(p uint8) string {
 		return "ICMP"
 	case 58:
 		return "ICMP"
+	case 47:
+		return "GRE"
+	case 50:
+		return "ESP"
+	case 51:
+		return "AH"
+	case 132:
+		return "SCTP"
 	default:
 		return fmt.Sprintf("%d", p)
 	}
 }
 
+// getTCPStateName maps conntrack TCP states (nf_conntrack_tcp.h) to names
+func getTCPStateName(proto, state uint8) string {
+	if proto != 6 {
+		return ""
+	}
+	switch state {
+	case 0:
+		return "NONE"
+	case 1:
+		return "SYN_SENT"
+	case 2:
+		return "SYN_RECV"
+	case 3:
+		return "ESTABLISHED"
+	case 4:
+		return "FIN_WAIT"
+	case 5:
+		return "CLOSE_WAIT"
+	case 6:
+		return "LAST_ACK"
+	case 7:
+		return "TIME_WAIT"
+	case 8:
+		return "CLOSE"
+	case 9:
+		return "SYN_SENT2"
+	default:
+		return fmt.Sprintf("%d", state)
+	}
+}
+
+// SetDeviceNames replaces the static names. Only clients whose name was
+// added, changed or removed are touched; removed ones fall back to DHCP or MAC.
 func (a *Aggregator) SetDeviceNames(names map[string]string) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	a.staticNames = make(map[string]string)
+	old := a.staticNames
+	a.staticNames = make(map[string]string, len(names))
 	for k, v := range names {
 		a.staticNames[strings.ToLower(k)] = v
 	}
 
-	// Update existing clients
 	for mac, c := range a.clients {
-		if name, ok := a.staticNames[mac]; ok {
+		name, ok := a.staticNames[mac]
+		prev, had := old[mac]
+		switch {
+		case ok && (!had || name != prev):
 			c.Name = name
+		case !ok && had:
+			c.Name = a.resolveName(mac, mac)
 		}
 	}
 }
 
+// SetDNSWatcher enables remote hostname labeling of flows
+func (a *Aggregator) SetDNSWatcher(w *dnswatch.Watcher) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dns = w
+}
+
+// The ClientHello is the first packet of a connection, older flows without a
+// server name won't get one
+const sniLabelWindow = time.Minute
+
+// SetSNIWatcher enables labeling flows with the server name clients connect to
+func (a *Aggregator) SetSNIWatcher(w *sniwatch.Watcher) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sni = w
+}
+
+// sniService reduces a server name to its registrable domain, so
+// "ipv4-c001.1.oca.nflxvideo.net" reads as "nflxvideo.net"
+func sniService(sni string) string {
+	if sni == "" {
+		return ""
+	}
+	if d, err := publicsuffix.EffectiveTLDPlusOne(sni); err == nil {
+		return d
+	}
+	return sni
+}
+
+func (a *Aggregator) remoteHostname(ip string) string {
+	if a.dns == nil {
+		return ""
+	}
+	return a.dns.Lookup(ip)
+}
+
+// SetGeoResolver enables GeoIP enrichment of flows
+func (a *Aggregator) SetGeoResolver(r *geo.Resolver) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.geo = r
+}
+
+// SetLeaseWatcher enables hostname resolution from DHCP leases
+func (a *Aggregator) SetLeaseWatcher(lw *monitor.LeaseWatcher) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.leases = lw
+}
+
+// SetNameResolver enables naming unnamed clients by probing them, nil disables it
+func (a *Aggregator) SetNameResolver(r *monitor.NameResolver) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.names = r
+}
+
+// SetUbusWatcher enables device metadata from OpenWrt's ubus
+func (a *Aggregator) SetUbusWatcher(w *openwrt.Watcher) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.ubus = w
+}
+
+// SetInterface limits LAN detection to an interface's subnets, "" watches all
 func (a *Aggregator) SetInterface(ifaceName string) error {
-	link, err := netlink.LinkByName(ifaceName)
+	if ifaceName == "" {
+		a.mu.Lock()
+		a.interfaceName = ""
+		a.interfaceIndex = 0
+		a.lanSubnets = nil
+		a.mu.Unlock()
+		return nil
+	}
+
+	link, err := monitor.Netlink().LinkByName(ifaceName)
 	if err != nil {
 		return err
 	}
@@ -754,12 +1742,12 @@ func (a *Aggregator) SetInterface(ifaceName string) error {
 
 	// Fetch Subnets
 	a.lanSubnets = nil
-	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	addrs, err := monitor.Netlink().AddrList(link, netlink.FAMILY_ALL)
 	if err == nil {
 		for _, addr := range addrs {
 			if addr.IPNet != nil {
 				a.lanSubnets = append(a.lanSubnets, *addr.IPNet)
-				fmt.Printf("[Info] Detected LAN Subnet: %s\n", addr.IPNet.String())
+				slog.Info("Detected LAN subnet", "subnet", addr.IPNet.String())
 			}
 		}
 	}
@@ -781,14 +1769,14 @@ func (a *Aggregator) checkFlowSubnet(src, dst net.IP) bool {
 		return true // No filtering
 	}
 
-	// Helper to check if IP is in any LAN subnet
+	// Helper to check if IP is in any LAN subnet, VPN peers count as LAN
 	inSubnet := func(ip net.IP) bool {
 		for _, sn := range a.lanSubnets {
 			if sn.Contains(ip) {
 				return true
 			}
 		}
-		return false
+		return a.tunnelPeer(ip.String()) != ""
 	}
 
 	return inSubnet(src) || inSubnet(dst)
@@ -0,0 +1,170 @@
+package stats
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+)
+
+// SetAliases merges clients with several MACs (Wi-Fi and dock Ethernet,
+// randomized MACs) into one: traffic of an alias is accounted to its primary
+// MAC. Totals already collected for an alias are folded into the primary.
+func (a *Aggregator) SetAliases(aliases map[string][]string) error {
+	byAlias, byPrimary, err := parseAliases(aliases)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.aliases.Store(&byAlias)
+	a.aliasesOf = byPrimary
+	for alias, primary := range byAlias {
+		if _, ok := a.clients[alias]; ok {
+			a.mergeClient(alias, primary)
+		}
+	}
+	// Plans may have been set on a MAC that is an alias now
+	a.devicePlans = a.primaryPlans(a.devicePlans)
+	a.applyDevicePlans()
+	return nil
+}
+
+// ValidateAliases checks aliases as SetAliases does, without applying them
+func ValidateAliases(aliases map[string][]string) error {
+	_, _, err := parseAliases(aliases)
+	return err
+}
+
+// parseAliases normalizes the MACs and indexes aliases both ways
+func parseAliases(aliases map[string][]string) (map[string]string, map[string][]string, error) {
+	byAlias := make(map[string]string)
+	byPrimary := make(map[string][]string, len(aliases))
+	for primary, list := range aliases {
+		hw, err := net.ParseMAC(primary)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid alias primary MAC %q", primary)
+		}
+		primary = hw.String()
+		for _, alias := range list {
+			hw, err := net.ParseMAC(alias)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid alias MAC %q", alias)
+			}
+			alias = hw.String()
+			if alias == primary {
+				continue
+			}
+			if other, ok := byAlias[alias]; ok && other != primary {
+				return nil, nil, fmt.Errorf("MAC %s is an alias of both %s and %s", alias, other, primary)
+			}
+			byAlias[alias] = primary
+			byPrimary[primary] = append(byPrimary[primary], alias)
+		}
+	}
+	for primary := range byPrimary {
+		if _, ok := byAlias[primary]; ok {
+			return nil, nil, fmt.Errorf("MAC %s is both a primary and an alias", primary)
+		}
+		slices.Sort(byPrimary[primary])
+	}
+	return byAlias, byPrimary, nil
+}
+
+// GetAliases returns the effective aliases, primary MAC -> alias MACs
+func (a *Aggregator) GetAliases() map[string][]string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return maps.Clone(a.aliasesOf)
+}
+
+// ClientAliases returns the alias MACs merged into a client
+func (a *Aggregator) ClientAliases(mac string) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.Clone(a.aliasesOf[mac])
+}
+
+// ClientIPs returns the addresses currently bound to a client and its aliases
+func (a *Aggregator) ClientIPs(mac string) []string {
+	ips := a.nw.IPs(mac)
+	for _, alias := range a.ClientAliases(mac) {
+		ips = append(ips, a.nw.IPs(alias)...)
+	}
+	return ips
+}
+
+// PrimaryMAC maps an alias to the client it belongs to, other MACs are
+// returned as is
+func (a *Aggregator) PrimaryMAC(mac string) string {
+	if p := a.aliases.Load(); p != nil {
+		if primary, ok := (*p)[mac]; ok {
+			return primary
+		}
+	}
+	return mac
+}
+
+// mergeClient folds the state of client alias into primary. Caller holds mu.
+func (a *Aggregator) mergeClient(alias, primary string) {
+	from := a.clients[alias]
+	to := a.getClient(primary)
+	slog.Info("Merging client into primary MAC", "alias", alias, "mac", primary, "name", to.Name)
+
+	to.TotalDownload += from.TotalDownload
+	to.TotalUpload += from.TotalUpload
+	to.TotalDownload4 += from.TotalDownload4
+	to.TotalUpload4 += from.TotalUpload4
+	to.TotalDownload6 += from.TotalDownload6
+	to.TotalUpload6 += from.TotalUpload6
+	to.SessionDownload += from.SessionDownload
+	to.SessionUpload += from.SessionUpload
+	to.NewConnections += from.NewConnections
+	to.ClosedConnections += from.ClosedConnections
+	to.FailedConnections += from.FailedConnections
+	to.NewFlows += from.NewFlows
+	// Keep rates continuous, the folded totals are not new traffic
+	to.TotalDownloadLast += from.TotalDownload
+	to.TotalUploadLast += from.TotalUpload
+	to.NewConnectionsLast += from.NewConnections
+	to.ClosedConnectionsLast += from.ClosedConnections
+	to.FailedConnectionsLast += from.FailedConnections
+	to.NewFlowsLast += from.NewFlows
+	if from.StartTime.Before(to.StartTime) {
+		to.StartTime = from.StartTime
+	}
+	if from.SessionStart.Before(to.SessionStart) {
+		to.SessionStart = from.SessionStart
+	}
+	if from.LastActive.After(to.LastActive) {
+		to.LastActive = from.LastActive
+	}
+
+	for _, cs := range a.clientCategories[alias] {
+		a.addCategoryBytes(primary, cs.Category, cs.TotalDownload, cs.TotalUpload)
+	}
+	for _, cs := range a.clientClasses[alias] {
+		a.addClassBytes(primary, cs.Class, cs.TotalDownload, cs.TotalUpload)
+	}
+	for _, cs := range a.clientCountries[alias] {
+		a.addCountryBytes(primary, cs.Country, cs.TotalDownload, cs.TotalUpload)
+	}
+	for i := range a.archive {
+		if a.archive[i].MAC == alias {
+			a.archive[i].MAC = primary
+		}
+	}
+
+	a.totalsEpoch.Add(1)
+	delete(a.clients, alias)
+	delete(a.clientWindows, alias)
+	delete(a.uploadBaselines, alias)
+	delete(a.fanouts, alias)
+	delete(a.planStates, alias)
+	delete(a.clientCategories, alias)
+	delete(a.clientClasses, alias)
+	delete(a.clientCountries, alias)
+	delete(a.presence, alias)
+}
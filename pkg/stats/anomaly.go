@@ -0,0 +1,183 @@
+package stats
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/units"
+)
+
+// Each minute of a client's upload is scored against its own baseline, an
+// exponentially weighted mean and variance of the previous minutes
+const (
+	anomalyBucket = time.Minute
+	anomalyAlpha  = 0.02             // Baseline weight of a minute, about an hour of memory
+	anomalyWarmup = 30               // Minutes of baseline before a client is scored
+	anomalyMinDev = 256 * 1024       // Deviation floor in bytes, so near-idle clients don't score on noise
+	anomalyHold   = 15 * time.Minute // Offenders stay listed this long after their last flagged minute
+
+	defaultAnomalyThreshold = 6                // Standard deviations above the baseline
+	defaultAnomalyMinUpload = 20 * 1024 * 1024 // Bytes per minute, smaller uploads are never flagged
+)
+
+// uploadBaseline is the upload history of one client
+type uploadBaseline struct {
+	start      time.Time // Current bucket
+	upStart    uint64    // Client totals at start
+	downStart  uint64
+	minutes    int // Buckets folded into the baseline
+	upMean     float64
+	upVar      float64
+	downMean   float64
+	upload     uint64 // Last completed bucket, per minute
+	download   uint64
+	score      float64
+	peak       float64 // Highest score of the current streak
+	reasons    []string
+	since      time.Time // Start of the current streak, zero if never flagged
+	lastFlag   time.Time
+	flaggedNow bool
+}
+
+// SetAnomalyThresholds configures upload anomaly detection: a minute is
+// flagged when its upload is at least minUpload bytes and threshold standard
+// deviations above the client's baseline. Zero values use the defaults.
+func (a *Aggregator) SetAnomalyThresholds(threshold float64, minUpload uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if threshold <= 0 {
+		threshold = defaultAnomalyThreshold
+	}
+	if minUpload == 0 {
+		minUpload = defaultAnomalyMinUpload
+	}
+	a.anomalyThreshold = threshold
+	a.anomalyMinUpload = minUpload
+}
+
+// scoreUpload updates the client's upload ratio and, once a minute, its
+// anomaly score. Caller holds mu.
+func (a *Aggregator) scoreUpload(c *model.ClientStats, now time.Time) {
+	c.UploadRatio = ratio(c.UploadSpeed15m, c.DownloadSpeed15m)
+
+	b, ok := a.uploadBaselines[c.MAC]
+	if !ok {
+		a.uploadBaselines[c.MAC] = &uploadBaseline{start: now, upStart: c.TotalUpload, downStart: c.TotalDownload}
+		return
+	}
+	elapsed := now.Sub(b.start)
+	if elapsed < anomalyBucket {
+		return
+	}
+
+	// Per minute, in case ticks were delayed
+	minutes := elapsed.Minutes()
+	up := float64(safeSub(c.TotalUpload, b.upStart)) / minutes
+	down := float64(safeSub(c.TotalDownload, b.downStart)) / minutes
+	b.start, b.upStart, b.downStart = now, c.TotalUpload, c.TotalDownload
+	b.upload, b.download = uint64(up), uint64(down)
+
+	if b.minutes == 0 {
+		b.upMean, b.downMean = up, down
+	}
+
+	b.score = 0
+	b.flaggedNow = false
+	if b.minutes >= anomalyWarmup {
+		dev := max(math.Sqrt(b.upVar), b.upMean/4, anomalyMinDev)
+		b.score = max((up-b.upMean)/dev, 0)
+		if b.score >= a.anomalyThreshold && b.upload >= a.anomalyMinUpload {
+			a.flagUpload(c, b, now)
+		}
+	}
+	c.AnomalyScore = math.Round(b.score*10) / 10
+
+	// Offending minutes barely move the baseline and not its spread, so
+	// ongoing exfiltration isn't learned as normal within the hour
+	diff := up - b.upMean
+	if b.flaggedNow {
+		b.upMean += anomalyAlpha / 10 * diff
+	} else {
+		b.upMean += anomalyAlpha * diff
+		b.upVar = (1 - anomalyAlpha) * (b.upVar + anomalyAlpha*diff*diff)
+	}
+	b.downMean += anomalyAlpha * (down - b.downMean)
+	b.minutes++
+}
+
+// flagUpload records an offending minute. Caller holds mu.
+func (a *Aggregator) flagUpload(c *model.ClientStats, b *uploadBaseline, now time.Time) {
+	if b.since.IsZero() || now.Sub(b.lastFlag) > anomalyHold {
+		b.since = now
+		b.peak = 0
+		slog.Warn("Upload anomaly detected", "mac", c.MAC, "name", c.Name, "score", math.Round(b.score*10)/10,
+			"upload", b.upload, "baseline", uint64(b.upMean))
+	}
+	b.flaggedNow = true
+	b.lastFlag = now
+	b.peak = max(b.peak, b.score)
+
+	b.reasons = []string{fmt.Sprintf("uploaded %s in a minute, %.0fx the baseline of %s",
+		units.Bytes(b.upload), float64(b.upload)/max(b.upMean, 1), units.Bytes(uint64(b.upMean)))}
+	// Mostly downloading devices that suddenly send more than they receive
+	if r, base := b.ratio(), b.baselineRatio(); r > 1 && r >= 4*base {
+		b.reasons = append(b.reasons, fmt.Sprintf("upload/download ratio %.1f, baseline %.2f", r, base))
+	}
+	if b.minutes < 24*60 {
+		b.reasons = append(b.reasons, fmt.Sprintf("baseline only %d minutes old", b.minutes))
+	}
+}
+
+// GetAnomalies returns the clients flagged within the hold time, highest score first
+func (a *Aggregator) GetAnomalies() []model.Anomaly {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	now := time.Now()
+	anomalies := make([]model.Anomaly, 0)
+	for mac, b := range a.uploadBaselines {
+		if b.since.IsZero() || now.Sub(b.lastFlag) > anomalyHold {
+			continue
+		}
+		name := mac
+		if c, ok := a.clients[mac]; ok {
+			name = c.Name
+		}
+		anomalies = append(anomalies, model.Anomaly{
+			MAC:            mac,
+			Name:           name,
+			Score:          math.Round(b.peak*10) / 10,
+			Upload:         b.upload,
+			Download:       b.download,
+			BaselineUpload: uint64(b.upMean),
+			UploadRatio:    math.Round(b.ratio()*100) / 100,
+			BaselineRatio:  math.Round(b.baselineRatio()*100) / 100,
+			Reasons:        b.reasons,
+			Since:          b.since,
+			LastSeen:       b.lastFlag,
+			Active:         b.flaggedNow,
+		})
+	}
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].Score > anomalies[j].Score })
+	return anomalies
+}
+
+// ratio is up/down of speeds, with download floored at 1 KiB/s so idle
+// clients don't produce huge ratios
+func ratio(up, down uint64) float64 {
+	return float64(up) / float64(max(down, 1024))
+}
+
+// ratio is up/down in the last bucket
+func (b *uploadBaseline) ratio() float64 {
+	return ratio(b.upload/60, b.download/60)
+}
+
+// baselineRatio is the typical up/down
+func (b *uploadBaseline) baselineRatio() float64 {
+	return ratio(uint64(b.upMean/60), uint64(b.downMean/60))
+}
@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"github.com/kisy/catchmole/model"
+)
+
+// Keep this many finished flows by default
+const defaultArchiveSize = 5000
+
+// Bound of the finished flows waiting for TakeEndedFlows
+const maxEndedFlows = 65536
+
+// SetArchiveSize sets how many finished flows are kept, 0 uses the default
+func (a *Aggregator) SetArchiveSize(n int) {
+	if n <= 0 {
+		n = defaultArchiveSize
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.archiveSize = n
+	if len(a.archive) > n {
+		a.archive = append([]model.ArchivedFlow(nil), a.archive[len(a.archive)-n:]...)
+	}
+}
+
+// archiveFlow records a flow leaving the table. Caller holds mu.
+func (a *Aggregator) archiveFlow(f *FlowTracker, reason string) {
+	if a.isPrivateFlow(f) {
+		return
+	}
+	if a.trackEnded && len(a.endedFlows) < maxEndedFlows {
+		a.endedFlows = append(a.endedFlows, f.record())
+	}
+	e := a.elephantView(f) // Client perspective and name
+	rec := model.ArchivedFlow{
+		MAC:            e.MAC,
+		Name:           e.Name,
+		Protocol:       e.Protocol,
+		ProtoInfo:      f.protoInfo(),
+		ClientIP:       e.ClientIP,
+		ClientPort:     e.ClientPort,
+		RemoteIP:       e.RemoteIP,
+		RemotePort:     e.RemotePort,
+		RemoteHostname: a.remoteHostname(e.RemoteIP),
+		SNI:            f.SNI,
+		Service:        sniService(f.SNI),
+		Tag:            f.Tag,
+		TotalDownload:  e.TotalDownload,
+		TotalUpload:    e.TotalUpload,
+		FirstSeen:      f.FirstSeen,
+		LastSeen:       f.LastSeen,
+		Duration:       uint64(f.LastSeen.Sub(f.FirstSeen).Seconds()),
+		Reason:         reason,
+	}
+
+	a.archive = append(a.archive, rec)
+	if len(a.archive) > a.archiveSize {
+		a.archive = a.archive[len(a.archive)-a.archiveSize:]
+	}
+}
+
+// TrackEndedFlows keeps the final counters of flows leaving the table for
+// TakeEndedFlows, so exporters polling GetFlowRecords don't lose the traffic
+// of the flows' last interval
+func (a *Aggregator) TrackEndedFlows() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.trackEnded = true
+}
+
+// TakeEndedFlows returns the flows that left the table since the last call
+func (a *Aggregator) TakeEndedFlows() []model.FlowRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ended := a.endedFlows
+	a.endedFlows = nil
+	return ended
+}
+
+// dropArchived forgets the finished flows of a client. Caller holds mu.
+func (a *Aggregator) dropArchived(mac string) {
+	kept := a.archive[:0]
+	for _, rec := range a.archive {
+		if rec.MAC != mac {
+			kept = append(kept, rec)
+		}
+	}
+	clear(a.archive[len(kept):])
+	a.archive = kept
+}
+
+// GetArchivedFlows returns finished flows newest first, of one client if mac
+// is set. limit 0 returns all of them.
+func (a *Aggregator) GetArchivedFlows(mac string, limit int) []model.ArchivedFlow {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	flows := make([]model.ArchivedFlow, 0)
+	for i := len(a.archive) - 1; i >= 0; i-- {
+		if limit > 0 && len(flows) >= limit {
+			break
+		}
+		if mac == "" || a.archive[i].MAC == mac {
+			flows = append(flows, a.archive[i])
+		}
+	}
+	return flows
+}
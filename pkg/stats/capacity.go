@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// linkCapacity is the speed of an uplink in bytes/sec, 0 if unknown
+type linkCapacity struct {
+	Download uint64
+	Upload   uint64
+}
+
+// utilization returns the share of the capacity in use, in percent
+func (l linkCapacity) utilization(download, upload uint64) (float64, float64) {
+	return percentOf(download, l.Download), percentOf(upload, l.Upload)
+}
+
+func percentOf(v, capacity uint64) float64 {
+	if capacity == 0 {
+		return 0
+	}
+	return float64(v) * 100 / float64(capacity)
+}
+
+// parseCapacity parses "downlink/uplink" in Mbps, e.g. "500/50"
+func parseCapacity(spec string) (linkCapacity, error) {
+	down, up, ok := strings.Cut(spec, "/")
+	if !ok {
+		return linkCapacity{}, fmt.Errorf("invalid link capacity %q (want down/up in Mbps, e.g. 500/50)", spec)
+	}
+	var l linkCapacity
+	for i, v := range []string{down, up} {
+		mbps, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		// Also rejects NaN, a capacity below 1 byte/s and one beyond uint64
+		if err != nil || !(mbps*1e6/8 >= 1 && mbps*1e6/8 < math.MaxUint64) {
+			return linkCapacity{}, fmt.Errorf("invalid link capacity %q (want down/up in Mbps, e.g. 500/50)", spec)
+		}
+		bps := uint64(mbps * 1e6 / 8)
+		if i == 0 {
+			l.Download = bps
+		} else {
+			l.Upload = bps
+		}
+	}
+	return l, nil
+}
+
+// SetLinkCapacity sets the WAN capacity as "down/up" in Mbps (empty = unknown)
+// and optionally per conntrack zone, for utilization percentages
+func (a *Aggregator) SetLinkCapacity(total string, zones map[string]string) error {
+	var global linkCapacity
+	if total != "" {
+		var err error
+		if global, err = parseCapacity(total); err != nil {
+			return err
+		}
+	}
+	perZone := make(map[uint16]linkCapacity, len(zones))
+	for spec, capacity := range zones {
+		zone, err := strconv.ParseUint(strings.TrimSpace(spec), 0, 16)
+		if err != nil {
+			return fmt.Errorf("invalid zone %q", spec)
+		}
+		if perZone[uint16(zone)], err = parseCapacity(capacity); err != nil {
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.linkCapacity = global
+	a.zoneCapacity = perZone
+	return nil
+}
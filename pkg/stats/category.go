@@ -0,0 +1,120 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// Traffic not matching any port group
+const otherCategory = "other"
+
+type portRange struct {
+	From uint16
+	To   uint16
+}
+
+type portGroup struct {
+	Name   string
+	Ranges []portRange
+}
+
+// SetPortGroups configures named port groups, e.g. "web" = "80,443" or "gaming" = "3074,27015-27030".
+// Flows are categorized by their service port (destination port of the original direction).
+func (a *Aggregator) SetPortGroups(groups map[string]string) error {
+	var parsed []portGroup
+	for name, spec := range groups {
+		ranges, err := parsePortRanges(spec)
+		if err != nil {
+			return fmt.Errorf("port group %q: %w", name, err)
+		}
+		parsed = append(parsed, portGroup{Name: name, Ranges: ranges})
+	}
+
+	// Deterministic matching order when groups overlap
+	sort.Slice(parsed, func(i, j int) bool { return parsed[i].Name < parsed[j].Name })
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.portGroups = parsed
+	return nil
+}
+
+func parsePortRanges(spec string) ([]portRange, error) {
+	var ranges []portRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		lo, hi, isRange := strings.Cut(part, "-")
+		from, err := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		to := from
+		if isRange {
+			to, err = strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+			if err != nil || to < from {
+				return nil, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		ranges = append(ranges, portRange{From: uint16(from), To: uint16(to)})
+	}
+	return ranges, nil
+}
+
+// categorize returns the port group name for a flow. Caller holds mu.
+func (a *Aggregator) categorize(proto uint8, port uint16) string {
+	// Only TCP/UDP carry meaningful ports
+	if proto != 6 && proto != 17 {
+		return otherCategory
+	}
+	for _, g := range a.portGroups {
+		for _, r := range g.Ranges {
+			if port >= r.From && port <= r.To {
+				return g.Name
+			}
+		}
+	}
+	return otherCategory
+}
+
+// addCategoryBytes accumulates client traffic into its category. Caller holds mu.
+func (a *Aggregator) addCategoryBytes(mac, category string, download, upload uint64) {
+	if len(a.portGroups) == 0 || (download == 0 && upload == 0) {
+		return
+	}
+
+	cats, ok := a.clientCategories[mac]
+	if !ok {
+		cats = make(map[string]*model.CategoryStats)
+		a.clientCategories[mac] = cats
+	}
+	cs, ok := cats[category]
+	if !ok {
+		cs = &model.CategoryStats{Category: category}
+		cats[category] = cs
+	}
+	cs.TotalDownload += download
+	cs.TotalUpload += upload
+}
+
+// GetClientCategories returns per-category traffic totals for a client, largest first
+func (a *Aggregator) GetClientCategories(mac string) []model.CategoryStats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	list := make([]model.CategoryStats, 0, len(a.clientCategories[mac]))
+	for _, cs := range a.clientCategories[mac] {
+		list = append(list, *cs)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].TotalDownload+list[i].TotalUpload > list[j].TotalDownload+list[j].TotalUpload
+	})
+	return list
+}
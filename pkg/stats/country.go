@@ -0,0 +1,116 @@
+package stats
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// SetBlockedCountries flags countries, by ISO code, that LAN devices are not
+// expected to talk to. Their traffic is marked in the country statistics.
+func (a *Aggregator) SetBlockedCountries(codes []string) {
+	blocked := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		blocked[strings.ToUpper(strings.TrimSpace(code))] = true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.blockedCountries = blocked
+}
+
+// countryOf looks up the country of the remote side of an internet flow.
+// Caller holds mu.
+func (a *Aggregator) countryOf(srcIP, dstIP, srcMac, dstMac string) string {
+	if a.geo == nil {
+		return ""
+	}
+	isSrcLocal := srcMac != ""
+	isDstLocal := dstMac != "" && dstMac != srcMac
+	switch {
+	case isSrcLocal && !isDstLocal:
+		return a.geo.Lookup(dstIP).Country
+	case isDstLocal && !isSrcLocal:
+		return a.geo.Lookup(srcIP).Country
+	}
+	return ""
+}
+
+// addCountryBytes accumulates client traffic into its remote country. Caller holds mu.
+func (a *Aggregator) addCountryBytes(mac, country string, download, upload uint64) {
+	if country == "" || (download == 0 && upload == 0) {
+		return
+	}
+
+	countries, ok := a.clientCountries[mac]
+	if !ok {
+		countries = make(map[string]*model.CountryStats)
+		a.clientCountries[mac] = countries
+	}
+	addCountry(countries, country, download, upload)
+}
+
+func addCountry(countries map[string]*model.CountryStats, country string, download, upload uint64) {
+	if country == "" {
+		return
+	}
+	cs, ok := countries[country]
+	if !ok {
+		cs = &model.CountryStats{Country: country}
+		countries[country] = cs
+	}
+	cs.TotalDownload += download
+	cs.TotalUpload += upload
+}
+
+// GetClientCountries returns per-country traffic totals for a client, largest first
+func (a *Aggregator) GetClientCountries(mac string) []model.CountryStats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.sortedCountries(a.clientCountries[mac])
+}
+
+// GetCountries returns per-country totals of the global (internet) traffic, largest first
+func (a *Aggregator) GetCountries() []model.CountryStats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.sortedCountries(a.globalCountries)
+}
+
+// BlockedCountryTraffic returns, per client, the traffic to blocked countries
+func (a *Aggregator) BlockedCountryTraffic() map[string][]model.CountryStats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.blockedCountryTraffic()
+}
+
+// blockedCountryTraffic is BlockedCountryTraffic for callers holding mu
+func (a *Aggregator) blockedCountryTraffic() map[string][]model.CountryStats {
+	result := make(map[string][]model.CountryStats)
+	if len(a.blockedCountries) == 0 {
+		return result
+	}
+	for mac, countries := range a.clientCountries {
+		for _, cs := range a.sortedCountries(countries) {
+			if cs.Blocked {
+				result[mac] = append(result[mac], cs)
+			}
+		}
+	}
+	return result
+}
+
+// sortedCountries copies and flags countries, largest first. Caller holds mu.
+func (a *Aggregator) sortedCountries(countries map[string]*model.CountryStats) []model.CountryStats {
+	list := make([]model.CountryStats, 0, len(countries))
+	for _, cs := range countries {
+		cp := *cs
+		cp.Blocked = a.blockedCountries[cs.Country]
+		list = append(list, cp)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].TotalDownload+list[i].TotalUpload > list[j].TotalDownload+list[j].TotalUpload
+	})
+	return list
+}
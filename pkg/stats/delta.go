@@ -0,0 +1,29 @@
+package stats
+
+import "log/slog"
+
+// Default cap of a single delta. Enough for a 1Gbps link between polls,
+// 10Gbps links with long intervals need more.
+const defaultMaxDelta = 1 * 1024 * 1024 * 1024 // 1GB
+
+// SetMaxDelta sets the largest byte delta accepted from a single event.
+// Larger deltas are dropped and counted, exported as catchmole_capped_deltas_total. 0 restores the default.
+func (a *Aggregator) SetMaxDelta(bytes uint64) {
+	if bytes == 0 {
+		bytes = defaultMaxDelta
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.maxDelta = bytes
+}
+
+// capDelta returns delta, or 0 if it exceeds the safety cap. Caller holds mu.
+func (a *Aggregator) capDelta(ft *FlowTracker, delta uint64) uint64 {
+	if delta <= a.maxDelta {
+		return delta
+	}
+	a.cappedDeltas++
+	a.cappedBytes += delta
+	slog.Debug("Huge delta, ignoring", "bytes", delta, "flow", ft.Key, "max_delta", a.maxDelta)
+	return 0
+}
@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// SetDeviceTags replaces the device tags, e.g. "aa:bb:.." = ["iot", "kid"].
+// Tags are lowercased, a device may carry several.
+func (a *Aggregator) SetDeviceTags(tags map[string][]string) {
+	parsed := make(map[string][]string, len(tags))
+	for mac, list := range tags {
+		var norm []string
+		for _, tag := range list {
+			if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+				norm = append(norm, tag)
+			}
+		}
+		slices.Sort(norm)
+		if norm = slices.Compact(norm); len(norm) > 0 {
+			parsed[strings.ToLower(mac)] = norm
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.deviceTags = parsed
+	for mac, c := range a.clients {
+		c.Tags = parsed[mac]
+	}
+}
+
+// GetDeviceTags returns per-tag statistics summed over the tagged clients,
+// sorted by tag. A client with several tags counts in each.
+func (a *Aggregator) GetDeviceTags() []model.GroupStats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.deviceTagStats()
+}
+
+// deviceTagStats is GetDeviceTags for callers holding mu
+func (a *Aggregator) deviceTagStats() []model.GroupStats {
+	tags := make(map[string]*model.GroupStats)
+	for mac, list := range a.deviceTags {
+		for _, tag := range list {
+			gs, ok := tags[tag]
+			if !ok {
+				gs = &model.GroupStats{Name: tag}
+				tags[tag] = gs
+			}
+			gs.Members = append(gs.Members, mac)
+
+			c, ok := a.clients[mac]
+			if !ok {
+				continue
+			}
+			if c.Online {
+				gs.ActiveClients++
+			}
+			gs.TotalDownload += c.TotalDownload
+			gs.TotalUpload += c.TotalUpload
+			gs.SessionDownload += c.SessionDownload
+			gs.SessionUpload += c.SessionUpload
+			gs.DownloadSpeed += c.DownloadSpeed
+			gs.UploadSpeed += c.UploadSpeed
+			gs.ActiveConnections += c.ActiveConnections
+			gs.NewConnections += c.NewConnections
+			gs.FailedConnections += c.FailedConnections
+		}
+	}
+
+	list := make([]model.GroupStats, 0, len(tags))
+	for _, gs := range tags {
+		slices.Sort(gs.Members)
+		list = append(list, *gs)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
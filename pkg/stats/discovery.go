@@ -0,0 +1,29 @@
+package stats
+
+import "github.com/kisy/catchmole/pkg/monitor/scanner"
+
+// SetScanner lists devices found by active scanning as clients, even before
+// they send traffic
+func (a *Aggregator) SetScanner(s *scanner.Scanner) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.scanner = s
+}
+
+// addDiscovered creates clients for scanned devices and marks them present.
+// Discovered devices never evict tracked clients. Caller holds mu.
+func (a *Aggregator) addDiscovered(present map[string]bool) {
+	if a.scanner == nil {
+		return
+	}
+	for mac := range a.scanner.Devices() {
+		if _, ignored := a.ignore.MACs[mac]; ignored {
+			continue
+		}
+		mac = a.PrimaryMAC(mac)
+		present[mac] = true
+		if _, ok := a.clients[mac]; !ok && (a.maxClients <= 0 || len(a.clients) < a.maxClients) {
+			a.getClient(mac)
+		}
+	}
+}
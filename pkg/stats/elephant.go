@@ -0,0 +1,161 @@
+package stats
+
+import (
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// Keep this many finished elephants for post-hoc inspection
+const maxRecentElephants = 50
+
+// SetElephantThresholds configures elephant flow detection.
+// A flow is an elephant once its total bytes reach sizeBytes, or its combined
+// speed stays at or above rateBps for at least sustain.
+func (a *Aggregator) SetElephantThresholds(sizeBytes, rateBps uint64, sustain time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.elephantBytes = sizeBytes
+	a.elephantRate = rateBps
+	a.elephantSustain = sustain
+}
+
+// elephantThresholds holds the detection settings, copied out of the
+// Aggregator so flows can be checked without holding mu
+type elephantThresholds struct {
+	bytes   uint64
+	rate    uint64
+	sustain time.Duration
+}
+
+// elephantThresholds returns the current settings. Caller holds mu.
+func (a *Aggregator) elephantThresholds() elephantThresholds {
+	return elephantThresholds{bytes: a.elephantBytes, rate: a.elephantRate, sustain: a.elephantSustain}
+}
+
+// checkElephant evaluates a flow after its speed was updated and reports
+// whether it just became an elephant. Caller holds the flow's shard lock.
+func checkElephant(f *FlowTracker, now time.Time, t elephantThresholds) bool {
+	speed := f.OrigSpeed + f.ReplySpeed
+	if speed > f.PeakSpeed {
+		f.PeakSpeed = speed
+	}
+
+	if !f.ElephantAt.IsZero() {
+		return false
+	}
+
+	reason := ""
+	if t.bytes > 0 && f.TotalOriginBytes+f.TotalReplyBytes >= t.bytes {
+		reason = "size"
+	} else if t.rate > 0 {
+		if speed >= t.rate {
+			if f.RateAboveSince.IsZero() {
+				f.RateAboveSince = now
+			}
+			if now.Sub(f.RateAboveSince) >= t.sustain {
+				reason = "rate"
+			}
+		} else {
+			f.RateAboveSince = time.Time{}
+		}
+	}
+
+	if reason == "" {
+		return false
+	}
+
+	f.ElephantAt = now
+	f.ElephantReason = reason
+	return true
+}
+
+// logElephant reports a newly detected elephant. Caller holds mu.
+func (a *Aggregator) logElephant(f *FlowTracker) {
+	e := a.elephantView(f)
+	slog.Info("Elephant flow detected", "proto", e.Protocol, "client_ip", e.ClientIP, "client_port", e.ClientPort,
+		"remote_ip", e.RemoteIP, "remote_port", e.RemotePort, "reason", e.Reason, "name", e.Name,
+		"bytes", e.TotalDownload+e.TotalUpload, "bps", f.OrigSpeed+f.ReplySpeed)
+}
+
+// retireElephant moves a flow leaving the table into the recent list. Caller holds mu.
+func (a *Aggregator) retireElephant(f *FlowTracker) {
+	if f.ElephantAt.IsZero() {
+		return
+	}
+
+	e := a.elephantView(f)
+	e.Active = false
+	e.DownloadSpeed = 0
+	e.UploadSpeed = 0
+
+	a.recentElephants = append(a.recentElephants, e)
+	if len(a.recentElephants) > maxRecentElephants {
+		a.recentElephants = a.recentElephants[len(a.recentElephants)-maxRecentElephants:]
+	}
+}
+
+// elephantView builds the client-perspective view of an elephant flow. Caller holds mu.
+func (a *Aggregator) elephantView(f *FlowTracker) model.ElephantFlow {
+	e := model.ElephantFlow{
+		Protocol:   getProtocolName(f.Proto),
+		PeakSpeed:  f.PeakSpeed,
+		Reason:     f.ElephantReason,
+		FirstSeen:  f.FirstSeen,
+		DetectedAt: f.ElephantAt,
+		LastSeen:   f.LastSeen,
+		Active:     true,
+	}
+
+	srcMac := a.macOf(f.SrcIP)
+	dstMac := a.macOf(f.DstIP)
+
+	if srcMac == "" && dstMac != "" {
+		// Client is Dst: Orig is Download, Reply is Upload
+		e.MAC = dstMac
+		e.ClientIP, e.ClientPort = f.DstIP, f.DstPort
+		e.RemoteIP, e.RemotePort = f.SrcIP, f.SrcPort
+		e.TotalDownload, e.TotalUpload = f.TotalOriginBytes, f.TotalReplyBytes
+		e.DownloadSpeed, e.UploadSpeed = f.OrigSpeed, f.ReplySpeed
+	} else {
+		// Client is Src: Orig is Upload, Reply is Download
+		e.MAC = srcMac
+		e.ClientIP, e.ClientPort = f.SrcIP, f.SrcPort
+		e.RemoteIP, e.RemotePort = f.DstIP, f.DstPort
+		e.TotalDownload, e.TotalUpload = f.TotalReplyBytes, f.TotalOriginBytes
+		e.DownloadSpeed, e.UploadSpeed = f.ReplySpeed, f.OrigSpeed
+	}
+
+	e.Name = e.MAC
+	if c, ok := a.clients[e.MAC]; ok {
+		e.Name = c.Name
+	}
+	return e
+}
+
+// GetElephants returns current elephant flows (largest first) and recently finished ones (newest first)
+func (a *Aggregator) GetElephants() ([]model.ElephantFlow, []model.ElephantFlow) {
+	flows := a.flowSnapshot()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	current := make([]model.ElephantFlow, 0)
+	for i := range flows {
+		if !flows[i].ElephantAt.IsZero() && !flows[i].Private {
+			current = append(current, a.elephantView(&flows[i].FlowTracker))
+		}
+	}
+	sort.Slice(current, func(i, j int) bool {
+		return current[i].TotalDownload+current[i].TotalUpload > current[j].TotalDownload+current[j].TotalUpload
+	})
+
+	recent := make([]model.ElephantFlow, 0, len(a.recentElephants))
+	for i := len(a.recentElephants) - 1; i >= 0; i-- {
+		recent = append(recent, a.recentElephants[i])
+	}
+
+	return current, recent
+}
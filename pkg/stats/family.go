@@ -0,0 +1,119 @@
+package stats
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// Dual-stack accounting: every flow counts as IPv4 or IPv6 traffic by the
+// stack its remote host is reached with. NAT64 flows (to 64:ff9b::/96 or a
+// configured prefix) reach IPv4 hosts and count as IPv4. A NAT64 translator
+// on the router opens a second, IPv4 flow of its own for each of them, which
+// is not counted again.
+const defaultNAT64Prefix = "64:ff9b::/96"
+
+// NAT64 destinations are remembered this long to recognize the translator's
+// IPv4 flows to them
+const nat64Linger = 5 * time.Minute
+
+// SetNAT64Prefixes configures the /96 prefixes IPv4 hosts are reached through
+// by NAT64, e.g. of DNS64 (empty = the well-known 64:ff9b::/96)
+func (a *Aggregator) SetNAT64Prefixes(prefixes []string) error {
+	if len(prefixes) == 0 {
+		prefixes = []string{defaultNAT64Prefix}
+	}
+	nets := make([]*net.IPNet, 0, len(prefixes))
+	for _, p := range prefixes {
+		_, n, err := net.ParseCIDR(p)
+		if err != nil || n.IP.To4() != nil {
+			return fmt.Errorf("invalid NAT64 prefix %q", p)
+		}
+		if ones, _ := n.Mask.Size(); ones != 96 {
+			return fmt.Errorf("NAT64 prefix %q: only /96 prefixes are supported", p)
+		}
+		nets = append(nets, n)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nat64Prefixes = nets
+	return nil
+}
+
+// nat64Host returns the IPv4 host embedded in a NAT64 address, or nil
+func (a *Aggregator) nat64Host(ip net.IP) net.IP {
+	if ip.To4() != nil {
+		return nil
+	}
+	for _, n := range a.nat64Prefixes {
+		if n.Contains(ip) {
+			return net.IPv4(ip[12], ip[13], ip[14], ip[15])
+		}
+	}
+	return nil
+}
+
+// flowFamily returns 4 or 6 for a new flow and remembers NAT64 destinations.
+// Caller holds mu.
+func (a *Aggregator) flowFamily(src, dst net.IP) uint8 {
+	if dst.To4() != nil {
+		return 4 // Including IPv4-mapped addresses
+	}
+	for _, ip := range []net.IP{dst, src} {
+		if host := a.nat64Host(ip); host != nil {
+			a.nat64Hosts[host.String()] = time.Now()
+			return 4
+		}
+	}
+	return 6
+}
+
+// translated reports whether a flow is the router's IPv4 side of a NAT64
+// flow, whose traffic is already counted. Caller holds mu.
+func (a *Aggregator) translated(ft *FlowTracker) bool {
+	if ft.Translated {
+		return true
+	}
+	if ft.Family != 4 || len(a.nat64Hosts) == 0 || !a.routerIPs[ft.SrcIP] {
+		return false
+	}
+	if _, ok := a.nat64Hosts[ft.DstIP]; !ok {
+		return false
+	}
+	ft.Translated = true
+	return true
+}
+
+// expireNAT64Hosts forgets NAT64 destinations not seen for nat64Linger. Caller holds mu.
+func (a *Aggregator) expireNAT64Hosts(now time.Time) {
+	for host, seen := range a.nat64Hosts {
+		if now.Sub(seen) > nat64Linger {
+			delete(a.nat64Hosts, host)
+		}
+	}
+}
+
+// addFamilyBytes adds traffic to the client's totals of the flow's family
+func addFamilyBytes(c *model.ClientStats, family uint8, download, upload uint64) {
+	if family == 6 {
+		c.TotalDownload6 += download
+		c.TotalUpload6 += upload
+	} else {
+		c.TotalDownload4 += download
+		c.TotalUpload4 += upload
+	}
+}
+
+// addGlobalFamilyBytes is addFamilyBytes for the internet totals. Caller holds mu.
+func (a *Aggregator) addGlobalFamilyBytes(family uint8, download, upload uint64) {
+	if family == 6 {
+		a.globalTotalDownload6 += download
+		a.globalTotalUpload6 += upload
+	} else {
+		a.globalTotalDownload4 += download
+		a.globalTotalUpload4 += upload
+	}
+}
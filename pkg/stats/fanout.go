@@ -0,0 +1,151 @@
+package stats
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// A client opening connections to many distinct remote IP and port pairs in
+// a short window is scanning: many ports on few hosts is a port scan, one
+// port on many hosts a sweep as done by worms
+const (
+	defaultFanoutThreshold = 500 // Distinct targets within the window, above busy P2P clients
+	defaultFanoutWindow    = time.Minute
+	fanoutHold             = 15 * time.Minute // Scanners stay listed this long after their last flagged tick
+	MaxFanoutTargets       = 4096             // Per client, beyond this the scan is obvious anyway; also the largest threshold
+)
+
+type fanoutTarget struct {
+	ip   string
+	port uint16
+}
+
+// fanout is the recent connection targets of one client
+type fanout struct {
+	targets    map[fanoutTarget]time.Time // Last connection attempt
+	hosts      int                        // Distinct IPs and ports at the last check
+	ports      int
+	peak       int // Most targets of the current streak
+	since      time.Time
+	lastFlag   time.Time
+	flaggedNow bool
+}
+
+// SetFanoutThresholds configures scan detection: a client is flagged when it
+// connects to at least threshold distinct remote IP and port pairs within
+// window. Zero values use the defaults, thresholds above MaxFanoutTargets
+// could never be reached and are capped.
+func (a *Aggregator) SetFanoutThresholds(threshold int, window time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if threshold <= 0 {
+		threshold = defaultFanoutThreshold
+	}
+	threshold = min(threshold, MaxFanoutTargets)
+	if window <= 0 {
+		window = defaultFanoutWindow
+	}
+	a.fanoutThreshold = threshold
+	a.fanoutWindow = window
+}
+
+// recordFanout notes a connection from a client. Caller holds mu.
+func (a *Aggregator) recordFanout(srcMac, dstMac, dstIP string, dstPort uint16) {
+	if srcMac == "" || srcMac == RouterMAC || srcMac == dstMac || a.privateMACs[srcMac] {
+		return
+	}
+	f, ok := a.fanouts[srcMac]
+	if !ok {
+		f = &fanout{targets: make(map[fanoutTarget]time.Time)}
+		a.fanouts[srcMac] = f
+	}
+	t := fanoutTarget{dstIP, dstPort}
+	if _, ok := f.targets[t]; ok || len(f.targets) < MaxFanoutTargets {
+		f.targets[t] = time.Now()
+	}
+}
+
+// checkFanouts expires targets outside the window and flags scanning clients. Caller holds mu.
+func (a *Aggregator) checkFanouts(now time.Time) {
+	for mac, f := range a.fanouts {
+		for t, seen := range f.targets {
+			if now.Sub(seen) > a.fanoutWindow {
+				delete(f.targets, t)
+			}
+		}
+
+		f.flaggedNow = false
+		if len(f.targets) >= a.fanoutThreshold {
+			a.flagFanout(mac, f, now)
+		}
+		if len(f.targets) == 0 && now.Sub(f.lastFlag) > fanoutHold {
+			delete(a.fanouts, mac)
+		}
+	}
+}
+
+// flagFanout records a tick above the threshold. Caller holds mu.
+func (a *Aggregator) flagFanout(mac string, f *fanout, now time.Time) {
+	hosts := make(map[string]struct{})
+	ports := make(map[uint16]struct{})
+	for t := range f.targets {
+		hosts[t.ip] = struct{}{}
+		ports[t.port] = struct{}{}
+	}
+	f.hosts, f.ports = len(hosts), len(ports)
+
+	if f.since.IsZero() || now.Sub(f.lastFlag) > fanoutHold {
+		f.since = now
+		f.peak = 0
+		slog.Warn("Connection fan-out detected", "mac", mac, "kind", f.kind(), "targets", len(f.targets),
+			"hosts", f.hosts, "ports", f.ports, "window", a.fanoutWindow)
+	}
+	f.flaggedNow = true
+	f.lastFlag = now
+	f.peak = max(f.peak, len(f.targets))
+}
+
+// kind tells a port scan from a host sweep
+func (f *fanout) kind() string {
+	if f.ports > f.hosts {
+		return "port_scan"
+	}
+	return "host_sweep"
+}
+
+// GetScanDetections returns the clients flagged within the hold time, most targets first
+func (a *Aggregator) GetScanDetections() []model.ScanDetection {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	now := time.Now()
+	scans := make([]model.ScanDetection, 0)
+	for mac, f := range a.fanouts {
+		if f.since.IsZero() || now.Sub(f.lastFlag) > fanoutHold {
+			continue
+		}
+		name := mac
+		if c, ok := a.clients[mac]; ok {
+			name = c.Name
+		}
+		scans = append(scans, model.ScanDetection{
+			MAC:      mac,
+			Name:     name,
+			Kind:     f.kind(),
+			Targets:  f.peak,
+			Hosts:    f.hosts,
+			Ports:    f.ports,
+			Window:   int(a.fanoutWindow.Seconds()),
+			Reason:   fmt.Sprintf("%d remote IP and port pairs within %s (%d hosts, %d ports)", f.peak, a.fanoutWindow, f.hosts, f.ports),
+			Since:    f.since,
+			LastSeen: f.lastFlag,
+			Active:   f.flaggedNow,
+		})
+	}
+	sort.Slice(scans, func(i, j int) bool { return scans[i].Targets > scans[j].Targets })
+	return scans
+}
@@ -0,0 +1,73 @@
+package stats
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kisy/catchmole/pkg/monitor"
+)
+
+// hasPorts reports whether flows of a protocol are told apart by their ports
+func hasPorts(proto uint8) bool {
+	switch proto {
+	case 6, 17, 33, 132, 136: // TCP, UDP, DCCP, SCTP, UDP-Lite
+		return true
+	}
+	return false
+}
+
+// flowKey identifies the flow of an event. Port-less protocols (ICMP, GRE,
+// ESP, ...) would all share one key per address pair, so ICMP flows are keyed
+// by type and identifier and the others by the source's flow ID.
+func flowKey(ev monitor.FlowEvent) string {
+	// The same tuple may exist once per conntrack zone
+	if zone := ev.Zone; zone != 0 {
+		ev.Zone = 0
+		return fmt.Sprintf("z%d:%s", zone, flowKey(ev))
+	}
+	switch {
+	case hasPorts(ev.Proto):
+		return fmt.Sprintf("%s:%d->%s:%d:%d", ev.SrcIP, ev.SrcPort, ev.DstIP, ev.DstPort, ev.Proto)
+	case ev.ICMP:
+		return fmt.Sprintf("%s->%s:icmp%d/%d:%d", ev.SrcIP, ev.DstIP, ev.ICMPType, ev.ICMPID, ev.Proto)
+	default:
+		return fmt.Sprintf("%s->%s:#%d:%d", ev.SrcIP, ev.DstIP, ev.FlowID, ev.Proto)
+	}
+}
+
+// Names of the ICMP types that carry an identifier or commonly show up in conntrack
+var (
+	icmpTypes = map[uint8]string{
+		0:  "echo reply",
+		3:  "unreachable",
+		8:  "echo request",
+		11: "time exceeded",
+		13: "timestamp",
+		14: "timestamp reply",
+	}
+	icmpv6Types = map[uint8]string{
+		1:   "unreachable",
+		3:   "time exceeded",
+		128: "echo request",
+		129: "echo reply",
+	}
+)
+
+// protoInfo describes what tells a port-less flow apart, e.g. "echo request id 4660"
+func (f *FlowTracker) protoInfo() string {
+	if !f.ICMP {
+		return ""
+	}
+	names := icmpTypes
+	if f.Proto == 58 {
+		names = icmpv6Types
+	}
+	name, ok := names[f.ICMPType]
+	if !ok {
+		name = "type " + strconv.Itoa(int(f.ICMPType))
+	}
+	if f.ICMPID != 0 {
+		name += " id " + strconv.Itoa(int(f.ICMPID))
+	}
+	return name
+}
@@ -0,0 +1,129 @@
+package stats
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// Valid sort keys for GetFlows
+var flowKeys = map[string]bool{
+	"download_speed": true,
+	"upload_speed":   true,
+	"speed":          true,
+	"total_download": true,
+	"total_upload":   true,
+	"total":          true,
+	"duration":       true,
+}
+
+// FlowFilter selects flows for GetFlows. Zero values match everything.
+type FlowFilter struct {
+	Proto    string     // Protocol name ("tcp", "udp", "icmp") or number
+	Port     uint16     // Client or remote port
+	Remote   *net.IPNet // Remote address range
+	MinSpeed uint64     // Combined speed in bytes/s
+}
+
+// GetFlows returns the whole flow table from the client side, filtered and
+// sorted by the given key (largest first). limit <= 0 means no limit.
+func (a *Aggregator) GetFlows(filter FlowFilter, by string, limit, offset int) (model.FlowList, error) {
+	if !flowKeys[by] {
+		return model.FlowList{}, fmt.Errorf("invalid sort key %q", by)
+	}
+	proto := strings.ToUpper(filter.Proto)
+
+	snapshot := a.flowSnapshot()
+	flows := make([]model.Flow, 0)
+	for i := range snapshot {
+		f := &snapshot[i]
+		if f.Private {
+			continue
+		}
+		if proto != "" && getProtocolName(f.Proto) != proto && strconv.Itoa(int(f.Proto)) != proto {
+			continue
+		}
+
+		// Same perspective as the elephant view: client is Src unless only Dst is known
+		fl := model.Flow{
+			MAC:        f.SrcMAC,
+			Protocol:   getProtocolName(f.Proto),
+			ClientIP:   f.SrcIP,
+			ClientPort: f.SrcPort,
+			RemoteIP:   f.DstIP,
+			RemotePort: f.DstPort,
+			FirstSeen:  f.FirstSeen,
+			LastSeen:   f.LastSeen,
+			TCPState:   getTCPStateName(f.Proto, f.TCPState),
+			Assured:    f.Assured,
+			Offload:    f.Offload,
+			ProtoInfo:  f.protoInfo(),
+			SNI:        f.SNI,
+			Service:    cmp.Or(f.LocalService, sniService(f.SNI)),
+			Tag:        f.Tag,
+			Zone:       f.Zone,
+
+			TotalDownload: f.TotalReplyBytes,
+			TotalUpload:   f.TotalOriginBytes,
+			DownloadSpeed: f.ReplySpeed,
+			UploadSpeed:   f.OrigSpeed,
+		}
+		if f.SrcMAC == "" && f.DstMAC != "" {
+			fl.MAC = f.DstMAC
+			fl.ClientIP, fl.ClientPort = f.DstIP, f.DstPort
+			fl.RemoteIP, fl.RemotePort = f.SrcIP, f.SrcPort
+			fl.TotalDownload, fl.TotalUpload = f.TotalOriginBytes, f.TotalReplyBytes
+			fl.DownloadSpeed, fl.UploadSpeed = f.OrigSpeed, f.ReplySpeed
+		}
+
+		if filter.Port != 0 && fl.ClientPort != filter.Port && fl.RemotePort != filter.Port {
+			continue
+		}
+		if filter.Remote != nil && !filter.Remote.Contains(net.ParseIP(fl.RemoteIP)) {
+			continue
+		}
+		if fl.DownloadSpeed+fl.UploadSpeed < filter.MinSpeed {
+			continue
+		}
+		flows = append(flows, fl)
+	}
+
+	key := func(f *model.Flow) uint64 {
+		if by == "duration" {
+			return uint64(f.LastSeen.Sub(f.FirstSeen) / time.Second)
+		}
+		return topValue(by, f.DownloadSpeed, f.UploadSpeed, f.TotalDownload, f.TotalUpload, 0)
+	}
+	sort.Slice(flows, func(i, j int) bool {
+		ki, kj := key(&flows[i]), key(&flows[j])
+		if ki != kj {
+			return ki > kj
+		}
+		return flows[i].LastSeen.After(flows[j].LastSeen)
+	})
+
+	list := model.FlowList{By: by, Count: len(flows), Flows: paginate(flows, limit, offset)}
+
+	// Label only the returned page, lookups are not free
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for i := range list.Flows {
+		f := &list.Flows[i]
+		if c, ok := a.clients[f.MAC]; ok {
+			f.Name = c.Name
+		}
+		f.Excluded = a.excludedTags[f.Tag]
+		f.RemoteHostname = a.remoteHostname(f.RemoteIP)
+		if a.geo != nil {
+			gi := a.geo.Lookup(f.RemoteIP)
+			f.RemoteCountry, f.RemoteASN, f.RemoteOrg = gi.Country, gi.ASN, gi.Org
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,62 @@
+package stats
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// SetGroups configures named client groups, e.g. "kids" = ["aa:bb:..", "cc:dd:.."].
+// A MAC may belong to several groups.
+func (a *Aggregator) SetGroups(groups map[string][]string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.groups = make(map[string][]string, len(groups))
+	for name, macs := range groups {
+		members := make([]string, 0, len(macs))
+		for _, mac := range macs {
+			members = append(members, strings.ToLower(mac))
+		}
+		a.groups[name] = members
+	}
+}
+
+// GetGroups returns per-group statistics summed over member clients, sorted by name
+func (a *Aggregator) GetGroups() []model.GroupStats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.groupStats()
+}
+
+// groupStats is GetGroups for callers holding mu
+func (a *Aggregator) groupStats() []model.GroupStats {
+	list := make([]model.GroupStats, 0, len(a.groups))
+	for name, members := range a.groups {
+		gs := model.GroupStats{Name: name, Members: slices.Clone(members)} // Not shared with a.groups
+		for _, mac := range members {
+			c, ok := a.clients[mac]
+			if !ok {
+				continue
+			}
+			if c.Online {
+				gs.ActiveClients++
+			}
+			gs.TotalDownload += c.TotalDownload
+			gs.TotalUpload += c.TotalUpload
+			gs.SessionDownload += c.SessionDownload
+			gs.SessionUpload += c.SessionUpload
+			gs.DownloadSpeed += c.DownloadSpeed
+			gs.UploadSpeed += c.UploadSpeed
+			gs.ActiveConnections += c.ActiveConnections
+			gs.NewConnections += c.NewConnections
+			gs.FailedConnections += c.FailedConnections
+		}
+		list = append(list, gs)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
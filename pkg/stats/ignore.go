@@ -0,0 +1,141 @@
+package stats
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/kisy/catchmole/pkg/monitor"
+)
+
+// portRule ignores a port range, optionally only towards one host
+type portRule struct {
+	Host   net.IP // nil = any host
+	Ranges []portRange
+}
+
+// ignoreRules excludes traffic from accounting
+type ignoreRules struct {
+	Subnets []*net.IPNet
+	Ports   []portRule
+	MACs    map[string]struct{}
+}
+
+func (r *ignoreRules) empty() bool {
+	return len(r.Subnets) == 0 && len(r.Ports) == 0 && len(r.MACs) == 0
+}
+
+// SetIgnoreRules configures traffic excluded from accounting:
+// subnets (CIDR or single IP) matching either endpoint, ports as "443",
+// "8000-8100" or host-scoped "192.168.1.10:443", and client MACs.
+func (a *Aggregator) SetIgnoreRules(subnets, ports, macs []string) error {
+	rules, err := parseIgnoreRules(subnets, ports, macs)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.ignore = rules
+	return nil
+}
+
+// ValidateIgnoreRules checks rules as SetIgnoreRules does, without applying them
+func ValidateIgnoreRules(subnets, ports, macs []string) error {
+	_, err := parseIgnoreRules(subnets, ports, macs)
+	return err
+}
+
+func parseIgnoreRules(subnets, ports, macs []string) (ignoreRules, error) {
+	var rules ignoreRules
+
+	for _, s := range subnets {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return rules, fmt.Errorf("invalid ignore subnet %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			s = fmt.Sprintf("%s/%d", s, bits)
+		}
+		_, sn, err := net.ParseCIDR(s)
+		if err != nil {
+			return rules, fmt.Errorf("invalid ignore subnet %q", s)
+		}
+		rules.Subnets = append(rules.Subnets, sn)
+	}
+
+	for _, p := range ports {
+		var rule portRule
+		spec := p
+		if i := strings.LastIndex(p, ":"); i >= 0 {
+			host := strings.Trim(p[:i], "[]")
+			if rule.Host = net.ParseIP(host); rule.Host == nil {
+				return rules, fmt.Errorf("invalid ignore port host %q", p)
+			}
+			spec = p[i+1:]
+		}
+		ranges, err := parsePortRanges(spec)
+		if err != nil || len(ranges) == 0 {
+			return rules, fmt.Errorf("invalid ignore port %q", p)
+		}
+		rule.Ranges = ranges
+		rules.Ports = append(rules.Ports, rule)
+	}
+
+	rules.MACs = make(map[string]struct{}, len(macs))
+	for _, m := range macs {
+		rules.MACs[strings.ToLower(m)] = struct{}{}
+	}
+	return rules, nil
+}
+
+// isIgnored reports whether an event matches the ignore rules. Caller holds mu.
+func (a *Aggregator) isIgnored(ev monitor.FlowEvent) bool {
+	if a.ignoreZones[ev.Zone] {
+		return true
+	}
+	if a.ignore.empty() {
+		return false
+	}
+
+	for _, sn := range a.ignore.Subnets {
+		if sn.Contains(ev.SrcIP) || sn.Contains(ev.DstIP) {
+			return true
+		}
+	}
+
+	// Only TCP/UDP carry meaningful ports
+	if ev.Proto == 6 || ev.Proto == 17 {
+		for _, rule := range a.ignore.Ports {
+			if portRuleMatch(rule, ev.SrcIP, ev.SrcPort) || portRuleMatch(rule, ev.DstIP, ev.DstPort) {
+				return true
+			}
+		}
+	}
+
+	if len(a.ignore.MACs) > 0 {
+		if _, ok := a.ignore.MACs[a.macOf(ev.SrcIP.String())]; ok {
+			return true
+		}
+		if _, ok := a.ignore.MACs[a.macOf(ev.DstIP.String())]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func portRuleMatch(rule portRule, ip net.IP, port uint16) bool {
+	if rule.Host != nil && !rule.Host.Equal(ip) {
+		return false
+	}
+	for _, r := range rule.Ranges {
+		if port >= r.From && port <= r.To {
+			return true
+		}
+	}
+	return false
+}
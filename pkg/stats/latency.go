@@ -0,0 +1,152 @@
+package stats
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/rttwatch"
+)
+
+// Latency from TCP handshakes: the medians cover the last rttWindowSize
+// connections of a client, or to a destination, within rttLinger
+const (
+	rttWindowSize = 32
+	rttLinger     = 10 * time.Minute
+	maxRTTRemotes = 256 // Destinations kept per client
+)
+
+// rttWindow keeps the latest round trips, in milliseconds
+type rttWindow struct {
+	samples []float64
+	next    int
+	last    time.Time
+}
+
+func (w *rttWindow) add(now time.Time, ms float64) {
+	if len(w.samples) < rttWindowSize {
+		w.samples = append(w.samples, ms)
+	} else {
+		w.samples[w.next] = ms
+		w.next = (w.next + 1) % rttWindowSize
+	}
+	w.last = now
+}
+
+func (w *rttWindow) median() float64 {
+	if w == nil || len(w.samples) == 0 {
+		return 0
+	}
+	s := slices.Clone(w.samples)
+	slices.Sort(s)
+	if n := len(s); n%2 == 0 {
+		return (s[n/2-1] + s[n/2]) / 2
+	}
+	return s[len(s)/2]
+}
+
+// SetRTTWatcher enables latency estimation from the TCP handshakes it sniffs
+func (a *Aggregator) SetRTTWatcher(w *rttwatch.Watcher) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rtt = w
+}
+
+// collectRTT folds the handshakes sniffed since the last tick into the
+// latency of clients and their destinations. Caller holds mu.
+func (a *Aggregator) collectRTT(now time.Time) {
+	if a.rtt == nil {
+		return
+	}
+	for _, s := range a.rtt.Drain() {
+		// Usually the client connects out. For connections to a client, the
+		// handshake times the LAN hop to it.
+		mac, remote := a.macOf(s.Client.String()), s.Server.String()
+		if mac == "" || mac == RouterMAC {
+			if m := a.macOf(remote); m != "" && m != RouterMAC {
+				mac, remote = m, s.Client.String()
+			}
+		}
+		if mac == "" || a.privateMACs[mac] {
+			continue
+		}
+		ms := float64(s.RTT.Microseconds()) / 1000
+
+		cw, ok := a.clientRTT[mac]
+		if !ok {
+			cw = &rttWindow{}
+			a.clientRTT[mac] = cw
+		}
+		cw.add(now, ms)
+
+		remotes, ok := a.remoteRTT[mac]
+		if !ok {
+			remotes = make(map[string]*rttWindow)
+			a.remoteRTT[mac] = remotes
+		}
+		rw, ok := remotes[remote]
+		if !ok {
+			if len(remotes) >= maxRTTRemotes {
+				expireRTT(remotes, now)
+			}
+			if len(remotes) >= maxRTTRemotes {
+				continue
+			}
+			rw = &rttWindow{}
+			remotes[remote] = rw
+		}
+		rw.add(now, ms)
+	}
+
+	for mac, w := range a.clientRTT {
+		if now.Sub(w.last) > rttLinger {
+			delete(a.clientRTT, mac)
+			delete(a.remoteRTT, mac)
+			continue
+		}
+		expireRTT(a.remoteRTT[mac], now)
+	}
+	for _, c := range a.clients {
+		c.RTT = a.clientRTT[c.MAC].median()
+	}
+}
+
+// expireRTT drops destinations without handshakes for rttLinger
+func expireRTT(remotes map[string]*rttWindow, now time.Time) {
+	for ip, w := range remotes {
+		if now.Sub(w.last) > rttLinger {
+			delete(remotes, ip)
+		}
+	}
+}
+
+// GetClientLatency returns the median handshake round trip to each
+// destination of a client, most used first. Private clients have none.
+func (a *Aggregator) GetClientLatency(mac string) []model.LatencyStats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.privateMACs[mac] {
+		return []model.LatencyStats{}
+	}
+
+	list := make([]model.LatencyStats, 0, len(a.remoteRTT[mac]))
+	for ip, w := range a.remoteRTT[mac] {
+		list = append(list, model.LatencyStats{
+			RemoteIP:       ip,
+			RemoteHostname: a.remoteHostname(ip),
+			RTT:            w.median(),
+			Min:            slices.Min(w.samples),
+			Samples:        len(w.samples),
+			LastSeen:       w.last,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Samples != list[j].Samples {
+			return list[i].Samples > list[j].Samples
+		}
+		return list[i].RemoteIP < list[j].RemoteIP
+	})
+	return list
+}
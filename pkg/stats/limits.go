@@ -0,0 +1,118 @@
+package stats
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Defaults sized for a router with 128MB of RAM
+const (
+	defaultMaxFlows   = 100000
+	defaultMaxClients = 4096
+)
+
+// Flows sampled per eviction; the least recently seen of them is dropped.
+// Sampling approximates LRU without keeping a list in order on every event.
+const evictionSamples = 16
+
+// SetTableLimits caps the tracked flows and clients (<=0 = default). At the
+// cap the least recently seen entry is evicted, so a SYN flood or port scan
+// degrades accounting instead of exhausting memory.
+func (a *Aggregator) SetTableLimits(maxFlows, maxClients int) {
+	if maxFlows <= 0 {
+		maxFlows = defaultMaxFlows
+	}
+	if maxClients <= 0 {
+		maxClients = defaultMaxClients
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.maxFlows = maxFlows
+	a.maxClients = maxClients
+}
+
+// makeRoomForFlow evicts the least recently seen of a sample of flows once
+// the table holds max_flows: from shard, which gets the new flow, if it
+// holds its share, otherwise from the fullest shard. Caller holds mu and
+// shard.mu; nothing else locks two shards at once, so this can't deadlock.
+func (a *Aggregator) makeRoomForFlow(shard *flowShard) {
+	victim := shard
+	if n := len(shard.flows); n == 0 || n < a.maxFlows/flowShards {
+		most := -1
+		for i := range a.shards {
+			s := &a.shards[i]
+			if s == shard {
+				continue
+			}
+			s.mu.Lock()
+			size := len(s.flows)
+			s.mu.Unlock()
+			if size > most {
+				victim, most = s, size
+			}
+		}
+		victim.mu.Lock()
+		defer victim.mu.Unlock()
+	}
+
+	var oldestKey string
+	var oldest *FlowTracker
+	n := 0
+	for key, f := range victim.flows { // Map iteration starts at random
+		if oldest == nil || f.LastSeen.Before(oldest.LastSeen) {
+			oldestKey, oldest = key, f
+		}
+		if n++; n >= evictionSamples {
+			break
+		}
+	}
+	if oldest == nil {
+		return
+	}
+
+	a.retireElephant(oldest)
+	delete(victim.flows, oldestKey)
+	a.flowCount.Add(-1)
+	a.evictedFlows++
+	a.warnLimit("Flow table full, evicting least recently seen flows", "max_flows", a.maxFlows)
+}
+
+// makeRoomForClient evicts the least recently active client, preferring
+// offline ones. Caller holds mu.
+func (a *Aggregator) makeRoomForClient() {
+	var victim, offline string
+	var victimLast, offlineLast time.Time
+	for mac, c := range a.clients {
+		last := c.LastActive
+		if last.IsZero() {
+			last = c.StartTime
+		}
+		if victim == "" || last.Before(victimLast) {
+			victim, victimLast = mac, last
+		}
+		if !c.Online && (offline == "" || last.Before(offlineLast)) {
+			offline, offlineLast = mac, last
+		}
+	}
+	if offline != "" {
+		victim = offline
+	}
+	if victim == "" {
+		return
+	}
+
+	a.dropClient(victim)
+	a.evictedClients++
+	a.warnLimit("Client table full, evicting least recently active clients", "max_clients", a.maxClients)
+}
+
+// warnLimit logs reaching a cap at most once a minute. Caller holds mu.
+func (a *Aggregator) warnLimit(msg string, args ...any) {
+	now := time.Now()
+	if now.Sub(a.lastLimitWarn) < time.Minute {
+		return
+	}
+	a.lastLimitWarn = now
+	slog.Warn(msg, append(args, "evicted_flows", a.evictedFlows, "evicted_clients", a.evictedClients)...)
+}
@@ -0,0 +1,111 @@
+package stats
+
+import (
+	"sort"
+
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/monitor"
+)
+
+// Traffic whose conntrack mark matches no class
+const unclassified = "other"
+
+type markClass struct {
+	Name  string
+	Value uint32
+	Mask  uint32
+}
+
+// SetMarkClasses maps conntrack marks to traffic classes, e.g. "0x100" = "VPN".
+// A mask selects the bits to compare: "0x100/0xff00" = "VPN".
+func (a *Aggregator) SetMarkClasses(classes map[string]string) error {
+	var parsed []markClass
+	for spec, name := range classes {
+		value, mask, err := monitor.ParseMark(spec)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, markClass{Name: name, Value: value & mask, Mask: mask})
+	}
+
+	// Deterministic matching order: most specific mask first, then by mark
+	sort.Slice(parsed, func(i, j int) bool {
+		if parsed[i].Mask != parsed[j].Mask {
+			return parsed[i].Mask > parsed[j].Mask
+		}
+		return parsed[i].Value < parsed[j].Value
+	})
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.markClasses = parsed
+	// Re-classify tracked flows so the new classes apply right away
+	for i := range a.shards {
+		sh := &a.shards[i]
+		sh.mu.Lock()
+		for _, f := range sh.flows {
+			f.Class = a.classify(f.Mark)
+		}
+		sh.mu.Unlock()
+	}
+	return nil
+}
+
+// classify returns the class of a conntrack mark. Caller holds mu.
+func (a *Aggregator) classify(mark uint32) string {
+	for _, c := range a.markClasses {
+		if mark&c.Mask == c.Value {
+			return c.Name
+		}
+	}
+	return unclassified
+}
+
+// addClassBytes accumulates client traffic into its mark class. Caller holds mu.
+func (a *Aggregator) addClassBytes(mac, class string, download, upload uint64) {
+	if len(a.markClasses) == 0 || (download == 0 && upload == 0) {
+		return
+	}
+
+	classes, ok := a.clientClasses[mac]
+	if !ok {
+		classes = make(map[string]*model.ClassStats)
+		a.clientClasses[mac] = classes
+	}
+	addClass(classes, class, download, upload)
+}
+
+func addClass(classes map[string]*model.ClassStats, class string, download, upload uint64) {
+	cs, ok := classes[class]
+	if !ok {
+		cs = &model.ClassStats{Class: class}
+		classes[class] = cs
+	}
+	cs.TotalDownload += download
+	cs.TotalUpload += upload
+}
+
+// GetClientClasses returns per-class traffic totals for a client, largest first
+func (a *Aggregator) GetClientClasses(mac string) []model.ClassStats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return sortedClasses(a.clientClasses[mac])
+}
+
+// GetGlobalClasses returns per-class totals of the global (internet) traffic, largest first
+func (a *Aggregator) GetGlobalClasses() []model.ClassStats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return sortedClasses(a.globalClasses)
+}
+
+func sortedClasses(classes map[string]*model.ClassStats) []model.ClassStats {
+	list := make([]model.ClassStats, 0, len(classes))
+	for _, cs := range classes {
+		list = append(list, *cs)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].TotalDownload+list[i].TotalUpload > list[j].TotalDownload+list[j].TotalUpload
+	})
+	return list
+}
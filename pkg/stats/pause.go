@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// Pause stops counting traffic, e.g. during maintenance or iperf runs. Events
+// are still drained so the source and watchdog keep running, and the traffic
+// they carry is never counted. Totals stay as they are until Resume.
+func (a *Aggregator) Pause() model.MonitorState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.paused.Load() {
+		a.pausedAt = time.Now()
+		a.pausedEvents.Store(0)
+		a.paused.Store(true)
+		slog.Info("Monitoring paused")
+	}
+	return a.monitorState()
+}
+
+// Resume counts traffic again. Flows seen before the pause continue from
+// their counters at resume, the bytes in between are skipped.
+func (a *Aggregator) Resume() model.MonitorState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.paused.Load() {
+		a.paused.Store(false)
+		slog.Info("Monitoring resumed", "paused_for", time.Since(a.pausedAt).Round(time.Second), "dropped_events", a.pausedEvents.Load())
+		a.pausedAt = time.Time{}
+	}
+	return a.monitorState()
+}
+
+// MonitorState returns whether accounting is paused
+func (a *Aggregator) MonitorState() model.MonitorState {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.monitorState()
+}
+
+// monitorState is MonitorState for callers holding mu
+func (a *Aggregator) monitorState() model.MonitorState {
+	return model.MonitorState{
+		Paused:        a.paused.Load(),
+		Since:         a.pausedAt,
+		DroppedEvents: a.pausedEvents.Load(),
+	}
+}
@@ -0,0 +1,213 @@
+package stats
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// A device annotated with a plan (bandwidth class, e.g. "iot" = "1/1") is
+// expected to stay below its rates. Exceeding them for the sustain time is a
+// violation, e.g. a firmware update storm or a compromised device.
+const defaultPlanSustain = time.Minute
+
+// planState is the plan compliance of one client
+type planState struct {
+	overSince    time.Time // Above a rate since, zero if within the plan
+	violating    bool      // The current streak was counted
+	violations   uint64
+	first        time.Time // First violation
+	last         time.Time // Last tick of a violation
+	since        time.Time // Start of the last violation
+	peakDownload uint64    // Highest speeds of the last violation
+	peakUpload   uint64
+	plan         string       // Plan at the last violation
+	rates        linkCapacity // and its rates
+}
+
+// SetPlans configures bandwidth plans as "down/up" in Mbps, e.g.
+// "iot" = "1/1" (0 leaves a direction unlimited), and how long a client must
+// exceed its plan to count as a violation (<=0 = default)
+func (a *Aggregator) SetPlans(plans map[string]string, sustain time.Duration) error {
+	parsed := make(map[string]linkCapacity, len(plans))
+	for name, spec := range plans {
+		rates, err := parseCapacity(spec)
+		if err != nil {
+			return fmt.Errorf("plan %s: %w", name, err)
+		}
+		parsed[strings.ToLower(name)] = rates
+	}
+	if sustain <= 0 {
+		sustain = defaultPlanSustain
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.plans = parsed
+	a.planSustain = sustain
+	for mac, plan := range a.devicePlans {
+		if _, ok := parsed[plan]; !ok {
+			slog.Warn("Device plan is not defined in [plans]", "mac", mac, "plan", plan)
+		}
+	}
+	return nil
+}
+
+// SetDevicePlans replaces the plans of devices, e.g. "aa:bb:.." = "iot".
+// A plan set on an alias MAC applies to its primary.
+func (a *Aggregator) SetDevicePlans(devicePlans map[string]string) {
+	parsed := make(map[string]string, len(devicePlans))
+	for mac, plan := range devicePlans {
+		if plan = strings.ToLower(strings.TrimSpace(plan)); plan != "" {
+			parsed[strings.ToLower(mac)] = plan
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.devicePlans = a.primaryPlans(parsed)
+	a.applyDevicePlans()
+}
+
+// primaryPlans keys device plans by primary MAC, the primary's own plan wins
+// over one set on an alias
+func (a *Aggregator) primaryPlans(plans map[string]string) map[string]string {
+	keyed := make(map[string]string, len(plans))
+	for mac, plan := range plans {
+		primary := a.PrimaryMAC(mac)
+		if _, ok := keyed[primary]; ok && primary != mac {
+			continue
+		}
+		keyed[primary] = plan
+	}
+	return keyed
+}
+
+// applyDevicePlans updates the clients after a change of the device plans.
+// Caller holds mu.
+func (a *Aggregator) applyDevicePlans() {
+	for mac := range a.planStates {
+		if _, ok := a.devicePlans[mac]; !ok {
+			delete(a.planStates, mac)
+		}
+	}
+	for mac, c := range a.clients {
+		c.Plan = a.devicePlans[mac]
+		if p, ok := a.planStates[mac]; ok {
+			c.PlanViolations = p.violations
+		} else {
+			c.PlanViolations = 0
+		}
+	}
+}
+
+// checkPlan compares the client's speeds against its plan and counts
+// sustained violations. Caller holds mu.
+func (a *Aggregator) checkPlan(c *model.ClientStats, now time.Time) {
+	plan, ok := a.devicePlans[c.MAC]
+	if !ok {
+		return
+	}
+	rates, ok := a.plans[plan]
+	if !ok {
+		return
+	}
+
+	p, ok := a.planStates[c.MAC]
+	if !ok {
+		p = &planState{}
+		a.planStates[c.MAC] = p
+	}
+
+	over := (rates.Download > 0 && c.DownloadSpeed > rates.Download) || (rates.Upload > 0 && c.UploadSpeed > rates.Upload)
+	if !over {
+		p.overSince = time.Time{}
+		p.violating = false
+		return
+	}
+	if p.overSince.IsZero() {
+		p.overSince = now
+	}
+	if now.Sub(p.overSince) < a.planSustain {
+		return
+	}
+
+	if !p.violating {
+		p.violating = true
+		p.violations++
+		c.PlanViolations = p.violations
+		p.since = p.overSince
+		p.peakDownload, p.peakUpload = 0, 0
+		p.plan, p.rates = plan, rates
+		if p.first.IsZero() {
+			p.first = p.since
+		}
+		slog.Warn("Client exceeds its plan", "mac", c.MAC, "name", c.Name, "plan", plan,
+			"download", c.DownloadSpeed, "upload", c.UploadSpeed, "violations", p.violations)
+	}
+	p.last = now
+	p.peakDownload = max(p.peakDownload, c.DownloadSpeed)
+	p.peakUpload = max(p.peakUpload, c.UploadSpeed)
+}
+
+// GetPlanViolations returns the clients with a plan that violated it at
+// least once, currently violating first, then by last violation
+func (a *Aggregator) GetPlanViolations() []model.PlanViolation {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	list := make([]model.PlanViolation, 0)
+	for mac, p := range a.planStates {
+		if p.violations == 0 {
+			continue
+		}
+		name := mac
+		if c, ok := a.clients[mac]; ok {
+			name = c.Name
+		}
+		list = append(list, model.PlanViolation{
+			MAC:           mac,
+			Name:          name,
+			Plan:          p.plan,
+			DownloadLimit: p.rates.Download,
+			UploadLimit:   p.rates.Upload,
+			Download:      p.peakDownload,
+			Upload:        p.peakUpload,
+			Violations:    p.violations,
+			Reason:        p.reason(),
+			FirstSeen:     p.first,
+			Since:         p.since,
+			LastSeen:      p.last,
+			Duration:      uint64(p.last.Sub(p.since).Seconds()),
+			Active:        p.violating,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Active != list[j].Active {
+			return list[i].Active
+		}
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+	return list
+}
+
+// reason describes the last violation
+func (p *planState) reason() string {
+	var over []string
+	if p.rates.Download > 0 && p.peakDownload > p.rates.Download {
+		over = append(over, fmt.Sprintf("download %.2f Mbps above %.2f", mbps(p.peakDownload), mbps(p.rates.Download)))
+	}
+	if p.rates.Upload > 0 && p.peakUpload > p.rates.Upload {
+		over = append(over, fmt.Sprintf("upload %.2f Mbps above %.2f", mbps(p.peakUpload), mbps(p.rates.Upload)))
+	}
+	return fmt.Sprintf("plan %s: %s for %s", p.plan, strings.Join(over, ", "), p.last.Sub(p.since).Round(time.Second))
+}
+
+// mbps converts bytes/sec to megabits/sec
+func mbps(bps uint64) float64 {
+	return float64(bps) * 8 / 1e6
+}
@@ -0,0 +1,99 @@
+package stats
+
+import (
+	"slices"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+const (
+	defaultIdleGap  = 10 * time.Minute
+	maxPresenceKept = 200 // Closed sessions kept per client
+)
+
+// presence tracks the wake/sleep sessions of one client
+type presence struct {
+	current *model.PresenceSession // Open session, nil while idle
+	closed  []model.PresenceSession
+
+	// Totals when the current session started, or of the last idle check
+	baseDownload uint64
+	baseUpload   uint64
+}
+
+// SetIdleGap sets how long a client must be without traffic before its
+// presence session ends. The next traffic starts a new one.
+func (a *Aggregator) SetIdleGap(d time.Duration) {
+	if d <= 0 {
+		d = defaultIdleGap
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.idleGap = d
+}
+
+func (a *Aggregator) GetIdleGap() time.Duration {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.idleGap
+}
+
+// trackPresence opens or closes the client's presence session. Caller holds mu.
+func (a *Aggregator) trackPresence(c *model.ClientStats, now time.Time) {
+	p, ok := a.presence[c.MAC]
+	if !ok {
+		p = &presence{baseDownload: c.TotalDownload, baseUpload: c.TotalUpload}
+		a.presence[c.MAC] = p
+	}
+	awake := !c.LastActive.IsZero() && now.Sub(c.LastActive) < a.idleGap
+
+	if s := p.current; s != nil {
+		s.End = c.LastActive
+		s.Download = safeSub(c.TotalDownload, p.baseDownload)
+		s.Upload = safeSub(c.TotalUpload, p.baseUpload)
+		s.Duration = uint64(s.End.Sub(s.Start).Seconds())
+		if awake {
+			return
+		}
+		s.Active = false
+		p.closed = append(p.closed, *s)
+		if len(p.closed) > maxPresenceKept {
+			p.closed = slices.Delete(p.closed, 0, len(p.closed)-maxPresenceKept)
+		}
+		p.current = nil
+	}
+
+	// Traffic since the last closed session wakes the client up. The base is
+	// still the previous check's, so the waking traffic counts.
+	if awake && (len(p.closed) == 0 || c.LastActive.After(p.closed[len(p.closed)-1].End)) {
+		p.current = &model.PresenceSession{
+			Start:    c.LastActive,
+			End:      c.LastActive,
+			Download: safeSub(c.TotalDownload, p.baseDownload),
+			Upload:   safeSub(c.TotalUpload, p.baseUpload),
+			Active:   true,
+		}
+		return
+	}
+	p.baseDownload, p.baseUpload = c.TotalDownload, c.TotalUpload
+}
+
+// GetClientSessions returns a client's presence sessions, newest first
+func (a *Aggregator) GetClientSessions(mac string) []model.PresenceSession {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	p, ok := a.presence[mac]
+	if !ok {
+		return []model.PresenceSession{}
+	}
+	list := make([]model.PresenceSession, 0, len(p.closed)+1)
+	if p.current != nil {
+		list = append(list, *p.current)
+	}
+	for i := len(p.closed) - 1; i >= 0; i-- {
+		list = append(list, p.closed[i])
+	}
+	return list
+}
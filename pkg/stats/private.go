@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// SetPrivateMACs marks clients whose traffic is counted only in aggregate:
+// totals, speeds and connection counts. Their flows' remote addresses, ports
+// and server names are never recorded in the archive, elephant lists,
+// countries, categories or classes, nor listed by any flow API. Details
+// recorded before a client became private are scrubbed.
+func (a *Aggregator) SetPrivateMACs(macs []string) {
+	private := make(map[string]bool, len(macs))
+	for _, m := range macs {
+		private[a.PrimaryMAC(strings.ToLower(strings.TrimSpace(m)))] = true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.privateMACs = private
+	for mac, c := range a.clients {
+		c.Private = private[mac]
+		if c.Private {
+			a.scrubClient(mac)
+		}
+	}
+}
+
+// IsPrivate reports whether a client is counted only in aggregate
+func (a *Aggregator) IsPrivate(mac string) bool {
+	mac = a.PrimaryMAC(mac)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.privateMACs[mac]
+}
+
+// isPrivateFlow reports whether either end of a flow is a private client. Caller holds mu.
+func (a *Aggregator) isPrivateFlow(f *FlowTracker) bool {
+	if len(a.privateMACs) == 0 {
+		return false
+	}
+	return a.privateMACs[a.macOf(f.SrcIP)] || a.privateMACs[a.macOf(f.DstIP)]
+}
+
+// scrubClient drops the flow details recorded for a client. Caller holds mu.
+func (a *Aggregator) scrubClient(mac string) {
+	delete(a.clientCategories, mac)
+	delete(a.clientClasses, mac)
+	delete(a.clientCountries, mac)
+	delete(a.fanouts, mac)
+	delete(a.remoteRTT, mac)
+	delete(a.clientRTT, mac)
+	a.archive = slices.DeleteFunc(a.archive, func(f model.ArchivedFlow) bool { return f.MAC == mac })
+	a.recentElephants = slices.DeleteFunc(a.recentElephants, func(e model.ElephantFlow) bool { return e.MAC == mac })
+
+	for i := range a.shards {
+		s := &a.shards[i]
+		s.mu.Lock()
+		for _, f := range s.flows {
+			if a.macOf(f.SrcIP) == mac || a.macOf(f.DstIP) == mac {
+				f.SNI = ""
+				f.ElephantAt, f.ElephantReason, f.RateAboveSince = time.Time{}, "", time.Time{}
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	// The next speed calculation publishes the flows marked private
+	var kept []flowView
+	for _, f := range a.flowSnapshot() {
+		if f.SrcMAC != mac && f.DstMAC != mac {
+			kept = append(kept, f)
+		}
+	}
+	a.publishFlows(kept)
+}
@@ -0,0 +1,55 @@
+package stats
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// Clients with traffic within this window count as online even without a neighbor entry
+const onlineWindow = 5 * time.Minute
+
+// SetClientRetention evicts clients that have been offline longer than d. Zero keeps them forever.
+func (a *Aggregator) SetClientRetention(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clientRetention = d
+}
+
+// expired reports whether an offline client is past the retention. Caller holds mu.
+func (a *Aggregator) expired(c *model.ClientStats, now time.Time) bool {
+	if a.clientRetention <= 0 || c.Online {
+		return false
+	}
+	last := c.LastActive
+	if last.IsZero() {
+		last = c.StartTime
+	}
+	return now.Sub(last) > a.clientRetention
+}
+
+// evictClient logs and drops a client past the retention. Caller holds mu.
+func (a *Aggregator) evictClient(mac string) {
+	c := a.clients[mac]
+	slog.Info("Evicting inactive client", "mac", mac, "name", c.Name, "last_active", c.LastActive)
+	a.dropClient(mac)
+}
+
+// dropClient deletes a client and its per-client state. Caller holds mu.
+func (a *Aggregator) dropClient(mac string) {
+	delete(a.clients, mac)
+	delete(a.clientWindows, mac)
+	delete(a.uploadBaselines, mac)
+	delete(a.fanouts, mac)
+	delete(a.planStates, mac)
+	delete(a.clientCategories, mac)
+	delete(a.clientClasses, mac)
+	delete(a.clientCountries, mac)
+	delete(a.presence, mac)
+	delete(a.speedTests, mac)
+	delete(a.clientRTT, mac)
+	delete(a.remoteRTT, mac)
+	delete(a.sessionRecords, mac)
+	a.dropArchived(mac)
+}
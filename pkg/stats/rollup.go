@@ -0,0 +1,344 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+const (
+	rollupSampleInterval = 10 * time.Second
+	dayLayout            = "2006-01-02"
+)
+
+// UsageDay is one calendar day of traffic
+type UsageDay struct {
+	Download uint64 `json:"download"`
+	Upload   uint64 `json:"upload"`
+}
+
+type usageCounter struct {
+	days     map[string]*UsageDay // Key: date in the rollup timezone
+	lastDown uint64               // Last seen cumulative totals
+	lastUp   uint64
+	init     bool
+}
+
+// UsageRollup closes out traffic into calendar days (midnight in the configured
+// timezone) and keeps the last N days per client and globally, so usage can be
+// compared against ISP data caps. Weeks and months are summed from days.
+type UsageRollup struct {
+	agg  *Aggregator
+	loc  *time.Location
+	days int
+
+	mu      sync.RWMutex
+	global  *usageCounter
+	clients map[string]*usageCounter // Key: MAC
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewUsageRollup(agg *Aggregator, loc *time.Location, days int) *UsageRollup {
+	if loc == nil {
+		loc = time.Local
+	}
+	if days <= 0 {
+		days = 90 // Default
+	}
+	return &UsageRollup{
+		agg:     agg,
+		loc:     loc,
+		days:    days,
+		global:  &usageCounter{days: make(map[string]*UsageDay)},
+		clients: make(map[string]*usageCounter),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start begins sampling. Totals present at start (e.g. restored after a
+// restart) are taken as the baseline and not counted again.
+func (r *UsageRollup) Start() {
+	r.sample()
+
+	r.wg.Go(func() {
+		ticker := time.NewTicker(rollupSampleInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.sample()
+			case <-r.stop:
+				return
+			}
+		}
+	})
+}
+
+// Stop takes a last sample so traffic up to shutdown is accounted
+func (r *UsageRollup) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+	r.sample()
+}
+
+func (r *UsageRollup) sample() {
+	now := time.Now().In(r.loc)
+	today := now.Format(dayLayout)
+	global := r.agg.GetGlobalStats()
+	clients := r.agg.GetClients()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.global.record(today, global.TotalDownload, global.TotalUpload)
+
+	seen := make(map[string]struct{}, len(clients))
+	for _, c := range clients {
+		seen[c.MAC] = struct{}{}
+		uc, ok := r.clients[c.MAC]
+		if !ok {
+			uc = &usageCounter{days: make(map[string]*UsageDay)}
+			r.clients[c.MAC] = uc
+		}
+		uc.record(today, c.TotalDownload, c.TotalUpload)
+	}
+
+	// Drop days beyond retention, and clients left without any
+	cutoff := now.AddDate(0, 0, -r.days).Format(dayLayout)
+	r.global.prune(cutoff)
+	for mac, uc := range r.clients {
+		uc.prune(cutoff)
+		if _, ok := seen[mac]; !ok {
+			uc.init = false // Counters restart if the client returns
+			if len(uc.days) == 0 {
+				delete(r.clients, mac)
+			}
+		}
+	}
+}
+
+// record adds the traffic since the last sample to the given day
+func (uc *usageCounter) record(day string, totalDown, totalUp uint64) {
+	if !uc.init {
+		uc.lastDown, uc.lastUp, uc.init = totalDown, totalUp, true
+		return
+	}
+
+	down, up := totalDown-uc.lastDown, totalUp-uc.lastUp
+	if totalDown < uc.lastDown || totalUp < uc.lastUp {
+		// Counters were reset, everything since then is new traffic
+		down, up = totalDown, totalUp
+	}
+	uc.lastDown, uc.lastUp = totalDown, totalUp
+
+	if down == 0 && up == 0 {
+		return
+	}
+	d, ok := uc.days[day]
+	if !ok {
+		d = &UsageDay{}
+		uc.days[day] = d
+	}
+	d.Download += down
+	d.Upload += up
+}
+
+func (uc *usageCounter) prune(cutoff string) {
+	for day := range uc.days {
+		if day < cutoff {
+			delete(uc.days, day)
+		}
+	}
+}
+
+// Replay adds logged traffic of a client (or global if mac is empty) to the
+// day of t. Call before Start.
+func (r *UsageRollup) Replay(mac string, t time.Time, down, up uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	uc := r.global
+	if mac != "" {
+		if uc = r.clients[mac]; uc == nil {
+			uc = &usageCounter{days: make(map[string]*UsageDay)}
+			r.clients[mac] = uc
+		}
+	}
+	day := t.In(r.loc).Format(dayLayout)
+	d, ok := uc.days[day]
+	if !ok {
+		d = &UsageDay{}
+		uc.days[day] = d
+	}
+	d.Download += down
+	d.Upload += up
+}
+
+// Query returns usage per period ("daily", "weekly" or "monthly"), oldest first.
+// An empty mac returns global usage. Weeks start on Monday.
+func (r *UsageRollup) Query(mac, period string) ([]model.UsagePeriod, error) {
+	switch period {
+	case "daily", "weekly", "monthly":
+	default:
+		return nil, fmt.Errorf("invalid period %q (want daily, weekly or monthly)", period)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	uc := r.global
+	if mac != "" {
+		uc = r.clients[mac]
+	}
+	if uc == nil {
+		return []model.UsagePeriod{}, nil
+	}
+
+	buckets := make(map[time.Time]*model.UsagePeriod)
+	for day, d := range uc.days {
+		t, err := time.ParseInLocation(dayLayout, day, r.loc)
+		if err != nil {
+			continue
+		}
+		switch period {
+		case "weekly":
+			offset := (int(t.Weekday()) + 6) % 7 // Days since Monday
+			t = t.AddDate(0, 0, -offset)
+		case "monthly":
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, r.loc)
+		}
+
+		b, ok := buckets[t]
+		if !ok {
+			b = &model.UsagePeriod{Start: t}
+			buckets[t] = b
+		}
+		b.Download += d.Download
+		b.Upload += d.Upload
+	}
+
+	list := make([]model.UsagePeriod, 0, len(buckets))
+	for _, b := range buckets {
+		switch period {
+		case "daily":
+			b.End = b.Start.AddDate(0, 0, 1)
+		case "weekly":
+			b.End = b.Start.AddDate(0, 0, 7)
+		case "monthly":
+			b.End = b.Start.AddDate(0, 1, 0)
+		}
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Start.Before(list[j].Start) })
+	return list, nil
+}
+
+// QueryBetween is Query limited to the periods overlapping [from, to)
+func (r *UsageRollup) QueryBetween(mac, period string, from, to time.Time) ([]model.UsagePeriod, error) {
+	periods, err := r.Query(mac, period)
+	if err != nil {
+		return nil, err
+	}
+	list := periods[:0]
+	for _, p := range periods {
+		if p.End.After(from) && p.Start.Before(to) {
+			list = append(list, p)
+		}
+	}
+	return list, nil
+}
+
+// Today returns usage of the current calendar day. An empty mac returns global usage.
+func (r *UsageRollup) Today(mac string) UsageDay {
+	today := time.Now().In(r.loc).Format(dayLayout)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	uc := r.global
+	if mac != "" {
+		uc = r.clients[mac]
+	}
+	if uc == nil || uc.days[today] == nil {
+		return UsageDay{}
+	}
+	return *uc.days[today]
+}
+
+// Totals sums the days in [from, to), globally and per client. Clients
+// without traffic in the range are left out.
+func (r *UsageRollup) Totals(from, to time.Time) (UsageDay, map[string]UsageDay) {
+	first, last := from.In(r.loc).Format(dayLayout), to.In(r.loc).Format(dayLayout)
+	sum := func(uc *usageCounter) UsageDay {
+		var total UsageDay
+		for day, d := range uc.days {
+			if day >= first && day < last {
+				total.Download += d.Download
+				total.Upload += d.Upload
+			}
+		}
+		return total
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	clients := make(map[string]UsageDay)
+	for mac, uc := range r.clients {
+		if t := sum(uc); t.Download+t.Upload > 0 {
+			clients[mac] = t
+		}
+	}
+	return sum(r.global), clients
+}
+
+// Location returns the timezone used for day boundaries
+func (r *UsageRollup) Location() *time.Location {
+	return r.loc
+}
+
+// Export returns the daily usage for persistence. The "" key holds global usage.
+func (r *UsageRollup) Export() map[string]map[string]UsageDay {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make(map[string]map[string]UsageDay, len(r.clients)+1)
+	export := func(uc *usageCounter) map[string]UsageDay {
+		days := make(map[string]UsageDay, len(uc.days))
+		for day, d := range uc.days {
+			days[day] = *d
+		}
+		return days
+	}
+	out[""] = export(r.global)
+	for mac, uc := range r.clients {
+		out[mac] = export(uc)
+	}
+	return out
+}
+
+// Import restores persisted daily usage, replacing the imported days
+func (r *UsageRollup) Import(usage map[string]map[string]UsageDay) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for mac, days := range usage {
+		uc := r.global
+		if mac != "" {
+			if uc = r.clients[mac]; uc == nil {
+				uc = &usageCounter{days: make(map[string]*UsageDay)}
+				r.clients[mac] = uc
+			}
+		}
+		uc.init = false // Totals are restored too, take a new baseline
+		for day, d := range days {
+			uc.days[day] = &d
+		}
+	}
+}
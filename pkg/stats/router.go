@@ -0,0 +1,134 @@
+package stats
+
+import (
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/kisy/catchmole/pkg/monitor"
+	"github.com/vishvananda/netlink"
+)
+
+// Listening sockets change rarely and finding their processes walks /proc
+const listenerRefresh = 30 * time.Second
+
+// RouterMAC is the client key of the router's own traffic (DNS forwarder,
+// VPN endpoints, package updates), which has no LAN MAC.
+const RouterMAC = "router"
+
+// SetRouterTraffic enables accounting of the router's own traffic as a
+// synthetic client, disabling it removes that client
+func (a *Aggregator) SetRouterTraffic(enabled bool) {
+	a.mu.Lock()
+	a.routerTraffic = enabled
+	a.servicesAt = time.Time{}
+	if !enabled {
+		a.routerIPs = nil
+		a.localServices = nil
+		if _, ok := a.clients[RouterMAC]; ok {
+			a.dropClient(RouterMAC)
+		}
+	}
+	a.mu.Unlock()
+
+	a.refreshRouterIPs()
+}
+
+// refreshRouterIPs collects the addresses of all local interfaces
+func (a *Aggregator) refreshRouterIPs() {
+	a.mu.RLock()
+	enabled := a.routerTraffic
+	a.mu.RUnlock()
+	if !enabled {
+		return
+	}
+
+	addrs, err := monitor.Netlink().AddrList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return
+	}
+
+	ips := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		ip := addr.IP
+		// Loopback traffic never leaves the box, link-local is not routed
+		if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		ips[ip.String()] = true
+	}
+
+	a.mu.Lock()
+	if a.routerTraffic {
+		a.routerIPs = ips
+	}
+	refresh := time.Since(a.servicesAt) >= listenerRefresh
+	if refresh {
+		a.servicesAt = time.Now()
+	}
+	a.mu.Unlock()
+
+	if refresh {
+		a.refreshLocalServices()
+	}
+}
+
+// refreshLocalServices names the router's listening ports after the process
+// owning them, so flows to the router read e.g. "AdGuardHome" or "smbd"
+func (a *Aggregator) refreshLocalServices() {
+	listeners, err := monitor.Listeners()
+	if err != nil {
+		slog.Debug("Failed to read listening sockets", "err", err)
+		return
+	}
+	services := make(map[monitor.Listener]string, len(listeners))
+	for l, name := range listeners {
+		if name == "" {
+			// Kernel socket, e.g. WireGuard
+			if name = serviceName(l.Proto, l.Port); name == otherCategory {
+				name = "kernel"
+			}
+		}
+		services[l] = name
+	}
+
+	a.mu.Lock()
+	if a.routerTraffic {
+		a.localServices = services
+	}
+	a.mu.Unlock()
+}
+
+// isRouterIP reports whether ip is one of the router's own addresses. Caller holds mu.
+func (a *Aggregator) isRouterIP(ip net.IP) bool {
+	return a.routerIPs[ip.String()]
+}
+
+// macOf returns the client MAC of a LAN address, the key of a VPN peer, or
+// RouterMAC for the router's own addresses when enabled. Caller holds mu.
+func (a *Aggregator) macOf(ip string) string {
+	return a.resolveMAC(a.routerIPs, ip)
+}
+
+// localService returns the router service a flow terminates on, or "".
+// The maps are passed in for use without mu.
+func localService(routerIPs map[string]bool, services map[monitor.Listener]string, f *FlowTracker) string {
+	if !routerIPs[f.DstIP] {
+		return ""
+	}
+	return services[monitor.Listener{Proto: f.Proto, Port: f.DstPort}]
+}
+
+// resolveMAC is macOf with the router addresses passed in, for use without mu
+func (a *Aggregator) resolveMAC(routerIPs map[string]bool, ip string) string {
+	if mac := a.nw.GetMAC(ip); mac != "" {
+		return a.PrimaryMAC(mac)
+	}
+	if mac := a.tunnelPeer(ip); mac != "" {
+		return mac
+	}
+	if routerIPs[ip] {
+		return RouterMAC
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package stats
+
+import (
+	"sort"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// serviceDef maps a protocol and port range to a well-known service
+type serviceDef struct {
+	Name  string
+	Proto uint8 // 6 = TCP, 17 = UDP
+	From  uint16
+	To    uint16
+}
+
+var wellKnownServices = []serviceDef{
+	{"HTTPS", 6, 443, 443},
+	{"QUIC", 17, 443, 443},
+	{"HTTP", 6, 80, 80},
+	{"HTTP", 6, 8080, 8080},
+	{"DNS", 17, 53, 53},
+	{"DNS", 6, 53, 53},
+	{"DoT", 6, 853, 853},
+	{"NTP", 17, 123, 123},
+	{"SSH", 6, 22, 22},
+	{"RDP", 6, 3389, 3389},
+	{"Mail", 6, 25, 25},
+	{"Mail", 6, 465, 465},
+	{"Mail", 6, 587, 587},
+	{"Mail", 6, 993, 993},
+	{"Mail", 6, 995, 995},
+	{"BitTorrent", 6, 6881, 6889},
+	{"BitTorrent", 17, 6881, 6889},
+	{"BitTorrent", 6, 51413, 51413},
+	{"BitTorrent", 17, 51413, 51413},
+	{"WireGuard", 17, 51820, 51820},
+	{"OpenVPN", 17, 1194, 1194},
+	{"OpenVPN", 6, 1194, 1194},
+	{"STUN", 17, 3478, 3479},
+	{"STUN", 17, 19302, 19309},
+	{"MQTT", 6, 1883, 1883},
+	{"MQTT", 6, 8883, 8883},
+}
+
+// serviceName returns the well-known service of a flow's service port
+func serviceName(proto uint8, port uint16) string {
+	for _, s := range wellKnownServices {
+		if s.Proto == proto && port >= s.From && port <= s.To {
+			return s.Name
+		}
+	}
+	return otherCategory
+}
+
+// GetClientServices groups a client's tracked flows by well-known service
+// (destination port of the original direction), largest first
+func (a *Aggregator) GetClientServices(mac string) []model.ServiceStats {
+	byService := make(map[string]*model.ServiceStats)
+	flows := a.flowSnapshot()
+	for i := range flows {
+		f := &flows[i]
+		isSrc := f.SrcMAC == mac
+		isDst := !isSrc && f.DstMAC == mac
+		if (!isSrc && !isDst) || f.Private {
+			continue
+		}
+
+		name := serviceName(f.Proto, f.DstPort)
+		s, ok := byService[name]
+		if !ok {
+			s = &model.ServiceStats{Service: name}
+			byService[name] = s
+		}
+
+		// Src: Orig is upload, Reply is download. Dst: the other way round.
+		if isSrc {
+			s.TotalDownload += f.TotalReplyBytes
+			s.TotalUpload += f.TotalOriginBytes
+			s.DownloadSpeed += f.ReplySpeed
+			s.UploadSpeed += f.OrigSpeed
+		} else {
+			s.TotalDownload += f.TotalOriginBytes
+			s.TotalUpload += f.TotalReplyBytes
+			s.DownloadSpeed += f.OrigSpeed
+			s.UploadSpeed += f.ReplySpeed
+		}
+		s.ActiveConnections++
+	}
+
+	list := make([]model.ServiceStats, 0, len(byService))
+	for _, s := range byService {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		ti, tj := list[i].TotalDownload+list[i].TotalUpload, list[j].TotalDownload+list[j].TotalUpload
+		if ti != tj {
+			return ti > tj
+		}
+		return list[i].Service < list[j].Service
+	})
+	return list
+}
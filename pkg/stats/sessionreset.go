@@ -0,0 +1,170 @@
+package stats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// Closed sessions kept per client
+const maxSessionRecords = 100
+
+// SessionSchedule is when session totals roll over:
+//
+//	daily 04:00
+//	weekly mon 04:00
+//	monthly 1 04:00   (days past the end of a month roll over on its last day)
+type SessionSchedule struct {
+	period  string // daily, weekly or monthly
+	weekday time.Weekday
+	day     int
+	minute  int // Of day
+}
+
+// ParseSessionSchedule parses a session_reset spec, "" is no schedule
+func ParseSessionSchedule(spec string) (*SessionSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	invalid := fmt.Errorf("invalid session_reset %q (want daily HH:MM, weekly DAY HH:MM or monthly N HH:MM)", spec)
+
+	s := &SessionSchedule{period: fields[0]}
+	switch {
+	case s.period == "daily" && len(fields) == 2:
+	case s.period == "weekly" && len(fields) == 3:
+		wd, ok := weekdays[strings.ToLower(fields[1])[:min(len(fields[1]), 3)]]
+		if !ok {
+			return nil, invalid
+		}
+		s.weekday = wd
+	case s.period == "monthly" && len(fields) == 3:
+		d, err := strconv.Atoi(fields[1])
+		if err != nil || d < 1 || d > 31 {
+			return nil, invalid
+		}
+		s.day = d
+	default:
+		return nil, invalid
+	}
+
+	t, err := time.Parse("15:04", fields[len(fields)-1])
+	if err != nil {
+		return nil, invalid
+	}
+	s.minute = t.Hour()*60 + t.Minute()
+	return s, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Next returns the first rollover after t, in t's location
+func (s *SessionSchedule) Next(t time.Time) time.Time {
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, s.minute/60, s.minute%60, 0, 0, t.Location())
+	}
+	y, m, d := t.Date()
+
+	switch s.period {
+	case "weekly":
+		next := at(y, m, d+(int(s.weekday)-int(t.Weekday())+7)%7)
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 7)
+		}
+		return next
+	case "monthly":
+		day := func(y int, m time.Month) int {
+			last := time.Date(y, m+1, 0, 0, 0, 0, 0, t.Location()).Day()
+			return min(s.day, last)
+		}
+		next := at(y, m, day(y, m))
+		if !next.After(t) {
+			next = at(y, m+1, day(y, m+1))
+		}
+		return next
+	}
+	next := at(y, m, d)
+	if !next.After(t) {
+		next = at(y, m, d+1)
+	}
+	return next
+}
+
+// SetSessionReset rolls session totals over on a schedule in loc, nil
+// leaves them to manual resets
+func (a *Aggregator) SetSessionReset(s *SessionSchedule, loc *time.Location) {
+	if loc == nil {
+		loc = time.Local
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sessionReset = s
+	a.nextSessionReset = time.Time{}
+	if s != nil {
+		a.nextSessionReset = s.Next(time.Now().In(loc))
+	}
+}
+
+// rolloverSessions archives and restarts the sessions of all clients when
+// the schedule is due. Caller holds mu.
+func (a *Aggregator) rolloverSessions(now time.Time) {
+	if a.sessionReset == nil || now.Before(a.nextSessionReset) {
+		return
+	}
+	a.nextSessionReset = a.sessionReset.Next(now.In(a.nextSessionReset.Location()))
+
+	for _, c := range a.clients {
+		a.closeSession(c, now, "scheduled")
+	}
+	// Flows keep their totals, their session counts from here
+	for i := range a.shards {
+		s := &a.shards[i]
+		s.mu.Lock()
+		for _, f := range s.flows {
+			f.SessionStartOriginBytes = f.TotalOriginBytes
+			f.SessionStartReplyBytes = f.TotalReplyBytes
+		}
+		s.mu.Unlock()
+	}
+}
+
+// closeSession archives a client's session totals, if any, and starts a
+// new session. Caller holds mu.
+func (a *Aggregator) closeSession(c *model.ClientStats, now time.Time, reason string) {
+	if c.SessionDownload > 0 || c.SessionUpload > 0 {
+		records := append(a.sessionRecords[c.MAC], model.SessionRecord{
+			Start:    c.SessionStart,
+			End:      now,
+			Download: c.SessionDownload,
+			Upload:   c.SessionUpload,
+			Reason:   reason,
+		})
+		if len(records) > maxSessionRecords {
+			records = records[len(records)-maxSessionRecords:]
+		}
+		a.sessionRecords[c.MAC] = records
+	}
+	c.SessionDownload = 0
+	c.SessionUpload = 0
+	c.SessionStart = now
+}
+
+// GetSessionHistory returns the closed sessions of a client, newest first.
+// They are kept in memory only and start over after a restart.
+func (a *Aggregator) GetSessionHistory(mac string) []model.SessionRecord {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	records := a.sessionRecords[mac]
+	list := make([]model.SessionRecord, 0, len(records))
+	for i := len(records) - 1; i >= 0; i-- {
+		list = append(list, records[i])
+	}
+	return list
+}
@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// Number of flow shards. Busy routers track 50k+ flows; splitting them keeps
+// the speed loop from holding one lock over the whole table.
+const flowShards = 32
+
+// flowShard owns a part of the flow table. Its mu guards the map and the
+// FlowTrackers in it. Lock order: Aggregator.mu before flowShard.mu.
+type flowShard struct {
+	mu    sync.Mutex
+	flows map[string]*FlowTracker // Key: FlowHash
+}
+
+// flowView is a copy of a flow published for API reads, with both endpoints
+// already resolved to client MACs
+type flowView struct {
+	FlowTracker
+	SrcMAC       string
+	DstMAC       string
+	LocalService string // Router process the flow terminates on
+	Private      bool   // Either end is a private client, not listed by flow APIs
+}
+
+// clearFlows empties all shards
+func (a *Aggregator) clearFlows() {
+	for i := range a.shards {
+		s := &a.shards[i]
+		s.mu.Lock()
+		a.flowCount.Add(-int64(len(s.flows)))
+		s.flows = make(map[string]*FlowTracker)
+		s.mu.Unlock()
+	}
+	a.publishFlows(nil)
+}
+
+// shardFor returns the shard owning key
+func (a *Aggregator) shardFor(key string) *flowShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &a.shards[h.Sum32()%flowShards]
+}
+
+// flowSnapshot returns the flows as of the last speed calculation.
+// The slice is shared and must not be modified.
+func (a *Aggregator) flowSnapshot() []flowView {
+	if p := a.snapshot.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+func (a *Aggregator) publishFlows(flows []flowView) {
+	a.snapshot.Store(&flows)
+}
+
+// dropFlows deletes the flows of a client from the table and the snapshot. Caller holds mu.
+func (a *Aggregator) dropFlows(mac string) {
+	for i := range a.shards {
+		s := &a.shards[i]
+		s.mu.Lock()
+		for k, f := range s.flows {
+			if a.macOf(f.SrcIP) == mac || a.macOf(f.DstIP) == mac {
+				delete(s.flows, k)
+				a.flowCount.Add(-1)
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	var kept []flowView
+	for _, f := range a.flowSnapshot() {
+		if f.SrcMAC != mac && f.DstMAC != mac {
+			kept = append(kept, f)
+		}
+	}
+	a.publishFlows(kept)
+}
@@ -0,0 +1,56 @@
+package stats
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// Defaults of the speed and connection smoothing settings
+const (
+	defaultAlpha        = 0.2
+	defaultMinElapsedMs = 500
+)
+
+func defaultSmoothing() model.Smoothing {
+	return model.Smoothing{Alpha: defaultAlpha, MinElapsedMs: defaultMinElapsedMs}
+}
+
+// SetSmoothing tunes how snappy or stable the reported numbers are. Zero
+// Alpha and MinElapsedMs select the defaults, zero WindowSeconds reports
+// speeds per interval.
+func (a *Aggregator) SetSmoothing(s model.Smoothing) error {
+	if s.Alpha == 0 {
+		s.Alpha = defaultAlpha
+	}
+	if s.MinElapsedMs <= 0 {
+		s.MinElapsedMs = defaultMinElapsedMs
+	}
+	if s.Alpha < 0 || s.Alpha > 1 {
+		return fmt.Errorf("alpha %v out of range (0, 1]", s.Alpha)
+	}
+	if s.WindowSeconds < 0 || time.Duration(s.WindowSeconds)*time.Second > maxSpeedWindow {
+		return fmt.Errorf("speed window %ds out of range [0, %d]", s.WindowSeconds, int(maxSpeedWindow.Seconds()))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.smoothing = s
+	return nil
+}
+
+func (a *Aggregator) GetSmoothing() model.Smoothing {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.smoothing
+}
+
+// ema moves a smoothed value towards raw. A zero value is seeded with raw so
+// counts don't ramp up slowly from startup.
+func ema(smoothed float64, raw uint64, alpha float64) float64 {
+	if smoothed == 0 && raw > 0 {
+		return float64(raw)
+	}
+	return alpha*float64(raw) + (1-alpha)*smoothed
+}
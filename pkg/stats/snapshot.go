@@ -0,0 +1,128 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// MetricsSnapshot is the state exported to Prometheus, copied under a single
+// read lock so a scrape sees one consistent tick and blocks event processing
+// only briefly
+type MetricsSnapshot struct {
+	Time    time.Time
+	Start   time.Time // Aggregator start
+	Global  model.GlobalStats
+	Clients []ClientMetrics // Sorted by MAC
+
+	GlobalClasses []model.ClassStats
+	Countries     []model.CountryStats
+	Zones         []model.ZoneStats
+	Groups        []model.GroupStats
+	Tags          []model.GroupStats
+	VLANs         []model.VLANStats
+
+	// Health of the aggregator
+	Monitor        model.MonitorState
+	CappedDeltas   uint64
+	CappedBytes    uint64
+	EvictedFlows   uint64
+	EvictedClients uint64
+	TrackedFlows   int
+	TrackedClients int
+	MaxFlows       int
+	MaxClients     int
+	LastTick       time.Time     // Zero before the first calculation
+	TickDuration   time.Duration // Of the last calculation
+}
+
+// ClientMetrics is a client with its breakdowns
+type ClientMetrics struct {
+	model.ClientStats
+	Protocols        map[string]ByteCount // Protocol name -> bytes of tracked flows
+	Categories       []model.CategoryStats
+	Classes          []model.ClassStats
+	BlockedCountries []model.CountryStats
+}
+
+// ByteCount is traffic seen from a client
+type ByteCount struct {
+	Download uint64
+	Upload   uint64
+}
+
+// MetricsSnapshot copies everything the Prometheus exporter needs
+func (a *Aggregator) MetricsSnapshot() MetricsSnapshot {
+	flows := a.flowSnapshot()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	snap := MetricsSnapshot{
+		Time:           time.Now(),
+		Start:          a.startTime,
+		Global:         a.globalStats(),
+		Clients:        make([]ClientMetrics, 0, len(a.clients)),
+		GlobalClasses:  sortedClasses(a.globalClasses),
+		Countries:      a.sortedCountries(a.globalCountries),
+		Zones:          a.zoneStats(),
+		Groups:         a.groupStats(),
+		Tags:           a.deviceTagStats(),
+		VLANs:          a.vlanStats(),
+		Monitor:        a.monitorState(),
+		CappedDeltas:   a.cappedDeltas,
+		CappedBytes:    a.cappedBytes,
+		EvictedFlows:   a.evictedFlows,
+		EvictedClients: a.evictedClients,
+		TrackedFlows:   len(flows),
+		TrackedClients: len(a.clients),
+		MaxFlows:       a.maxFlows,
+		MaxClients:     a.maxClients,
+		LastTick:       a.lastTick,
+		TickDuration:   a.tickTook,
+	}
+
+	// Protocol totals of all clients in one pass over the flows
+	protocols := make(map[string]map[string]ByteCount)
+	add := func(mac, proto string, down, up uint64) {
+		if mac == "" {
+			return
+		}
+		if protocols[mac] == nil {
+			protocols[mac] = make(map[string]ByteCount)
+		}
+		bc := protocols[mac][proto]
+		bc.Download += down
+		bc.Upload += up
+		protocols[mac][proto] = bc
+	}
+	for i := range flows {
+		f := &flows[i]
+		if f.Private {
+			continue
+		}
+		proto := getProtocolName(f.Proto)
+		add(f.SrcMAC, proto, f.TotalReplyBytes, f.TotalOriginBytes)
+		if f.DstMAC != f.SrcMAC {
+			add(f.DstMAC, proto, f.TotalOriginBytes, f.TotalReplyBytes)
+		}
+	}
+
+	blocked := a.blockedCountryTraffic()
+	for mac, c := range a.clients {
+		cats := make([]model.CategoryStats, 0, len(a.clientCategories[mac]))
+		for _, cs := range a.clientCategories[mac] {
+			cats = append(cats, *cs)
+		}
+		snap.Clients = append(snap.Clients, ClientMetrics{
+			ClientStats:      *c,
+			Protocols:        protocols[mac],
+			Categories:       cats,
+			Classes:          sortedClasses(a.clientClasses[mac]),
+			BlockedCountries: blocked[mac],
+		})
+	}
+	sort.Slice(snap.Clients, func(i, j int) bool { return snap.Clients[i].MAC < snap.Clients[j].MAC })
+	return snap
+}
@@ -0,0 +1,107 @@
+package stats
+
+import "math"
+
+// Speed samples kept per flow, one per calculation interval
+const speedHistoryLen = 60
+
+// speedRing holds the recent speeds of a flow. It is allocated on the first
+// non-zero speed, most tracked flows are idle and never need one.
+// Guarded by the flow's shard lock.
+type speedRing struct {
+	orig  [speedHistoryLen]uint32 // Bytes/s, saturating
+	reply [speedHistoryLen]uint32
+	last  uint64 // Tick of the latest sample
+}
+
+// recordSpeed stores the flow's current speed as the sample of tick. Caller holds the shard lock.
+func (f *FlowTracker) recordSpeed(tick uint64) {
+	if f.History == nil {
+		if f.OrigSpeed == 0 && f.ReplySpeed == 0 {
+			return
+		}
+		f.History = &speedRing{}
+	}
+	r := f.History
+	// Zero the samples of ticks the flow was not seen in
+	if tick-r.last >= speedHistoryLen {
+		*r = speedRing{}
+	} else {
+		for t := r.last + 1; t < tick; t++ {
+			r.orig[t%speedHistoryLen] = 0
+			r.reply[t%speedHistoryLen] = 0
+		}
+	}
+	r.orig[tick%speedHistoryLen] = saturate32(f.OrigSpeed)
+	r.reply[tick%speedHistoryLen] = saturate32(f.ReplySpeed)
+	r.last = tick
+}
+
+// ringOf copies the speed ring of a flow in the table, nil if it has none
+func (a *Aggregator) ringOf(key string) *speedRing {
+	shard := a.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if f, ok := shard.flows[key]; ok && f.History != nil {
+		r := *f.History
+		return &r
+	}
+	return nil
+}
+
+// speedHistory sums the rings of several flows from the client's side
+type speedHistory struct {
+	rings []*speedRing
+	swap  []bool // Client is Dst: Orig is its download
+}
+
+func (h *speedHistory) add(r *speedRing, clientIsDst bool) {
+	if r == nil {
+		return
+	}
+	h.rings = append(h.rings, r)
+	h.swap = append(h.swap, clientIsDst)
+}
+
+// series returns download and upload samples, oldest first, aligned on the
+// newest tick of any ring. Nil if no flow has a history.
+func (h *speedHistory) series() (down, up []uint64) {
+	if len(h.rings) == 0 {
+		return nil, nil
+	}
+	var now uint64
+	for _, r := range h.rings {
+		now = max(now, r.last)
+	}
+
+	down = make([]uint64, speedHistoryLen)
+	up = make([]uint64, speedHistoryLen)
+	for i, r := range h.rings {
+		for k := range uint64(speedHistoryLen) {
+			if k > now {
+				break
+			}
+			t := now - k
+			if t > r.last || t+speedHistoryLen <= r.last {
+				continue // Outside the ring
+			}
+			orig, reply := uint64(r.orig[t%speedHistoryLen]), uint64(r.reply[t%speedHistoryLen])
+			pos := speedHistoryLen - 1 - k
+			if h.swap[i] {
+				down[pos] += orig
+				up[pos] += reply
+			} else {
+				down[pos] += reply
+				up[pos] += orig
+			}
+		}
+	}
+	return down, up
+}
+
+func saturate32(v uint64) uint32 {
+	if v > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(v)
+}
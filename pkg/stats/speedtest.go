@@ -0,0 +1,76 @@
+package stats
+
+import (
+	"net"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/monitor"
+)
+
+// Speed test results kept per client
+const maxSpeedTests = 20
+
+// A speed test connection not seen as a flow by then is forgotten
+const speedTestConnTTL = 5 * time.Minute
+
+// ExcludeSpeedTest tags the TCP connection of a speed test from a client to
+// the router with TagRouterSpeedTest, so its bytes don't count towards the
+// client's usage and plan. The flow may only show up with its first counters
+// after the test, until then the connection is remembered.
+func (a *Aggregator) ExcludeSpeedTest(clientIP net.IP, clientPort uint16, routerIP net.IP, routerPort uint16) {
+	key := flowKey(monitor.FlowEvent{SrcIP: clientIP, DstIP: routerIP, SrcPort: clientPort, DstPort: routerPort, Proto: 6})
+	shard := a.shardFor(key)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	shard.mu.Lock()
+	ft, ok := shard.flows[key]
+	if ok {
+		ft.Tag = TagRouterSpeedTest
+	}
+	shard.mu.Unlock()
+	if ok {
+		return
+	}
+
+	now := time.Now()
+	for k, added := range a.speedTestConns {
+		if now.Sub(added) > speedTestConnTTL {
+			delete(a.speedTestConns, k)
+		}
+	}
+	a.speedTestConns[key] = now
+}
+
+// ClientMAC returns the client behind a LAN address, or "" if unknown
+func (a *Aggregator) ClientMAC(ip string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.macOf(ip)
+}
+
+// RecordSpeedTest adds a result to the client's speed test history
+func (a *Aggregator) RecordSpeedTest(t model.SpeedTest) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tests := append(a.speedTests[t.MAC], t)
+	if len(tests) > maxSpeedTests {
+		tests = tests[len(tests)-maxSpeedTests:]
+	}
+	a.speedTests[t.MAC] = tests
+}
+
+// GetSpeedTests returns the speed tests of a client, newest first
+func (a *Aggregator) GetSpeedTests(mac string) []model.SpeedTest {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	tests := a.speedTests[mac]
+	list := make([]model.SpeedTest, 0, len(tests))
+	for i := len(tests) - 1; i >= 0; i-- {
+		list = append(list, tests[i])
+	}
+	return list
+}
@@ -0,0 +1,123 @@
+package stats
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Tag for automated bandwidth tests, built in unless configured otherwise
+const TagSpeedTest = "speedtest"
+
+// Tag of the connections of /api/speedtest, which measures the LAN and is
+// always kept out of usage totals, see ExcludeSpeedTest
+const TagRouterSpeedTest = "router-speedtest"
+
+// Ookla test servers are run by ISPs everywhere and have no fixed ranges,
+// they are recognized by hostname (needs dns_sniff or dns_ptr). fast.com
+// measures against Netflix caches, which also serve regular streaming, so
+// only fast.com and its API hosts are listed.
+var defaultSpeedTestHosts = []string{"*.speedtest.net", "speedtest.*", "*.ookla.com", "fast.com", "*.fast.com"}
+
+// tagRule labels traffic to remote subnets or hostnames (glob patterns)
+type tagRule struct {
+	Name  string
+	Nets  []*net.IPNet
+	Hosts []string
+}
+
+// SetTags configures traffic tags, e.g. "speedtest" = ["151.101.0.0/16", "*.speedtest.net"].
+// Flows to excluded tags stay visible in flow lists but are not added to usage totals.
+func (a *Aggregator) SetTags(tags map[string][]string, exclude []string) error {
+	tags = maps.Clone(tags)
+	if tags == nil {
+		tags = make(map[string][]string)
+	}
+	if _, ok := tags[TagSpeedTest]; !ok {
+		tags[TagSpeedTest] = defaultSpeedTestHosts
+	}
+
+	var rules []tagRule
+	for _, name := range slices.Sorted(maps.Keys(tags)) {
+		rule := tagRule{Name: name}
+		for _, entry := range tags[name] {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 128
+				if ip.To4() != nil {
+					bits = 32
+				}
+				rule.Nets = append(rule.Nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+			if strings.Contains(entry, "/") {
+				_, sn, err := net.ParseCIDR(entry)
+				if err != nil {
+					return fmt.Errorf("invalid subnet %q in tag %s", entry, name)
+				}
+				rule.Nets = append(rule.Nets, sn)
+				continue
+			}
+			if _, err := path.Match(entry, ""); err != nil {
+				return fmt.Errorf("invalid host pattern %q in tag %s", entry, name)
+			}
+			rule.Hosts = append(rule.Hosts, strings.ToLower(entry))
+		}
+		rules = append(rules, rule)
+	}
+
+	excluded := map[string]bool{TagRouterSpeedTest: true}
+	for _, name := range exclude {
+		if _, ok := tags[name]; !ok {
+			return fmt.Errorf("excluded tag %q is not defined", name)
+		}
+		excluded[name] = true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tags = rules
+	a.excludedTags = excluded
+	// Re-tag tracked flows so the new rules apply right away
+	for i := range a.shards {
+		sh := &a.shards[i]
+		sh.mu.Lock()
+		for _, f := range sh.flows {
+			if f.Tag != TagRouterSpeedTest {
+				f.Tag = a.tagFor(f.SrcIP, f.DstIP)
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return nil
+}
+
+// tagFor returns the first tag matching either endpoint of a flow, the
+// client (originating) side before the remote one. Caller holds mu.
+func (a *Aggregator) tagFor(client, remote string) string {
+	if len(a.tags) == 0 {
+		return ""
+	}
+	for _, s := range []string{client, remote} {
+		ip := net.ParseIP(s)
+		host := strings.ToLower(a.remoteHostname(s))
+		for _, rule := range a.tags {
+			for _, sn := range rule.Nets {
+				if sn.Contains(ip) {
+					return rule.Name
+				}
+			}
+			if host == "" {
+				continue
+			}
+			for _, pattern := range rule.Hosts {
+				if ok, _ := path.Match(pattern, host); ok {
+					return rule.Name
+				}
+			}
+		}
+	}
+	return ""
+}
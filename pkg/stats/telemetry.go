@@ -0,0 +1,33 @@
+package stats
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Internal observability, exported when telemetry is enabled
+var (
+	meter  = otel.Meter("github.com/kisy/catchmole/pkg/stats")
+	tracer = otel.Tracer("github.com/kisy/catchmole/pkg/stats")
+
+	eventLatency, _ = meter.Float64Histogram("catchmole.aggregator.event.latency",
+		metric.WithDescription("Time from an event leaving the traffic source until it is accounted"), metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1))
+	tickDuration, _ = meter.Float64Histogram("catchmole.aggregator.tick.duration",
+		metric.WithDescription("Duration of the periodic neighbor refresh and speed calculation"), metric.WithUnit("s"))
+)
+
+// registerTelemetry reports the event backlog and table sizes on collection
+func (a *Aggregator) registerTelemetry() {
+	queue, _ := meter.Int64ObservableGauge("catchmole.aggregator.queue.length",
+		metric.WithDescription("Events waiting in the channel from the traffic source"))
+	flows, _ := meter.Int64ObservableGauge("catchmole.aggregator.flows",
+		metric.WithDescription("Flows currently tracked"))
+	meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(queue, int64(len(a.mon.Events())))
+		o.ObserveInt64(flows, int64(len(a.flowSnapshot())))
+		return nil
+	}, queue, flows)
+}
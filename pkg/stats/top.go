@@ -0,0 +1,213 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// Valid ranking keys for GetTop
+var topKeys = map[string]bool{
+	"download_speed": true,
+	"upload_speed":   true,
+	"speed":          true, // Download + upload
+	"total_download": true,
+	"total_upload":   true,
+	"total":          true,
+	"connections":    true,
+}
+
+// GetTop ranks clients and remote destinations by the given key.
+// window ("1m", "5m", "15m") ranks client speeds by rolling averages instead of
+// the current speed; remotes always use current speeds. limit <= 0 means no limit.
+func (a *Aggregator) GetTop(by, window string, limit, offset int) (model.TopStats, error) {
+	if !topKeys[by] {
+		return model.TopStats{}, fmt.Errorf("invalid sort key %q", by)
+	}
+	switch window {
+	case "", "1m", "5m", "15m":
+	default:
+		return model.TopStats{}, fmt.Errorf("invalid window %q (want 1m, 5m or 15m)", window)
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	top := model.TopStats{By: by, Window: window}
+
+	// Clients
+	clients := make([]model.ClientStats, 0, len(a.clients))
+	for _, c := range a.clients {
+		clients = append(clients, *c)
+	}
+	clientKey := func(c *model.ClientStats) uint64 {
+		down, up := c.DownloadSpeed, c.UploadSpeed
+		switch window {
+		case "1m":
+			down, up = c.DownloadSpeed1m, c.UploadSpeed1m
+		case "5m":
+			down, up = c.DownloadSpeed5m, c.UploadSpeed5m
+		case "15m":
+			down, up = c.DownloadSpeed15m, c.UploadSpeed15m
+		}
+		return topValue(by, down, up, c.TotalDownload, c.TotalUpload, c.ActiveConnections)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		ki, kj := clientKey(&clients[i]), clientKey(&clients[j])
+		if ki != kj {
+			return ki > kj
+		}
+		return clients[i].MAC < clients[j].MAC
+	})
+	top.ClientCount = len(clients)
+	top.Clients = paginate(clients, limit, offset)
+
+	// Remote destinations across all clients
+	byRemote := make(map[string]*model.RemoteStats)
+	remoteClients := make(map[string]map[string]struct{})
+	flows := a.flowSnapshot()
+	for i := range flows {
+		f := &flows[i]
+		srcMac, dstMac := f.SrcMAC, f.DstMAC
+		if (srcMac == "" && dstMac == "") || f.Private {
+			continue
+		}
+
+		// Same perspective as the elephant view: client is Src unless only Dst is known
+		mac, remoteIP := srcMac, f.DstIP
+		down, up := f.TotalReplyBytes, f.TotalOriginBytes
+		downSpeed, upSpeed := f.ReplySpeed, f.OrigSpeed
+		if srcMac == "" {
+			mac, remoteIP = dstMac, f.SrcIP
+			down, up = f.TotalOriginBytes, f.TotalReplyBytes
+			downSpeed, upSpeed = f.OrigSpeed, f.ReplySpeed
+		}
+
+		r, ok := byRemote[remoteIP]
+		if !ok {
+			r = &model.RemoteStats{RemoteIP: remoteIP}
+			byRemote[remoteIP] = r
+			remoteClients[remoteIP] = make(map[string]struct{})
+		}
+		r.TotalDownload += down
+		r.TotalUpload += up
+		r.DownloadSpeed += downSpeed
+		r.UploadSpeed += upSpeed
+		r.ActiveConnections++
+		remoteClients[remoteIP][mac] = struct{}{}
+	}
+
+	remotes := make([]model.RemoteStats, 0, len(byRemote))
+	for ip, r := range byRemote {
+		r.Clients = len(remoteClients[ip])
+		remotes = append(remotes, *r)
+	}
+	remoteKey := func(r *model.RemoteStats) uint64 {
+		return topValue(by, r.DownloadSpeed, r.UploadSpeed, r.TotalDownload, r.TotalUpload, r.ActiveConnections)
+	}
+	sort.Slice(remotes, func(i, j int) bool {
+		ki, kj := remoteKey(&remotes[i]), remoteKey(&remotes[j])
+		if ki != kj {
+			return ki > kj
+		}
+		return remotes[i].RemoteIP < remotes[j].RemoteIP
+	})
+	top.RemoteCount = len(remotes)
+	top.Remotes = paginate(remotes, limit, offset)
+
+	// Label only the returned page, lookups are not free
+	for i := range top.Remotes {
+		r := &top.Remotes[i]
+		r.RemoteHostname = a.remoteHostname(r.RemoteIP)
+		if a.geo != nil {
+			gi := a.geo.Lookup(r.RemoteIP)
+			r.RemoteCountry, r.RemoteASN, r.RemoteOrg = gi.Country, gi.ASN, gi.Org
+		}
+	}
+
+	return top, nil
+}
+
+// GetTopRange ranks clients by their traffic in [from, to), given per
+// client (the history recorder keeps it), with speeds averaged over the
+// range. Remote destinations are not kept over time and are left empty.
+func (a *Aggregator) GetTopRange(by string, traffic map[string]UsageDay, from, to time.Time, limit, offset int) (model.TopStats, error) {
+	if !topKeys[by] || by == "connections" {
+		return model.TopStats{}, fmt.Errorf("invalid sort key %q for a time range", by)
+	}
+
+	end := to
+	if now := time.Now(); end.After(now) {
+		end = now // The range is still running
+	}
+	secs := end.Sub(from).Seconds()
+
+	a.mu.RLock()
+	clients := make([]model.ClientStats, 0, len(a.clients))
+	for _, c := range a.clients {
+		clients = append(clients, *c)
+	}
+	a.mu.RUnlock()
+
+	for i := range clients {
+		c := &clients[i]
+		t := traffic[c.MAC]
+		c.TotalDownload, c.TotalUpload = t.Download, t.Upload
+		c.DownloadSpeed, c.UploadSpeed = 0, 0
+		if secs > 0 {
+			c.DownloadSpeed = uint64(float64(t.Download) / secs)
+			c.UploadSpeed = uint64(float64(t.Upload) / secs)
+		}
+	}
+	clientKey := func(c *model.ClientStats) uint64 {
+		return topValue(by, c.DownloadSpeed, c.UploadSpeed, c.TotalDownload, c.TotalUpload, 0)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		ki, kj := clientKey(&clients[i]), clientKey(&clients[j])
+		if ki != kj {
+			return ki > kj
+		}
+		return clients[i].MAC < clients[j].MAC
+	})
+
+	return model.TopStats{
+		By:          by,
+		From:        from,
+		To:          to,
+		ClientCount: len(clients),
+		Clients:     paginate(clients, limit, offset),
+		Remotes:     []model.RemoteStats{},
+	}, nil
+}
+
+func topValue(by string, downSpeed, upSpeed, down, up, conns uint64) uint64 {
+	switch by {
+	case "download_speed":
+		return downSpeed
+	case "upload_speed":
+		return upSpeed
+	case "speed":
+		return downSpeed + upSpeed
+	case "total_download":
+		return down
+	case "total_upload":
+		return up
+	case "total":
+		return down + up
+	default: // connections
+		return conns
+	}
+}
+
+func paginate[T any](list []T, limit, offset int) []T {
+	if offset >= len(list) {
+		return []T{}
+	}
+	list = list[offset:]
+	if limit > 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	return list
+}
@@ -0,0 +1,51 @@
+package stats
+
+import "time"
+
+// SetProtoTTLs overrides the flow TTL per protocol. Zero keeps the flow_ttl
+// default: short DNS lookups can expire fast while long QUIC sessions stay.
+func (a *Aggregator) SetProtoTTLs(tcp, udp, icmp time.Duration) {
+	ttls := make(map[uint8]time.Duration)
+	for _, p := range []struct {
+		protos []uint8
+		ttl    time.Duration
+	}{
+		{[]uint8{6}, tcp},
+		{[]uint8{17}, udp},
+		{[]uint8{1, 58}, icmp}, // ICMP and ICMPv6
+	} {
+		if p.ttl <= 0 {
+			continue
+		}
+		for _, proto := range p.protos {
+			ttls[proto] = p.ttl
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.protoTTL = ttls
+}
+
+// flowTTLs is a copy of the TTL settings, usable without holding mu
+type flowTTLs struct {
+	def   time.Duration
+	proto map[uint8]time.Duration // Never modified once set
+}
+
+// flowTTLs returns the current TTL settings. Caller holds mu.
+func (a *Aggregator) flowTTLs() flowTTLs {
+	def := a.flowTTL
+	if def <= 0 {
+		def = 60 * time.Second
+	}
+	return flowTTLs{def: def, proto: a.protoTTL}
+}
+
+// forProto returns the idle timeout of a protocol's flows
+func (t flowTTLs) forProto(proto uint8) time.Duration {
+	if ttl, ok := t.proto[proto]; ok {
+		return ttl
+	}
+	return t.def
+}
@@ -0,0 +1,59 @@
+package stats
+
+import (
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/integrations/tunnel"
+)
+
+// SetTunnelWatcher accounts VPN peers terminated on the router as clients,
+// keyed by a MAC derived from the peer, instead of dropping their traffic
+// for lacking a neighbor entry
+func (a *Aggregator) SetTunnelWatcher(w *tunnel.Watcher) {
+	a.tunnels.Store(w)
+}
+
+// tunnelPeer returns the client key of a VPN peer address, or "". Safe
+// without mu.
+func (a *Aggregator) tunnelPeer(ip string) string {
+	w := a.tunnels.Load()
+	if w == nil {
+		return ""
+	}
+	if p, ok := w.Lookup(ip); ok {
+		return p.MAC
+	}
+	return ""
+}
+
+// addTunnelPeers creates clients for connected VPN peers and marks them
+// present. Caller holds mu.
+func (a *Aggregator) addTunnelPeers(present map[string]bool) {
+	w := a.tunnels.Load()
+	if w == nil {
+		return
+	}
+	for mac, p := range w.Peers() {
+		if !p.Online {
+			continue
+		}
+		if _, ignored := a.ignore.MACs[mac]; ignored {
+			continue
+		}
+		present[mac] = true
+		if _, ok := a.clients[mac]; !ok && (a.maxClients <= 0 || len(a.clients) < a.maxClients) {
+			a.getClient(mac)
+		}
+	}
+}
+
+// applyTunnel copies the peer identity into a VPN client. Caller holds mu.
+func (a *Aggregator) applyTunnel(c *model.ClientStats) {
+	w := a.tunnels.Load()
+	if w == nil {
+		return
+	}
+	p, _ := w.Peer(c.MAC)
+	c.Tunnel = p.Interface
+	c.PeerKey = p.Key
+	c.Endpoint = p.Endpoint
+}
@@ -0,0 +1,48 @@
+package stats
+
+import (
+	"sort"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// GetVLANs returns per-VLAN statistics summed over clients, sorted by VLAN ID.
+// Empty unless some client was seen on a VLAN.
+func (a *Aggregator) GetVLANs() []model.VLANStats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.vlanStats()
+}
+
+// vlanStats is GetVLANs for callers holding mu
+func (a *Aggregator) vlanStats() []model.VLANStats {
+	vlans := make(map[uint16]*model.VLANStats)
+	tagged := false
+	for _, c := range a.clients {
+		if c.MAC == RouterMAC {
+			continue
+		}
+		vs, ok := vlans[c.VLAN]
+		if !ok {
+			vs = &model.VLANStats{VLAN: c.VLAN}
+			vlans[c.VLAN] = vs
+		}
+		tagged = tagged || c.VLAN != 0
+		vs.ActiveClients++
+		vs.TotalDownload += c.TotalDownload
+		vs.TotalUpload += c.TotalUpload
+		vs.DownloadSpeed += c.DownloadSpeed
+		vs.UploadSpeed += c.UploadSpeed
+		vs.ActiveConnections += c.ActiveConnections
+	}
+	if !tagged {
+		return nil
+	}
+
+	list := make([]model.VLANStats, 0, len(vlans))
+	for _, vs := range vlans {
+		list = append(list, *vs)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].VLAN < list[j].VLAN })
+	return list
+}
@@ -0,0 +1,53 @@
+package stats
+
+import (
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/integrations/hostapd"
+)
+
+// SetHostapdWatcher enables Wi-Fi association state from hostapd. Associated
+// stations count as present and are listed as clients before they send traffic.
+func (a *Aggregator) SetHostapdWatcher(w *hostapd.Watcher) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.hostapd = w
+}
+
+// StationJoined lists a station as an online client as soon as it associates
+func (a *Aggregator) StationJoined(mac string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c := a.addStation(mac); c != nil {
+		c.Online = true
+		a.applyStation(c)
+	}
+}
+
+// addAssociated creates clients for associated stations and marks them
+// present. Caller holds mu.
+func (a *Aggregator) addAssociated(present map[string]bool) {
+	if a.hostapd == nil {
+		return
+	}
+	for mac := range a.hostapd.Stations() {
+		if c := a.addStation(mac); c != nil {
+			present[c.MAC] = true
+		}
+	}
+}
+
+// addStation returns the client of a station, creating it if there is room.
+// Caller holds mu.
+func (a *Aggregator) addStation(mac string) *model.ClientStats {
+	if _, ignored := a.ignore.MACs[mac]; ignored {
+		return nil
+	}
+	mac = a.PrimaryMAC(mac)
+	if c, ok := a.clients[mac]; ok {
+		return c
+	}
+	if a.maxClients > 0 && len(a.clients) >= a.maxClients {
+		return nil // Stations never evict tracked clients
+	}
+	return a.getClient(mac)
+}
@@ -0,0 +1,57 @@
+package stats
+
+import "time"
+
+// Longest averaging window kept in memory
+const maxSpeedWindow = 15 * time.Minute
+
+type speedSample struct {
+	At   time.Time
+	Down uint64 // Cumulative bytes
+	Up   uint64
+}
+
+// speedWindow keeps cumulative byte samples for load-style (1m/5m/15m) speed averages
+type speedWindow struct {
+	samples []speedSample
+}
+
+func (w *speedWindow) add(now time.Time, down, up uint64) {
+	w.samples = append(w.samples, speedSample{At: now, Down: down, Up: up})
+
+	// Drop samples that no longer cover any window (keep one just outside the longest)
+	cutoff := now.Add(-maxSpeedWindow)
+	drop := 0
+	for drop+1 < len(w.samples) && !w.samples[drop+1].At.After(cutoff) {
+		drop++
+	}
+	if drop > 0 {
+		w.samples = append(w.samples[:0], w.samples[drop:]...)
+	}
+}
+
+// average returns the mean download/upload speed over the last d.
+// A window younger than d is averaged over the time available.
+func (w *speedWindow) average(d time.Duration) (uint64, uint64) {
+	if len(w.samples) < 2 {
+		return 0, 0
+	}
+
+	last := w.samples[len(w.samples)-1]
+	cutoff := last.At.Add(-d)
+
+	// Latest sample at or before the window start
+	first := w.samples[0]
+	for _, s := range w.samples {
+		if s.At.After(cutoff) {
+			break
+		}
+		first = s
+	}
+
+	secs := last.At.Sub(first.At).Seconds()
+	if secs <= 0 {
+		return 0, 0
+	}
+	return uint64(float64(safeSub(last.Down, first.Down)) / secs), uint64(float64(safeSub(last.Up, first.Up)) / secs)
+}
@@ -0,0 +1,115 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// SetZones labels conntrack zones, e.g. "1" = "wan1", and ignores the traffic
+// of the listed zones. Multi-WAN setups usually give each uplink its own zone.
+func (a *Aggregator) SetZones(names map[string]string, ignore []int) error {
+	parsed := make(map[uint16]string, len(names))
+	for spec, name := range names {
+		zone, err := strconv.ParseUint(strings.TrimSpace(spec), 0, 16)
+		if err != nil {
+			return fmt.Errorf("invalid zone %q", spec)
+		}
+		parsed[uint16(zone)] = name
+	}
+	ignored := make(map[uint16]bool, len(ignore))
+	for _, zone := range ignore {
+		if zone < 0 || zone > 0xffff {
+			return fmt.Errorf("invalid ignore zone %d", zone)
+		}
+		ignored[uint16(zone)] = true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.zoneNames = parsed
+	a.ignoreZones = ignored
+	return nil
+}
+
+// addZoneBytes accumulates internet traffic into its zone. Caller holds mu.
+func (a *Aggregator) addZoneBytes(zone uint16, download, upload uint64) {
+	zs, ok := a.zones[zone]
+	if !ok {
+		zs = &model.ZoneStats{Zone: zone}
+		a.zones[zone] = zs
+	}
+	zs.TotalDownload += download
+	zs.TotalUpload += upload
+}
+
+// GetZones returns the internet traffic per conntrack zone, sorted by zone.
+// Empty unless zones are configured or traffic was seen outside zone 0.
+func (a *Aggregator) GetZones() []model.ZoneStats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.zoneStats()
+}
+
+// zoneStats is GetZones for callers holding mu
+func (a *Aggregator) zoneStats() []model.ZoneStats {
+	zoned := len(a.zoneNames) > 0 || len(a.zoneCapacity) > 0
+	zones := make(map[uint16]*model.ZoneStats, len(a.zones)+len(a.zoneNames))
+	for zone, zs := range a.zones {
+		cp := *zs
+		zones[zone] = &cp
+		zoned = zoned || zone != 0
+	}
+	if !zoned {
+		return nil
+	}
+	for zone := range a.zoneNames {
+		if _, ok := zones[zone]; !ok {
+			zones[zone] = &model.ZoneStats{Zone: zone}
+		}
+	}
+	for zone := range a.zoneCapacity {
+		if _, ok := zones[zone]; !ok {
+			zones[zone] = &model.ZoneStats{Zone: zone}
+		}
+	}
+
+	// Same direction rules as the global totals
+	for _, f := range a.flowSnapshot() {
+		if f.SrcMAC == RouterMAC || f.DstMAC == RouterMAC {
+			continue
+		}
+		isSrcLocal := f.SrcMAC != ""
+		isDstLocal := f.DstMAC != "" && f.DstMAC != f.SrcMAC
+		if isSrcLocal == isDstLocal {
+			continue
+		}
+		zs, ok := zones[f.Zone]
+		if !ok {
+			zs = &model.ZoneStats{Zone: f.Zone}
+			zones[f.Zone] = zs
+		}
+		if isSrcLocal {
+			zs.DownloadSpeed += f.ReplySpeed
+			zs.UploadSpeed += f.OrigSpeed
+		} else {
+			zs.DownloadSpeed += f.OrigSpeed
+			zs.UploadSpeed += f.ReplySpeed
+		}
+		zs.ActiveConnections++
+	}
+
+	list := make([]model.ZoneStats, 0, len(zones))
+	for zone, zs := range zones {
+		zs.Name = a.zoneNames[zone]
+		capacity := a.zoneCapacity[zone]
+		zs.DownloadCapacity, zs.UploadCapacity = capacity.Download, capacity.Upload
+		zs.DownloadUtilization, zs.UploadUtilization = capacity.utilization(zs.DownloadSpeed, zs.UploadSpeed)
+		list = append(list, *zs)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Zone < list[j].Zone })
+	return list
+}
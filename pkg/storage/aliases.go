@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+)
+
+// AliasStore keeps MAC aliases set at runtime (via the API) in a JSON file,
+// layered over the [aliases] table of the config file. Runtime entries
+// replace the config entry of the same primary MAC.
+type AliasStore struct {
+	path string
+
+	mu      sync.RWMutex
+	config  map[string][]string
+	runtime map[string][]string
+}
+
+// OpenAliasStore loads runtime aliases from path. A missing file is not an error.
+func OpenAliasStore(path string) (*AliasStore, error) {
+	s := &AliasStore{
+		path:    path,
+		config:  make(map[string][]string),
+		runtime: make(map[string][]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.runtime); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// SetConfigAliases replaces the aliases from the config file
+func (s *AliasStore) SetConfigAliases(aliases map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.config = make(map[string][]string, len(aliases))
+	for k, v := range aliases {
+		s.config[strings.ToLower(k)] = v
+	}
+}
+
+// Aliases returns the effective aliases (config merged with runtime)
+func (s *AliasStore) Aliases() map[string][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	aliases := maps.Clone(s.config)
+	maps.Copy(aliases, s.runtime)
+	return aliases
+}
+
+// RuntimeAliases returns only the aliases set at runtime
+func (s *AliasStore) RuntimeAliases() map[string][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.runtime)
+}
+
+// Set replaces the aliases of a primary MAC and persists the runtime aliases
+func (s *AliasStore) Set(mac string, aliases []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.runtime[mac] = aliases
+	return s.save()
+}
+
+// Delete removes runtime aliases. It reports false if mac had none.
+func (s *AliasStore) Delete(mac string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.runtime[mac]; !ok {
+		return false, nil
+	}
+	delete(s.runtime, mac)
+	return true, s.save()
+}
+
+// save writes the runtime aliases. Caller holds mu.
+func (s *AliasStore) save() error {
+	data, err := json.MarshalIndent(s.runtime, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
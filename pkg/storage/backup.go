@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"sync"
+
+	"github.com/kisy/catchmole/pkg/history"
+	"github.com/kisy/catchmole/pkg/stats"
+)
+
+// Backup is a complete snapshot of the statistics and the settings made
+// through the API, for moving an installation to another router
+type Backup struct {
+	state
+	Devices map[string]Device   `json:"devices,omitempty"` // Names, tags and notes set via the API
+	Aliases map[string][]string `json:"aliases,omitempty"` // Aliases set via the API
+}
+
+// Backups exports and imports Backup snapshots of a running daemon
+type Backups struct {
+	agg     *stats.Aggregator
+	usage   *stats.UsageRollup
+	hist    *history.Recorder
+	devices *DeviceStore
+	aliases *AliasStore
+
+	mu sync.Mutex // Serializes imports
+}
+
+func NewBackups(agg *stats.Aggregator, usage *stats.UsageRollup, hist *history.Recorder, devices *DeviceStore, aliases *AliasStore) *Backups {
+	return &Backups{agg: agg, usage: usage, hist: hist, devices: devices, aliases: aliases}
+}
+
+// Export captures the current statistics
+func (b *Backups) Export() Backup {
+	return Backup{
+		state:   snapshot(b.agg, b.usage, b.hist),
+		Devices: b.devices.RuntimeDevices(),
+		Aliases: b.aliases.RuntimeAliases(),
+	}
+}
+
+// Import restores a backup over the current statistics. The backup is
+// validated as a whole first; clients missing from it are dropped so the
+// global totals match the clients.
+func (b *Backups) Import(bk Backup) error {
+	if bk.Version != stateVersion {
+		return fmt.Errorf("unsupported backup version %d", bk.Version)
+	}
+	for _, c := range bk.Clients {
+		if _, err := net.ParseMAC(c.MAC); err != nil && c.MAC != stats.RouterMAC {
+			return fmt.Errorf("invalid client MAC %q", c.MAC)
+		}
+	}
+	for mac := range bk.Devices {
+		if _, err := net.ParseMAC(mac); err != nil {
+			return fmt.Errorf("invalid device MAC %q", mac)
+		}
+	}
+	var aliases map[string][]string
+	if len(bk.Aliases) > 0 {
+		aliases = b.aliases.Aliases()
+		maps.Copy(aliases, bk.Aliases)
+		if err := stats.ValidateAliases(aliases); err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Aliases first, so restored totals of alias MACs are merged
+	if aliases != nil {
+		if err := b.agg.SetAliases(aliases); err != nil {
+			return err
+		}
+		for mac, list := range bk.Aliases {
+			if err := b.aliases.Set(mac, list); err != nil {
+				return err
+			}
+		}
+	}
+	for mac, dev := range bk.Devices {
+		if err := b.devices.Set(mac, dev); err != nil {
+			return err
+		}
+	}
+	b.agg.SetDeviceNames(b.devices.Names())
+	b.agg.SetDeviceTags(b.devices.Tags())
+
+	b.agg.ReplaceTotals(bk.StartTime, bk.Global, bk.Clients)
+	bk.apply(b.agg, b.usage, b.hist, false)
+	slog.Info("Imported backup", "clients", len(bk.Clients), "saved_at", bk.SavedAt)
+	return nil
+}
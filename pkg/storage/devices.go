@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Device is what is configured for a MAC. In the config file and the devices
+// file it is either a plain name or a table with name, tags and notes.
+type Device struct {
+	Name  string   `json:"name,omitempty"`
+	Tags  []string `json:"tags,omitempty"`  // e.g. "iot", "work", "kid"
+	Notes string   `json:"notes,omitempty"` // Free text
+	Plan  string   `json:"plan,omitempty"`  // Expected bandwidth class, one of [plans]
+}
+
+// Equal reports whether two devices carry the same settings
+func (d Device) Equal(o Device) bool {
+	return d.Name == o.Name && d.Notes == o.Notes && d.Plan == o.Plan && slices.Equal(d.Tags, o.Tags)
+}
+
+// MarshalJSON writes a plain name when there is nothing else, like older versions did
+func (d Device) MarshalJSON() ([]byte, error) {
+	if len(d.Tags) == 0 && d.Notes == "" && d.Plan == "" {
+		return json.Marshal(d.Name)
+	}
+	type plain Device
+	return json.Marshal(plain(d))
+}
+
+// UnmarshalJSON accepts a plain name or an object
+func (d *Device) UnmarshalJSON(data []byte) error {
+	var name string
+	if json.Unmarshal(data, &name) == nil {
+		*d = Device{Name: name}
+		return nil
+	}
+	type plain Device
+	return json.Unmarshal(data, (*plain)(d))
+}
+
+// UnmarshalTOML accepts "aa:bb:.." = "Name" as well as
+// "aa:bb:.." = { name = "Name", tags = ["iot"], notes = "...", plan = "iot" }
+func (d *Device) UnmarshalTOML(v any) error {
+	switch v := v.(type) {
+	case string:
+		*d = Device{Name: v}
+		return nil
+	case map[string]any:
+		*d = Device{}
+		for key, val := range v {
+			var ok bool
+			switch key {
+			case "name":
+				d.Name, ok = val.(string)
+			case "notes":
+				d.Notes, ok = val.(string)
+			case "plan":
+				d.Plan, ok = val.(string)
+			case "tags":
+				var list []any
+				if list, ok = val.([]any); ok {
+					for _, t := range list {
+						tag, isStr := t.(string)
+						if !isStr {
+							return fmt.Errorf("device tags must be strings, got %v", t)
+						}
+						d.Tags = append(d.Tags, tag)
+					}
+				}
+			default:
+				return fmt.Errorf("unknown device key %q", key)
+			}
+			if !ok {
+				return fmt.Errorf("invalid device %s %v", key, val)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("device must be a name or a table, got %v", v)
+	}
+}
+
+// DeviceStore keeps devices set at runtime (via the API) in a JSON file,
+// layered over the [devices] table of the config file. Runtime entries
+// replace the config entry of the same MAC.
+type DeviceStore struct {
+	path string
+
+	mu      sync.RWMutex
+	config  map[string]Device
+	runtime map[string]Device
+}
+
+// OpenDeviceStore loads runtime devices from path. A missing file is not an error.
+func OpenDeviceStore(path string) (*DeviceStore, error) {
+	d := &DeviceStore{
+		path:    path,
+		config:  make(map[string]Device),
+		runtime: make(map[string]Device),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &d.runtime); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return d, nil
+}
+
+// SetConfigDevices replaces the devices from the config file
+func (d *DeviceStore) SetConfigDevices(devices map[string]Device) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.config = make(map[string]Device, len(devices))
+	for k, v := range devices {
+		d.config[strings.ToLower(k)] = v
+	}
+}
+
+// Devices returns the effective devices (config merged with runtime)
+func (d *DeviceStore) Devices() map[string]Device {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	devices := maps.Clone(d.config)
+	maps.Copy(devices, d.runtime)
+	return devices
+}
+
+// Device returns the effective entry of a MAC
+func (d *DeviceStore) Device(mac string) (Device, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if dev, ok := d.runtime[mac]; ok {
+		return dev, true
+	}
+	dev, ok := d.config[mac]
+	return dev, ok
+}
+
+// Names returns the effective names, devices without a name are left out
+func (d *DeviceStore) Names() map[string]string {
+	names := make(map[string]string)
+	for mac, dev := range d.Devices() {
+		if dev.Name != "" {
+			names[mac] = dev.Name
+		}
+	}
+	return names
+}
+
+// Tags returns the effective tags of tagged devices
+func (d *DeviceStore) Tags() map[string][]string {
+	tags := make(map[string][]string)
+	for mac, dev := range d.Devices() {
+		if len(dev.Tags) > 0 {
+			tags[mac] = dev.Tags
+		}
+	}
+	return tags
+}
+
+// Plans returns the effective plans of devices that have one
+func (d *DeviceStore) Plans() map[string]string {
+	plans := make(map[string]string)
+	for mac, dev := range d.Devices() {
+		if dev.Plan != "" {
+			plans[mac] = dev.Plan
+		}
+	}
+	return plans
+}
+
+// RuntimeDevices returns only the devices set at runtime
+func (d *DeviceStore) RuntimeDevices() map[string]Device {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return maps.Clone(d.runtime)
+}
+
+// Set replaces the runtime entry of a device and persists the runtime devices
+func (d *DeviceStore) Set(mac string, dev Device) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.runtime[mac] = dev
+	return d.save()
+}
+
+// Delete removes a runtime entry. It reports false if mac had none.
+func (d *DeviceStore) Delete(mac string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.runtime[mac]; !ok {
+		return false, nil
+	}
+	delete(d.runtime, mac)
+	return true, d.save()
+}
+
+// save writes the runtime devices. Caller holds mu.
+func (d *DeviceStore) save() error {
+	data, err := json.MarshalIndent(d.runtime, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(d.path, data)
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// RuntimeSettings are settings changed via the API. Nil fields were never
+// changed at runtime and follow the config file.
+type RuntimeSettings struct {
+	FlowTTL       *int      `json:"flow_ttl,omitempty"`
+	Interval      *int      `json:"interval,omitempty"`
+	MonitorLAN    *bool     `json:"monitor_lan,omitempty"`
+	Interface     *string   `json:"interface,omitempty"`
+	IgnoreSubnets *[]string `json:"ignore_subnets,omitempty"`
+	IgnorePorts   *[]string `json:"ignore_ports,omitempty"`
+	IgnoreMACs    *[]string `json:"ignore_macs,omitempty"`
+}
+
+// Apply overwrites the fields of s that are set in r
+func (r RuntimeSettings) Apply(s *model.Settings) {
+	if r.FlowTTL != nil {
+		s.FlowTTL = *r.FlowTTL
+	}
+	if r.Interval != nil {
+		s.Interval = *r.Interval
+	}
+	if r.MonitorLAN != nil {
+		s.MonitorLAN = *r.MonitorLAN
+	}
+	if r.Interface != nil {
+		s.Interface = *r.Interface
+	}
+	if r.IgnoreSubnets != nil {
+		s.IgnoreSubnets = slices.Clone(*r.IgnoreSubnets)
+	}
+	if r.IgnorePorts != nil {
+		s.IgnorePorts = slices.Clone(*r.IgnorePorts)
+	}
+	if r.IgnoreMACs != nil {
+		s.IgnoreMACs = slices.Clone(*r.IgnoreMACs)
+	}
+}
+
+// merge sets the fields of r that are set in o
+func (r *RuntimeSettings) merge(o RuntimeSettings) {
+	r.FlowTTL = firstSet(o.FlowTTL, r.FlowTTL)
+	r.Interval = firstSet(o.Interval, r.Interval)
+	r.MonitorLAN = firstSet(o.MonitorLAN, r.MonitorLAN)
+	r.Interface = firstSet(o.Interface, r.Interface)
+	r.IgnoreSubnets = firstSet(o.IgnoreSubnets, r.IgnoreSubnets)
+	r.IgnorePorts = firstSet(o.IgnorePorts, r.IgnorePorts)
+	r.IgnoreMACs = firstSet(o.IgnoreMACs, r.IgnoreMACs)
+}
+
+// firstSet returns the first non-nil pointer
+func firstSet[T any](a, b *T) *T {
+	if a != nil {
+		return a
+	}
+	return b
+}
+
+// SettingsStore keeps settings changed at runtime (via the API) in a JSON
+// file, layered over the same keys of the config file. Only the keys changed
+// via the API are stored, the others keep following the config file.
+type SettingsStore struct {
+	path string
+
+	mu      sync.RWMutex
+	config  model.Settings
+	runtime RuntimeSettings
+}
+
+// OpenSettingsStore loads runtime settings from path. A missing file is not an error.
+func OpenSettingsStore(path string) (*SettingsStore, error) {
+	s := &SettingsStore{path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.runtime); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// SetConfigSettings replaces the settings from the config file
+func (s *SettingsStore) SetConfigSettings(settings model.Settings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = settings
+}
+
+// Settings returns the effective settings (config overridden by runtime)
+func (s *SettingsStore) Settings() model.Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	settings := s.config
+	settings.IgnoreSubnets = slices.Clone(settings.IgnoreSubnets)
+	settings.IgnorePorts = slices.Clone(settings.IgnorePorts)
+	settings.IgnoreMACs = slices.Clone(settings.IgnoreMACs)
+	s.runtime.Apply(&settings)
+	return settings
+}
+
+// RuntimeSettings returns only the settings changed at runtime
+func (s *SettingsStore) RuntimeSettings() RuntimeSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.runtime
+}
+
+// Update records the fields set in r as runtime settings and persists them
+func (s *SettingsStore) Update(r RuntimeSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.runtime.merge(r)
+	return s.save()
+}
+
+// Replace sets the runtime settings to r and persists them, e.g. to undo an Update
+func (s *SettingsStore) Replace(r RuntimeSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.runtime = r
+	return s.save()
+}
+
+// save writes the runtime settings. Caller holds mu.
+func (s *SettingsStore) save() error {
+	data, err := json.MarshalIndent(s.runtime, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
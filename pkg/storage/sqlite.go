@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/stats"
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS global (
+	id             INTEGER PRIMARY KEY CHECK (id = 1),
+	total_download INTEGER NOT NULL,
+	total_upload   INTEGER NOT NULL,
+	start_time     INTEGER NOT NULL,
+	updated_at     INTEGER NOT NULL,
+	total_download4 INTEGER NOT NULL DEFAULT 0,
+	total_upload4   INTEGER NOT NULL DEFAULT 0,
+	total_download6 INTEGER NOT NULL DEFAULT 0,
+	total_upload6   INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS clients (
+	mac            TEXT PRIMARY KEY,
+	name           TEXT NOT NULL,
+	total_download INTEGER NOT NULL,
+	total_upload   INTEGER NOT NULL,
+	start_time     INTEGER NOT NULL,
+	last_active    INTEGER NOT NULL,
+	updated_at     INTEGER NOT NULL,
+	total_download4 INTEGER NOT NULL DEFAULT 0,
+	total_upload4   INTEGER NOT NULL DEFAULT 0,
+	total_download6 INTEGER NOT NULL DEFAULT 0,
+	total_upload6   INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS snapshots (
+	ts             INTEGER NOT NULL,
+	mac            TEXT NOT NULL,
+	total_download INTEGER NOT NULL,
+	total_upload   INTEGER NOT NULL,
+	download_speed INTEGER NOT NULL,
+	upload_speed   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_snapshots_mac_ts ON snapshots (mac, ts);
+`
+
+// familyColumns hold the per-family totals, added to databases created
+// before they existed by migrate
+var familyColumns = []string{"total_download4", "total_upload4", "total_download6", "total_upload6"}
+
+// Store persists client totals and periodic speed snapshots in SQLite
+// so statistics survive daemon restarts.
+type Store struct {
+	db        *sql.DB
+	agg       *stats.Aggregator
+	retention time.Duration
+	savedAt   time.Time // Of the restored totals
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func Open(path string, agg *stats.Aggregator, retention time.Duration) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// SQLite allows a single writer
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	return &Store{
+		db:        db,
+		agg:       agg,
+		retention: retention,
+		stop:      make(chan struct{}),
+	}, nil
+}
+
+// migrate adds the columns missing from older databases
+func migrate(db *sql.DB) error {
+	for _, table := range []string{"global", "clients"} {
+		for _, col := range familyColumns {
+			var n int
+			if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, col).Scan(&n); err != nil {
+				return err
+			}
+			if n > 0 {
+				continue
+			}
+			if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s INTEGER NOT NULL DEFAULT 0`, table, col)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Restore loads persisted totals into the aggregator
+func (s *Store) Restore() error {
+	var global model.GlobalStats
+	var startUnix, updatedUnix int64
+	err := s.db.QueryRow(`SELECT total_download, total_upload, total_download4, total_upload4, total_download6, total_upload6,
+		start_time, updated_at FROM global WHERE id = 1`).
+		Scan(&global.TotalDownload, &global.TotalUpload, &global.TotalDownload4, &global.TotalUpload4,
+			&global.TotalDownload6, &global.TotalUpload6, &startUnix, &updatedUnix)
+	if err == sql.ErrNoRows {
+		return nil // Fresh database
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load global totals: %w", err)
+	}
+
+	rows, err := s.db.Query(`SELECT mac, name, total_download, total_upload, total_download4, total_upload4,
+		total_download6, total_upload6, start_time, last_active FROM clients`)
+	if err != nil {
+		return fmt.Errorf("failed to load clients: %w", err)
+	}
+	defer rows.Close()
+
+	var clients []model.ClientStats
+	for rows.Next() {
+		var c model.ClientStats
+		var start, active int64
+		if err := rows.Scan(&c.MAC, &c.Name, &c.TotalDownload, &c.TotalUpload, &c.TotalDownload4, &c.TotalUpload4,
+			&c.TotalDownload6, &c.TotalUpload6, &start, &active); err != nil {
+			return fmt.Errorf("failed to scan client: %w", err)
+		}
+		c.StartTime = time.Unix(start, 0)
+		c.LastActive = time.Unix(active, 0)
+		clients = append(clients, c)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.agg.RestoreTotals(time.Unix(startUnix, 0), global, clients)
+	s.savedAt = time.Unix(updatedUnix, 0)
+	slog.Info("Restored totals from database", "clients", len(clients))
+	return nil
+}
+
+// SavedAt returns when the restored totals were saved, zero if none were restored
+func (s *Store) SavedAt() time.Time {
+	return s.savedAt
+}
+
+// Start snapshots the aggregator every interval
+func (s *Store) Start(interval time.Duration) {
+	s.wg.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Snapshot(); err != nil {
+					slog.Error("Storage snapshot error", "err", err)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	})
+}
+
+// Stop takes a final snapshot and closes the database
+func (s *Store) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+
+	if err := s.Snapshot(); err != nil {
+		slog.Error("Storage final snapshot error", "err", err)
+	}
+	s.db.Close()
+}
+
+// Snapshot writes current totals and a speed sample per client
+func (s *Store) Snapshot() error {
+	now := time.Now()
+	global := s.agg.GetGlobalStats()
+	clients := s.agg.GetClients()
+	startTime := s.agg.GetStartTime()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO global (id, total_download, total_upload, total_download4, total_upload4, total_download6, total_upload6,
+		start_time, updated_at) VALUES (1, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET total_download = excluded.total_download, total_upload = excluded.total_upload,
+		total_download4 = excluded.total_download4, total_upload4 = excluded.total_upload4,
+		total_download6 = excluded.total_download6, total_upload6 = excluded.total_upload6,
+		start_time = excluded.start_time, updated_at = excluded.updated_at`,
+		global.TotalDownload, global.TotalUpload, global.TotalDownload4, global.TotalUpload4, global.TotalDownload6, global.TotalUpload6,
+		startTime.Unix(), now.Unix()); err != nil {
+		return err
+	}
+
+	// Replace the client table so resets are persisted too
+	if _, err := tx.Exec(`DELETE FROM clients`); err != nil {
+		return err
+	}
+
+	for _, c := range clients {
+		if _, err := tx.Exec(`INSERT INTO clients (mac, name, total_download, total_upload, total_download4, total_upload4, total_download6, total_upload6,
+			start_time, last_active, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.MAC, c.Name, c.TotalDownload, c.TotalUpload, c.TotalDownload4, c.TotalUpload4, c.TotalDownload6, c.TotalUpload6,
+			c.StartTime.Unix(), c.LastActive.Unix(), now.Unix()); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO snapshots (ts, mac, total_download, total_upload, download_speed, upload_speed) VALUES (?, ?, ?, ?, ?, ?)`,
+			now.Unix(), c.MAC, c.TotalDownload, c.TotalUpload, c.DownloadSpeed, c.UploadSpeed); err != nil {
+			return err
+		}
+	}
+
+	if s.retention > 0 {
+		if _, err := tx.Exec(`DELETE FROM snapshots WHERE ts < ?`, now.Add(-s.retention).Unix()); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
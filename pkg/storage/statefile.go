@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/history"
+	"github.com/kisy/catchmole/pkg/stats"
+)
+
+// Bump when the state layout changes incompatibly
+const stateVersion = 1
+
+type state struct {
+	Version   int                 `json:"version"`
+	SavedAt   time.Time           `json:"saved_at"`
+	StartTime time.Time           `json:"start_time"`
+	Global    model.GlobalStats   `json:"global"`
+	Clients   []model.ClientStats `json:"clients"`
+
+	// Daily usage rollups, "" is global (optional)
+	Usage map[string]map[string]stats.UsageDay `json:"usage,omitempty"`
+
+	// Weekly activity heatmaps, "" is global (optional)
+	Heatmaps map[string]history.Heatmap `json:"heatmaps,omitempty"`
+}
+
+// snapshot captures the totals, usage and hist are optional
+func snapshot(agg *stats.Aggregator, usage *stats.UsageRollup, hist *history.Recorder) state {
+	st := state{
+		Version:   stateVersion,
+		SavedAt:   time.Now(),
+		StartTime: agg.GetStartTime(),
+		Global:    agg.GetGlobalStats(),
+		Clients:   agg.GetClients(),
+	}
+	if usage != nil {
+		st.Usage = usage.Export()
+	}
+	if hist != nil {
+		st.Heatmaps = hist.ExportHeatmaps()
+	}
+	return st
+}
+
+// apply restores the totals unless skipped, usage and hist are optional
+func (st *state) apply(agg *stats.Aggregator, usage *stats.UsageRollup, hist *history.Recorder, totals bool) {
+	if totals {
+		agg.RestoreTotals(st.StartTime, st.Global, st.Clients)
+	}
+	if usage != nil && st.Usage != nil {
+		usage.Import(st.Usage)
+	}
+	if hist != nil && st.Heatmaps != nil {
+		hist.ImportHeatmaps(st.Heatmaps)
+	}
+}
+
+// StateFile persists cumulative totals to a JSON file. It is a lightweight
+// alternative to the SQLite Store when only continuous totals are needed.
+type StateFile struct {
+	path  string
+	agg   *stats.Aggregator
+	usage *stats.UsageRollup // Optional
+	hist  *history.Recorder  // Optional
+
+	savedAt     time.Time // Of the restored state
+	totalsSaved time.Time // Of totals restored before, see SetRestoredTotals
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewStateFile(path string, agg *stats.Aggregator) *StateFile {
+	return &StateFile{
+		path: path,
+		agg:  agg,
+		stop: make(chan struct{}),
+	}
+}
+
+// SetUsage includes daily usage rollups in the state. Call before Restore.
+func (f *StateFile) SetUsage(r *stats.UsageRollup) {
+	f.usage = r
+}
+
+// SetHistory includes the weekly heatmaps in the state. Call before Restore.
+func (f *StateFile) SetHistory(h *history.Recorder) {
+	f.hist = h
+}
+
+// SetRestoredTotals tells Restore that totals saved at t were restored from
+// elsewhere (the database), so only newer totals in the state replace them.
+// Usage and heatmaps are restored either way. Call before Restore.
+func (f *StateFile) SetRestoredTotals(t time.Time) {
+	f.totalsSaved = t
+}
+
+// Restore loads the state file into the aggregator. A missing file is not an error.
+func (f *StateFile) Restore() error {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("failed to parse state file: %w", err)
+	}
+	if st.Version != stateVersion {
+		return fmt.Errorf("unsupported state file version %d", st.Version)
+	}
+
+	totals := st.SavedAt.After(f.totalsSaved)
+	st.apply(f.agg, f.usage, f.hist, totals)
+	f.savedAt = st.SavedAt
+	if !totals {
+		slog.Info("Kept newer totals from database, restored usage from state file", "path", f.path, "saved_at", st.SavedAt)
+		return nil
+	}
+	slog.Info("Restored totals from state file", "clients", len(st.Clients), "path", f.path, "saved_at", st.SavedAt)
+	return nil
+}
+
+// SavedAt returns when the restored state was saved, zero if none was restored.
+// Its totals may have been skipped, see SetRestoredTotals.
+func (f *StateFile) SavedAt() time.Time {
+	return f.savedAt
+}
+
+// Start saves the state every interval
+func (f *StateFile) Start(interval time.Duration) {
+	f.wg.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := f.Save(); err != nil {
+					slog.Error("State file save error", "err", err)
+				}
+			case <-f.stop:
+				return
+			}
+		}
+	})
+}
+
+// Stop saves the state one last time
+func (f *StateFile) Stop() {
+	close(f.stop)
+	f.wg.Wait()
+
+	if err := f.Save(); err != nil {
+		slog.Error("State file final save error", "err", err)
+	}
+}
+
+// Save writes the current totals atomically (temp file + rename)
+func (f *StateFile) Save() error {
+	data, err := json.Marshal(snapshot(f.agg, f.usage, f.hist))
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(f.path, data)
+}
+
+// writeFileAtomic replaces path with data via a synced temp file and rename
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,552 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/history"
+	"github.com/kisy/catchmole/pkg/stats"
+)
+
+const (
+	walMagic   = "CMWAL\x01" // Segment header, the last byte is the format version
+	walPrefix  = "wal-"
+	walSuffix  = ".log"
+	walMaxBody = 16 << 20 // Larger records are treated as corruption
+
+	defaultSegmentSize = 4 << 20 // 4MB
+
+	// Records older than this are merged per client and hour on compaction
+	walCompactAge = 24 * time.Hour
+)
+
+// walEntry is the traffic of one client (or global if MAC is empty) in one interval
+type walEntry struct {
+	MAC      string
+	Download uint64
+	Upload   uint64
+}
+
+type walRecord struct {
+	Time    time.Time
+	Entries []walEntry
+}
+
+type walCounter struct {
+	lastDown uint64
+	lastUp   uint64
+}
+
+// WAL appends per-interval traffic deltas to segment files so the totals,
+// daily usage and history accumulated since the last snapshot survive a
+// crash. Each record is length and CRC framed; a torn record at the end of
+// a segment is dropped on replay. Full segments are rotated and compacted:
+// records beyond retention are dropped, records older than a day are merged
+// per client and hour.
+type WAL struct {
+	dir         string
+	agg         *stats.Aggregator
+	usage       *stats.UsageRollup // Optional
+	hist        *history.Recorder  // Optional
+	retention   time.Duration
+	segmentSize int64
+
+	mu     sync.Mutex
+	file   *os.File
+	seq    uint64 // Sequence number of the active segment
+	size   int64
+	global walCounter
+	last   map[string]walCounter // Key: MAC
+	epoch  uint64                // Aggregator totals epoch of the baseline
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// OpenWAL creates dir if needed. Records older than retention are dropped on
+// compaction, segmentSize <= 0 uses the default of 4MB.
+func OpenWAL(dir string, agg *stats.Aggregator, retention time.Duration, segmentSize int64) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create wal directory: %w", err)
+	}
+	if segmentSize <= 0 {
+		segmentSize = defaultSegmentSize
+	}
+	return &WAL{
+		dir:         dir,
+		agg:         agg,
+		retention:   retention,
+		segmentSize: segmentSize,
+		last:        make(map[string]walCounter),
+		stop:        make(chan struct{}),
+	}, nil
+}
+
+// SetUsage replays deltas into the daily usage rollups. Call before Replay.
+func (w *WAL) SetUsage(r *stats.UsageRollup) {
+	w.usage = r
+}
+
+// SetHistory replays deltas into the traffic history. Call before Replay.
+func (w *WAL) SetHistory(h *history.Recorder) {
+	w.hist = h
+}
+
+// Replay applies logged deltas newer than the restored snapshots: totalsSince
+// is when the totals were saved, usageSince when daily usage and heatmaps
+// were (zero if they were not restored). History rings are not persisted
+// elsewhere and get every record. Call after restoring and before Start.
+func (w *WAL) Replay(totalsSince, usageSince time.Time) error {
+	records, err := w.readSegments()
+	if err != nil {
+		return err
+	}
+
+	global := w.agg.GetGlobalStats()
+	clients := make(map[string]*model.ClientStats)
+	for _, c := range w.agg.GetClients() {
+		clients[c.MAC] = &c
+	}
+
+	var applied int
+	for _, rec := range records {
+		totals := rec.Time.After(totalsSince)
+		usage := rec.Time.After(usageSince)
+		if totals {
+			applied++
+		}
+		for _, e := range rec.Entries {
+			if totals {
+				if e.MAC == "" {
+					global.TotalDownload += e.Download
+					global.TotalUpload += e.Upload
+				} else {
+					c, ok := clients[e.MAC]
+					if !ok {
+						c = &model.ClientStats{MAC: e.MAC, StartTime: rec.Time}
+						clients[e.MAC] = c
+					}
+					c.TotalDownload += e.Download
+					c.TotalUpload += e.Upload
+					c.LastActive = rec.Time
+				}
+			}
+			if usage && w.usage != nil {
+				w.usage.Replay(e.MAC, rec.Time, e.Download, e.Upload)
+			}
+			if w.hist != nil {
+				w.hist.Replay(e.MAC, rec.Time, e.Download, e.Upload, usage)
+			}
+		}
+	}
+	if applied == 0 {
+		return nil
+	}
+
+	list := make([]model.ClientStats, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, *c)
+	}
+	w.agg.RestoreTotals(w.agg.GetStartTime(), global, list)
+	slog.Info("Replayed write-ahead log", "records", applied, "since", totalsSince, "dir", w.dir)
+	return nil
+}
+
+// Start opens a new segment and logs deltas every interval. Totals present
+// at start (restored and replayed) are the baseline.
+func (w *WAL) Start(interval time.Duration) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seqs, err := w.segments()
+	if err != nil {
+		return err
+	}
+	if len(seqs) > 0 {
+		w.seq = seqs[len(seqs)-1]
+	}
+	if len(seqs) > 1 {
+		if err := w.compact(seqs); err != nil {
+			slog.Warn("WAL compaction failed", "err", err)
+		}
+	}
+	if err := w.openSegment(w.seq + 1); err != nil {
+		return err
+	}
+	w.baseline()
+
+	w.wg.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := w.Append(); err != nil {
+					slog.Error("WAL append error", "err", err)
+				}
+			case <-w.stop:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// Stop logs the deltas up to shutdown and closes the segment
+func (w *WAL) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+
+	if err := w.Append(); err != nil {
+		slog.Error("WAL final append error", "err", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+}
+
+// baseline records the current totals without logging them. Caller holds mu.
+func (w *WAL) baseline() {
+	w.epoch = w.agg.TotalsEpoch()
+	w.rebase(w.agg.GetGlobalStats(), w.agg.GetClients())
+}
+
+// rebase makes the given totals the baseline. Caller holds mu.
+func (w *WAL) rebase(global model.GlobalStats, clients []model.ClientStats) {
+	w.global = walCounter{global.TotalDownload, global.TotalUpload}
+	clear(w.last)
+	for _, c := range clients {
+		w.last[c.MAC] = walCounter{c.TotalDownload, c.TotalUpload}
+	}
+}
+
+// Append logs the traffic since the previous call and syncs it to disk
+func (w *WAL) Append() error {
+	epoch := w.agg.TotalsEpoch()
+	global := w.agg.GetGlobalStats()
+	clients := w.agg.GetClients()
+	now := time.Now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return errors.New("wal is closed")
+	}
+
+	// Totals were restored, imported or merged since the last call: the jump
+	// is not traffic (and merged aliases are gone), start over from here.
+	// Checked after the snapshot too, a change in between may be half in it.
+	if w.epoch != epoch || w.agg.TotalsEpoch() != epoch {
+		w.rebase(global, clients)
+		w.epoch = epoch
+		return nil
+	}
+
+	rec := walRecord{Time: now}
+	if down, up := w.global.advance(global.TotalDownload, global.TotalUpload); down+up > 0 {
+		rec.Entries = append(rec.Entries, walEntry{Download: down, Upload: up})
+	}
+	seen := make(map[string]walCounter, len(clients))
+	for _, c := range clients {
+		wc := w.last[c.MAC]
+		if down, up := wc.advance(c.TotalDownload, c.TotalUpload); down+up > 0 {
+			rec.Entries = append(rec.Entries, walEntry{MAC: c.MAC, Download: down, Upload: up})
+		}
+		seen[c.MAC] = wc
+	}
+	w.last = seen // Evicted clients start over if they return
+	if len(rec.Entries) == 0 {
+		return nil
+	}
+
+	n, err := writeRecord(w.file, rec)
+	if err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.size += int64(n)
+
+	if w.size >= w.segmentSize {
+		return w.rotate()
+	}
+	return nil
+}
+
+// advance returns the traffic since the last totals, counting everything after a reset
+func (c *walCounter) advance(totalDown, totalUp uint64) (down, up uint64) {
+	down, up = totalDown-c.lastDown, totalUp-c.lastUp
+	if totalDown < c.lastDown || totalUp < c.lastUp {
+		down, up = totalDown, totalUp
+	}
+	c.lastDown, c.lastUp = totalDown, totalUp
+	return down, up
+}
+
+// rotate closes the full segment, compacts the closed ones and opens the next. Caller holds mu.
+func (w *WAL) rotate() error {
+	w.file.Close()
+	w.file = nil
+
+	seqs, err := w.segments()
+	if err != nil {
+		return err
+	}
+	if len(seqs) > 1 {
+		if err := w.compact(seqs); err != nil {
+			slog.Warn("WAL compaction failed", "err", err)
+		}
+	}
+	return w.openSegment(w.seq + 1)
+}
+
+// openSegment creates segment seq with its header. Caller holds mu.
+func (w *WAL) openSegment(seq uint64) error {
+	f, err := os.OpenFile(w.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create wal segment: %w", err)
+	}
+	if _, err := f.WriteString(walMagic); err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.seq, w.size = f, seq, int64(len(walMagic))
+	return nil
+}
+
+// compact rewrites the closed segments seqs into the first one. Caller holds mu.
+func (w *WAL) compact(seqs []uint64) error {
+	var records []walRecord
+	for _, seq := range seqs {
+		recs, err := readSegment(w.segmentPath(seq))
+		if err != nil {
+			return err
+		}
+		records = append(records, recs...)
+	}
+	records = compactRecords(records, time.Now(), w.retention)
+
+	var buf strings.Builder
+	buf.WriteString(walMagic)
+	for _, rec := range records {
+		if _, err := writeRecord(&buf, rec); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(w.segmentPath(seqs[0]), []byte(buf.String())); err != nil {
+		return err
+	}
+	for _, seq := range seqs[1:] {
+		if err := os.Remove(w.segmentPath(seq)); err != nil {
+			return err
+		}
+	}
+	slog.Debug("Compacted WAL", "segments", len(seqs), "records", len(records))
+	return nil
+}
+
+// compactRecords drops records beyond retention and merges those older than
+// walCompactAge per hour, stamped with the hour's last record time
+func compactRecords(records []walRecord, now time.Time, retention time.Duration) []walRecord {
+	type bucket struct {
+		rec   walRecord
+		index map[string]int // MAC -> index in rec.Entries
+	}
+	var out []walRecord
+	merged := make(map[time.Time]*bucket)
+	var hours []time.Time
+
+	for _, rec := range records {
+		age := now.Sub(rec.Time)
+		if retention > 0 && age > retention {
+			continue
+		}
+		if age <= walCompactAge {
+			out = append(out, rec)
+			continue
+		}
+		hour := rec.Time.Truncate(time.Hour)
+		b, ok := merged[hour]
+		if !ok {
+			b = &bucket{index: make(map[string]int)}
+			merged[hour] = b
+			hours = append(hours, hour)
+		}
+		b.rec.Time = rec.Time
+		for _, e := range rec.Entries {
+			if i, ok := b.index[e.MAC]; ok {
+				b.rec.Entries[i].Download += e.Download
+				b.rec.Entries[i].Upload += e.Upload
+				continue
+			}
+			b.index[e.MAC] = len(b.rec.Entries)
+			b.rec.Entries = append(b.rec.Entries, e)
+		}
+	}
+
+	compacted := make([]walRecord, 0, len(hours)+len(out))
+	for _, hour := range hours {
+		compacted = append(compacted, merged[hour].rec)
+	}
+	compacted = append(compacted, out...)
+	sort.SliceStable(compacted, func(i, j int) bool { return compacted[i].Time.Before(compacted[j].Time) })
+	return compacted
+}
+
+// readSegments returns the records of all segments in order
+func (w *WAL) readSegments() ([]walRecord, error) {
+	seqs, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+	var records []walRecord
+	for _, seq := range seqs {
+		recs, err := readSegment(w.segmentPath(seq))
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, recs...)
+	}
+	return records, nil
+}
+
+// segments returns the sequence numbers of the segment files, ascending
+func (w *WAL) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, e := range entries {
+		var seq uint64
+		name := e.Name()
+		if !strings.HasPrefix(name, walPrefix) || !strings.HasSuffix(name, walSuffix) {
+			continue
+		}
+		if _, err := fmt.Sscanf(strings.TrimSuffix(strings.TrimPrefix(name, walPrefix), walSuffix), "%x", &seq); err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	slices.Sort(seqs)
+	return seqs, nil
+}
+
+func (w *WAL) segmentPath(seq uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%s%016x%s", walPrefix, seq, walSuffix))
+}
+
+// writeRecord frames rec as length, CRC-32 and body:
+// varint unix nanos, uvarint entry count, then per entry the MAC (uvarint
+// length and bytes) and the uvarint download and upload bytes
+func writeRecord(out io.Writer, rec walRecord) (int, error) {
+	body := binary.AppendVarint(nil, rec.Time.UnixNano())
+	body = binary.AppendUvarint(body, uint64(len(rec.Entries)))
+	for _, e := range rec.Entries {
+		body = binary.AppendUvarint(body, uint64(len(e.MAC)))
+		body = append(body, e.MAC...)
+		body = binary.AppendUvarint(body, e.Download)
+		body = binary.AppendUvarint(body, e.Upload)
+	}
+
+	frame := binary.LittleEndian.AppendUint32(nil, uint32(len(body)))
+	frame = binary.LittleEndian.AppendUint32(frame, crc32.ChecksumIEEE(body))
+	return out.Write(append(frame, body...))
+}
+
+// readSegment decodes the records of one segment. Reading stops at the first
+// torn or corrupt record, which only a crash mid-write leaves behind.
+func readSegment(path string) ([]walRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(walMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != walMagic {
+		slog.Warn("Skipping WAL segment without header", "path", path)
+		return nil, nil
+	}
+
+	var records []walRecord
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err != io.EOF {
+				slog.Warn("Truncated WAL record", "path", path, "records", len(records))
+			}
+			return records, nil
+		}
+		size := binary.LittleEndian.Uint32(header)
+		if size > walMaxBody {
+			slog.Warn("Corrupt WAL record", "path", path, "records", len(records))
+			return records, nil
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil || crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(header[4:]) {
+			slog.Warn("Truncated WAL record", "path", path, "records", len(records))
+			return records, nil
+		}
+		rec, err := decodeRecord(body)
+		if err != nil {
+			slog.Warn("Corrupt WAL record", "path", path, "err", err)
+			return records, nil
+		}
+		records = append(records, rec)
+	}
+}
+
+func decodeRecord(body []byte) (walRecord, error) {
+	errShort := errors.New("short record")
+	nanos, n := binary.Varint(body)
+	if n <= 0 {
+		return walRecord{}, errShort
+	}
+	body = body[n:]
+	count, n := binary.Uvarint(body)
+	if n <= 0 || count > uint64(len(body)) {
+		return walRecord{}, errShort
+	}
+	body = body[n:]
+
+	rec := walRecord{Time: time.Unix(0, nanos), Entries: make([]walEntry, 0, count)}
+	for range count {
+		var e walEntry
+		size, n := binary.Uvarint(body)
+		if n <= 0 || size > uint64(len(body)-n) {
+			return walRecord{}, errShort
+		}
+		e.MAC = string(body[n : n+int(size)])
+		body = body[n+int(size):]
+		if e.Download, n = binary.Uvarint(body); n <= 0 {
+			return walRecord{}, errShort
+		}
+		body = body[n:]
+		if e.Upload, n = binary.Uvarint(body); n <= 0 {
+			return walRecord{}, errShort
+		}
+		body = body[n:]
+		rec.Entries = append(rec.Entries, e)
+	}
+	return rec, nil
+}
@@ -0,0 +1,72 @@
+// Package telemetry exports catchmole's own metrics and traces over OTLP.
+// Components record through the global OpenTelemetry API, which is a no-op
+// until Start installs the SDK providers.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Config is the [otel] section of the config file
+type Config struct {
+	Endpoint string            `toml:"endpoint"` // OTLP/HTTP base URL, e.g. http://collector:4318
+	Headers  map[string]string `toml:"headers"`  // Sent with every export, e.g. authorization
+	Interval int               `toml:"interval"` // Metric export interval in seconds
+}
+
+// Start installs the global meter and tracer providers exporting to
+// cfg.Endpoint. The returned function flushes pending data and stops them.
+func Start(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	base, err := url.Parse(cfg.Endpoint)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", cfg.Endpoint)
+	}
+	endpoint := strings.TrimRight(cfg.Endpoint, "/")
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	res := resource.NewSchemaless(attribute.String("service.name", "catchmole"))
+
+	metricExp, err := otlpmetrichttp.New(ctx,
+		otlpmetrichttp.WithEndpointURL(endpoint+"/v1/metrics"),
+		otlpmetrichttp.WithHeaders(cfg.Headers))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metric exporter: %w", err)
+	}
+	traceExp, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(endpoint+"/v1/traces"),
+		otlptracehttp.WithHeaders(cfg.Headers))
+	if err != nil {
+		metricExp.Shutdown(ctx)
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExp, sdkmetric.WithInterval(interval))))
+	// Sampling follows OTEL_TRACES_SAMPLER / OTEL_TRACES_SAMPLER_ARG
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithBatcher(traceExp))
+	otel.SetMeterProvider(mp)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		return errors.Join(mp.Shutdown(ctx), tp.Shutdown(ctx))
+	}, nil
+}
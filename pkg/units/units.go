@@ -0,0 +1,73 @@
+// Package units formats byte counts for people, in binary (IEC) units as
+// powers of 1024 (KiB, MiB, GiB) or decimal (SI) units as powers of 1000
+// (kB, MB, GB), the way ISPs and disk vendors count.
+package units
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// System is a family of byte units
+type System string
+
+const (
+	IEC System = "iec"
+	SI  System = "si"
+)
+
+// Parse reads a byte_units setting, "" is IEC
+func Parse(s string) (System, error) {
+	switch s {
+	case "", "iec", "binary", "1024":
+		return IEC, nil
+	case "si", "decimal", "1000":
+		return SI, nil
+	}
+	return "", fmt.Errorf("invalid byte_units %q (want iec or si)", s)
+}
+
+var current atomic.Pointer[System]
+
+// Set selects the units used by Bytes process-wide
+func Set(s System) {
+	current.Store(&s)
+}
+
+// Current returns the units selected with Set, IEC by default
+func Current() System {
+	if s := current.Load(); s != nil {
+		return *s
+	}
+	return IEC
+}
+
+// Base is 1000 for SI and 1024 for IEC
+func (s System) Base() uint64 {
+	if s == SI {
+		return 1000
+	}
+	return 1024
+}
+
+// Bytes formats b in the current units, e.g. "1.5 GiB" or "1.6 GB"
+func Bytes(b uint64) string {
+	return Current().Bytes(b)
+}
+
+// Bytes formats b in units of s
+func (s System) Bytes(b uint64) string {
+	unit := s.Base()
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := unit, 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	if s == SI {
+		return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "kMGTPE"[exp])
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
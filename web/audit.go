@@ -0,0 +1,161 @@
+package web
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// Mutating requests kept in memory for /api/audit
+const auditSize = 1000
+
+// auditLog records mutating API calls, newest last
+type auditLog struct {
+	mu      sync.Mutex
+	entries []model.AuditEntry
+	file    *os.File // Optional, JSON lines
+}
+
+// SetAuditFile additionally appends audited requests to path as JSON lines,
+// "" stops writing them
+func (s *Server) SetAuditFile(path string) error {
+	var f *os.File
+	if path != "" {
+		var err error
+		if f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+			return err
+		}
+	}
+	s.audit.mu.Lock()
+	defer s.audit.mu.Unlock()
+	if s.audit.file != nil {
+		s.audit.file.Close()
+	}
+	s.audit.file = f
+	return nil
+}
+
+func (l *auditLog) add(e model.AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, e)
+	if len(l.entries) > auditSize {
+		l.entries = l.entries[len(l.entries)-auditSize:]
+	}
+	if l.file != nil {
+		line, _ := json.Marshal(e)
+		if _, err := fmt.Fprintf(l.file, "%s\n", line); err != nil {
+			slog.Warn("Failed to write audit log", "err", err)
+		}
+	}
+}
+
+// list returns up to limit entries newest first, all if limit is 0
+func (l *auditLog) list(limit int) []model.AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := make([]model.AuditEntry, 0)
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if limit > 0 && len(list) >= limit {
+			break
+		}
+		list = append(list, l.entries[i])
+	}
+	return list
+}
+
+// withAudit records every request that may change state: who sent it from
+// where, the client it concerned and how it ended
+func (s *Server) withAudit(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr // Unix socket
+		}
+		query := r.URL.Query()
+		user, _, _ := r.BasicAuth()
+		if user == "" && (strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") || query.Has("token")) {
+			user = "token"
+		}
+		query.Del("token") // Keep the secret out of the log
+		mac := sw.mac
+		if mac == "" {
+			if mac = strings.ToLower(strings.TrimSpace(query.Get("mac"))); mac != "" {
+				mac = s.agg.PrimaryMAC(mac)
+			}
+		}
+		s.audit.add(model.AuditEntry{
+			Time:         time.Now(),
+			Method:       r.Method,
+			Path:         r.URL.Path,
+			Query:        query.Encode(),
+			RemoteIP:     ip,
+			ForwardedFor: r.Header.Get("X-Forwarded-For"),
+			User:         user,
+			MAC:          mac,
+			Status:       cmp.Or(sw.status, http.StatusOK), // Handler wrote nothing
+		})
+	})
+}
+
+// statusWriter remembers the response status and the client of the request
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	mac    string // Set by handlers through auditMAC
+}
+
+// auditMAC records the client a request concerned when its MAC is not in
+// the query, e.g. in a JSON body
+func auditMAC(w http.ResponseWriter, mac string) {
+	for {
+		if sw, ok := w.(*statusWriter); ok {
+			sw.mac = mac
+			return
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = u.Unwrap()
+	}
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
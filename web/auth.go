@@ -0,0 +1,133 @@
+package web
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// SetAuth enables authentication for the UI and API. Clients may use basic
+// auth (user/password) or a bearer token; empty values disable that method.
+// With neither configured everything is open.
+func (s *Server) SetAuth(user, password, token string) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	s.authUser = user
+	s.authPassword = password
+	s.authToken = token
+}
+
+// AuthEnabled reports whether requests need credentials, see SetAuth
+func (s *Server) AuthEnabled() bool {
+	s.authMu.RLock()
+	defer s.authMu.RUnlock()
+	return s.authEnabled()
+}
+
+// authEnabled is AuthEnabled. Caller holds authMu.
+func (s *Server) authEnabled() bool {
+	return (s.authUser != "" && s.authPassword != "") || s.authToken != ""
+}
+
+// Handler returns the registered routes wrapped with authentication, CSRF
+// protection and auditing
+func (s *Server) Handler() http.Handler {
+	return s.withAuth(withSameOrigin(s.withAudit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metrics" && s.metricsSeparate {
+			http.NotFound(w, r)
+			return
+		}
+		http.DefaultServeMux.ServeHTTP(w, r)
+	}))))
+}
+
+// MetricsHandler serves only /metrics, for a listener of its own
+// (metrics_listen), and removes it from Handler. Call before serving.
+func (s *Server) MetricsHandler() http.Handler {
+	s.metricsSeparate = true
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return s.withAuth(mux)
+}
+
+func (s *Server) withAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Health probes stay open
+		if r.URL.Path == "/readyz" || s.authorized(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="catchmole"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	s.authMu.RLock()
+	defer s.authMu.RUnlock()
+
+	if !s.authEnabled() {
+		return true
+	}
+	basicEnabled := s.authUser != "" && s.authPassword != ""
+
+	if s.authToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token") // WebSocket clients can't set headers
+		}
+		if token != "" && secureEqual(token, s.authToken) {
+			return true
+		}
+	}
+
+	if basicEnabled {
+		if user, password, ok := r.BasicAuth(); ok {
+			return secureEqual(user, s.authUser) && secureEqual(password, s.authPassword)
+		}
+	}
+	return false
+}
+
+// withSameOrigin rejects state-changing requests sent by another site's
+// page (CSRF), which the browser would send with the user's basic auth
+// credentials or to an open instance on the LAN. Browsers attach Origin or
+// Sec-Fetch-Site to such requests; scripts and the CLI send neither.
+func withSameOrigin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func sameOrigin(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return fromSameOrigin(r)
+}
+
+// fromSameOrigin reports whether a request comes from a page of this server
+// or from a client that is not a browser
+func fromSameOrigin(r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin) // "null" from sandboxed pages has no host
+		return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+		return true
+	}
+	return false
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
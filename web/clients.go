@@ -0,0 +1,175 @@
+package web
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// clientFields maps the JSON names of model.ClientStats to field indexes
+var clientFields = sync.OnceValue(func() map[string]int {
+	t := reflect.TypeFor[model.ClientStats]()
+	fields := make(map[string]int, t.NumField())
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+})
+
+// clientQuery sorts, filters, paginates and trims client lists server-side:
+//
+//	sort    any scalar field, e.g. download_speed, name, last_active
+//	order   asc or desc (default desc, asc for text fields)
+//	filter  all, online (in the neighbor table or recently active) or
+//	        active (has connections or traffic right now)
+//	fields  comma separated fields to return, e.g. mac,name,download_speed
+//	limit, offset
+type clientQuery struct {
+	sort   int // Field index, -1 keeps the list order
+	desc   bool
+	filter string
+	fields []string
+	limit  int
+	offset int
+}
+
+func parseClientQuery(q url.Values, filter string) (clientQuery, error) {
+	cq := clientQuery{sort: -1, filter: cmp.Or(q.Get("filter"), filter)}
+
+	if v := q.Get("sort"); v != "" {
+		i, ok := clientFields()[v]
+		if !ok || !sortable(reflect.TypeFor[model.ClientStats]().Field(i).Type) {
+			return cq, fmt.Errorf("invalid sort field %q", v)
+		}
+		cq.sort = i
+		cq.desc = reflect.TypeFor[model.ClientStats]().Field(i).Type.Kind() != reflect.String
+	}
+	switch q.Get("order") {
+	case "":
+	case "asc":
+		cq.desc = false
+	case "desc":
+		cq.desc = true
+	default:
+		return cq, fmt.Errorf("invalid order %q (want asc or desc)", q.Get("order"))
+	}
+
+	switch cq.filter {
+	case "all", "online", "active":
+	default:
+		return cq, fmt.Errorf("invalid filter %q (want all, online or active)", cq.filter)
+	}
+
+	if v := q.Get("fields"); v != "" {
+		for name := range strings.SplitSeq(v, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := clientFields()[name]; !ok {
+				return cq, fmt.Errorf("unknown field %q", name)
+			}
+			cq.fields = append(cq.fields, name)
+		}
+	}
+
+	for _, p := range []struct {
+		name string
+		v    *int
+	}{{"limit", &cq.limit}, {"offset", &cq.offset}} {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return cq, fmt.Errorf("invalid %s: %s", p.name, v)
+			}
+			*p.v = n
+		}
+	}
+	return cq, nil
+}
+
+// sortable reports whether clients can be ordered by a field of type t
+func sortable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return t == reflect.TypeFor[time.Time]()
+}
+
+// apply returns the number of matching clients and the requested page,
+// as []model.ClientStats or, with fields, as one object per client
+func (cq clientQuery) apply(clients []model.ClientStats) (int, any) {
+	switch cq.filter {
+	case "online":
+		clients = slices.DeleteFunc(clients, func(c model.ClientStats) bool { return !c.Online })
+	case "active":
+		clients = slices.DeleteFunc(clients, func(c model.ClientStats) bool {
+			return c.ActiveConnections == 0 && c.DownloadSpeed == 0 && c.UploadSpeed == 0
+		})
+	}
+
+	if cq.sort >= 0 {
+		slices.SortStableFunc(clients, func(a, b model.ClientStats) int {
+			c := compareField(reflect.ValueOf(a).Field(cq.sort), reflect.ValueOf(b).Field(cq.sort))
+			if cq.desc {
+				c = -c
+			}
+			return cmp.Or(c, strings.Compare(a.MAC, b.MAC))
+		})
+	}
+
+	count := len(clients)
+	page := clients[min(cq.offset, count):]
+	if cq.limit > 0 && len(page) > cq.limit {
+		page = page[:cq.limit]
+	}
+	if cq.fields == nil {
+		return count, page
+	}
+
+	trimmed := make([]map[string]any, len(page))
+	for i := range page {
+		v := reflect.ValueOf(page[i])
+		m := make(map[string]any, len(cq.fields))
+		for _, name := range cq.fields {
+			m[name] = v.Field(clientFields()[name]).Interface()
+		}
+		trimmed[i] = m
+	}
+	return count, trimmed
+}
+
+func compareField(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.String:
+		return strings.Compare(strings.ToLower(a.String()), strings.ToLower(b.String()))
+	case reflect.Bool:
+		return cmp.Compare(btoi(a.Bool()), btoi(b.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cmp.Compare(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float())
+	}
+	return a.Interface().(time.Time).Compare(b.Interface().(time.Time))
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseTimeRange reads the common time window parameters of history-style endpoints:
+//
+//	from, to    unix seconds or RFC3339 (to defaults to now)
+//	range       window length ending at to, e.g. 1h, 24h, 7d (used when from is absent)
+//	resolution  bucket width for server-side downsampling, e.g. 5m, 1h (0 = native)
+func parseTimeRange(r *http.Request, defaultRange time.Duration) (from, to time.Time, resolution time.Duration, err error) {
+	q := r.URL.Query()
+
+	to = time.Now()
+	if v := q.Get("to"); v != "" {
+		if to, err = parseTime(v); err != nil {
+			return from, to, 0, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+
+	if v := q.Get("from"); v != "" {
+		if from, err = parseTime(v); err != nil {
+			return from, to, 0, fmt.Errorf("invalid from: %w", err)
+		}
+	} else {
+		window := defaultRange
+		if v := q.Get("range"); v != "" {
+			if window, err = parseDuration(v); err != nil || window <= 0 {
+				return from, to, 0, fmt.Errorf("invalid range: %s", v)
+			}
+		}
+		from = to.Add(-window)
+	}
+
+	if !from.Before(to) {
+		return from, to, 0, fmt.Errorf("from must be before to")
+	}
+
+	if v := q.Get("resolution"); v != "" {
+		if resolution, err = parseDuration(v); err != nil || resolution < 0 {
+			return from, to, 0, fmt.Errorf("invalid resolution: %s", v)
+		}
+	}
+
+	return from, to, resolution, nil
+}
+
+// hasTimeRange reports whether the request narrows the time window, endpoints
+// covering all retained data by default keep doing so otherwise
+func hasTimeRange(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Has("from") || q.Has("to") || q.Has("range")
+}
+
+// usagePeriod maps a resolution to the calendar period of the usage rollups
+func usagePeriod(resolution time.Duration) (string, bool) {
+	const day = 24 * time.Hour
+	switch {
+	case resolution == day:
+		return "daily", true
+	case resolution == 7*day:
+		return "weekly", true
+	case resolution >= 28*day && resolution <= 31*day:
+		return "monthly", true
+	}
+	return "", false
+}
+
+func parseTime(v string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// parseDuration extends time.ParseDuration with a "d" (day) suffix
+func parseDuration(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// parseLimit reads the optional limit parameter, 0 meaning no limit
+func parseLimit(r *http.Request, def int) (int, error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid limit: %s", v)
+	}
+	return n, nil
+}
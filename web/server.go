@@ -1,15 +1,29 @@
 package web
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/alert"
+	"github.com/kisy/catchmole/pkg/federation"
+	"github.com/kisy/catchmole/pkg/history"
+	"github.com/kisy/catchmole/pkg/monitor"
+	"github.com/kisy/catchmole/pkg/report"
+	"github.com/kisy/catchmole/pkg/shaping"
 	"github.com/kisy/catchmole/pkg/stats"
+	"github.com/kisy/catchmole/pkg/storage"
+	"github.com/kisy/catchmole/pkg/units"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -19,26 +33,148 @@ var indexHTML []byte
 //go:embed static
 var staticFiles embed.FS
 
+// Bounds of /api/client/capture
+const (
+	defaultCaptureDuration = 30 * time.Second
+	maxCaptureDuration     = 5 * time.Minute
+	maxCaptureBytes        = 100 << 20
+	maxCaptures            = 2 // Concurrent
+)
+
+// Largest backup accepted by /api/import
+const maxBackupSize = 64 << 20
+
+// Largest body accepted by /api/devices, notes included
+const maxDeviceSize = 64 << 10
+
 type Server struct {
-	agg     *stats.Aggregator
-	ipTools map[string]string
-	flowTTL int
+	agg      *stats.Aggregator
+	watchdog *monitor.Watchdog
+	history  *history.Recorder
+	usage    *stats.UsageRollup
+	ipTools  map[string]string
+	devices  *storage.DeviceStore   // Optional, enables /api/devices
+	aliases  *storage.AliasStore    // Optional, enables /api/aliases
+	settings *storage.SettingsStore // Optional, enables /api/settings
+	source   monitor.TrafficSource  // Polling restarted on interval changes
+	iface    string                 // Interface the source, watchdog, capture and sniffers are bound to
+	backups  *storage.Backups       // Optional, enables /api/export and /api/import
+	shaper   *shaping.Controller    // Optional, enables /api/client/limit
+	reporter *report.Reporter       // Optional, enables /api/report
+	fed      *federation.Poller     // Optional, enables /api/federation
+	alerts   *alert.Engine          // Optional, adds fired alerts to /api/alerts
+
+	captureIface string        // Enables /api/client/capture
+	captureSlots chan struct{} // Running captures
+
+	speedTestSlots chan struct{} // Running speed tests
+
+	degraded []monitor.Degradation // Features off or limited, e.g. for lack of capabilities
+
+	audit auditLog // Mutating requests, see /api/audit
+
+	metricsSeparate bool // /metrics is served on its own listener
+
+	authMu       sync.RWMutex
+	authUser     string
+	authPassword string
+	authToken    string
+
+	limitMu    sync.Mutex
+	limitRate  float64
+	limitBurst float64
+	buckets    map[string]*bucket
+
+	cacheMu sync.Mutex
+	cache   map[string]cacheEntry
+
+	settingsMu sync.Mutex // Serializes PUT /api/settings
 }
 
-func NewServer(agg *stats.Aggregator, ipTools map[string]string, flowTTL int) *Server {
+func NewServer(agg *stats.Aggregator, watchdog *monitor.Watchdog, hist *history.Recorder, usage *stats.UsageRollup, ipTools map[string]string) *Server {
 	return &Server{
-		agg:     agg,
-		ipTools: ipTools,
-		flowTTL: flowTTL,
+		agg:      agg,
+		watchdog: watchdog,
+		history:  hist,
+		usage:    usage,
+		ipTools:  ipTools,
+		buckets:  make(map[string]*bucket),
+		cache:    make(map[string]cacheEntry),
+
+		captureSlots:   make(chan struct{}, maxCaptures),
+		speedTestSlots: make(chan struct{}, maxSpeedTests),
 	}
 }
 
+// SetDeviceStore enables editing device names through /api/devices
+func (s *Server) SetDeviceStore(d *storage.DeviceStore) {
+	s.devices = d
+}
+
+// deviceNotes returns the notes kept for a device, if any
+func (s *Server) deviceNotes(mac string) string {
+	if s.devices == nil {
+		return ""
+	}
+	dev, _ := s.devices.Device(mac)
+	return dev.Notes
+}
+
+// SetAliasStore enables editing MAC aliases through /api/aliases
+func (s *Server) SetAliasStore(a *storage.AliasStore) {
+	s.aliases = a
+}
+
+// SetSettingsStore enables /api/settings, applying changes to the aggregator and
+// source. iface is the interface the running components were started on, which
+// an interface change only rebinds after a restart.
+func (s *Server) SetSettingsStore(st *storage.SettingsStore, source monitor.TrafficSource, iface string) {
+	s.settings = st
+	s.source = source
+	s.iface = iface
+}
+
+// SetBackups enables /api/export and /api/import
+func (s *Server) SetBackups(b *storage.Backups) {
+	s.backups = b
+}
+
+// SetShaper enables per-client rate limits through /api/client/limit
+func (s *Server) SetShaper(c *shaping.Controller) {
+	s.shaper = c
+}
+
+// SetFederation enables the merged multi-site view through /api/federation
+func (s *Server) SetFederation(p *federation.Poller) {
+	s.fed = p
+}
+
+// SetAlerts lists the alerts fired by the engine in /api/alerts
+func (s *Server) SetAlerts(e *alert.Engine) {
+	s.alerts = e
+}
+
+// SetReporter enables report previews through /api/report
+func (s *Server) SetReporter(r *report.Reporter) {
+	s.reporter = r
+}
+
+// SetDegraded reports features that are off for lack of capabilities in /api/meta
+func (s *Server) SetDegraded(d []monitor.Degradation) {
+	s.degraded = d
+}
+
+// SetCaptureInterface enables pcap captures of a client on the interface
+func (s *Server) SetCaptureInterface(iface string) {
+	s.captureIface = iface
+}
+
 func (s *Server) RegisterHandlers() {
 	// SPA fallback - serve index.html for all page routes
 	// "/" matches all paths not handled by other handlers
 	serveIndex := func(w http.ResponseWriter, r *http.Request) {
 		// Skip API and static paths
-		if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/static/") || r.URL.Path == "/metrics" {
+		if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/static/") || r.URL.Path == "/metrics" || r.URL.Path == "/readyz" {
 			http.NotFound(w, r)
 			return
 		}
@@ -52,23 +188,87 @@ func (s *Server) RegisterHandlers() {
 	http.HandleFunc("/api/meta", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		response := struct {
-			IpTools map[string]string `json:"ip_tools"`
+			IpTools      map[string]string     `json:"ip_tools"`
+			Degraded     bool                  `json:"degraded"`
+			Degradations []monitor.Degradation `json:"degradations,omitempty"`
+			Capabilities map[string]bool       `json:"capabilities"`
+			ByteUnits    units.System          `json:"byte_units"` // iec or si, for formatting byte counts
+			ByteBase     uint64                `json:"byte_base"`  // 1024 or 1000
 		}{
-			IpTools: s.ipTools,
+			IpTools:      s.ipTools,
+			Degraded:     len(s.degraded) > 0,
+			Degradations: s.degraded,
+			Capabilities: monitor.Capabilities(),
+			ByteUnits:    units.Current(),
+			ByteBase:     units.Current().Base(),
 		}
 		json.NewEncoder(w).Encode(response)
 	})
 
-	http.HandleFunc("/api/stats", func(w http.ResponseWriter, r *http.Request) {
+	// Clients can be sorted, filtered, trimmed and paginated, see clientQuery
+	http.HandleFunc("/api/stats", s.throttled(func(w http.ResponseWriter, r *http.Request) {
+		cq, err := parseClientQuery(r.URL.Query(), "all")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		count, clients := cq.apply(withTag(s.agg.GetClients(), r.URL.Query().Get("tag")))
+
 		w.Header().Set("Content-Type", "application/json")
 		response := struct {
-			StartTime time.Time           `json:"start_time"`
-			Global    model.GlobalStats   `json:"global"`
-			Clients   []model.ClientStats `json:"clients"`
+			StartTime   time.Time         `json:"start_time"`
+			Global      model.GlobalStats `json:"global"`
+			ClientCount int               `json:"client_count"` // Matching clients before pagination
+			Clients     any               `json:"clients"`
+			VLANs       []model.VLANStats `json:"vlans,omitempty"` // Only when clients were seen on VLANs
+			Zones       []model.ZoneStats `json:"zones,omitempty"` // Only when conntrack zones are in use
 		}{
-			StartTime: s.agg.GetStartTime(),
-			Global:    s.agg.GetGlobalStats(),
-			Clients:   s.agg.GetClients(),
+			StartTime:   s.agg.GetStartTime(),
+			Global:      s.agg.GetGlobalStats(),
+			ClientCount: count,
+			Clients:     clients,
+			VLANs:       s.agg.GetVLANs(),
+			Zones:       s.agg.GetZones(),
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+
+	http.Handle("/api/stream", s.handleStream())
+
+	// This instance and the remote sites, clients labelled by site
+	http.HandleFunc("/api/federation", s.throttled(func(w http.ResponseWriter, r *http.Request) {
+		if s.fed == nil {
+			http.Error(w, "Federation is not enabled", http.StatusNotFound)
+			return
+		}
+		view := s.fed.View()
+		if tag := r.URL.Query().Get("tag"); tag != "" {
+			view.Clients = slices.DeleteFunc(view.Clients, func(c model.SiteClient) bool { return !slices.Contains(c.Tags, tag) })
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(view)
+	}))
+
+	// Online clients by default, same parameters as /api/stats
+	http.HandleFunc("/api/clients", func(w http.ResponseWriter, r *http.Request) {
+		filter := "online"
+		if includeInactive, _ := strconv.ParseBool(r.URL.Query().Get("include_inactive")); includeInactive {
+			filter = "all"
+		}
+		cq, err := parseClientQuery(r.URL.Query(), filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		count, clients := cq.apply(withTag(s.agg.GetClients(), r.URL.Query().Get("tag")))
+
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			Count   int `json:"count"` // Matching clients before pagination
+			Clients any `json:"clients"`
+		}{
+			Count:   count,
+			Clients: clients,
 		}
 		json.NewEncoder(w).Encode(response)
 	})
@@ -78,24 +278,86 @@ func (s *Server) RegisterHandlers() {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		// flush=true also clears the kernel table, so counters of existing
+		// connections restart from zero instead of continuing
+		if flush, _ := strconv.ParseBool(r.URL.Query().Get("flush")); flush {
+			f, ok := s.source.(monitor.Flusher)
+			if !ok {
+				http.Error(w, "Traffic source has no table to flush", http.StatusBadRequest)
+				return
+			}
+			if err := f.Flush(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
 		if err := s.agg.Reset(); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		s.purgeCache()
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status":"ok"}`))
 	})
 
-	http.HandleFunc("/api/client", func(w http.ResponseWriter, r *http.Request) {
+	// Accounting pause, e.g. for maintenance or iperf runs that should not count
+	http.HandleFunc("/api/monitor", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.agg.MonitorState())
+	})
+	http.HandleFunc("/api/monitor/pause", s.monitorControl(s.agg.Pause))
+	http.HandleFunc("/api/monitor/resume", s.monitorControl(s.agg.Resume))
+
+	http.HandleFunc("/api/export", func(w http.ResponseWriter, r *http.Request) {
+		if s.backups == nil {
+			http.Error(w, "Backups are not available", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="catchmole-%s.json"`, time.Now().Format("20060102")))
+		json.NewEncoder(w).Encode(s.backups.Export())
+	})
+
+	http.HandleFunc("/api/import", func(w http.ResponseWriter, r *http.Request) {
+		if s.backups == nil {
+			http.Error(w, "Backups are not available", http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var backup storage.Backup
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBackupSize)).Decode(&backup); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		slog.Info("API: import backup", "clients", len(backup.Clients), "saved_at", backup.SavedAt)
+		if err := s.backups.Import(backup); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.purgeCache()
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+
+	http.HandleFunc("/api/client", s.throttled(func(w http.ResponseWriter, r *http.Request) {
 		mac := r.URL.Query().Get("mac")
 		if mac == "" {
 			http.Error(w, "Missing mac parameter", http.StatusBadRequest)
 			return
 		}
-		mac = strings.TrimSpace(strings.ToLower(mac))
+		mac = s.agg.PrimaryMAC(strings.TrimSpace(strings.ToLower(mac)))
 		w.Header().Set("Content-Type", "application/json")
 
 		flows, activeConns, localIPs := s.agg.GetFlowsByMAC(mac)
+		// Optional tag filter, "none" selects untagged flows
+		if tag := r.URL.Query().Get("tag"); tag != "" {
+			if tag == "none" {
+				tag = ""
+			}
+			flows = slices.DeleteFunc(flows, func(f model.FlowDetail) bool { return f.Tag != tag })
+		}
 
 		clientStats := s.agg.GetClientWithSession(mac)
 		if clientStats != nil {
@@ -103,15 +365,351 @@ func (s *Server) RegisterHandlers() {
 		}
 
 		response := struct {
-			Client   *model.ClientStats `json:"client"`
-			Flows    []model.FlowDetail `json:"flows"`
-			LocalIPs []string           `json:"local_ips"`
-			FlowTTL  int                `json:"flow_ttl"`
+			Client     *model.ClientStats    `json:"client"`
+			Flows      []model.FlowDetail    `json:"flows"`
+			LocalIPs   []string              `json:"local_ips"`
+			FlowTTL    int                   `json:"flow_ttl"`
+			Categories []model.CategoryStats `json:"categories"`
+			Classes    []model.ClassStats    `json:"classes"`
+			Aliases    []string              `json:"aliases,omitempty"` // Further MACs merged into this client
+			Notes      string                `json:"notes,omitempty"`
 		}{
-			Client:   clientStats,
-			Flows:    flows,
-			LocalIPs: localIPs,
-			FlowTTL:  s.flowTTL,
+			Client:     clientStats,
+			Flows:      flows,
+			LocalIPs:   localIPs,
+			FlowTTL:    int(s.agg.GetFlowTTL().Seconds()),
+			Categories: s.agg.GetClientCategories(mac),
+			Classes:    s.agg.GetClientClasses(mac),
+			Aliases:    s.agg.ClientAliases(mac),
+			Notes:      s.deviceNotes(mac),
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+
+	http.HandleFunc("/api/client/limit", func(w http.ResponseWriter, r *http.Request) {
+		if s.shaper == nil {
+			http.Error(w, "Shaping is not enabled", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			// limit is "down/up" in Mbps like the config, empty removes the runtime limit
+			var req struct {
+				MAC   string `json:"mac"`
+				Limit string `json:"limit"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+				return
+			}
+			hw, err := net.ParseMAC(strings.TrimSpace(req.MAC))
+			if err != nil {
+				http.Error(w, "Invalid mac", http.StatusBadRequest)
+				return
+			}
+			var limit shaping.Limit
+			if req.Limit != "" {
+				if limit, err = shaping.ParseLimit(req.Limit); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			mac := s.agg.PrimaryMAC(hw.String())
+			auditMAC(w, mac)
+			slog.Info("API: set client limit", "mac", mac, "limit", req.Limit)
+			s.shaper.SetLimit(mac, limit)
+		case http.MethodDelete:
+			mac := s.agg.PrimaryMAC(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac"))))
+			auditMAC(w, mac)
+			slog.Info("API: remove client limit", "mac", mac)
+			s.shaper.SetLimit(mac, shaping.Limit{})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			Limits []shaping.ClientLimit `json:"limits"`
+		}{
+			Limits: s.shaper.Limits(),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	// Preview of the report that would be sent now, ?format=html renders the email
+	http.HandleFunc("/api/report", func(w http.ResponseWriter, r *http.Request) {
+		if s.reporter == nil {
+			http.Error(w, "Reports are not enabled", http.StatusNotFound)
+			return
+		}
+		// A time range (and resolution for a usage breakdown) replaces the scheduled period
+		rep := s.reporter.Generate(time.Now())
+		if hasTimeRange(r) || r.URL.Query().Has("resolution") {
+			from, to, resolution, err := parseTimeRange(r, 7*24*time.Hour)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var breakdown string
+			if resolution > 0 {
+				var ok bool
+				if breakdown, ok = usagePeriod(resolution); !ok {
+					http.Error(w, fmt.Sprintf("invalid resolution: %s (want 1d, 7d or 30d)", r.URL.Query().Get("resolution")), http.StatusBadRequest)
+					return
+				}
+			}
+			if rep, err = s.reporter.GenerateRange(from, to, breakdown); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if r.URL.Query().Get("format") == "html" {
+			page, err := report.RenderHTML(rep)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(page)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rep)
+	})
+
+	// Records the client's packets for duration (default 30s) and streams them as pcap
+	http.HandleFunc("/api/client/capture", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.captureIface == "" {
+			http.Error(w, "Capture requires interface to be set", http.StatusNotFound)
+			return
+		}
+		hw, err := net.ParseMAC(strings.TrimSpace(r.URL.Query().Get("mac")))
+		if err != nil {
+			http.Error(w, "Invalid mac", http.StatusBadRequest)
+			return
+		}
+		duration := defaultCaptureDuration
+		if v := r.URL.Query().Get("duration"); v != "" {
+			if duration, err = time.ParseDuration(v); err != nil || duration <= 0 || duration > maxCaptureDuration {
+				http.Error(w, fmt.Sprintf("Invalid duration, want up to %s", maxCaptureDuration), http.StatusBadRequest)
+				return
+			}
+		}
+		mac := s.agg.PrimaryMAC(hw.String())
+		if s.agg.IsPrivate(mac) {
+			http.Error(w, "Client is private", http.StatusForbidden)
+			return
+		}
+		ips := s.agg.ClientIPs(mac)
+		if len(ips) == 0 {
+			http.Error(w, "No addresses known for client", http.StatusNotFound)
+			return
+		}
+
+		select {
+		case s.captureSlots <- struct{}{}:
+			defer func() { <-s.captureSlots }()
+		default:
+			http.Error(w, "Too many captures running", http.StatusTooManyRequests)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), duration)
+		defer cancel()
+		name := fmt.Sprintf("catchmole-%s-%s.pcap", strings.ReplaceAll(mac, ":", ""), time.Now().Format("20060102-150405"))
+		w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+		slog.Info("API: client capture started", "mac", mac, "ips", ips, "duration", duration)
+		st, err := monitor.CapturePcap(ctx, w, s.captureIface, ips, maxCaptureBytes)
+		if err != nil {
+			slog.Error("API: client capture failed", "mac", mac, "err", err)
+			if st.Bytes == 0 {
+				w.Header().Del("Content-Disposition")
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		slog.Info("API: client capture done", "mac", mac, "packets", st.Packets, "bytes", st.Bytes, "truncated", st.Truncated)
+	})
+
+	http.HandleFunc("/api/speedtest", s.handleSpeedTest)
+
+	http.HandleFunc("/api/client/speedtests", func(w http.ResponseWriter, r *http.Request) {
+		mac := s.agg.PrimaryMAC(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac"))))
+		if mac == "" {
+			http.Error(w, "Missing mac parameter", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			MAC        string            `json:"mac"`
+			SpeedTests []model.SpeedTest `json:"speed_tests"`
+		}{
+			MAC:        mac,
+			SpeedTests: s.agg.GetSpeedTests(mac),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/client/session-history", func(w http.ResponseWriter, r *http.Request) {
+		mac := s.agg.PrimaryMAC(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac"))))
+		if mac == "" {
+			http.Error(w, "Missing mac parameter", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			MAC      string                `json:"mac"`
+			Sessions []model.SessionRecord `json:"sessions"`
+		}{
+			MAC:      mac,
+			Sessions: s.agg.GetSessionHistory(mac),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/client/latency", func(w http.ResponseWriter, r *http.Request) {
+		mac := s.agg.PrimaryMAC(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac"))))
+		if mac == "" {
+			http.Error(w, "Missing mac parameter", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			MAC          string               `json:"mac"`
+			Destinations []model.LatencyStats `json:"destinations"`
+		}{
+			MAC:          mac,
+			Destinations: s.agg.GetClientLatency(mac),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/client/services", func(w http.ResponseWriter, r *http.Request) {
+		mac := s.agg.PrimaryMAC(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac"))))
+		if mac == "" {
+			http.Error(w, "Missing mac parameter", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			MAC      string               `json:"mac"`
+			Services []model.ServiceStats `json:"services"`
+		}{
+			MAC:      mac,
+			Services: s.agg.GetClientServices(mac),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/client/countries", func(w http.ResponseWriter, r *http.Request) {
+		mac := s.agg.PrimaryMAC(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac"))))
+		if mac == "" {
+			http.Error(w, "Missing mac parameter", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			MAC       string               `json:"mac"`
+			Countries []model.CountryStats `json:"countries"`
+		}{
+			MAC:       mac,
+			Countries: s.agg.GetClientCountries(mac),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/client/sessions", func(w http.ResponseWriter, r *http.Request) {
+		mac := s.agg.PrimaryMAC(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac"))))
+		if mac == "" {
+			http.Error(w, "Missing mac parameter", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			MAC      string                  `json:"mac"`
+			IdleGap  int                     `json:"idle_gap"` // Seconds
+			Sessions []model.PresenceSession `json:"sessions"`
+		}{
+			MAC:      mac,
+			IdleGap:  int(s.agg.GetIdleGap().Seconds()),
+			Sessions: s.agg.GetClientSessions(mac),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/client/history", func(w http.ResponseWriter, r *http.Request) {
+		mac := s.agg.PrimaryMAC(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac"))))
+		if mac == "" {
+			http.Error(w, "Missing mac parameter", http.StatusBadRequest)
+			return
+		}
+		limit, err := parseLimit(r, 100)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			MAC   string               `json:"mac"`
+			Flows []model.ArchivedFlow `json:"flows"`
+		}{
+			MAC:   mac,
+			Flows: s.agg.GetArchivedFlows(mac, limit),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/client/heatmap", func(w http.ResponseWriter, r *http.Request) {
+		mac := s.agg.PrimaryMAC(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac"))))
+		if mac == "" {
+			http.Error(w, "Missing mac parameter", http.StatusBadRequest)
+			return
+		}
+		// All time by default, a time range is built from the hourly history (30 days)
+		from, to, resolution, err := parseTimeRange(r, 7*24*time.Hour)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hours := int(resolution / time.Hour)
+		if resolution != 0 && (resolution%time.Hour != 0 || hours == 0 || 24%hours != 0) {
+			http.Error(w, fmt.Sprintf("invalid resolution: %s (want whole hours dividing a day, e.g. 3h)", r.URL.Query().Get("resolution")), http.StatusBadRequest)
+			return
+		}
+		var heatmap history.Heatmap
+		var ok bool
+		if hasTimeRange(r) {
+			heatmap, ok = s.history.HeatmapBetween(mac, from, to)
+		} else {
+			heatmap, ok = s.history.Heatmap(mac)
+			from, to = time.Time{}, time.Time{}
+		}
+		if !ok {
+			http.Error(w, "Client not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			MAC       string    `json:"mac"`
+			From      time.Time `json:"from,omitzero"`
+			To        time.Time `json:"to,omitzero"`
+			CellHours int       `json:"cell_hours"` // Each cell sums this many hours into its first
+			history.Heatmap
+		}{
+			MAC:       mac,
+			From:      from,
+			To:        to,
+			CellHours: max(hours, 1),
+			Heatmap:   heatmap.Fold(hours),
 		}
 		json.NewEncoder(w).Encode(response)
 	})
@@ -121,12 +719,13 @@ func (s *Server) RegisterHandlers() {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		mac := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac")))
-		log.Printf("API: Reset Client %s\n", mac)
+		mac := s.agg.PrimaryMAC(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac"))))
+		slog.Info("API: reset client", "mac", mac)
 		if err := s.agg.ResetClientByMAC(mac); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		s.purgeCache()
 		w.Write([]byte("OK"))
 	})
 
@@ -135,13 +734,636 @@ func (s *Server) RegisterHandlers() {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		mac := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac")))
-		log.Printf("API: Reset Session %s\n", mac)
+		mac := s.agg.PrimaryMAC(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac"))))
+		slog.Info("API: reset session", "mac", mac)
 		if err := s.agg.ResetSessionByMAC(mac); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		s.purgeCache()
+		w.Write([]byte("OK"))
+	})
+	// Runtime tuning of speed and connection smoothing, until the next restart or config reload
+	http.HandleFunc("/api/smoothing", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			// Fields missing from the body keep their current value
+			settings := s.agg.GetSmoothing()
+			if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+				http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+				return
+			}
+			if err := s.agg.SetSmoothing(settings); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			slog.Info("API: smoothing updated", "alpha", settings.Alpha, "min_elapsed_ms", settings.MinElapsedMs, "window_seconds", settings.WindowSeconds)
+			s.purgeCache()
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.agg.GetSmoothing())
+	})
+
+	// Runtime monitoring settings, persisted over the config file
+	http.HandleFunc("/api/settings", func(w http.ResponseWriter, r *http.Request) {
+		if s.settings == nil {
+			http.Error(w, "Settings are not editable", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			// Fields missing from the body keep their current value
+			var req storage.RuntimeSettings
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+				return
+			}
+			s.settingsMu.Lock()
+			defer s.settingsMu.Unlock()
+			cur := s.settings.Settings()
+			next := cur
+			req.Apply(&next)
+			if err := checkSettings(next); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// Saved first, a change that is not persisted is not applied
+			prev := s.settings.RuntimeSettings()
+			if err := s.settings.Update(req); err != nil {
+				slog.Error("Failed to save settings", "err", err)
+				http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+				return
+			}
+			if err := s.applySettings(cur, next); err != nil {
+				if err := s.settings.Replace(prev); err != nil {
+					slog.Error("Failed to restore settings", "err", err)
+				}
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			slog.Info("API: settings updated", "flow_ttl", next.FlowTTL, "interval", next.Interval,
+				"monitor_lan", next.MonitorLAN, "interface", next.Interface)
+			if next.Interface != cur.Interface && next.Interface != s.iface {
+				slog.Warn("API: interface changed for LAN detection only, restart to move capture and sniffers",
+					"running", s.iface, "interface", next.Interface)
+			}
+			s.purgeCache()
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// The traffic source, watchdog, capture and sniffers stay on the interface
+		// they were started on
+		settings := s.settings.Settings()
+		response := struct {
+			model.Settings
+			RestartRequired []string `json:"restart_required,omitempty"` // Saved settings not yet in effect
+		}{
+			Settings: settings,
+		}
+		if settings.Interface != s.iface {
+			response.RestartRequired = append(response.RestartRequired, "interface")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/devices", func(w http.ResponseWriter, r *http.Request) {
+		if s.devices == nil {
+			http.Error(w, "Devices are not editable", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			// Fields left out keep their current value
+			var req struct {
+				MAC   string    `json:"mac"`
+				Name  *string   `json:"name"`
+				Tags  *[]string `json:"tags"`
+				Notes *string   `json:"notes"`
+				Plan  *string   `json:"plan"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDeviceSize)).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+				return
+			}
+			hw, err := net.ParseMAC(strings.TrimSpace(req.MAC))
+			if err != nil || len(hw) != 6 {
+				http.Error(w, "Invalid mac", http.StatusBadRequest)
+				return
+			}
+			mac := hw.String()
+			auditMAC(w, mac)
+			dev, _ := s.devices.Device(mac)
+			if req.Name != nil {
+				dev.Name = strings.TrimSpace(*req.Name)
+			}
+			if req.Tags != nil {
+				dev.Tags = *req.Tags
+			}
+			if req.Notes != nil {
+				dev.Notes = *req.Notes
+			}
+			if req.Plan != nil {
+				dev.Plan = strings.ToLower(strings.TrimSpace(*req.Plan))
+			}
+			if dev.Name == "" && len(dev.Tags) == 0 && dev.Notes == "" && dev.Plan == "" {
+				http.Error(w, "Empty name, tags, notes and plan", http.StatusBadRequest)
+				return
+			}
+			slog.Info("API: set device", "mac", mac, "name", dev.Name, "tags", dev.Tags, "plan", dev.Plan)
+			if err := s.devices.Set(mac, dev); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			s.agg.SetDeviceNames(s.devices.Names())
+			s.agg.SetDeviceTags(s.devices.Tags())
+			s.agg.SetDevicePlans(s.devices.Plans())
+			s.purgeCache()
+		case http.MethodDelete:
+			mac := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac")))
+			ok, err := s.devices.Delete(mac)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "No runtime entry for this mac (devices from the config file must be edited there)", http.StatusNotFound)
+				return
+			}
+			slog.Info("API: remove device", "mac", mac)
+			s.agg.SetDeviceNames(s.devices.Names())
+			s.agg.SetDeviceTags(s.devices.Tags())
+			s.agg.SetDevicePlans(s.devices.Plans())
+			s.purgeCache()
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			Devices map[string]storage.Device `json:"devices"` // Effective entries
+			Runtime map[string]storage.Device `json:"runtime"` // Set via this API
+		}{
+			Devices: s.devices.Devices(),
+			Runtime: s.devices.RuntimeDevices(),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/aliases", func(w http.ResponseWriter, r *http.Request) {
+		if s.aliases == nil {
+			http.Error(w, "Aliases are not editable", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req struct {
+				MAC     string   `json:"mac"`
+				Aliases []string `json:"aliases"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+				return
+			}
+			hw, err := net.ParseMAC(strings.TrimSpace(req.MAC))
+			if err != nil || len(req.Aliases) == 0 {
+				http.Error(w, "Invalid mac or no aliases", http.StatusBadRequest)
+				return
+			}
+			mac := hw.String()
+			auditMAC(w, mac)
+			aliases := s.aliases.Aliases()
+			aliases[mac] = req.Aliases
+			// Validate against the other entries before persisting
+			if err := s.agg.SetAliases(aliases); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			slog.Info("API: set aliases", "mac", mac, "aliases", req.Aliases)
+			if err := s.aliases.Set(mac, req.Aliases); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			s.purgeCache()
+		case http.MethodDelete:
+			mac := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mac")))
+			ok, err := s.aliases.Delete(mac)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "No runtime aliases for this mac (aliases from the config file must be edited there)", http.StatusNotFound)
+				return
+			}
+			slog.Info("API: remove aliases", "mac", mac)
+			if err := s.agg.SetAliases(s.aliases.Aliases()); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			s.purgeCache()
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			Aliases map[string][]string `json:"aliases"` // Effective aliases, primary MAC -> alias MACs
+			Runtime map[string][]string `json:"runtime"` // Set via this API
+		}{
+			Aliases: s.agg.GetAliases(),
+			Runtime: s.aliases.RuntimeAliases(),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/history", func(w http.ResponseWriter, r *http.Request) {
+		// mac is optional, empty means global traffic
+		mac := s.agg.PrimaryMAC(strings.TrimSpace(strings.ToLower(r.URL.Query().Get("mac"))))
+		from, to, resolution, err := parseTimeRange(r, 24*time.Hour)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			MAC    string          `json:"mac,omitempty"`
+			From   time.Time       `json:"from"`
+			To     time.Time       `json:"to"`
+			Points []history.Point `json:"points"`
+		}{
+			MAC:    mac,
+			From:   from,
+			To:     to,
+			Points: s.history.Query(mac, from, to, resolution),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/flows/elephants", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		current, recent := s.agg.GetElephants()
+		response := struct {
+			Current []model.ElephantFlow `json:"current"`
+			Recent  []model.ElephantFlow `json:"recent"`
+		}{
+			Current: current,
+			Recent:  recent,
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	// Clients uploading far above their own baseline
+	http.HandleFunc("/api/anomalies", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			Anomalies []model.Anomaly `json:"anomalies"`
+		}{
+			Anomalies: s.agg.GetAnomalies(),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	// Clients exceeding the bandwidth of their plan
+	http.HandleFunc("/api/plans", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			Violations []model.PlanViolation `json:"violations"`
+		}{
+			Violations: s.agg.GetPlanViolations(),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	// Scanning clients, and the alerts fired if notifications are configured
+	// Mutating requests newest first, e.g. who reset the statistics
+	http.HandleFunc("/api/audit", func(w http.ResponseWriter, r *http.Request) {
+		limit, err := parseLimit(r, 100)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.audit.list(limit))
+	})
+
+	http.HandleFunc("/api/alerts", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			Scans  []model.ScanDetection `json:"scans"`
+			Recent []alert.Alert         `json:"recent"`
+		}{
+			Scans:  s.agg.GetScanDetections(),
+			Recent: make([]alert.Alert, 0),
+		}
+		if s.alerts != nil {
+			response.Recent = s.alerts.Recent()
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/flows/recent", func(w http.ResponseWriter, r *http.Request) {
+		limit, err := parseLimit(r, 100)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			Flows []model.ArchivedFlow `json:"flows"`
+		}{
+			Flows: s.agg.GetArchivedFlows("", limit),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/flows", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		by := q.Get("by")
+		if by == "" {
+			by = "speed"
+		}
+		filter := stats.FlowFilter{Proto: q.Get("proto")}
+		if v := q.Get("port"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 16)
+			if err != nil {
+				http.Error(w, "invalid port", http.StatusBadRequest)
+				return
+			}
+			filter.Port = uint16(n)
+		}
+		if v := q.Get("remote"); v != "" {
+			if !strings.Contains(v, "/") {
+				if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+					v += "/32"
+				} else {
+					v += "/128"
+				}
+			}
+			_, sn, err := net.ParseCIDR(v)
+			if err != nil {
+				http.Error(w, "invalid remote", http.StatusBadRequest)
+				return
+			}
+			filter.Remote = sn
+		}
+		if v := q.Get("min_speed"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid min_speed", http.StatusBadRequest)
+				return
+			}
+			filter.MinSpeed = n
+		}
+		limit, offset := 100, 0
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		if v := q.Get("offset"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid offset", http.StatusBadRequest)
+				return
+			}
+			offset = n
+		}
+
+		flows, err := s.agg.GetFlows(filter, by, limit, offset)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(flows)
+	})
+
+	http.HandleFunc("/api/top", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		by := q.Get("by")
+		if by == "" {
+			by = "download_speed"
+		}
+		limit, offset := 20, 0
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		if v := q.Get("offset"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid offset", http.StatusBadRequest)
+				return
+			}
+			offset = n
+		}
+
+		if !hasTimeRange(r) {
+			top, err := s.agg.GetTop(by, q.Get("window"), limit, offset)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(top)
+			return
+		}
+
+		// Ranked by traffic in the range from history, resolution adds the
+		// traffic series of each returned client
+		if q.Get("window") != "" {
+			http.Error(w, "window and a time range are exclusive", http.StatusBadRequest)
+			return
+		}
+		from, to, resolution, err := parseTimeRange(r, time.Hour)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		top, err := s.agg.GetTopRange(by, s.history.Totals(from, to), from, to, limit, offset)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response := struct {
+			model.TopStats
+			Series map[string][]history.Point `json:"series,omitempty"`
+		}{
+			TopStats: top,
+		}
+		if resolution > 0 {
+			response.Series = make(map[string][]history.Point, len(top.Clients))
+			for _, c := range top.Clients {
+				response.Series[c.MAC] = s.history.Query(c.MAC, from, to, resolution)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/usage", func(w http.ResponseWriter, r *http.Request) {
+		mac := s.agg.PrimaryMAC(strings.TrimSpace(strings.ToLower(r.URL.Query().Get("mac")))) // Empty = global
+		// All retained days unless a time range is given, resolution (1d, 7d, 30d)
+		// is an alternative to period
+		from, to, resolution, err := parseTimeRange(r, 30*24*time.Hour)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		period := r.URL.Query().Get("period")
+		if period == "" && resolution > 0 {
+			var ok bool
+			if period, ok = usagePeriod(resolution); !ok {
+				http.Error(w, fmt.Sprintf("invalid resolution: %s (want 1d, 7d or 30d)", r.URL.Query().Get("resolution")), http.StatusBadRequest)
+				return
+			}
+		}
+		if period == "" {
+			period = "daily"
+		}
+
+		var periods []model.UsagePeriod
+		if hasTimeRange(r) {
+			periods, err = s.usage.QueryBetween(mac, period, from, to)
+		} else {
+			periods, err = s.usage.Query(mac, period)
+			from, to = time.Time{}, time.Time{}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			MAC      string              `json:"mac,omitempty"`
+			Period   string              `json:"period"`
+			From     time.Time           `json:"from,omitzero"`
+			To       time.Time           `json:"to,omitzero"`
+			Timezone string              `json:"timezone"`
+			Usage    []model.UsagePeriod `json:"usage"`
+		}{
+			MAC:      mac,
+			Period:   period,
+			From:     from,
+			To:       to,
+			Timezone: s.usage.Location().String(),
+			Usage:    periods,
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/groups", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			Groups []model.GroupStats `json:"groups"`
+		}{
+			Groups: s.agg.GetGroups(),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/device-tags", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			Tags []model.GroupStats `json:"tags"`
+		}{
+			Tags: s.agg.GetDeviceTags(),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/countries", func(w http.ResponseWriter, r *http.Request) {
+		type blockedClient struct {
+			MAC       string               `json:"mac"`
+			Name      string               `json:"name"`
+			Countries []model.CountryStats `json:"countries"`
+		}
+		var blocked []blockedClient
+		for mac, countries := range s.agg.BlockedCountryTraffic() {
+			bc := blockedClient{MAC: mac, Countries: countries}
+			if c := s.agg.GetClientWithSession(mac); c != nil {
+				bc.Name = c.Name
+			}
+			blocked = append(blocked, bc)
+		}
+		slices.SortFunc(blocked, func(a, b blockedClient) int { return strings.Compare(a.MAC, b.MAC) })
+
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			Countries []model.CountryStats `json:"countries"`         // Internet traffic by country, largest first
+			Blocked   []blockedClient      `json:"blocked,omitempty"` // Clients that talked to blocked countries
+		}{
+			Countries: s.agg.GetCountries(),
+			Blocked:   blocked,
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/classes", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			Classes []model.ClassStats `json:"classes"`
+		}{
+			Classes: s.agg.GetGlobalClasses(),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if s.watchdog != nil {
+			if ok, reason := s.watchdog.Healthy(); !ok {
+				http.Error(w, "stalled: "+reason, http.StatusServiceUnavailable)
+				return
+			}
+		}
 		w.Write([]byte("OK"))
 	})
+
 	http.Handle("/metrics", promhttp.Handler())
 }
+
+// monitorControl serves POST endpoints that pause or resume accounting
+func (s *Server) monitorControl(apply func() model.MonitorState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		state := apply()
+		s.purgeCache()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	}
+}
+
+// withTag keeps the clients carrying a device tag, all clients if tag is empty
+func withTag(clients []model.ClientStats, tag string) []model.ClientStats {
+	if tag = strings.ToLower(strings.TrimSpace(tag)); tag == "" {
+		return clients
+	}
+	return slices.DeleteFunc(clients, func(c model.ClientStats) bool { return !slices.Contains(c.Tags, tag) })
+}
@@ -0,0 +1,70 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/monitor"
+	"github.com/kisy/catchmole/pkg/stats"
+)
+
+// checkSettings rejects invalid settings without changing anything
+func checkSettings(next model.Settings) error {
+	if next.FlowTTL <= 0 {
+		return errors.New("flow_ttl must be positive")
+	}
+	if next.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	if err := stats.ValidateIgnoreRules(next.IgnoreSubnets, next.IgnorePorts, next.IgnoreMACs); err != nil {
+		return err
+	}
+	if next.Interface != "" {
+		if _, err := monitor.Netlink().LinkByName(next.Interface); err != nil {
+			return fmt.Errorf("invalid interface %q: %w", next.Interface, err)
+		}
+	}
+	return nil
+}
+
+// applySettings pushes changed settings into the running aggregator and
+// source. Nothing is changed if next is invalid.
+func (s *Server) applySettings(cur, next model.Settings) error {
+
+	ignoreChanged := !slices.Equal(cur.IgnoreSubnets, next.IgnoreSubnets) ||
+		!slices.Equal(cur.IgnorePorts, next.IgnorePorts) || !slices.Equal(cur.IgnoreMACs, next.IgnoreMACs)
+	if ignoreChanged {
+		if err := s.agg.SetIgnoreRules(next.IgnoreSubnets, next.IgnorePorts, next.IgnoreMACs); err != nil {
+			return err
+		}
+	}
+	if next.Interface != cur.Interface {
+		if err := s.agg.SetInterface(next.Interface); err != nil {
+			if ignoreChanged {
+				s.agg.SetIgnoreRules(cur.IgnoreSubnets, cur.IgnorePorts, cur.IgnoreMACs)
+			}
+			return fmt.Errorf("invalid interface %q: %w", next.Interface, err)
+		}
+	}
+
+	if next.FlowTTL != cur.FlowTTL {
+		s.agg.SetFlowTTL(time.Duration(next.FlowTTL) * time.Second)
+	}
+	if next.MonitorLAN != cur.MonitorLAN {
+		s.agg.SetIgnoreLAN(!next.MonitorLAN)
+	}
+	if next.Interval != cur.Interval {
+		d := time.Duration(next.Interval) * time.Second
+		s.agg.SetInterval(d)
+		if s.source != nil {
+			if err := s.source.SetPollInterval(d); err != nil {
+				slog.Error("Failed to restart monitor with new interval", "err", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+)
+
+// Bounds of /api/speedtest
+const (
+	defaultSpeedTestMB = 10
+	maxSpeedTestMB     = 200
+	maxSpeedTests      = 2 // Concurrent
+)
+
+// Incompressible payload, streamed repeatedly for downloads
+var speedTestChunk = sync.OnceValue(func() []byte {
+	b := make([]byte, 1<<20)
+	rand.Read(b)
+	return b
+})
+
+// handleSpeedTest measures the throughput between the requesting client and
+// the router: GET streams size megabytes (default 10) to the client, POST
+// reads up to size megabytes from it. Results are kept per client, see
+// /api/client/speedtests.
+func (s *Server) handleSpeedTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	size := defaultSpeedTestMB
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxSpeedTestMB {
+			http.Error(w, fmt.Sprintf("Invalid size, want 1 to %d (MB)", maxSpeedTestMB), http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+
+	// The result is recorded for the device the request comes from
+	ip := remoteIP(r)
+	if ip == "" {
+		http.Error(w, "Speed tests need a TCP connection from the device", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case s.speedTestSlots <- struct{}{}:
+		defer func() { <-s.speedTestSlots }()
+	default:
+		http.Error(w, "Too many speed tests running", http.StatusTooManyRequests)
+		return
+	}
+
+	// The test measures the LAN, its bytes are not the client's usage
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); ok {
+		if remote, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+			s.agg.ExcludeSpeedTest(remote.IP, uint16(remote.Port), local.IP, uint16(local.Port))
+		}
+	}
+
+	t := model.SpeedTest{
+		MAC:      s.agg.ClientMAC(ip),
+		ClientIP: ip,
+		Time:     time.Now(),
+	}
+	want := uint64(size) << 20
+
+	if r.Method == http.MethodGet {
+		t.Direction = "download"
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatUint(want, 10))
+		w.Header().Set("Cache-Control", "no-store")
+		chunk := speedTestChunk()
+		for t.Bytes < want {
+			n, err := w.Write(chunk[:min(uint64(len(chunk)), want-t.Bytes)])
+			t.Bytes += uint64(n)
+			if err != nil {
+				break
+			}
+		}
+	} else {
+		t.Direction = "upload"
+		n, err := io.Copy(io.Discard, http.MaxBytesReader(w, r.Body, int64(want)))
+		t.Bytes = uint64(n)
+		if err != nil && t.Bytes < want {
+			slog.Debug("API: speed test upload ended early", "ip", ip, "err", err)
+		}
+	}
+
+	t.Duration = time.Since(t.Time).Seconds()
+	t.Complete = t.Bytes == want
+	if t.Duration > 0 {
+		t.Speed = uint64(float64(t.Bytes) / t.Duration)
+	}
+	if t.MAC != "" && !s.agg.IsPrivate(t.MAC) {
+		s.agg.RecordSpeedTest(t)
+	}
+	slog.Info("API: speed test done", "mac", t.MAC, "ip", ip, "direction", t.Direction, "bytes", t.Bytes, "speed", t.Speed)
+
+	if r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t)
+	}
+}
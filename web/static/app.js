@@ -1,9 +1,14 @@
 // Helpers
+
+// Byte units from /api/meta (byte_units): iec (KiB, 1024) or si (kB, 1000)
+let byteUnits = 'iec';
+
 function formatBytes(bytes, decimals = 2) {
     if (bytes === 0) return '0 B';
-    const k = 1024;
+    const si = byteUnits === 'si';
+    const k = si ? 1000 : 1024;
     const dm = decimals < 0 ? 0 : decimals;
-    const sizes = ['B', 'KB', 'MB', 'GB', 'TB', 'PB'];
+    const sizes = si ? ['B', 'kB', 'MB', 'GB', 'TB', 'PB'] : ['B', 'KiB', 'MiB', 'GiB', 'TiB', 'PiB'];
     const i = Math.floor(Math.log(bytes) / Math.log(k));
     return parseFloat((bytes / Math.pow(k, i)).toFixed(dm)) + ' ' + sizes[i];
 }
@@ -12,6 +17,14 @@ function formatSpeed(bytesPerSec) {
     return formatBytes(bytesPerSec) + '/s';
 }
 
+// SVG polyline points of a speed history (viewBox 0 0 60 16), oldest first
+function sparkPoints(series) {
+    if (!series || series.length === 0) return '';
+    const peak = Math.max(...series) || 1;
+    const step = 60 / Math.max(series.length - 1, 1);
+    return series.map((v, i) => `${(i * step).toFixed(1)},${(15 - (v / peak) * 14).toFixed(1)}`).join(' ');
+}
+
 function formatTime(isoString) {
     if (!isoString || isoString.startsWith('0001')) return '-';
     return new Date(isoString).toLocaleTimeString();
@@ -79,6 +92,9 @@ function applyTheme(theme) {
     localStorage.setItem('catchmole_theme', theme);
 }
 
+// Latest client state received over /api/stream (MAC -> ClientStats)
+let streamClients = {};
+
 // Alpine SPA App
 document.addEventListener('alpine:init', () => {
     
@@ -90,6 +106,7 @@ document.addEventListener('alpine:init', () => {
         // === Shared State ===
         theme: getInitialTheme(),
         autoRefresh: true,
+        streaming: false,
         
         // === Clients List State ===
         clients: [],
@@ -98,6 +115,8 @@ document.addEventListener('alpine:init', () => {
         sortBy: localStorage.getItem('catchmole_sortBy') || 'total_download',
         sortDesc: localStorage.getItem('catchmole_sortDesc') === 'true',
         startTime: '',
+        degradations: [], // Features off for lack of capabilities
+        speedTest: '', // Result of the last speed test of this device
         
         // === Client Detail State ===
         detail: {
@@ -144,11 +163,44 @@ document.addEventListener('alpine:init', () => {
             
             // Start data fetching
             this.fetchData();
+            this.connectStream();
             setInterval(() => {
-                if (this.autoRefresh) this.fetchData();
+                if (!this.autoRefresh) return;
+                // Clients list is pushed over WebSocket when connected
+                if (this.currentView === 'clients' && this.streaming) return;
+                this.fetchData();
             }, 1000);
         },
         
+        // === Live Stream ===
+        connectStream() {
+            if (!window.WebSocket) return;
+            const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+            const ws = new WebSocket(`${proto}//${location.host}/api/stream`);
+            
+            ws.onopen = () => { this.streaming = true; };
+            ws.onmessage = (ev) => {
+                this.applyStream(JSON.parse(ev.data));
+            };
+            ws.onclose = () => {
+                // Fall back to polling, retry later
+                this.streaming = false;
+                setTimeout(() => this.connectStream(), 5000);
+            };
+        },
+        
+        applyStream(msg) {
+            // Always track deltas so nothing is missed while paused
+            if (msg.full) streamClients = {};
+            (msg.clients || []).forEach(c => { streamClients[c.mac] = c; });
+            (msg.removed || []).forEach(mac => { delete streamClients[mac]; });
+            
+            if (!this.autoRefresh) return;
+            this.clients = Object.values(streamClients);
+            this.global = msg.global || {};
+            this.startTime = msg.start_time;
+        },
+        
         // === Router ===
         handleRoute() {
             const path = window.location.pathname;
@@ -203,6 +255,8 @@ document.addEventListener('alpine:init', () => {
             try {
                 const res = await fetch('/api/meta');
                 const data = await res.json();
+                this.degradations = data.degradations || [];
+                byteUnits = data.byte_units || 'iec';
                 if (data.ip_tools) {
                     this.detail.ipTools = data.ip_tools;
                     const tools = Object.values(this.detail.ipTools);
@@ -286,6 +340,25 @@ document.addEventListener('alpine:init', () => {
             localStorage.setItem('catchmole_sortDesc', this.sortDesc);
         },
         
+        // Measures this device's throughput to the router, see /api/speedtest
+        async runSpeedTest() {
+            const size = 20; // MB
+            this.speedTest = 'Testing...';
+            try {
+                const start = performance.now();
+                const res = await fetch(`/api/speedtest?size=${size}`, { cache: 'no-store' });
+                const data = await res.arrayBuffer();
+                const down = data.byteLength / ((performance.now() - start) / 1000);
+
+                const up = await fetch(`/api/speedtest?size=${size}`, { method: 'POST', body: new Uint8Array(size << 20) });
+                const result = await up.json();
+                this.speedTest = '↓ ' + formatSpeed(Math.round(down)) + ' ↑ ' + formatSpeed(result.speed || 0);
+            } catch (e) {
+                console.error(e);
+                this.speedTest = 'Speed test failed';
+            }
+        },
+
         async resetAll() {
             if (!confirm('Clear ALL statistics?')) return;
             await fetch('/api/reset', { method: 'POST' });
@@ -357,6 +430,12 @@ document.addEventListener('alpine:init', () => {
             this.detail.filterRemotePort = '';
         },
         
+        // TCP connections shutting down linger until conntrack times them out
+        isClosing(f) {
+            return ['FIN_WAIT', 'CLOSE_WAIT', 'LAST_ACK', 'TIME_WAIT', 'CLOSE'].includes(f.tcp_state) &&
+                !f.download_speed && !f.upload_speed;
+        },
+        
         getIpView(ip) {
             if (!ip || !ip.includes(':')) return ip;
             const parts = ip.split(':');
@@ -0,0 +1,98 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+	"golang.org/x/net/websocket"
+)
+
+// Shortest push interval a client may request
+const minStreamInterval = 100 * time.Millisecond
+
+// streamMessage is pushed over /api/stream. The first message is a full
+// snapshot; later ones only carry clients that changed or were removed.
+type streamMessage struct {
+	Full      bool                `json:"full"`
+	StartTime time.Time           `json:"start_time"`
+	Global    model.GlobalStats   `json:"global"`
+	Clients   []model.ClientStats `json:"clients"`
+	Removed   []string            `json:"removed,omitempty"`
+}
+
+// handleStream pushes stats deltas over WebSocket at the refresh interval.
+// An optional ?interval=500ms overrides the push interval. Browsers let any
+// site open WebSockets with the user's credentials, so other origins are
+// refused like state-changing requests.
+func (s *Server) handleStream() http.Handler {
+	return websocket.Server{Handshake: checkStreamOrigin, Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		interval := s.agg.GetInterval()
+		if v := ws.Request().URL.Query().Get("interval"); v != "" {
+			if d, err := time.ParseDuration(v); err == nil {
+				interval = max(d, minStreamInterval)
+			}
+		}
+
+		// Detect client disconnect (we don't expect incoming messages)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			var discard string
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := make(map[string]model.ClientStats)
+		full := true
+
+		for {
+			msg := streamMessage{
+				Full:      full,
+				StartTime: s.agg.GetStartTime(),
+				Global:    s.agg.GetGlobalStats(),
+				Clients:   make([]model.ClientStats, 0),
+			}
+
+			seen := make(map[string]struct{})
+			for _, c := range s.agg.GetClients() {
+				seen[c.MAC] = struct{}{}
+				if prev, ok := last[c.MAC]; full || !ok || !reflect.DeepEqual(prev, c) {
+					msg.Clients = append(msg.Clients, c)
+					last[c.MAC] = c
+				}
+			}
+			for mac := range last {
+				if _, ok := seen[mac]; !ok {
+					msg.Removed = append(msg.Removed, mac)
+					delete(last, mac)
+				}
+			}
+
+			if err := websocket.JSON.Send(ws, msg); err != nil {
+				return
+			}
+			full = false
+
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}}
+}
+
+func checkStreamOrigin(_ *websocket.Config, r *http.Request) error {
+	if !fromSameOrigin(r) {
+		return errors.New("cross-origin request refused")
+	}
+	return nil
+}
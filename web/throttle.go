@@ -0,0 +1,165 @@
+package web
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Bound the number of remembered clients and responses
+const (
+	maxRateClients  = 1024
+	maxCacheEntries = 256
+	defaultBurst    = 10
+)
+
+// SetRateLimit limits each client IP to rps requests per second on the heavy
+// endpoints, with bursts of up to burst requests. rps <= 0 disables limiting.
+func (s *Server) SetRateLimit(rps float64, burst int) {
+	if burst <= 0 {
+		burst = defaultBurst
+	}
+	s.limitMu.Lock()
+	defer s.limitMu.Unlock()
+	s.limitRate = rps
+	s.limitBurst = float64(burst)
+	s.buckets = make(map[string]*bucket)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the client's bucket
+func (s *Server) allow(r *http.Request) bool {
+	s.limitMu.Lock()
+	defer s.limitMu.Unlock()
+	if s.limitRate <= 0 {
+		return true
+	}
+
+	// Unix socket peers have no address; they are local, e.g. a reverse
+	// proxy forwarding all clients, and not limited
+	ip := remoteIP(r)
+	if ip == "" {
+		return true
+	}
+
+	now := time.Now()
+	b, ok := s.buckets[ip]
+	if !ok {
+		if len(s.buckets) >= maxRateClients {
+			s.pruneBuckets(now)
+		}
+		b = &bucket{tokens: s.limitBurst, last: now}
+		s.buckets[ip] = b
+	}
+	b.tokens = min(s.limitBurst, b.tokens+now.Sub(b.last).Seconds()*s.limitRate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// remoteIP returns the client IP of a request, "" for Unix socket peers
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return ip
+}
+
+// pruneBuckets drops clients whose bucket has refilled. Caller holds limitMu.
+func (s *Server) pruneBuckets(now time.Time) {
+	for ip, b := range s.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*s.limitRate >= s.limitBurst {
+			delete(s.buckets, ip)
+		}
+	}
+}
+
+type cacheEntry struct {
+	body        []byte
+	contentType string
+	expires     time.Time
+}
+
+// throttled rate limits a handler and caches its successful GET responses
+// for one refresh interval, the data doesn't change in between
+func (s *Server) throttled(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.allow(r) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		if r.Method != http.MethodGet {
+			h(w, r)
+			return
+		}
+
+		key := r.URL.RequestURI()
+		now := time.Now()
+		s.cacheMu.Lock()
+		e, ok := s.cache[key]
+		s.cacheMu.Unlock()
+		if ok && now.Before(e.expires) {
+			w.Header().Set("Content-Type", e.contentType)
+			w.Write(e.body)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+		if rec.status != http.StatusOK {
+			return
+		}
+
+		s.cacheMu.Lock()
+		defer s.cacheMu.Unlock()
+		if len(s.cache) >= maxCacheEntries {
+			for k, e := range s.cache {
+				if !now.Before(e.expires) {
+					delete(s.cache, k)
+				}
+			}
+			if len(s.cache) >= maxCacheEntries {
+				return
+			}
+		}
+		s.cache[key] = cacheEntry{
+			body:        rec.body.Bytes(),
+			contentType: w.Header().Get("Content-Type"),
+			expires:     now.Add(s.agg.GetInterval()),
+		}
+	}
+}
+
+// purgeCache drops cached responses after state changing requests
+func (s *Server) purgeCache() {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	clear(s.cache)
+}
+
+// recorder passes a response through while keeping a copy of the body
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
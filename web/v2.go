@@ -0,0 +1,475 @@
+package web
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kisy/catchmole/model"
+	"github.com/kisy/catchmole/pkg/stats"
+)
+
+// API v2 has a versioned schema for third-party dashboards. Within schema
+// version 2 fields are only ever added, never renamed or removed. Names are
+// snake_case and carry their unit: _bytes, _bytes_per_second, _per_second,
+// _seconds, _percent, _dbm; timestamps (_at) are RFC 3339.
+const v2SchemaVersion = 2
+
+// Page size of list endpoints, unless limit says otherwise
+const (
+	v2DefaultLimit = 100
+	v2MaxLimit     = 1000
+)
+
+type v2Object struct {
+	SchemaVersion int       `json:"schema_version"`
+	GeneratedAt   time.Time `json:"generated_at"`
+	Data          any       `json:"data"`
+}
+
+type v2Page struct {
+	SchemaVersion int       `json:"schema_version"`
+	GeneratedAt   time.Time `json:"generated_at"`
+	Total         int       `json:"total"` // Items before pagination
+	Limit         int       `json:"limit"`
+	Offset        int       `json:"offset"`
+	NextOffset    *int      `json:"next_offset,omitempty"` // Absent on the last page
+	Items         any       `json:"items"`
+}
+
+type v2Error struct {
+	SchemaVersion int    `json:"schema_version"`
+	Error         string `json:"error"`
+}
+
+type v2Global struct {
+	StartedAt                      time.Time `json:"started_at"`
+	DownloadBytes                  uint64    `json:"download_bytes"`
+	UploadBytes                    uint64    `json:"upload_bytes"`
+	DownloadBytesPerSecond         uint64    `json:"download_bytes_per_second"`
+	UploadBytesPerSecond           uint64    `json:"upload_bytes_per_second"`
+	DownloadBytesPerSecond1m       uint64    `json:"download_bytes_per_second_1m"`
+	DownloadBytesPerSecond5m       uint64    `json:"download_bytes_per_second_5m"`
+	DownloadBytesPerSecond15m      uint64    `json:"download_bytes_per_second_15m"`
+	UploadBytesPerSecond1m         uint64    `json:"upload_bytes_per_second_1m"`
+	UploadBytesPerSecond5m         uint64    `json:"upload_bytes_per_second_5m"`
+	UploadBytesPerSecond15m        uint64    `json:"upload_bytes_per_second_15m"`
+	ActiveConnections              uint64    `json:"active_connections"`
+	NewConnections                 uint64    `json:"new_connections"`
+	ClosedConnections              uint64    `json:"closed_connections"`
+	FailedConnections              uint64    `json:"failed_connections"`
+	NewConnectionsPerSecond        float64   `json:"new_connections_per_second"`
+	ClosedConnectionsPerSecond     float64   `json:"closed_connections_per_second"`
+	FailedConnectionsPerSecond     float64   `json:"failed_connections_per_second"`
+	DownloadCapacityBytesPerSecond uint64    `json:"download_capacity_bytes_per_second,omitempty"`
+	UploadCapacityBytesPerSecond   uint64    `json:"upload_capacity_bytes_per_second,omitempty"`
+	DownloadUtilizationPercent     float64   `json:"download_utilization_percent,omitempty"`
+	UploadUtilizationPercent       float64   `json:"upload_utilization_percent,omitempty"`
+	Clients                        int       `json:"clients"`
+	OnlineClients                  int       `json:"online_clients"`
+}
+
+type v2Client struct {
+	MAC                        string    `json:"mac"`
+	Name                       string    `json:"name"`
+	Hostname                   string    `json:"hostname,omitempty"`
+	Tags                       []string  `json:"tags"`
+	Online                     bool      `json:"online"`
+	VLAN                       uint16    `json:"vlan,omitempty"`
+	SSID                       string    `json:"ssid,omitempty"`
+	SignalDBm                  int       `json:"signal_dbm,omitempty"`
+	FirstSeenAt                time.Time `json:"first_seen_at"`
+	LastActiveAt               time.Time `json:"last_active_at"`
+	DownloadBytes              uint64    `json:"download_bytes"`
+	UploadBytes                uint64    `json:"upload_bytes"`
+	SessionDownloadBytes       uint64    `json:"session_download_bytes"`
+	SessionUploadBytes         uint64    `json:"session_upload_bytes"`
+	DownloadBytesPerSecond     uint64    `json:"download_bytes_per_second"`
+	UploadBytesPerSecond       uint64    `json:"upload_bytes_per_second"`
+	DownloadBytesPerSecond1m   uint64    `json:"download_bytes_per_second_1m"`
+	DownloadBytesPerSecond5m   uint64    `json:"download_bytes_per_second_5m"`
+	DownloadBytesPerSecond15m  uint64    `json:"download_bytes_per_second_15m"`
+	UploadBytesPerSecond1m     uint64    `json:"upload_bytes_per_second_1m"`
+	UploadBytesPerSecond5m     uint64    `json:"upload_bytes_per_second_5m"`
+	UploadBytesPerSecond15m    uint64    `json:"upload_bytes_per_second_15m"`
+	ActiveConnections          uint64    `json:"active_connections"`
+	NewConnections             uint64    `json:"new_connections"`
+	ClosedConnections          uint64    `json:"closed_connections"`
+	FailedConnections          uint64    `json:"failed_connections"`
+	NewConnectionsPerSecond    float64   `json:"new_connections_per_second"`
+	ClosedConnectionsPerSecond float64   `json:"closed_connections_per_second"`
+	FailedConnectionsPerSecond float64   `json:"failed_connections_per_second"`
+	NewFlows                   uint64    `json:"new_flows"`
+	NewFlowsPerSecond          float64   `json:"new_flows_per_second"`
+}
+
+// v2Flow is one remote endpoint of a client (/clients/{mac}/flows) or one
+// tracked flow (/flows); fields not known for a view are left out
+type v2Flow struct {
+	MAC                    string     `json:"mac,omitempty"`
+	Protocol               string     `json:"protocol"`
+	ClientIP               string     `json:"client_ip"`
+	ClientPort             uint16     `json:"client_port,omitempty"`
+	RemoteIP               string     `json:"remote_ip"`
+	RemotePort             uint16     `json:"remote_port"`
+	RemoteHostname         string     `json:"remote_hostname,omitempty"`
+	RemoteCountry          string     `json:"remote_country,omitempty"`
+	RemoteASN              uint       `json:"remote_asn,omitempty"`
+	RemoteOrg              string     `json:"remote_org,omitempty"`
+	DownloadBytes          uint64     `json:"download_bytes"`
+	UploadBytes            uint64     `json:"upload_bytes"`
+	DownloadBytesPerSecond uint64     `json:"download_bytes_per_second"`
+	UploadBytesPerSecond   uint64     `json:"upload_bytes_per_second"`
+	DurationSeconds        uint64     `json:"duration_seconds"`
+	FirstSeenAt            *time.Time `json:"first_seen_at,omitempty"`
+	LastSeenAt             *time.Time `json:"last_seen_at,omitempty"`
+	ActiveConnections      uint64     `json:"active_connections,omitempty"`
+	TTLRemainingSeconds    *int       `json:"ttl_remaining_seconds,omitempty"`
+	TCPState               string     `json:"tcp_state,omitempty"`
+	Assured                bool       `json:"assured"`
+	Offload                bool       `json:"offload"`
+	ProtoInfo              string     `json:"proto_info,omitempty"`
+	Tag                    string     `json:"tag,omitempty"`
+	Excluded               bool       `json:"excluded"`
+	Zone                   uint16     `json:"zone,omitempty"`
+}
+
+func toV2Client(c model.ClientStats) v2Client {
+	tags := c.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return v2Client{
+		MAC:                        c.MAC,
+		Name:                       c.Name,
+		Hostname:                   c.Hostname,
+		Tags:                       tags,
+		Online:                     c.Online,
+		VLAN:                       c.VLAN,
+		SSID:                       c.SSID,
+		SignalDBm:                  c.Signal,
+		FirstSeenAt:                c.StartTime,
+		LastActiveAt:               c.LastActive,
+		DownloadBytes:              c.TotalDownload,
+		UploadBytes:                c.TotalUpload,
+		SessionDownloadBytes:       c.SessionDownload,
+		SessionUploadBytes:         c.SessionUpload,
+		DownloadBytesPerSecond:     c.DownloadSpeed,
+		UploadBytesPerSecond:       c.UploadSpeed,
+		DownloadBytesPerSecond1m:   c.DownloadSpeed1m,
+		DownloadBytesPerSecond5m:   c.DownloadSpeed5m,
+		DownloadBytesPerSecond15m:  c.DownloadSpeed15m,
+		UploadBytesPerSecond1m:     c.UploadSpeed1m,
+		UploadBytesPerSecond5m:     c.UploadSpeed5m,
+		UploadBytesPerSecond15m:    c.UploadSpeed15m,
+		ActiveConnections:          c.ActiveConnections,
+		NewConnections:             c.NewConnections,
+		ClosedConnections:          c.ClosedConnections,
+		FailedConnections:          c.FailedConnections,
+		NewConnectionsPerSecond:    c.NewConnRate,
+		ClosedConnectionsPerSecond: c.ClosedConnRate,
+		FailedConnectionsPerSecond: c.FailedConnRate,
+		NewFlows:                   c.NewFlows,
+		NewFlowsPerSecond:          c.NewFlowRate,
+	}
+}
+
+// RegisterV2Handlers adds the /api/v2/ routes. Call after RegisterHandlers.
+func (s *Server) RegisterV2Handlers() {
+	http.HandleFunc("GET /api/v2/stats", s.v2(func(w http.ResponseWriter, r *http.Request) {
+		g := s.agg.GetGlobalStats()
+		clients := s.agg.GetClients()
+		online := 0
+		for _, c := range clients {
+			if c.Online {
+				online++
+			}
+		}
+		s.v2Write(w, r, v2Object{Data: v2Global{
+			StartedAt:                      s.agg.GetStartTime(),
+			DownloadBytes:                  g.TotalDownload,
+			UploadBytes:                    g.TotalUpload,
+			DownloadBytesPerSecond:         g.DownloadSpeed,
+			UploadBytesPerSecond:           g.UploadSpeed,
+			DownloadBytesPerSecond1m:       g.DownloadSpeed1m,
+			DownloadBytesPerSecond5m:       g.DownloadSpeed5m,
+			DownloadBytesPerSecond15m:      g.DownloadSpeed15m,
+			UploadBytesPerSecond1m:         g.UploadSpeed1m,
+			UploadBytesPerSecond5m:         g.UploadSpeed5m,
+			UploadBytesPerSecond15m:        g.UploadSpeed15m,
+			ActiveConnections:              g.ActiveConnections,
+			NewConnections:                 g.NewConnections,
+			ClosedConnections:              g.ClosedConnections,
+			FailedConnections:              g.FailedConnections,
+			NewConnectionsPerSecond:        g.NewConnRate,
+			ClosedConnectionsPerSecond:     g.ClosedConnRate,
+			FailedConnectionsPerSecond:     g.FailedConnRate,
+			DownloadCapacityBytesPerSecond: g.DownloadCapacity,
+			UploadCapacityBytesPerSecond:   g.UploadCapacity,
+			DownloadUtilizationPercent:     g.DownloadUtilization,
+			UploadUtilizationPercent:       g.UploadUtilization,
+			Clients:                        len(clients),
+			OnlineClients:                  online,
+		}})
+	}))
+
+	// Clients ordered by MAC so pages are stable; ?online=true and ?tag= filter
+	http.HandleFunc("GET /api/v2/clients", s.v2(func(w http.ResponseWriter, r *http.Request) {
+		limit, offset, ok := v2Pagination(w, r)
+		if !ok {
+			return
+		}
+		clients := withTag(s.agg.GetClients(), r.URL.Query().Get("tag"))
+		if online, _ := strconv.ParseBool(r.URL.Query().Get("online")); online {
+			clients = slices.DeleteFunc(clients, func(c model.ClientStats) bool { return !c.Online })
+		}
+		slices.SortFunc(clients, func(a, b model.ClientStats) int { return strings.Compare(a.MAC, b.MAC) })
+
+		items := make([]v2Client, 0, len(clients))
+		for _, c := range clients {
+			items = append(items, toV2Client(c))
+		}
+		s.v2Write(w, r, v2Paginate(items, limit, offset))
+	}))
+
+	http.HandleFunc("GET /api/v2/clients/{mac}", s.v2(func(w http.ResponseWriter, r *http.Request) {
+		mac, ok := v2MAC(w, r)
+		if !ok {
+			return
+		}
+		c := s.agg.GetClientWithSession(s.agg.PrimaryMAC(mac))
+		if c == nil {
+			v2Fail(w, http.StatusNotFound, "client not found")
+			return
+		}
+		s.v2Write(w, r, v2Object{Data: toV2Client(*c)})
+	}))
+
+	// Remote endpoints of a client, busiest first
+	http.HandleFunc("GET /api/v2/clients/{mac}/flows", s.v2(func(w http.ResponseWriter, r *http.Request) {
+		mac, ok := v2MAC(w, r)
+		if !ok {
+			return
+		}
+		limit, offset, ok := v2Pagination(w, r)
+		if !ok {
+			return
+		}
+		flows, _, _ := s.agg.GetFlowsByMAC(s.agg.PrimaryMAC(mac))
+		slices.SortStableFunc(flows, func(a, b model.FlowDetail) int {
+			return cmp.Compare(b.DownloadSpeed+b.UploadSpeed, a.DownloadSpeed+a.UploadSpeed)
+		})
+
+		items := make([]v2Flow, 0, len(flows))
+		for _, f := range flows {
+			ttl := f.TTLRemaining
+			items = append(items, v2Flow{
+				Protocol:               f.Protocol,
+				ClientIP:               f.ClientIP,
+				RemoteIP:               f.RemoteIP,
+				RemotePort:             f.RemotePort,
+				RemoteHostname:         f.RemoteHostname,
+				RemoteCountry:          f.RemoteCountry,
+				RemoteASN:              f.RemoteASN,
+				RemoteOrg:              f.RemoteOrg,
+				DownloadBytes:          f.TotalDownload,
+				UploadBytes:            f.TotalUpload,
+				DownloadBytesPerSecond: f.DownloadSpeed,
+				UploadBytesPerSecond:   f.UploadSpeed,
+				DurationSeconds:        f.Duration,
+				ActiveConnections:      f.ActiveConnections,
+				TTLRemainingSeconds:    &ttl,
+				TCPState:               f.TCPState,
+				Assured:                f.Assured,
+				Offload:                f.Offload,
+				ProtoInfo:              f.ProtoInfo,
+				Tag:                    f.Tag,
+				Excluded:               f.Excluded,
+				Zone:                   f.Zone,
+			})
+		}
+		s.v2Write(w, r, v2Paginate(items, limit, offset))
+	}))
+
+	// The whole flow table, same filters and sort keys as /api/flows
+	http.HandleFunc("GET /api/v2/flows", s.v2(func(w http.ResponseWriter, r *http.Request) {
+		limit, offset, ok := v2Pagination(w, r)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		by := q.Get("sort")
+		if by == "" {
+			by = "speed"
+		}
+		filter := stats.FlowFilter{Proto: q.Get("proto")}
+		if v := q.Get("port"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 16)
+			if err != nil {
+				v2Fail(w, http.StatusBadRequest, "invalid port")
+				return
+			}
+			filter.Port = uint16(n)
+		}
+		if v := q.Get("remote"); v != "" {
+			if !strings.Contains(v, "/") {
+				if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+					v += "/32"
+				} else {
+					v += "/128"
+				}
+			}
+			_, sn, err := net.ParseCIDR(v)
+			if err != nil {
+				v2Fail(w, http.StatusBadRequest, "invalid remote")
+				return
+			}
+			filter.Remote = sn
+		}
+
+		list, err := s.agg.GetFlows(filter, by, limit, offset)
+		if err != nil {
+			v2Fail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		items := make([]v2Flow, 0, len(list.Flows))
+		for _, f := range list.Flows {
+			first, last := f.FirstSeen, f.LastSeen
+			items = append(items, v2Flow{
+				MAC:                    f.MAC,
+				Protocol:               f.Protocol,
+				ClientIP:               f.ClientIP,
+				ClientPort:             f.ClientPort,
+				RemoteIP:               f.RemoteIP,
+				RemotePort:             f.RemotePort,
+				RemoteHostname:         f.RemoteHostname,
+				RemoteCountry:          f.RemoteCountry,
+				RemoteASN:              f.RemoteASN,
+				RemoteOrg:              f.RemoteOrg,
+				DownloadBytes:          f.TotalDownload,
+				UploadBytes:            f.TotalUpload,
+				DownloadBytesPerSecond: f.DownloadSpeed,
+				UploadBytesPerSecond:   f.UploadSpeed,
+				DurationSeconds:        uint64(last.Sub(first) / time.Second),
+				FirstSeenAt:            &first,
+				LastSeenAt:             &last,
+				TCPState:               f.TCPState,
+				Assured:                f.Assured,
+				Offload:                f.Offload,
+				ProtoInfo:              f.ProtoInfo,
+				Tag:                    f.Tag,
+				Excluded:               f.Excluded,
+				Zone:                   f.Zone,
+			})
+		}
+		page := v2Page{Total: list.Count, Limit: limit, Offset: offset, Items: items}
+		if next := offset + len(items); next < list.Count {
+			page.NextOffset = &next
+		}
+		s.v2Write(w, r, page)
+	}))
+}
+
+// v2 applies the API rate limit with v2 errors
+func (s *Server) v2(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.allow(r) {
+			w.Header().Set("Retry-After", "1")
+			v2Fail(w, http.StatusTooManyRequests, "too many requests")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// v2Write encodes a response with caching headers. Data changes once per
+// interval, so clients may reuse it that long and revalidate with the ETag.
+func (s *Server) v2Write(w http.ResponseWriter, r *http.Request, v any) {
+	// The ETag covers the payload only, generated_at differs on every request
+	var payload any
+	now := time.Now().UTC()
+	switch resp := v.(type) {
+	case v2Object:
+		resp.SchemaVersion, resp.GeneratedAt = v2SchemaVersion, now
+		v, payload = resp, resp.Data
+	case v2Page:
+		resp.SchemaVersion, resp.GeneratedAt = v2SchemaVersion, now
+		v, payload = resp, []any{resp.Total, resp.Offset, resp.Items}
+	}
+	tag, err := json.Marshal(payload)
+	if err != nil {
+		v2Fail(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h := fnv.New64a()
+	h.Write(tag)
+	etag := fmt.Sprintf(`"%x"`, h.Sum64())
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", max(int(s.agg.GetInterval().Seconds()), 1)))
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func v2Fail(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v2Error{SchemaVersion: v2SchemaVersion, Error: msg})
+}
+
+// v2Pagination reads limit (default 100, at most 1000) and offset
+func v2Pagination(w http.ResponseWriter, r *http.Request) (limit, offset int, ok bool) {
+	limit = v2DefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > v2MaxLimit {
+			v2Fail(w, http.StatusBadRequest, fmt.Sprintf("invalid limit, want 1-%d", v2MaxLimit))
+			return 0, 0, false
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			v2Fail(w, http.StatusBadRequest, "invalid offset")
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+func v2Paginate[T any](items []T, limit, offset int) v2Page {
+	page := v2Page{Total: len(items), Limit: limit, Offset: offset}
+	if offset >= len(items) {
+		page.Items = []T{}
+		return page
+	}
+	end := min(offset+limit, len(items))
+	page.Items = items[offset:end]
+	if end < len(items) {
+		page.NextOffset = &end
+	}
+	return page
+}
+
+func v2MAC(w http.ResponseWriter, r *http.Request) (string, bool) {
+	hw, err := net.ParseMAC(r.PathValue("mac"))
+	if err != nil {
+		v2Fail(w, http.StatusBadRequest, "invalid mac")
+		return "", false
+	}
+	return hw.String(), true
+}